@@ -43,7 +43,7 @@ func main() {
 	go metricsCollector.InitCollector()
 	go metricsCollector.InitPsutilCollector()
 
-	metricsSender := metrics.NewSender(config.ServerAddress, config.Key, config.RateLimit, config.ReportInterval, publicKey, metricsCollector)
+	metricsSender := metrics.NewSender(config.ServerAddress, config.Key, config.RateLimit, config.ReportInterval, config.BatchMode, publicKey, metricsCollector)
 	go metricsSender.InitSender()
 
 	select {}
@@ -0,0 +1,111 @@
+// Command metricsmigrate copies metrics offline between server snapshot files, JSON lines files and PostgreSQL database
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/logger"
+	"github.com/zavtra-na-rabotu/gometrics/internal/metricsmigrate"
+	"github.com/zavtra-na-rabotu/gometrics/internal/utils/stringutils"
+	"go.uber.org/zap"
+)
+
+const usage = `Usage: metricsmigrate -from SOURCE -to TARGET [-dry-run] [-verify] [-force] [-batch-size N] [-from-gzip] [-to-gzip]
+
+SOURCE and TARGET are one of:
+  postgres://...   PostgreSQL database
+  jsonl://PATH     file with one JSON metric per line
+  file://PATH      snapshot file written by server, PATH without scheme is the same
+
+Target must be empty unless -force is set: database counters are added to stored values,
+files are replaced.
+
+Flags:
+`
+
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	logger.InitLogger()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdout)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		zap.L().Fatal("Failed to migrate metrics", zap.Error(err))
+	}
+}
+
+// run parse arguments, copy metrics and print report to {out}
+func run(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("metricsmigrate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() {
+		fmt.Fprint(out, usage)
+		flags.PrintDefaults()
+	}
+
+	from := flags.String("from", "", "Source endpoint")
+	to := flags.String("to", "", "Target endpoint")
+	dryRun := flags.Bool("dry-run", false, "Read source and target, write nothing")
+	verify := flags.Bool("verify", false, "Reload target after writing and compare checksums")
+	force := flags.Bool("force", false, "Write into non-empty target")
+	batchSize := flags.Int("batch-size", metricsmigrate.DefaultBatchSize, "Number of metrics written to database with one batch")
+	fromGzip := flags.Bool("from-gzip", false, "Source snapshot file is compressed with gzip")
+	toGzip := flags.Bool("to-gzip", false, "Target snapshot file is compressed with gzip")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if stringutils.IsEmpty(*from) || stringutils.IsEmpty(*to) {
+		flags.Usage()
+		return fmt.Errorf("%w: source and target are required", errUsage)
+	}
+
+	source, err := metricsmigrate.Open(*from, *fromGzip)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := metricsmigrate.Open(*to, *toGzip)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	report, err := metricsmigrate.Run(ctx, source, target, metricsmigrate.Options{
+		BatchSize: *batchSize,
+		DryRun:    *dryRun,
+		Verify:    *verify,
+		Force:     *force,
+	})
+
+	fmt.Fprintf(out, "source %s: %s\n", source, report.Source)
+	fmt.Fprintf(out, "target %s: %s\n", target, report.Target)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *dryRun:
+		fmt.Fprintf(out, "dry run: %d series would be written\n", report.Source.Total())
+	case report.Verified:
+		fmt.Fprintf(out, "%d series written and verified\n", report.Source.Total())
+	default:
+		fmt.Fprintf(out, "%d series written\n", report.Source.Total())
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
@@ -36,8 +37,22 @@ func main() {
 	sugar.Infof("Build date: %s", buildDate)
 	sugar.Infof("Build commit: %s", buildCommit)
 
+	// Database schema is managed by subcommand without starting server
+	if len(os.Args) > 1 && os.Args[1] == migrateCommand {
+		err := runMigrate(context.Background(), os.Args[2:], os.Stdout)
+		if err != nil {
+			zap.L().Fatal("Failed to run migrate command", zap.Error(err))
+		}
+		return
+	}
+
 	config := configuration.Configure()
 
+	retentionPolicy, err := storage.ParseRetentionPolicy(config.Retention)
+	if err != nil {
+		zap.L().Fatal("Failed to parse retention policy", zap.Error(err))
+	}
+
 	r := chi.NewRouter()
 
 	if config.CryptoKey != "" {
@@ -57,46 +72,154 @@ func main() {
 		r.Use(middleware.ResponseHashMiddleware(config.Key))
 	}
 
-	var storageToUse storage.Storage
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer stop()
+
+	// Storage components are started in order and stopped in reverse order
+	var lifecycles []storage.Lifecycle
 
-	if !stringutils.IsEmpty(config.DatabaseDsn) {
+	// Database (PostgreSQL, SQLite, Redis or embedded key-value) and in memory storages are both used only when they are mirrored
+	var databaseStorage, memoryStorage storage.Storage
+
+	if storage.IsSQLiteDsn(config.DatabaseDsn) {
+		zap.L().Info("Using SQLite storage")
+
+		sqliteStorage, err := storage.NewSQLiteStorage(config.DatabaseDsn)
+		if err != nil {
+			zap.L().Fatal("Failed to open SQLite database", zap.Error(err))
+		}
+
+		databaseStorage = sqliteStorage
+		lifecycles = append(lifecycles, sqliteStorage)
+	} else if storage.IsRedisDsn(config.DatabaseDsn) {
+		zap.L().Info("Using Redis storage")
+
+		redisStorage, err := storage.NewRedisStorage(config.DatabaseDsn)
+		if err != nil {
+			zap.L().Fatal("Failed to create Redis client", zap.Error(err))
+		}
+
+		databaseStorage = redisStorage
+		lifecycles = append(lifecycles, redisStorage)
+	} else if !stringutils.IsEmpty(config.DatabaseDsn) {
 		zap.L().Info("Using database storage")
 
-		dbStorage, err := storage.NewDBStorage(config.DatabaseDsn)
+		dbStorage, err := storage.NewDBStorage(config.DatabaseDsn, storage.PoolConfig{
+			MaxConns:          int32(config.DBMaxConns),
+			MinConns:          int32(config.DBMinConns),
+			MaxConnLifetime:   time.Duration(config.DBMaxConnLifetime) * time.Second,
+			MaxConnIdleTime:   time.Duration(config.DBMaxConnIdleTime) * time.Second,
+			HealthCheckPeriod: time.Duration(config.DBHealthCheckPeriod) * time.Second,
+			StatementTimeout:  time.Duration(config.DBStatementTimeout) * time.Second,
+		})
 		if err != nil {
 			zap.L().Fatal("Failed to connect to database", zap.Error(err))
 		}
-		defer dbStorage.Close()
 
-		err = dbStorage.RunMigrations()
+		databaseStorage = dbStorage
+		lifecycles = append(lifecycles, dbStorage)
+	} else if !stringutils.IsEmpty(config.KVStoragePath) {
+		zap.L().Info("Using embedded key-value storage")
+
+		boltStorage, err := storage.NewBoltStorage(config.KVStoragePath)
 		if err != nil {
-			zap.L().Fatal("Failed to run migrations", zap.Error(err))
+			zap.L().Fatal("Failed to open key-value database", zap.Error(err))
 		}
 
-		storageToUse = dbStorage
-	} else {
+		databaseStorage = boltStorage
+		lifecycles = append(lifecycles, boltStorage)
+	}
+
+	// Cache is started after migrations and flushed before database is closed
+	if databaseStorage != nil && config.CacheFlushInterval > 0 {
+		zap.L().Info("Using write-behind cache of database storage")
+
+		cachedStorage := storage.NewCachedStorage(databaseStorage, time.Duration(config.CacheFlushInterval)*time.Second, config.CacheFlushSize)
+
+		databaseStorage = cachedStorage
+		lifecycles = append(lifecycles, cachedStorage)
+	}
+
+	if databaseStorage == nil || !stringutils.IsEmpty(config.StoragePrimary) {
 		zap.L().Info("Using in memory storage")
 
-		storageToUse = storage.NewMemStorage()
+		memStorage := storage.NewMemStorage()
+		memStorage.SetSnapshotGzip(config.SnapshotGzip)
+		memStorage.SetHistorySize(config.HistorySize)
+		if config.CompactInterval > 0 {
+			memStorage.SetRetentionPolicy(retentionPolicy)
+		}
+
+		memoryStorage = memStorage
+		lifecycles = append(lifecycles, storage.NewPersistence(memStorage, config.FileStoragePath, config.Restore, config.StoreInterval))
+	}
+
+	var storageToUse storage.Storage
+
+	switch config.StoragePrimary {
+	case "":
+		storageToUse = databaseStorage
+		if storageToUse == nil {
+			storageToUse = memoryStorage
+		}
+	case "database", "memory":
+		if databaseStorage == nil {
+			zap.L().Fatal("Mirroring requires database DSN or key-value storage path", zap.String("primary", config.StoragePrimary))
+		}
 
-		err := storage.ConfigureStorage(storageToUse.(*storage.MemStorage), config.FileStoragePath, config.Restore, config.StoreInterval)
+		mirrorPolicy, err := storage.ParseMirrorPolicy(config.MirrorPolicy)
 		if err != nil {
-			zap.L().Fatal("failed to configure storage", zap.Error(err))
+			zap.L().Fatal("Failed to parse mirror policy", zap.Error(err))
+		}
+
+		primary, secondary := databaseStorage, memoryStorage
+		if config.StoragePrimary == "memory" {
+			primary, secondary = memoryStorage, databaseStorage
+		}
+
+		zap.L().Info("Mirroring storage", zap.String("primary", config.StoragePrimary), zap.String("policy", string(mirrorPolicy)))
+
+		// Mirror is stopped first, so queued updates are written before secondary is stopped
+		mirroredStorage := storage.NewMirroredStorage(primary, mirrorPolicy, time.Duration(config.MirrorRetryInterval)*time.Second, secondary)
+
+		storageToUse = mirroredStorage
+		lifecycles = append(lifecycles, mirroredStorage)
+	default:
+		zap.L().Fatal("Invalid primary storage", zap.String("primary", config.StoragePrimary))
+	}
+
+	// Roll metric history up and delete expired samples in background
+	if compactor, ok := storageToUse.(storage.Compactor); ok && config.CompactInterval > 0 {
+		lifecycles = append(lifecycles, storage.NewCompactorRunner(compactor, retentionPolicy, time.Duration(config.CompactInterval)*time.Second))
+	}
+
+	for _, lifecycle := range lifecycles {
+		err := lifecycle.Start(ctx)
+		if err != nil {
+			zap.L().Fatal("Failed to start storage", zap.Error(err))
 		}
 	}
 
 	// API v1
 	r.Post("/update/{type}/{name}/{value}", v1.UpdateMetric(storageToUse))
 	r.Get("/value/{type}/{name}", v1.GetMetric(storageToUse))
+	r.Delete("/value/{type}/{name}", v1.DeleteMetric(storageToUse))
 	r.Get("/", v1.RenderAllMetrics(storageToUse))
 
 	// API v2
 	r.Post("/update/", v2.UpdateMetric(storageToUse))
 	r.Post("/value/", v2.GetMetric(storageToUse))
+	r.Post("/values/", v2.GetMetrics(storageToUse))
 
 	// API v3
 	r.Get("/ping", v3.Ping(storageToUse))
 	r.Post("/updates/", v3.UpdateMetrics(storageToUse))
+	r.Get("/history/{type}/{name}", v3.GetHistory(storageToUse))
+	r.Post("/delete/", v3.DeleteMetrics(storageToUse))
+	r.Get("/api/metrics", v3.ListMetrics(storageToUse))
+
+	// Diagnostics
+	r.Get("/debug/pool", v3.GetPoolStats(storageToUse))
 
 	// Profiler
 	r.Mount("/debug", profilermiddleware.Profiler())
@@ -106,9 +229,6 @@ func main() {
 		Handler: r,
 	}
 
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
-	defer stop()
-
 	// Start server in separate goroutine
 	go func() {
 		zap.L().Info("Starting server", zap.String("address", config.ServerAddress))
@@ -123,13 +243,21 @@ func main() {
 	<-ctx.Done()
 	zap.L().Info("Shutting down server...")
 
-	// Context with timeout to shut down server
+	// Context with timeout to shut down server and storage
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	err := server.Shutdown(shutdownCtx)
+	// Server is stopped first, so no update arrives after the last snapshot
+	err = server.Shutdown(shutdownCtx)
 	if err != nil {
-		zap.L().Fatal("Server forced to shutdown", zap.Error(err))
+		zap.L().Error("Server forced to shutdown", zap.Error(err))
+	}
+
+	for i := len(lifecycles) - 1; i >= 0; i-- {
+		err := lifecycles[i].Stop(shutdownCtx)
+		if err != nil {
+			zap.L().Error("Failed to stop storage", zap.Error(err))
+		}
 	}
 
 	zap.L().Info("Server exiting")
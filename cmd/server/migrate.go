@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+	"github.com/zavtra-na-rabotu/gometrics/internal/utils/stringutils"
+)
+
+// migrateCommand name of subcommand to manage database schema
+const migrateCommand = "migrate"
+
+const migrateUsage = `Usage: server migrate [-d DSN] COMMAND
+
+Commands:
+  up            apply all migrations
+  down          roll back the last applied migration
+  to VERSION    migrate up or down to VERSION, 0 rolls back all migrations
+  status        show applied version and embedded migrations
+
+DSN is taken from DATABASE_DSN when -d is not set.
+`
+
+var errMigrateUsage = errors.New("invalid migrate command")
+
+// runMigrate parse arguments of migrate subcommand and run it against database
+func runMigrate(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet(migrateCommand, flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() {
+		fmt.Fprint(out, migrateUsage)
+	}
+
+	dsn := flags.String("d", os.Getenv("DATABASE_DSN"), "Database DSN")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if stringutils.IsEmpty(*dsn) {
+		flags.Usage()
+		return fmt.Errorf("%w: database DSN is required", errMigrateUsage)
+	}
+
+	// SQLite schema is migrated when server starts, Redis has no schema
+	if storage.IsSQLiteDsn(*dsn) || storage.IsRedisDsn(*dsn) {
+		return fmt.Errorf("%w: only PostgreSQL database is supported", errMigrateUsage)
+	}
+
+	command := flags.Args()
+	if len(command) == 0 {
+		flags.Usage()
+		return fmt.Errorf("%w: command is required", errMigrateUsage)
+	}
+
+	dbStorage, err := storage.NewDBStorage(*dsn, storage.PoolConfig{MaxConns: 1})
+	if err != nil {
+		return err
+	}
+	defer dbStorage.Close()
+
+	switch {
+	case command[0] == "up" && len(command) == 1:
+		return dbStorage.MigrateUp(ctx)
+	case command[0] == "down" && len(command) == 1:
+		return dbStorage.MigrateDown(ctx)
+	case command[0] == "to" && len(command) == 2:
+		version, err := strconv.ParseUint(command[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid version %q", errMigrateUsage, command[1])
+		}
+		return dbStorage.MigrateTo(ctx, uint(version))
+	case command[0] == "status" && len(command) == 1:
+		status, err := dbStorage.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		printMigrationStatus(out, status)
+		return nil
+	default:
+		flags.Usage()
+		return fmt.Errorf("%w: %v", errMigrateUsage, command)
+	}
+}
+
+// printMigrationStatus print applied version and mark of every embedded migration
+func printMigrationStatus(out io.Writer, status storage.MigrationStatus) {
+	if !status.Applied {
+		fmt.Fprintln(out, "version: none")
+	} else {
+		fmt.Fprintf(out, "version: %d, dirty: %t\n", status.Version, status.Dirty)
+	}
+
+	for _, version := range status.Available {
+		state := "pending"
+		if status.Applied && version <= status.Version {
+			state = "applied"
+		}
+		fmt.Fprintf(out, "  %03d %s\n", version, state)
+	}
+}
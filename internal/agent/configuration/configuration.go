@@ -32,6 +32,9 @@ type Configuration struct {
 
 	// RateLimit limit outgoing requests with metrics
 	RateLimit int `json:"rate_limit"`
+
+	// BatchMode how server applies batch of metrics: "atomic" or "partial", header is not sent when empty
+	BatchMode string `json:"batch_mode"`
 }
 
 type envs struct {
@@ -42,6 +45,7 @@ type envs struct {
 	ReportInterval int    `env:"REPORT_INTERVAL"`
 	PollInterval   int    `env:"POLL_INTERVAL"`
 	RateLimit      int    `env:"RATE_LIMIT"`
+	BatchMode      string `env:"BATCH_MODE"`
 }
 
 // Configure read env variables and CLI parameters to configure server
@@ -61,6 +65,7 @@ func Configure() *Configuration {
 	flag.StringVar(&config.Key, "k", "", "Key")
 	flag.IntVar(&config.RateLimit, "l", 1, "Rate limit")
 	flag.StringVar(&config.CryptoKey, "crypto-key", "", "Crypto Key")
+	flag.StringVar(&config.BatchMode, "batch-mode", "", "Batch mode: atomic or partial, server applies batch atomically when empty")
 	flag.Parse()
 
 	var envVariables envs
@@ -111,6 +116,11 @@ func Configure() *Configuration {
 		config.CryptoKey = envVariables.CryptoKey
 	}
 
+	_, exists = os.LookupEnv("BATCH_MODE")
+	if exists && envVariables.BatchMode != "" {
+		config.BatchMode = envVariables.BatchMode
+	}
+
 	return &config
 }
 
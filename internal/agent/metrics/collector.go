@@ -15,25 +15,34 @@ import (
 	"github.com/shirou/gopsutil/v4/mem"
 )
 
+// labeledGauge gauge metric with dimensions, e.g. CPUutilization with cpu label
+type labeledGauge struct {
+	labels model.Labels
+	name   string
+	value  float64
+}
+
 // Collector structure with all dependencies for metrics collection
 type Collector struct {
-	gaugeMetrics   map[string]float64
-	counterMetrics map[string]int64
-	metrics        chan []model.Metrics
-	pollInterval   time.Duration
-	gaugeLock      sync.RWMutex
-	counterLock    sync.RWMutex
+	gaugeMetrics        map[string]float64
+	labeledGaugeMetrics map[string]labeledGauge
+	counterMetrics      map[string]int64
+	metrics             chan []model.Metrics
+	pollInterval        time.Duration
+	gaugeLock           sync.RWMutex
+	counterLock         sync.RWMutex
 }
 
 // NewCollector Collector constructor
 func NewCollector(pollInterval int, metrics chan []model.Metrics) *Collector {
 	return &Collector{
-		gaugeMetrics:   make(map[string]float64),
-		counterMetrics: make(map[string]int64),
-		pollInterval:   time.Duration(pollInterval) * time.Second,
-		metrics:        metrics,
-		gaugeLock:      sync.RWMutex{},
-		counterLock:    sync.RWMutex{},
+		gaugeMetrics:        make(map[string]float64),
+		labeledGaugeMetrics: make(map[string]labeledGauge),
+		counterMetrics:      make(map[string]int64),
+		pollInterval:        time.Duration(pollInterval) * time.Second,
+		metrics:             metrics,
+		gaugeLock:           sync.RWMutex{},
+		counterLock:         sync.RWMutex{},
 	}
 }
 
@@ -107,7 +116,12 @@ func (collector *Collector) CollectPsutilMetrics() {
 	}
 
 	for cpuNum, cpuPercent := range cpuPercents {
-		collector.gaugeMetrics["CPUutilization"+strconv.FormatInt(int64(cpuNum), 10)] = cpuPercent
+		labels := model.Labels{"cpu": strconv.Itoa(cpuNum)}
+		collector.labeledGaugeMetrics[model.SeriesKey("CPUutilization", labels)] = labeledGauge{
+			name:   "CPUutilization",
+			labels: labels,
+			value:  cpuPercent,
+		}
 	}
 }
 
@@ -165,24 +179,28 @@ func (collector *Collector) CreateMetrics() []model.Metrics {
 
 	collector.gaugeLock.RLock()
 	for name, value := range collector.gaugeMetrics {
-		metrics = append(metrics, *createMetric(name, string(model.Gauge), value, 0))
+		metrics = append(metrics, *createMetric(name, nil, string(model.Gauge), value, 0))
+	}
+	for _, gauge := range collector.labeledGaugeMetrics {
+		metrics = append(metrics, *createMetric(gauge.name, gauge.labels, string(model.Gauge), gauge.value, 0))
 	}
 	collector.gaugeLock.RUnlock()
 
 	collector.counterLock.RLock()
 	for name, value := range collector.counterMetrics {
-		metrics = append(metrics, *createMetric(name, string(model.Counter), 0, value))
+		metrics = append(metrics, *createMetric(name, nil, string(model.Counter), 0, value))
 	}
 	collector.counterLock.RUnlock()
 
 	return metrics
 }
 
-func createMetric(id string, mType string, value float64, delta int64) *model.Metrics {
+func createMetric(id string, labels model.Labels, mType string, value float64, delta int64) *model.Metrics {
 	return &model.Metrics{
-		ID:    id,
-		MType: mType,
-		Value: &value,
-		Delta: &delta,
+		ID:     id,
+		MType:  mType,
+		Labels: labels,
+		Value:  &value,
+		Delta:  &delta,
 	}
 }
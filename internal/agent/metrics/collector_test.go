@@ -76,6 +76,11 @@ func TestCollector_CollectPsutilMetrics(t *testing.T) {
 
 	assert.Contains(t, collector.gaugeMetrics, "TotalMemory")
 	assert.Contains(t, collector.gaugeMetrics, "FreeMemory")
+
+	cpuUtilization, ok := collector.labeledGaugeMetrics[model.SeriesKey("CPUutilization", model.Labels{"cpu": "0"})]
+	assert.True(t, ok)
+	assert.Equal(t, "CPUutilization", cpuUtilization.name)
+	assert.Equal(t, model.Labels{"cpu": "0"}, cpuUtilization.labels)
 }
 
 func TestCollector_CreateMetrics(t *testing.T) {
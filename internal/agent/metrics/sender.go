@@ -12,14 +12,17 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
 	"go.uber.org/zap"
 )
 
@@ -31,10 +34,31 @@ type Sender struct {
 	rateLimit      int
 	reportInterval time.Duration
 	publicKey      *rsa.PublicKey
+	batchMode      string
+	retryDelays    []time.Duration
+	jobs           chan sendJob
+	// lock guards closing of jobs, so scheduled retry is not sent to closed channel
+	lock    sync.Mutex
+	stopped bool
+}
+
+// sendJob metrics of one request, attempt is number of failed sends of these metrics
+type sendJob struct {
+	metrics []model.Metrics
+	attempt int
+}
+
+// RetryableMetricsError metrics of batch rejected by server which can be sent again
+type RetryableMetricsError struct {
+	Metrics []model.Metrics
+}
+
+func (e *RetryableMetricsError) Error() string {
+	return fmt.Sprintf("%d metrics rejected by server can be sent again", len(e.Metrics))
 }
 
 // NewSender sender constructor
-func NewSender(url string, key string, rateLimit int, reportInterval int, publicKey *rsa.PublicKey, collector *Collector) *Sender {
+func NewSender(url string, key string, rateLimit int, reportInterval int, batchMode string, publicKey *rsa.PublicKey, collector *Collector) *Sender {
 	return &Sender{
 		client:         resty.New().SetBaseURL("http://" + url),
 		collector:      collector,
@@ -42,15 +66,18 @@ func NewSender(url string, key string, rateLimit int, reportInterval int, public
 		rateLimit:      rateLimit,
 		reportInterval: time.Duration(reportInterval) * time.Second,
 		publicKey:      publicKey,
+		batchMode:      batchMode,
+		retryDelays:    []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second},
+		jobs:           make(chan sendJob, rateLimit),
 	}
 }
 
-func (sender *Sender) worker(id int, jobs <-chan []model.Metrics, wg *sync.WaitGroup) {
+func (sender *Sender) worker(id int, wg *sync.WaitGroup) {
 	zap.L().Info("Starting worker", zap.Int("Worker id", id))
 	defer wg.Done()
 
-	for metrics := range jobs {
-		err := sender.sendMetrics(metrics)
+	for job := range sender.jobs {
+		err := sender.send(job)
 		if err != nil {
 			zap.L().Error("Error sending metrics", zap.Error(err), zap.Int("Worker id", id))
 		} else {
@@ -61,12 +88,10 @@ func (sender *Sender) worker(id int, jobs <-chan []model.Metrics, wg *sync.WaitG
 
 // InitSender init and start sending collected metrics with specified interval and rate limit
 func (sender *Sender) InitSender() {
-	sendJobs := make(chan []model.Metrics, sender.rateLimit)
-
 	var wg sync.WaitGroup
 	for i := 1; i <= sender.rateLimit; i++ {
 		wg.Add(1)
-		go sender.worker(i, sendJobs, &wg)
+		go sender.worker(i, &wg)
 	}
 
 	ticker := time.NewTicker(sender.reportInterval)
@@ -76,14 +101,71 @@ func (sender *Sender) InitSender() {
 		zap.L().Info("Sending metrics")
 		metric, ok := <-sender.collector.metrics
 		if !ok {
-			close(sendJobs)
+			sender.stop()
 			wg.Wait()
 			return
 		}
-		sendJobs <- metric
+		sender.jobs <- sendJob{metrics: metric}
 	}
 }
 
+// stop close jobs of workers, retries scheduled later are dropped
+func (sender *Sender) stop() {
+	sender.lock.Lock()
+	defer sender.lock.Unlock()
+
+	sender.stopped = true
+	close(sender.jobs)
+}
+
+// retry put job back to workers after delay of its attempt, so worker is not blocked while waiting
+func (sender *Sender) retry(job sendJob) {
+	delay := sender.retryDelays[job.attempt-1]
+	time.AfterFunc(delay, func() {
+		sender.lock.Lock()
+		defer sender.lock.Unlock()
+
+		if sender.stopped {
+			zap.L().Warn("Dropped retry of metrics, sender is stopped", zap.Int("metrics", len(job.metrics)))
+			return
+		}
+		select {
+		case sender.jobs <- job:
+		default:
+			zap.L().Warn("Dropped retry of metrics, send queue is full", zap.Int("metrics", len(job.metrics)))
+		}
+	})
+}
+
+// send metrics of job. Only metrics which did not reach server storage are retried: request which failed to connect
+// and metrics rejected by server as retryable. Request which may be stored by server is not sent again,
+// so counters are not incremented twice. Returns nil when metrics are stored or scheduled for retry
+func (sender *Sender) send(job sendJob) error {
+	err := sender.sendMetrics(job.metrics)
+	if err == nil {
+		return nil
+	}
+
+	var retryable *RetryableMetricsError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &retryable):
+		job.metrics = retryable.Metrics
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+	default:
+		return err
+	}
+
+	if job.attempt >= len(sender.retryDelays) {
+		return err
+	}
+
+	job.attempt++
+	zap.L().Warn("Failed to send metrics, retrying", zap.Error(err), zap.Int("attempt", job.attempt))
+	sender.retry(job)
+	return nil
+}
+
 func (sender *Sender) sendMetrics(metrics []model.Metrics) error {
 	jsonData, err := json.Marshal(metrics)
 	if err != nil {
@@ -107,6 +189,10 @@ func (sender *Sender) sendMetrics(metrics []model.Metrics) error {
 
 	request := sender.client.R()
 
+	if sender.batchMode != "" {
+		request.SetHeader(model.BatchModeHeader, sender.batchMode)
+	}
+
 	if sender.key != "" {
 		var hash = calculateHash(jsonData, sender.key)
 		request.SetHeader("HashSHA256", hash)
@@ -123,8 +209,49 @@ func (sender *Sender) sendMetrics(metrics []model.Metrics) error {
 		return fmt.Errorf("failed to send metric %w", err)
 	}
 	if response.StatusCode() != http.StatusOK {
+		// Server describes rejected request with problem+json body
+		if details, ok := problem.Read(response.Header().Get("Content-Type"), bytes.NewReader(response.Body())); ok {
+			return fmt.Errorf("failed to send metric, StatusCode: %d: %w", response.StatusCode(), details)
+		}
 		return fmt.Errorf("failed to send metric, StatusCode: %d", response.StatusCode())
 	}
+	if sender.batchMode == model.BatchModePartial {
+		return readBatchResult(response.Body(), metrics)
+	}
+	return nil
+}
+
+// readBatchResult log metrics rejected by server and return ones which can be sent again
+func readBatchResult(body []byte, metrics []model.Metrics) error {
+	if len(body) == 0 {
+		return nil
+	}
+
+	var result model.BatchResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to read batch result: %w", err)
+	}
+
+	var retryable []model.Metrics
+	for _, rejected := range result.Rejected {
+		if rejected.Index < 0 || rejected.Index >= len(metrics) {
+			continue
+		}
+		if rejected.Retryable {
+			retryable = append(retryable, metrics[rejected.Index])
+			continue
+		}
+		zap.L().Warn("Metric rejected by server",
+			zap.String("name", rejected.ID),
+			zap.String("type", rejected.MType),
+			zap.String("code", rejected.Code),
+			zap.String("field", rejected.Field),
+			zap.String("detail", rejected.Detail))
+	}
+
+	if len(retryable) > 0 {
+		return &RetryableMetricsError{Metrics: retryable}
+	}
 	return nil
 }
 
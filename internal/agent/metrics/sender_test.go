@@ -8,6 +8,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -16,6 +17,7 @@ import (
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
 )
 
 func TestNewSender(t *testing.T) {
@@ -26,13 +28,15 @@ func TestNewSender(t *testing.T) {
 
 	collector := &Collector{}
 
-	sender := NewSender(url, key, rateLimit, reportInterval, nil, collector)
+	sender := NewSender(url, key, rateLimit, reportInterval, model.BatchModePartial, nil, collector)
 
 	assert.NotNil(t, sender)
 	assert.Equal(t, "http://"+url, sender.client.BaseURL)
 	assert.Equal(t, key, sender.key)
 	assert.Equal(t, rateLimit, sender.rateLimit)
 	assert.Equal(t, time.Duration(reportInterval)*time.Second, sender.reportInterval)
+	assert.Equal(t, model.BatchModePartial, sender.batchMode)
+	assert.Equal(t, rateLimit, cap(sender.jobs))
 }
 
 func TestSender_SendMetrics(t *testing.T) {
@@ -83,6 +87,25 @@ func TestSender_SendMetrics(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSender_SendMetrics_Problem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem.New(http.StatusBadRequest, problem.MissingValue, "counter PollCount without delta").
+			WithField("delta").WithMetric("PollCount").Write(w, r)
+	}))
+	defer server.Close()
+
+	sender := &Sender{client: resty.New().SetBaseURL(server.URL)}
+
+	err := sender.sendMetrics([]model.Metrics{{ID: "PollCount", MType: "counter"}})
+
+	var details *problem.Problem
+	assert.ErrorAs(t, err, &details)
+	assert.Equal(t, problem.MissingValue, details.Code)
+	assert.Equal(t, "delta", details.Field)
+	assert.Equal(t, "PollCount", details.MetricID)
+	assert.ErrorContains(t, err, "StatusCode: 400: 400 missing_value of PollCount in field delta")
+}
+
 func TestSender_CalculateHash(t *testing.T) {
 	data := []byte("test data")
 	key := "testkey"
@@ -111,3 +134,179 @@ func TestSender_CompressBody(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, data, decompressedData)
 }
+
+func TestSender_Send(t *testing.T) {
+	first, second, third := 1.0, 2.0, 3.0
+	metrics := []model.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &first},
+		{ID: "PollCount", MType: "counter"},
+		{ID: "Frees", MType: "gauge", Value: &second},
+		{ID: "Lookups", MType: "gauge", Value: &third},
+	}
+
+	// writeResult respond with result of partial batch, rejected metrics are listed in first response only
+	writeResult := func(rejected ...model.RejectedMetric) func(w http.ResponseWriter, r *http.Request, request int, received []model.Metrics) {
+		return func(w http.ResponseWriter, r *http.Request, request int, received []model.Metrics) {
+			result := model.BatchResult{Accepted: len(received), Rejected: []model.RejectedMetric{}}
+			if request == 1 {
+				result.Accepted = len(received) - len(rejected)
+				result.Rejected = rejected
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			assert.NoError(t, json.NewEncoder(w).Encode(&result))
+		}
+	}
+
+	tests := []struct {
+		name         string
+		batchMode    string
+		respond      func(w http.ResponseWriter, r *http.Request, request int, received []model.Metrics)
+		wantRequests [][]model.Metrics
+		wantErr      bool
+	}{
+		{
+			name: "Atomic batch is stored",
+			respond: func(w http.ResponseWriter, r *http.Request, request int, received []model.Metrics) {
+				w.WriteHeader(http.StatusOK)
+			},
+			wantRequests: [][]model.Metrics{metrics},
+		},
+		{
+			name:      "Only retryable metrics are sent again",
+			batchMode: model.BatchModePartial,
+			respond: writeResult(
+				model.RejectedMetric{Index: 1, ID: "PollCount", MType: "counter", Code: string(problem.MissingValue), Field: "delta"},
+				model.RejectedMetric{Index: 3, ID: "Lookups", MType: "gauge", Code: string(problem.StorageError), Retryable: true},
+			),
+			wantRequests: [][]model.Metrics{metrics, {metrics[3]}},
+		},
+		{
+			name:      "Non retryable metrics are dropped",
+			batchMode: model.BatchModePartial,
+			respond: writeResult(
+				model.RejectedMetric{Index: 1, ID: "PollCount", MType: "counter", Code: string(problem.MissingValue), Field: "delta"},
+				model.RejectedMetric{Index: 3, ID: "Lookups", MType: "gauge", Code: string(problem.InvalidValue), Field: "value"},
+			),
+			wantRequests: [][]model.Metrics{metrics},
+		},
+		{
+			name: "Client error problem stops retries",
+			respond: func(w http.ResponseWriter, r *http.Request, request int, received []model.Metrics) {
+				problem.New(http.StatusBadRequest, problem.MissingValue, "missing delta").WithMetric("PollCount").Write(w, r)
+			},
+			wantRequests: [][]model.Metrics{metrics},
+			wantErr:      true,
+		},
+		{
+			name: "Server error is not retried",
+			respond: func(w http.ResponseWriter, r *http.Request, request int, received []model.Metrics) {
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to update metrics").Write(w, r)
+			},
+			wantRequests: [][]model.Metrics{metrics},
+			wantErr:      true,
+		},
+		{
+			name: "Gateway timeout is not retried",
+			respond: func(w http.ResponseWriter, r *http.Request, request int, received []model.Metrics) {
+				// Server may have stored the batch after proxy gave up
+				w.WriteHeader(http.StatusGatewayTimeout)
+			},
+			wantRequests: [][]model.Metrics{metrics},
+			wantErr:      true,
+		},
+		{
+			name:      "Retryable metrics are retried until attempts are exhausted",
+			batchMode: model.BatchModePartial,
+			respond: func(w http.ResponseWriter, r *http.Request, request int, received []model.Metrics) {
+				result := model.BatchResult{Rejected: []model.RejectedMetric{}}
+				for i, metric := range received {
+					result.Rejected = append(result.Rejected,
+						model.RejectedMetric{Index: i, ID: metric.ID, MType: metric.MType, Code: string(problem.StorageError), Retryable: true})
+				}
+				w.Header().Set("Content-Type", "application/json")
+				assert.NoError(t, json.NewEncoder(w).Encode(&result))
+			},
+			wantRequests: [][]model.Metrics{metrics, metrics, metrics},
+			wantErr:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests [][]model.Metrics
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, test.batchMode, r.Header.Get(model.BatchModeHeader))
+
+				gzipReader, err := gzip.NewReader(r.Body)
+				assert.NoError(t, err)
+				var received []model.Metrics
+				assert.NoError(t, json.NewDecoder(gzipReader).Decode(&received))
+				requests = append(requests, received)
+
+				test.respond(w, r, len(requests), received)
+			}))
+			defer server.Close()
+
+			sender := &Sender{
+				client:      resty.New().SetBaseURL(server.URL),
+				batchMode:   test.batchMode,
+				retryDelays: []time.Duration{time.Millisecond, time.Millisecond},
+				jobs:        make(chan sendJob, 1),
+			}
+
+			_, err := sendWithRetries(sender, metrics)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.wantRequests, requests)
+		})
+	}
+}
+
+func TestSender_Send_RetriedOnDialError(t *testing.T) {
+	// Address without listener, so request does not reach server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	assert.NoError(t, listener.Close())
+
+	sender := &Sender{
+		client:      resty.New().SetBaseURL("http://" + address),
+		retryDelays: []time.Duration{time.Millisecond, time.Millisecond},
+		jobs:        make(chan sendJob, 1),
+	}
+
+	attempts, err := sendWithRetries(sender, []model.Metrics{{ID: "PollCount", MType: "counter"}})
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestSender_Retry_Stopped(t *testing.T) {
+	sender := &Sender{
+		retryDelays: []time.Duration{time.Millisecond},
+		jobs:        make(chan sendJob, 1),
+	}
+	sender.stop()
+
+	// Retry scheduled before stop is dropped instead of being sent to closed channel
+	sender.retry(sendJob{metrics: []model.Metrics{{ID: "PollCount", MType: "counter"}}, attempt: 1})
+	time.Sleep(10 * time.Millisecond)
+}
+
+// sendWithRetries send metrics and their retries as worker does, returns number of attempts and error of the last one
+func sendWithRetries(sender *Sender, metrics []model.Metrics) (int, error) {
+	attempts := 1
+	err := sender.send(sendJob{metrics: metrics})
+	for {
+		select {
+		case job := <-sender.jobs:
+			attempts++
+			err = sender.send(job)
+		case <-time.After(100 * time.Millisecond):
+			return attempts, err
+		}
+	}
+}
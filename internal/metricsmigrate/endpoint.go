@@ -0,0 +1,246 @@
+// Package metricsmigrate copies metrics between storage backends: server snapshot files,
+// plain JSON lines files, PostgreSQL and SQLite databases
+package metricsmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+)
+
+const (
+	fileScheme  = "file://"
+	jsonlScheme = "jsonl://"
+)
+
+var ErrUnsupportedEndpoint = errors.New("unsupported endpoint")
+
+// Endpoint backend which metrics are loaded from and stored to.
+// Counters hold accumulated values in Delta
+type Endpoint interface {
+	// Load returns all metrics, endpoint without data returns empty slice
+	Load(ctx context.Context) ([]model.Metrics, error)
+	// Store write metrics in batches of {batchSize}
+	Store(ctx context.Context, metrics []model.Metrics, batchSize int) error
+	// Prepare make endpoint ready to store metrics, e.g. apply database migrations
+	Prepare(ctx context.Context) error
+	Close() error
+	String() string
+}
+
+// Open endpoint by URI:
+//   - postgres://... or postgresql://... database DSN
+//   - sqlite://path SQLite database file
+//   - jsonl://path plain file with one JSON metric per line
+//   - file://path or path snapshot file written by server, compressed with gzip when {gzip} is set
+func Open(uri string, gzip bool) (Endpoint, error) {
+	switch {
+	case strings.HasPrefix(uri, "postgres://"), strings.HasPrefix(uri, "postgresql://"):
+		dbStorage, err := storage.NewDBStorage(uri, storage.PoolConfig{})
+		if err != nil {
+			return nil, err
+		}
+		return &dbEndpoint{storage: dbStorage, name: "postgres"}, nil
+	case storage.IsSQLiteDsn(uri):
+		path, _, _ := strings.Cut(strings.TrimPrefix(uri, storage.SQLiteScheme), "?")
+		if path == "" {
+			return nil, fmt.Errorf("%w: %s", storage.ErrInvalidSQLiteDsn, uri)
+		}
+		return &sqliteEndpoint{dbEndpoint: dbEndpoint{name: uri}, path: path}, nil
+	case strings.HasPrefix(uri, jsonlScheme):
+		return &jsonlEndpoint{path: strings.TrimPrefix(uri, jsonlScheme)}, nil
+	case strings.HasPrefix(uri, fileScheme):
+		return &snapshotEndpoint{path: strings.TrimPrefix(uri, fileScheme), gzip: gzip}, nil
+	case strings.Contains(uri, "://"):
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEndpoint, uri)
+	default:
+		return &snapshotEndpoint{path: uri, gzip: gzip}, nil
+	}
+}
+
+// snapshotEndpoint snapshot file with header and checksum, file is replaced entirely on store
+type snapshotEndpoint struct {
+	path string
+	gzip bool
+}
+
+func (endpoint *snapshotEndpoint) Load(_ context.Context) ([]model.Metrics, error) {
+	metrics, err := storage.ReadSnapshot(endpoint.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []model.Metrics{}, nil
+	}
+	return metrics, err
+}
+
+func (endpoint *snapshotEndpoint) Store(ctx context.Context, metrics []model.Metrics, _ int) error {
+	return storage.WriteSnapshot(ctx, metrics, endpoint.path, endpoint.gzip)
+}
+
+func (endpoint *snapshotEndpoint) Prepare(_ context.Context) error {
+	return nil
+}
+
+func (endpoint *snapshotEndpoint) Close() error {
+	return nil
+}
+
+func (endpoint *snapshotEndpoint) String() string {
+	return fileScheme + endpoint.path
+}
+
+// jsonlEndpoint file with one JSON metric per line without header, read and written with storage.Reader and storage.Writer
+type jsonlEndpoint struct {
+	path string
+}
+
+func (endpoint *jsonlEndpoint) Load(_ context.Context) ([]model.Metrics, error) {
+	if _, err := os.Stat(endpoint.path); errors.Is(err, os.ErrNotExist) {
+		return []model.Metrics{}, nil
+	}
+
+	reader, err := storage.NewReader(endpoint.path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	metrics := make([]model.Metrics, 0)
+	for {
+		metric, err := reader.ReadMetric()
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", endpoint.path, err)
+		}
+		if metric == nil {
+			return metrics, nil
+		}
+		metrics = append(metrics, *metric)
+	}
+}
+
+func (endpoint *jsonlEndpoint) Store(ctx context.Context, metrics []model.Metrics, _ int) error {
+	writer, err := storage.NewWriter(endpoint.path)
+	if err != nil {
+		return err
+	}
+
+	for _, metric := range metrics {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(err, writer.Close())
+		}
+		if err := writer.WriteMetric(metric); err != nil {
+			return errors.Join(err, writer.Close())
+		}
+	}
+
+	return writer.Close()
+}
+
+func (endpoint *jsonlEndpoint) Prepare(_ context.Context) error {
+	return nil
+}
+
+func (endpoint *jsonlEndpoint) Close() error {
+	return nil
+}
+
+func (endpoint *jsonlEndpoint) String() string {
+	return jsonlScheme + endpoint.path
+}
+
+// databaseStorage storage of PostgreSQL or SQLite database
+type databaseStorage interface {
+	storage.Storage
+	storage.Exporter
+	storage.Lifecycle
+	MigrateUp(ctx context.Context) error
+}
+
+// dbEndpoint PostgreSQL or SQLite database, metrics are written with storage batches,
+// so counters of non-empty database are added to stored values
+type dbEndpoint struct {
+	storage databaseStorage
+	name    string
+}
+
+func (endpoint *dbEndpoint) Load(ctx context.Context) ([]model.Metrics, error) {
+	metrics, err := endpoint.storage.ExportMetrics(ctx)
+	if errors.Is(err, storage.ErrSchemaMissing) {
+		// Database without migrations has no metrics, e.g. target of dry run
+		return []model.Metrics{}, nil
+	}
+	return metrics, err
+}
+
+func (endpoint *dbEndpoint) Store(ctx context.Context, metrics []model.Metrics, batchSize int) error {
+	for start := 0; start < len(metrics); start += batchSize {
+		end := min(start+batchSize, len(metrics))
+		if err := endpoint.storage.UpdateMetrics(ctx, metrics[start:end]); err != nil {
+			return fmt.Errorf("could not store metrics %d-%d: %w", start, end, err)
+		}
+	}
+	return nil
+}
+
+func (endpoint *dbEndpoint) Prepare(ctx context.Context) error {
+	return endpoint.storage.MigrateUp(ctx)
+}
+
+func (endpoint *dbEndpoint) Close() error {
+	return endpoint.storage.Stop(context.Background())
+}
+
+func (endpoint *dbEndpoint) String() string {
+	return endpoint.name
+}
+
+// sqliteEndpoint SQLite database file. Database is opened when file exists or on Prepare,
+// so dry run into missing file does not create it
+type sqliteEndpoint struct {
+	dbEndpoint
+	path string
+}
+
+func (endpoint *sqliteEndpoint) open() error {
+	if endpoint.storage != nil {
+		return nil
+	}
+
+	sqliteStorage, err := storage.NewSQLiteStorage(endpoint.name)
+	if err != nil {
+		return err
+	}
+	endpoint.storage = sqliteStorage
+	return nil
+}
+
+func (endpoint *sqliteEndpoint) Load(ctx context.Context) ([]model.Metrics, error) {
+	if endpoint.storage == nil {
+		if _, err := os.Stat(endpoint.path); errors.Is(err, os.ErrNotExist) {
+			return []model.Metrics{}, nil
+		}
+	}
+
+	if err := endpoint.open(); err != nil {
+		return nil, err
+	}
+	return endpoint.dbEndpoint.Load(ctx)
+}
+
+func (endpoint *sqliteEndpoint) Prepare(ctx context.Context) error {
+	if err := endpoint.open(); err != nil {
+		return err
+	}
+	return endpoint.dbEndpoint.Prepare(ctx)
+}
+
+func (endpoint *sqliteEndpoint) Close() error {
+	if endpoint.storage == nil {
+		return nil
+	}
+	return endpoint.dbEndpoint.Close()
+}
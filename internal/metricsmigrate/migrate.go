@@ -0,0 +1,176 @@
+package metricsmigrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+)
+
+// DefaultBatchSize number of metrics written to database with one batch
+const DefaultBatchSize = 1000
+
+var (
+	ErrTargetNotEmpty     = errors.New("target is not empty")
+	ErrVerificationFailed = errors.New("verification failed")
+)
+
+// Options of migration
+type Options struct {
+	// BatchSize number of metrics written with one batch
+	BatchSize int
+	// DryRun only read source and target and report what would be written
+	DryRun bool
+	// Verify reload target after writing and compare its summary with source
+	Verify bool
+	// Force write into non-empty target
+	Force bool
+}
+
+// Summary number of series of every type and checksum of all values, independent of order of metrics
+type Summary struct {
+	Checksum   string `json:"checksum"`
+	Gauges     int    `json:"gauges"`
+	Counters   int    `json:"counters"`
+	Histograms int    `json:"histograms"`
+}
+
+// Total number of series
+func (summary Summary) Total() int {
+	return summary.Gauges + summary.Counters + summary.Histograms
+}
+
+func (summary Summary) String() string {
+	return fmt.Sprintf("gauges=%d counters=%d histograms=%d checksum=%s",
+		summary.Gauges, summary.Counters, summary.Histograms, summary.Checksum)
+}
+
+// Report result of migration
+type Report struct {
+	Source  Summary
+	Target  Summary
+	Written bool
+	// Verified target summary after writing is equal to source summary
+	Verified bool
+}
+
+// Summarize count series and compute SHA-256 of sorted canonical lines "type series value"
+func Summarize(metrics []model.Metrics) (Summary, error) {
+	var summary Summary
+
+	lines := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		var value string
+		switch metric.MType {
+		case string(model.Gauge):
+			if metric.Value == nil {
+				return Summary{}, fmt.Errorf("%w: gauge %s without value", storage.ErrInvalidMetric, metric.ID)
+			}
+			summary.Gauges++
+			value = strconv.FormatFloat(*metric.Value, 'g', -1, 64)
+		case string(model.Counter):
+			if metric.Delta == nil {
+				return Summary{}, fmt.Errorf("%w: counter %s without value", storage.ErrInvalidMetric, metric.ID)
+			}
+			summary.Counters++
+			value = strconv.FormatInt(*metric.Delta, 10)
+		case string(model.Histogram):
+			if metric.Histogram == nil {
+				return Summary{}, fmt.Errorf("%w: histogram %s without value", storage.ErrInvalidMetric, metric.ID)
+			}
+			summary.Histograms++
+			value = metric.Histogram.String()
+		default:
+			return Summary{}, fmt.Errorf("%w: unknown type %q of %s", storage.ErrInvalidMetric, metric.MType, metric.ID)
+		}
+
+		lines = append(lines, metric.MType+" "+model.SeriesKey(metric.ID, metric.Labels)+" "+value)
+	}
+
+	sort.Strings(lines)
+
+	hash := sha256.New()
+	for _, line := range lines {
+		hash.Write([]byte(line))
+		hash.Write([]byte{'\n'})
+	}
+	summary.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	return summary, nil
+}
+
+// Run copy all metrics of {source} into {target}.
+// Target must be empty unless Force is set, dry run reads both endpoints and writes nothing
+func Run(ctx context.Context, source Endpoint, target Endpoint, options Options) (Report, error) {
+	var report Report
+
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultBatchSize
+	}
+
+	metrics, err := source.Load(ctx)
+	if err != nil {
+		return report, fmt.Errorf("could not load %s: %w", source, err)
+	}
+
+	report.Source, err = Summarize(metrics)
+	if err != nil {
+		return report, fmt.Errorf("invalid metrics in %s: %w", source, err)
+	}
+
+	if !options.DryRun {
+		if err := target.Prepare(ctx); err != nil {
+			return report, fmt.Errorf("could not prepare %s: %w", target, err)
+		}
+	}
+
+	existing, err := target.Load(ctx)
+	if err != nil {
+		return report, fmt.Errorf("could not load %s: %w", target, err)
+	}
+
+	report.Target, err = Summarize(existing)
+	if err != nil {
+		return report, fmt.Errorf("invalid metrics in %s: %w", target, err)
+	}
+
+	if report.Target.Total() > 0 && !options.Force {
+		return report, fmt.Errorf("%w: %s has %d series", ErrTargetNotEmpty, target, report.Target.Total())
+	}
+
+	if options.DryRun {
+		return report, nil
+	}
+
+	if err := target.Store(ctx, metrics, options.BatchSize); err != nil {
+		return report, fmt.Errorf("could not store metrics into %s: %w", target, err)
+	}
+	report.Written = true
+
+	if !options.Verify {
+		return report, nil
+	}
+
+	stored, err := target.Load(ctx)
+	if err != nil {
+		return report, fmt.Errorf("could not reload %s: %w", target, err)
+	}
+
+	report.Target, err = Summarize(stored)
+	if err != nil {
+		return report, fmt.Errorf("invalid metrics in %s: %w", target, err)
+	}
+
+	if report.Target != report.Source {
+		return report, fmt.Errorf("%w: source %s, target %s", ErrVerificationFailed, report.Source, report.Target)
+	}
+	report.Verified = true
+
+	return report, nil
+}
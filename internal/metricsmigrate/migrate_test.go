@@ -0,0 +1,194 @@
+package metricsmigrate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+)
+
+func testMetrics() []model.Metrics {
+	gauge := 1.5
+	counter := int64(42)
+	histogram := &model.HistogramData{Bounds: []float64{1, 10}, Counts: []int64{0, 1, 0}, Sum: 5, Count: 1}
+
+	return []model.Metrics{
+		{ID: "Alloc", MType: string(model.Gauge), Value: &gauge, Labels: model.Labels{"host": "a"}},
+		{ID: "PollCount", MType: string(model.Counter), Delta: &counter},
+		{ID: "Latency", MType: string(model.Histogram), Histogram: histogram},
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	metrics := testMetrics()
+
+	summary, err := Summarize(metrics)
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Gauges)
+	assert.Equal(t, 1, summary.Counters)
+	assert.Equal(t, 1, summary.Histograms)
+
+	// Order of metrics does not change checksum
+	reversed := []model.Metrics{metrics[2], metrics[1], metrics[0]}
+	reversedSummary, err := Summarize(reversed)
+	require.NoError(t, err)
+	assert.Equal(t, summary, reversedSummary)
+
+	changed := 2.5
+	metrics[0].Value = &changed
+	changedSummary, err := Summarize(metrics)
+	require.NoError(t, err)
+	assert.NotEqual(t, summary.Checksum, changedSummary.Checksum)
+
+	_, err = Summarize([]model.Metrics{{ID: "PollCount", MType: string(model.Counter)}})
+	assert.ErrorIs(t, err, storage.ErrInvalidMetric)
+}
+
+func TestRun_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	snapshotPath := filepath.Join(dir, "metrics.json")
+	copyPath := filepath.Join(dir, "copy.json")
+
+	err := storage.WriteSnapshot(context.Background(), testMetrics(), snapshotPath, true)
+	require.NoError(t, err)
+
+	source, err := Open(snapshotPath, true)
+	require.NoError(t, err)
+	jsonl, err := Open("jsonl://"+filepath.Join(dir, "metrics.jsonl"), false)
+	require.NoError(t, err)
+	target, err := Open("file://"+copyPath, false)
+	require.NoError(t, err)
+
+	report, err := Run(context.Background(), source, jsonl, Options{Verify: true})
+	require.NoError(t, err)
+	assert.True(t, report.Written)
+	assert.True(t, report.Verified)
+	assert.Equal(t, 3, report.Source.Total())
+
+	report, err = Run(context.Background(), jsonl, target, Options{Verify: true})
+	require.NoError(t, err)
+	assert.True(t, report.Verified)
+
+	copied, err := storage.ReadSnapshot(copyPath)
+	require.NoError(t, err)
+	summary, err := Summarize(copied)
+	require.NoError(t, err)
+	assert.Equal(t, report.Source, summary)
+}
+
+func TestRun_TargetNotEmpty(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "source.json")
+	targetPath := filepath.Join(dir, "target.json")
+
+	require.NoError(t, storage.WriteSnapshot(context.Background(), testMetrics(), sourcePath, false))
+	require.NoError(t, storage.WriteSnapshot(context.Background(), testMetrics()[:1], targetPath, false))
+
+	source, err := Open(sourcePath, false)
+	require.NoError(t, err)
+	target, err := Open(targetPath, false)
+	require.NoError(t, err)
+
+	report, err := Run(context.Background(), source, target, Options{})
+	assert.ErrorIs(t, err, ErrTargetNotEmpty)
+	assert.False(t, report.Written)
+
+	report, err = Run(context.Background(), source, target, Options{Force: true, Verify: true})
+	require.NoError(t, err)
+	assert.True(t, report.Verified)
+}
+
+func TestRun_DryRun(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "source.json")
+	targetPath := filepath.Join(dir, "target.json")
+
+	require.NoError(t, storage.WriteSnapshot(context.Background(), testMetrics(), sourcePath, false))
+
+	source, err := Open(sourcePath, false)
+	require.NoError(t, err)
+	target, err := Open(targetPath, false)
+	require.NoError(t, err)
+
+	report, err := Run(context.Background(), source, target, Options{DryRun: true, Verify: true})
+	require.NoError(t, err)
+	assert.False(t, report.Written)
+	assert.Equal(t, 3, report.Source.Total())
+	assert.Equal(t, 0, report.Target.Total())
+
+	_, err = os.Stat(targetPath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestRun_DryRunSQLite(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "source.json")
+
+	require.NoError(t, storage.WriteSnapshot(context.Background(), testMetrics(), sourcePath, false))
+
+	// Database without migrations has no metrics
+	emptyPath := filepath.Join(dir, "empty.db")
+	emptyStorage, err := storage.NewSQLiteStorage("sqlite://" + emptyPath)
+	require.NoError(t, err)
+	require.NoError(t, emptyStorage.Ping(context.Background()))
+	require.NoError(t, emptyStorage.Stop(context.Background()))
+
+	tests := []struct {
+		name   string
+		path   string
+		exists bool
+	}{
+		{name: "Missing file", path: filepath.Join(dir, "missing.db")},
+		{name: "Database without schema", path: emptyPath, exists: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, err := Open(sourcePath, false)
+			require.NoError(t, err)
+			target, err := Open("sqlite://"+test.path, false)
+			require.NoError(t, err)
+			defer target.Close()
+
+			report, err := Run(context.Background(), source, target, Options{DryRun: true})
+			require.NoError(t, err)
+			assert.False(t, report.Written)
+			assert.Equal(t, 3, report.Source.Total())
+			assert.Equal(t, 0, report.Target.Total())
+
+			_, err = os.Stat(test.path)
+			if test.exists {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, os.ErrNotExist)
+			}
+		})
+	}
+}
+
+func TestOpen_Unsupported(t *testing.T) {
+	_, err := Open("mysql://localhost/metrics", false)
+	assert.ErrorIs(t, err, ErrUnsupportedEndpoint)
+}
+
+func TestRun_SQLite(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "source.json")
+
+	require.NoError(t, storage.WriteSnapshot(context.Background(), testMetrics(), sourcePath, false))
+
+	source, err := Open(sourcePath, false)
+	require.NoError(t, err)
+	target, err := Open("sqlite://"+filepath.Join(dir, "metrics.db"), false)
+	require.NoError(t, err)
+	defer target.Close()
+
+	report, err := Run(context.Background(), source, target, Options{Verify: true})
+	require.NoError(t, err)
+	assert.True(t, report.Verified)
+}
@@ -5,7 +5,9 @@
 package mock_storage
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	model "github.com/zavtra-na-rabotu/gometrics/internal/model"
@@ -34,119 +36,290 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 	return m.recorder
 }
 
+// Delete mocks base method.
+func (m *MockStorage) Delete(ctx context.Context, mType model.MetricType, name string, labels model.Labels) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, mType, name, labels)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockStorageMockRecorder) Delete(ctx, mType, name, labels interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockStorage)(nil).Delete), ctx, mType, name, labels)
+}
+
+// DeleteByPrefix mocks base method.
+func (m *MockStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteByPrefix", ctx, prefix)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteByPrefix indicates an expected call of DeleteByPrefix.
+func (mr *MockStorageMockRecorder) DeleteByPrefix(ctx, prefix interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteByPrefix", reflect.TypeOf((*MockStorage)(nil).DeleteByPrefix), ctx, prefix)
+}
+
 // GetAllCounter mocks base method.
-func (m *MockStorage) GetAllCounter() (map[string]int64, error) {
+func (m *MockStorage) GetAllCounter(ctx context.Context) (map[string]int64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAllCounter")
+	ret := m.ctrl.Call(m, "GetAllCounter", ctx)
 	ret0, _ := ret[0].(map[string]int64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAllCounter indicates an expected call of GetAllCounter.
-func (mr *MockStorageMockRecorder) GetAllCounter() *gomock.Call {
+func (mr *MockStorageMockRecorder) GetAllCounter(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllCounter", reflect.TypeOf((*MockStorage)(nil).GetAllCounter))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllCounter", reflect.TypeOf((*MockStorage)(nil).GetAllCounter), ctx)
 }
 
 // GetAllGauge mocks base method.
-func (m *MockStorage) GetAllGauge() (map[string]float64, error) {
+func (m *MockStorage) GetAllGauge(ctx context.Context) (map[string]float64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAllGauge")
+	ret := m.ctrl.Call(m, "GetAllGauge", ctx)
 	ret0, _ := ret[0].(map[string]float64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetAllGauge indicates an expected call of GetAllGauge.
-func (mr *MockStorageMockRecorder) GetAllGauge() *gomock.Call {
+func (mr *MockStorageMockRecorder) GetAllGauge(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllGauge", reflect.TypeOf((*MockStorage)(nil).GetAllGauge), ctx)
+}
+
+// GetAllHistogram mocks base method.
+func (m *MockStorage) GetAllHistogram(ctx context.Context) (map[string]model.HistogramData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAllHistogram", ctx)
+	ret0, _ := ret[0].(map[string]model.HistogramData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAllHistogram indicates an expected call of GetAllHistogram.
+func (mr *MockStorageMockRecorder) GetAllHistogram(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllGauge", reflect.TypeOf((*MockStorage)(nil).GetAllGauge))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllHistogram", reflect.TypeOf((*MockStorage)(nil).GetAllHistogram), ctx)
 }
 
 // GetCounter mocks base method.
-func (m *MockStorage) GetCounter(name string) (int64, error) {
+func (m *MockStorage) GetCounter(ctx context.Context, name string, labels model.Labels) (int64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCounter", name)
+	ret := m.ctrl.Call(m, "GetCounter", ctx, name, labels)
 	ret0, _ := ret[0].(int64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetCounter indicates an expected call of GetCounter.
-func (mr *MockStorageMockRecorder) GetCounter(name interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) GetCounter(ctx, name, labels interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCounter", reflect.TypeOf((*MockStorage)(nil).GetCounter), name)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCounter", reflect.TypeOf((*MockStorage)(nil).GetCounter), ctx, name, labels)
 }
 
 // GetGauge mocks base method.
-func (m *MockStorage) GetGauge(name string) (float64, error) {
+func (m *MockStorage) GetGauge(ctx context.Context, name string, labels model.Labels) (float64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetGauge", name)
+	ret := m.ctrl.Call(m, "GetGauge", ctx, name, labels)
 	ret0, _ := ret[0].(float64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetGauge indicates an expected call of GetGauge.
-func (mr *MockStorageMockRecorder) GetGauge(name interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) GetGauge(ctx, name, labels interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGauge", reflect.TypeOf((*MockStorage)(nil).GetGauge), ctx, name, labels)
+}
+
+// GetHistogram mocks base method.
+func (m *MockStorage) GetHistogram(ctx context.Context, name string, labels model.Labels) (model.HistogramData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHistogram", ctx, name, labels)
+	ret0, _ := ret[0].(model.HistogramData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHistogram indicates an expected call of GetHistogram.
+func (mr *MockStorageMockRecorder) GetHistogram(ctx, name, labels interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHistogram", reflect.TypeOf((*MockStorage)(nil).GetHistogram), ctx, name, labels)
+}
+
+// GetHistory mocks base method.
+func (m *MockStorage) GetHistory(ctx context.Context, mType model.MetricType, name string, labels model.Labels, from, to time.Time) ([]model.Sample, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHistory", ctx, mType, name, labels, from, to)
+	ret0, _ := ret[0].([]model.Sample)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHistory indicates an expected call of GetHistory.
+func (mr *MockStorageMockRecorder) GetHistory(ctx, mType, name, labels, from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHistory", reflect.TypeOf((*MockStorage)(nil).GetHistory), ctx, mType, name, labels, from, to)
+}
+
+// GetMetrics mocks base method.
+func (m *MockStorage) GetMetrics(ctx context.Context, metrics []model.Metrics) ([]model.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetrics", ctx, metrics)
+	ret0, _ := ret[0].([]model.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetrics indicates an expected call of GetMetrics.
+func (mr *MockStorageMockRecorder) GetMetrics(ctx, metrics interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetrics", reflect.TypeOf((*MockStorage)(nil).GetMetrics), ctx, metrics)
+}
+
+// GetRollups mocks base method.
+func (m *MockStorage) GetRollups(ctx context.Context, mType model.MetricType, name string, labels model.Labels, resolution time.Duration, from, to time.Time) ([]model.Rollup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRollups", ctx, mType, name, labels, resolution, from, to)
+	ret0, _ := ret[0].([]model.Rollup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRollups indicates an expected call of GetRollups.
+func (mr *MockStorageMockRecorder) GetRollups(ctx, mType, name, labels, resolution, from, to interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGauge", reflect.TypeOf((*MockStorage)(nil).GetGauge), name)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRollups", reflect.TypeOf((*MockStorage)(nil).GetRollups), ctx, mType, name, labels, resolution, from, to)
+}
+
+// QueryMetrics mocks base method.
+func (m *MockStorage) QueryMetrics(ctx context.Context, query model.MetricsQuery) ([]model.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryMetrics", ctx, query)
+	ret0, _ := ret[0].([]model.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryMetrics indicates an expected call of QueryMetrics.
+func (mr *MockStorageMockRecorder) QueryMetrics(ctx, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryMetrics", reflect.TypeOf((*MockStorage)(nil).QueryMetrics), ctx, query)
 }
 
 // UpdateCounter mocks base method.
-func (m *MockStorage) UpdateCounter(name string, metric int64) error {
+func (m *MockStorage) UpdateCounter(ctx context.Context, name string, labels model.Labels, metric int64) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateCounter", name, metric)
+	ret := m.ctrl.Call(m, "UpdateCounter", ctx, name, labels, metric)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateCounter indicates an expected call of UpdateCounter.
-func (mr *MockStorageMockRecorder) UpdateCounter(name, metric interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) UpdateCounter(ctx, name, labels, metric interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCounter", reflect.TypeOf((*MockStorage)(nil).UpdateCounter), name, metric)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCounter", reflect.TypeOf((*MockStorage)(nil).UpdateCounter), ctx, name, labels, metric)
 }
 
 // UpdateCounterAndReturn mocks base method.
-func (m *MockStorage) UpdateCounterAndReturn(name string, metric int64) (int64, error) {
+func (m *MockStorage) UpdateCounterAndReturn(ctx context.Context, name string, labels model.Labels, metric int64) (int64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateCounterAndReturn", name, metric)
+	ret := m.ctrl.Call(m, "UpdateCounterAndReturn", ctx, name, labels, metric)
 	ret0, _ := ret[0].(int64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // UpdateCounterAndReturn indicates an expected call of UpdateCounterAndReturn.
-func (mr *MockStorageMockRecorder) UpdateCounterAndReturn(name, metric interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) UpdateCounterAndReturn(ctx, name, labels, metric interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCounterAndReturn", reflect.TypeOf((*MockStorage)(nil).UpdateCounterAndReturn), name, metric)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCounterAndReturn", reflect.TypeOf((*MockStorage)(nil).UpdateCounterAndReturn), ctx, name, labels, metric)
 }
 
 // UpdateGauge mocks base method.
-func (m *MockStorage) UpdateGauge(name string, metric float64) error {
+func (m *MockStorage) UpdateGauge(ctx context.Context, name string, labels model.Labels, metric float64) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateGauge", name, metric)
+	ret := m.ctrl.Call(m, "UpdateGauge", ctx, name, labels, metric)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateGauge indicates an expected call of UpdateGauge.
-func (mr *MockStorageMockRecorder) UpdateGauge(name, metric interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) UpdateGauge(ctx, name, labels, metric interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateGauge", reflect.TypeOf((*MockStorage)(nil).UpdateGauge), ctx, name, labels, metric)
+}
+
+// UpdateHistogram mocks base method.
+func (m *MockStorage) UpdateHistogram(ctx context.Context, name string, labels model.Labels, metric model.HistogramData) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateHistogram", ctx, name, labels, metric)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateHistogram indicates an expected call of UpdateHistogram.
+func (mr *MockStorageMockRecorder) UpdateHistogram(ctx, name, labels, metric interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateGauge", reflect.TypeOf((*MockStorage)(nil).UpdateGauge), name, metric)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateHistogram", reflect.TypeOf((*MockStorage)(nil).UpdateHistogram), ctx, name, labels, metric)
 }
 
 // UpdateMetrics mocks base method.
-func (m *MockStorage) UpdateMetrics(arg0 []model.Metrics) error {
+func (m *MockStorage) UpdateMetrics(ctx context.Context, metrics []model.Metrics) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateMetrics", arg0)
+	ret := m.ctrl.Call(m, "UpdateMetrics", ctx, metrics)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateMetrics indicates an expected call of UpdateMetrics.
-func (mr *MockStorageMockRecorder) UpdateMetrics(arg0 interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) UpdateMetrics(ctx, metrics interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMetrics", reflect.TypeOf((*MockStorage)(nil).UpdateMetrics), ctx, metrics)
+}
+
+// MockExporter is a mock of Exporter interface.
+type MockExporter struct {
+	ctrl     *gomock.Controller
+	recorder *MockExporterMockRecorder
+}
+
+// MockExporterMockRecorder is the mock recorder for MockExporter.
+type MockExporterMockRecorder struct {
+	mock *MockExporter
+}
+
+// NewMockExporter creates a new mock instance.
+func NewMockExporter(ctrl *gomock.Controller) *MockExporter {
+	mock := &MockExporter{ctrl: ctrl}
+	mock.recorder = &MockExporterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockExporter) EXPECT() *MockExporterMockRecorder {
+	return m.recorder
+}
+
+// ExportMetrics mocks base method.
+func (m *MockExporter) ExportMetrics(ctx context.Context) ([]model.Metrics, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportMetrics", ctx)
+	ret0, _ := ret[0].([]model.Metrics)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportMetrics indicates an expected call of ExportMetrics.
+func (mr *MockExporterMockRecorder) ExportMetrics(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMetrics", reflect.TypeOf((*MockStorage)(nil).UpdateMetrics), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportMetrics", reflect.TypeOf((*MockExporter)(nil).ExportMetrics), ctx)
 }
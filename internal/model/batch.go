@@ -0,0 +1,32 @@
+package model
+
+// BatchModeHeader request header to choose how batch of metrics is applied
+const BatchModeHeader = "X-Batch-Mode"
+
+// MirrorFailedHeader response header of update which is stored by server, but not copied to its secondary storage
+const MirrorFailedHeader = "X-Mirror-Failed"
+
+const (
+	// BatchModeAtomic whole batch is stored or rejected, used when header is not set
+	BatchModeAtomic = "atomic"
+	// BatchModePartial valid metrics are stored, invalid are listed in BatchResult
+	BatchModePartial = "partial"
+)
+
+// BatchResult response of batch update in partial mode
+type BatchResult struct {
+	Accepted   int              `json:"accepted"`             // количество сохранённых метрик
+	Rejected   []RejectedMetric `json:"rejected"`             // отклонённые метрики в порядке запроса
+	Unmirrored []int            `json:"unmirrored,omitempty"` // позиции сохранённых метрик, которые не скопированы во вторичное хранилище
+}
+
+// RejectedMetric metric of batch which was not stored
+type RejectedMetric struct {
+	Index     int    `json:"index"`           // позиция метрики в запросе
+	ID        string `json:"id"`              // имя метрики
+	MType     string `json:"type"`            // тип метрики
+	Code      string `json:"code"`            // машиночитаемый код ошибки
+	Field     string `json:"field,omitempty"` // поле метрики с ошибкой
+	Detail    string `json:"detail"`          // описание ошибки
+	Retryable bool   `json:"retryable"`       // повторная отправка метрики может быть успешной
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/utils/stringutils"
+)
+
+// DeleteRequest series removed with one request, values of metrics are ignored
+type DeleteRequest struct {
+	Metrics  []Metrics `json:"metrics,omitempty"`  // удаляемые серии: имя, тип и необязательные измерения
+	Prefixes []string  `json:"prefixes,omitempty"` // префиксы имён, удаляются серии всех типов, чьё имя начинается с префикса
+}
+
+// DeleteResponse result of delete request
+type DeleteResponse struct {
+	Deleted int `json:"deleted"` // количество удалённых серий
+}
+
+// Validate check that every series has name, known type and valid labels and every prefix is not empty.
+// Error wraps ErrInvalidMetric
+func (r *DeleteRequest) Validate() error {
+	for i := range r.Metrics {
+		if err := r.Metrics[i].ValidateSeries(); err != nil {
+			return fmt.Errorf("metric %d: %w", i, err)
+		}
+	}
+
+	for i, prefix := range r.Prefixes {
+		if stringutils.IsEmpty(prefix) {
+			return fmt.Errorf("%w: prefix %d is empty", ErrInvalidMetric, i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HistogramData distribution of observed values split into buckets.
+// Bounds are upper bounds of buckets in increasing order, Counts has one more element for +Inf bucket
+type HistogramData struct {
+	Bounds []float64 `json:"bounds"` // верхние границы бакетов
+	Counts []int64   `json:"counts"` // количество наблюдений в каждом бакете, последний бакет +Inf
+	Sum    float64   `json:"sum"`    // сумма всех наблюдений
+	Count  int64     `json:"count"`  // общее количество наблюдений
+}
+
+var (
+	ErrInvalidHistogram        = errors.New("invalid histogram")
+	ErrHistogramBoundsMismatch = errors.New("histogram bounds mismatch")
+)
+
+// Validate check bucket boundaries and counts consistency
+func (h *HistogramData) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("%w: expected %d bucket counts, got %d", ErrInvalidHistogram, len(h.Bounds)+1, len(h.Counts))
+	}
+
+	for i := 1; i < len(h.Bounds); i++ {
+		if h.Bounds[i] <= h.Bounds[i-1] {
+			return fmt.Errorf("%w: bounds must be strictly increasing", ErrInvalidHistogram)
+		}
+	}
+
+	var total int64
+	for _, count := range h.Counts {
+		if count < 0 {
+			return fmt.Errorf("%w: bucket count can not be negative", ErrInvalidHistogram)
+		}
+		total += count
+	}
+
+	if total != h.Count {
+		return fmt.Errorf("%w: count %d does not match sum of bucket counts %d", ErrInvalidHistogram, h.Count, total)
+	}
+
+	return nil
+}
+
+// Merge add observations from other histogram with the same bounds
+func (h *HistogramData) Merge(other *HistogramData) error {
+	if !h.SameBounds(other) {
+		return ErrHistogramBoundsMismatch
+	}
+
+	for i := range h.Counts {
+		h.Counts[i] += other.Counts[i]
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+
+	return nil
+}
+
+// SameBounds check that both histograms have identical bucket boundaries
+func (h *HistogramData) SameBounds(other *HistogramData) bool {
+	if len(h.Bounds) != len(other.Bounds) {
+		return false
+	}
+
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Clone returns deep copy of histogram
+func (h *HistogramData) Clone() HistogramData {
+	return HistogramData{
+		Bounds: append([]float64(nil), h.Bounds...),
+		Counts: append([]int64(nil), h.Counts...),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
+
+// String human-readable representation, e.g. count=3 sum=1.5 [le 0.5: 1, le 1: 2, +Inf: 0]
+func (h *HistogramData) String() string {
+	var builder strings.Builder
+
+	builder.WriteString("count=")
+	builder.WriteString(strconv.FormatInt(h.Count, 10))
+	builder.WriteString(" sum=")
+	builder.WriteString(strconv.FormatFloat(h.Sum, 'f', -1, 64))
+	builder.WriteString(" [")
+	for i, count := range h.Counts {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		if i < len(h.Bounds) {
+			builder.WriteString("le ")
+			builder.WriteString(strconv.FormatFloat(h.Bounds[i], 'f', -1, 64))
+		} else {
+			builder.WriteString("+Inf")
+		}
+		builder.WriteString(": ")
+		builder.WriteString(strconv.FormatInt(count, 10))
+	}
+	builder.WriteByte(']')
+
+	return builder.String()
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramData_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		histogram HistogramData
+		wantErr   bool
+	}{
+		{
+			name:      "Valid histogram",
+			histogram: HistogramData{Bounds: []float64{0.1, 0.5}, Counts: []int64{1, 2, 3}, Sum: 10, Count: 6},
+		},
+		{
+			name:      "Histogram without bounds has only +Inf bucket",
+			histogram: HistogramData{Counts: []int64{4}, Sum: 1, Count: 4},
+		},
+		{
+			name:      "Wrong number of bucket counts",
+			histogram: HistogramData{Bounds: []float64{0.1, 0.5}, Counts: []int64{1, 2}, Count: 3},
+			wantErr:   true,
+		},
+		{
+			name:      "Bounds are not increasing",
+			histogram: HistogramData{Bounds: []float64{0.5, 0.1}, Counts: []int64{1, 2, 3}, Count: 6},
+			wantErr:   true,
+		},
+		{
+			name:      "Negative bucket count",
+			histogram: HistogramData{Bounds: []float64{0.1}, Counts: []int64{-1, 1}, Count: 0},
+			wantErr:   true,
+		},
+		{
+			name:      "Count does not match buckets",
+			histogram: HistogramData{Bounds: []float64{0.1}, Counts: []int64{1, 1}, Count: 5},
+			wantErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.histogram.Validate()
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidHistogram)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHistogramData_Merge(t *testing.T) {
+	histogram := HistogramData{Bounds: []float64{0.1, 0.5}, Counts: []int64{1, 2, 3}, Sum: 10, Count: 6}
+
+	err := histogram.Merge(&HistogramData{Bounds: []float64{0.1, 0.5}, Counts: []int64{1, 0, 1}, Sum: 2, Count: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, HistogramData{Bounds: []float64{0.1, 0.5}, Counts: []int64{2, 2, 4}, Sum: 12, Count: 8}, histogram)
+
+	err = histogram.Merge(&HistogramData{Bounds: []float64{0.2, 0.5}, Counts: []int64{1, 0, 1}, Sum: 2, Count: 2})
+	assert.ErrorIs(t, err, ErrHistogramBoundsMismatch)
+}
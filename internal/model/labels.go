@@ -0,0 +1,82 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/utils/stringutils"
+)
+
+// Labels optional set of dimensions attached to a metric (e.g. cpu="0")
+type Labels map[string]string
+
+var ErrInvalidLabelName = errors.New("invalid label name")
+
+// SeriesKeySeparators characters of series key syntax, they are not allowed in metric and label names,
+// so different series never get the same key
+const SeriesKeySeparators = `{}=,"`
+
+// ValidName check that metric or label name is not empty and has no series key separators
+func ValidName(name string) bool {
+	return !stringutils.IsEmpty(name) && !strings.ContainsAny(name, SeriesKeySeparators)
+}
+
+// String canonical representation of labels sorted by name, e.g. {cpu="0",host="a"}. Empty labels give empty string
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(name)
+		builder.WriteByte('=')
+		builder.WriteString(strconv.Quote(l[name]))
+	}
+	builder.WriteByte('}')
+
+	return builder.String()
+}
+
+// Validate check that all label names are not empty and have no series key separators
+func (l Labels) Validate() error {
+	for name := range l {
+		if !ValidName(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidLabelName, name)
+		}
+	}
+
+	return nil
+}
+
+// Clone returns copy of labels, so callers can not modify stored labels
+func (l Labels) Clone() Labels {
+	if len(l) == 0 {
+		return nil
+	}
+
+	labels := make(Labels, len(l))
+	for name, value := range l {
+		labels[name] = value
+	}
+
+	return labels
+}
+
+// SeriesKey unique identifier of one time series built from metric name and labels, e.g. CPUutilization{cpu="0"}
+func SeriesKey(name string, labels Labels) string {
+	return name + labels.String()
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric string
+		labels Labels
+		want   string
+	}{
+		{
+			name:   "Metric without labels",
+			metric: "Alloc",
+			labels: nil,
+			want:   "Alloc",
+		},
+		{
+			name:   "Labels are sorted by name",
+			metric: "CPUutilization",
+			labels: Labels{"host": "a", "cpu": "0"},
+			want:   `CPUutilization{cpu="0",host="a"}`,
+		},
+		{
+			name:   "Label values are quoted",
+			metric: "Requests",
+			labels: Labels{"path": `/a"b`},
+			want:   `Requests{path="/a\"b"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, SeriesKey(test.metric, test.labels))
+		})
+	}
+}
+
+func TestLabels_Validate(t *testing.T) {
+	assert.NoError(t, Labels{"cpu": "0"}.Validate())
+	assert.NoError(t, Labels(nil).Validate())
+	assert.ErrorIs(t, Labels{" ": "0"}.Validate(), ErrInvalidLabelName)
+	assert.ErrorIs(t, Labels{`a="1",b`: "2"}.Validate(), ErrInvalidLabelName)
+}
+
+func TestValidName(t *testing.T) {
+	assert.True(t, ValidName("CPUutilization"))
+	assert.False(t, ValidName(" "))
+	for _, name := range []string{`x{a="1"}`, "a}", "a=b", "a,b", `a"b`} {
+		assert.False(t, ValidName(name), name)
+	}
+}
@@ -3,16 +3,109 @@ package model
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/utils/stringutils"
 )
 
 // Metrics main structure to store all types of metrics
 type Metrics struct {
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
+	Value     *float64       `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Delta     *int64         `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Histogram *HistogramData `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Labels    Labels         `json:"labels,omitempty"`    // необязательные измерения метрики (например, cpu)
+	ID        string         `json:"id"`                  // имя метрики
+	MType     string         `json:"type"`                // параметр, принимающий значение gauge, counter или histogram
+}
+
+var ErrInvalidMetric = errors.New("invalid metric")
+
+// MetricError invalid metric with name of invalid field, wraps ErrInvalidMetric
+type MetricError struct {
+	MetricID string // имя метрики
+	Field    string // поле с ошибкой: id, type, labels, value, delta или histogram
+	err      error
+}
+
+func (e *MetricError) Error() string {
+	return e.err.Error()
+}
+
+func (e *MetricError) Unwrap() error {
+	return e.err
+}
+
+// invalidMetric returns MetricError of field with message formatted after ErrInvalidMetric
+func invalidMetric(id string, field string, format string, args ...any) error {
+	return &MetricError{MetricID: id, Field: field, err: fmt.Errorf("%w: "+format, append([]any{ErrInvalidMetric}, args...)...)}
+}
+
+// Validate check that metric has name, known type, value of its type and valid labels and histogram.
+// Error is *MetricError
+func (m *Metrics) Validate() error {
+	if err := validateID(m.ID); err != nil {
+		return err
+	}
+
+	if err := m.Labels.Validate(); err != nil {
+		return invalidMetric(m.ID, "labels", "%s: %w", m.ID, err)
+	}
+
+	switch m.MType {
+	case string(Gauge):
+		if m.Value == nil {
+			return invalidMetric(m.ID, "value", "gauge %s without value", m.ID)
+		}
+	case string(Counter):
+		if m.Delta == nil {
+			return invalidMetric(m.ID, "delta", "counter %s without delta", m.ID)
+		}
+	case string(Histogram):
+		if m.Histogram == nil {
+			return invalidMetric(m.ID, "histogram", "histogram %s without value", m.ID)
+		}
+		if err := m.Histogram.Validate(); err != nil {
+			return invalidMetric(m.ID, "histogram", "%s: %w", m.ID, err)
+		}
+	default:
+		return invalidMetric(m.ID, "type", "unknown type %q of %s", m.MType, m.ID)
+	}
+
+	return nil
+}
+
+// validateID check that metric name is not empty and has no series key separators
+func validateID(id string) error {
+	if stringutils.IsEmpty(id) {
+		return invalidMetric(id, "id", "empty name")
+	}
+	if !ValidName(id) {
+		return invalidMetric(id, "id", "name %s contains one of %s", id, SeriesKeySeparators)
+	}
+
+	return nil
+}
+
+// ValidateSeries check that metric identifies series: has name, known type and valid labels, value is not checked.
+// Error is *MetricError
+func (m *Metrics) ValidateSeries() error {
+	if err := validateID(m.ID); err != nil {
+		return err
+	}
+
+	switch MetricType(m.MType) {
+	case Gauge, Counter, Histogram:
+	default:
+		return invalidMetric(m.ID, "type", "unknown type %q of %s", m.MType, m.ID)
+	}
+
+	if err := m.Labels.Validate(); err != nil {
+		return invalidMetric(m.ID, "labels", "%s: %w", m.ID, err)
+	}
+
+	return nil
 }
 
 // UnmarshalJSON custom logic for unmarshalling JSON to Metrics structure
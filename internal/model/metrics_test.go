@@ -118,3 +118,47 @@ func TestMetrics_UnmarshalJSON_Negative(t *testing.T) {
 		})
 	}
 }
+
+func TestMetrics_Validate(t *testing.T) {
+	value := 1.5
+	delta := int64(2)
+
+	tests := []struct {
+		name    string
+		metric  Metrics
+		isValid bool
+		field   string
+	}{
+		{name: "Gauge", metric: Metrics{ID: "Alloc", MType: string(Gauge), Value: &value}, isValid: true},
+		{name: "Counter", metric: Metrics{ID: "PollCount", MType: string(Counter), Delta: &delta}, isValid: true},
+		{name: "Histogram", metric: Metrics{ID: "Latency", MType: string(Histogram),
+			Histogram: &HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 0.5, Count: 1}}, isValid: true},
+		{name: "Empty name", metric: Metrics{ID: " ", MType: string(Gauge), Value: &value}, field: "id"},
+		{name: "Name with labels", metric: Metrics{ID: `Alloc{cpu="0"}`, MType: string(Gauge), Value: &value}, field: "id"},
+		{name: "Label name with separators", metric: Metrics{ID: "Alloc", MType: string(Gauge), Value: &value,
+			Labels: Labels{`cpu="0",host`: "a"}}, field: "labels"},
+		{name: "Gauge without value", metric: Metrics{ID: "Alloc", MType: string(Gauge), Delta: &delta}, field: "value"},
+		{name: "Counter without delta", metric: Metrics{ID: "PollCount", MType: string(Counter), Value: &value}, field: "delta"},
+		{name: "Histogram without value", metric: Metrics{ID: "Latency", MType: string(Histogram)}, field: "histogram"},
+		{name: "Invalid histogram", metric: Metrics{ID: "Latency", MType: string(Histogram),
+			Histogram: &HistogramData{Bounds: []float64{1}, Counts: []int64{1}, Count: 1}}, field: "histogram"},
+		{name: "Invalid labels", metric: Metrics{ID: "Alloc", MType: string(Gauge), Value: &value, Labels: Labels{"": "0"}}, field: "labels"},
+		{name: "Unknown type", metric: Metrics{ID: "Alloc", MType: "summary", Value: &value}, field: "type"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.metric.Validate()
+			if test.isValid {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidMetric)
+
+			var metricErr *MetricError
+			if assert.ErrorAs(t, err, &metricErr) {
+				assert.Equal(t, test.field, metricErr.Field)
+				assert.Equal(t, test.metric.ID, metricErr.MetricID)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package model
+
+// MetricsQuery filter, order and page of metrics listing.
+// Metrics are ordered by name, type and labels, After is the last metric of previous page
+type MetricsQuery struct {
+	Type   MetricType // тип метрик, пустой - все типы
+	Prefix string     // префикс имени метрики
+	Desc   bool       // обратный порядок
+	Limit  int        // максимальное количество метрик, 0 - без ограничения
+	After  *Metrics   // последняя метрика предыдущей страницы, nil - первая страница
+}
+
+// MetricsPage one page of metrics listing
+type MetricsPage struct {
+	Metrics    []Metrics `json:"metrics"`               // метрики страницы
+	NextCursor string    `json:"next_cursor,omitempty"` // курсор следующей страницы, пустой на последней странице
+}
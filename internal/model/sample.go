@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// Sample one timestamped value of metric time series
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"` // время принятия обновления
+	Value     float64   `json:"value"`     // значение gauge или накопленное значение counter
+}
+
+// Rollup aggregate of samples in one bucket of retention tier
+type Rollup struct {
+	Timestamp time.Time `json:"timestamp"` // начало интервала
+	Min       float64   `json:"min"`       // минимальное значение за интервал
+	Max       float64   `json:"max"`       // максимальное значение за интервал
+	Avg       float64   `json:"avg"`       // среднее значение за интервал
+	Count     int64     `json:"count"`     // количество значений за интервал
+}
+
+// History series of samples returned by range query
+type History struct {
+	Labels     Labels   `json:"labels,omitempty"`     // измерения метрики
+	ID         string   `json:"id"`                   // имя метрики
+	MType      string   `json:"type"`                 // тип метрики
+	Step       string   `json:"step,omitempty"`       // шаг агрегации
+	Resolution string   `json:"resolution,omitempty"` // разрешение уровня хранения
+	Samples    []Sample `json:"samples"`              // значения, упорядоченные по времени
+	Rollups    []Rollup `json:"rollups,omitempty"`    // агрегаты уровня хранения, упорядоченные по времени
+}
@@ -0,0 +1,7 @@
+package model
+
+// ValuesResponse result of batch read of series
+type ValuesResponse struct {
+	Metrics []Metrics `json:"metrics"` // найденные метрики со значениями в порядке запроса
+	Missing []Metrics `json:"missing"` // запрошенные метрики, которых нет в хранилище
+}
@@ -0,0 +1,162 @@
+// Package problem contains RFC 7807 error responses (application/problem+json) of server handlers and middlewares
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"go.uber.org/zap"
+)
+
+// ContentType media type of problem responses
+const ContentType = "application/problem+json"
+
+// typePrefix prefix of problem type URI, the rest is code
+const typePrefix = "urn:gometrics:problem:"
+
+// Code machine-readable reason of error
+type Code string
+
+const (
+	MalformedBody    Code = "malformed_body"
+	InvalidType      Code = "invalid_type"
+	InvalidName      Code = "invalid_name"
+	InvalidLabels    Code = "invalid_labels"
+	MissingValue     Code = "missing_value"
+	InvalidValue     Code = "invalid_value"
+	InvalidHistogram Code = "invalid_histogram"
+	BoundsMismatch   Code = "histogram_bounds_mismatch"
+	InvalidParameter Code = "invalid_parameter"
+	NotFound         Code = "not_found"
+	NotSupported     Code = "not_supported"
+	HashMismatch     Code = "hash_mismatch"
+	DecryptionFailed Code = "decryption_failed"
+	StorageError     Code = "storage_error"
+	InternalError    Code = "internal_error"
+)
+
+// titles short summary of every problem type
+var titles = map[Code]string{
+	MalformedBody:    "Request body can not be decoded",
+	InvalidType:      "Unknown metric type",
+	InvalidName:      "Invalid metric name",
+	InvalidLabels:    "Invalid metric labels",
+	MissingValue:     "Metric value is missing",
+	InvalidValue:     "Invalid metric value",
+	InvalidHistogram: "Invalid histogram",
+	BoundsMismatch:   "Histogram bounds do not match stored histogram",
+	InvalidParameter: "Invalid query parameter",
+	NotFound:         "Metric not found",
+	NotSupported:     "Not supported by storage",
+	HashMismatch:     "Request hash mismatch",
+	DecryptionFailed: "Request can not be decrypted",
+	StorageError:     "Storage error",
+	InternalError:    "Internal server error",
+}
+
+// Problem problem details of RFC 7807 with extension members code, field and metric_id
+type Problem struct {
+	Type     string `json:"type"`                // URI типа ошибки
+	Title    string `json:"title"`               // краткое описание типа ошибки
+	Status   int    `json:"status"`              // HTTP статус ответа
+	Detail   string `json:"detail,omitempty"`    // описание конкретной ошибки
+	Instance string `json:"instance,omitempty"`  // путь запроса
+	Code     Code   `json:"code"`                // машиночитаемый код ошибки
+	Field    string `json:"field,omitempty"`     // поле запроса с ошибкой
+	MetricID string `json:"metric_id,omitempty"` // имя метрики
+}
+
+// New constructor of problem with status, code and detail
+func New(status int, code Code, detail string) *Problem {
+	return &Problem{
+		Type:   typePrefix + string(code),
+		Title:  titles[code],
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// InvalidMetric problem of metric failed validation, field and metric id are taken from *model.MetricError
+func InvalidMetric(err error) *Problem {
+	var metricErr *model.MetricError
+	if !errors.As(err, &metricErr) {
+		return New(http.StatusBadRequest, InvalidValue, err.Error())
+	}
+
+	code := MissingValue
+	switch metricErr.Field {
+	case "id":
+		code = InvalidName
+	case "type":
+		code = InvalidType
+	case "labels":
+		code = InvalidLabels
+	case "histogram":
+		if errors.Is(err, model.ErrInvalidHistogram) {
+			code = InvalidHistogram
+		}
+	}
+
+	return New(http.StatusBadRequest, code, err.Error()).WithField(metricErr.Field).WithMetric(metricErr.MetricID)
+}
+
+// WithField set field of request with error
+func (p *Problem) WithField(field string) *Problem {
+	p.Field = field
+	return p
+}
+
+// WithMetric set name of metric
+func (p *Problem) WithMetric(id string) *Problem {
+	p.MetricID = id
+	return p
+}
+
+// Write write problem as response with its status, instance is path of request
+func (p *Problem) Write(w http.ResponseWriter, r *http.Request) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		zap.L().Error("Failed to write problem response", zap.Error(err))
+	}
+}
+
+// Error problem as error, used by clients which decoded problem response
+func (p *Problem) Error() string {
+	message := fmt.Sprintf("%d %s", p.Status, p.Code)
+	if p.MetricID != "" {
+		message += " of " + p.MetricID
+	}
+	if p.Field != "" {
+		message += " in field " + p.Field
+	}
+	if p.Detail != "" {
+		message += ": " + p.Detail
+	}
+	return message
+}
+
+// Read decode problem from response with problem content type, false is returned for other responses
+func Read(contentType string, body io.Reader) (*Problem, bool) {
+	if !strings.HasPrefix(contentType, ContentType) {
+		return nil, false
+	}
+
+	var p Problem
+	if err := json.NewDecoder(body).Decode(&p); err != nil {
+		return nil, false
+	}
+
+	return &p, true
+}
@@ -0,0 +1,81 @@
+package problem
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+func TestProblem_Write(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/update/", http.NoBody)
+	responseRecorder := httptest.NewRecorder()
+
+	New(http.StatusBadRequest, MissingValue, "counter PollCount without delta").WithField("delta").WithMetric("PollCount").
+		Write(responseRecorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+	assert.Equal(t, ContentType, responseRecorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "urn:gometrics:problem:missing_value",
+		"title": "Metric value is missing",
+		"status": 400,
+		"detail": "counter PollCount without delta",
+		"instance": "/update/",
+		"code": "missing_value",
+		"field": "delta",
+		"metric_id": "PollCount"
+	}`, responseRecorder.Body.String())
+}
+
+func TestInvalidMetric(t *testing.T) {
+	value := 1.5
+
+	tests := []struct {
+		name   string
+		metric model.Metrics
+		code   Code
+		field  string
+	}{
+		{name: "Empty name", metric: model.Metrics{MType: string(model.Gauge), Value: &value}, code: InvalidName, field: "id"},
+		{name: "Unknown type", metric: model.Metrics{ID: "Alloc", MType: "summary"}, code: InvalidType, field: "type"},
+		{name: "Missing delta", metric: model.Metrics{ID: "PollCount", MType: string(model.Counter)}, code: MissingValue, field: "delta"},
+		{name: "Invalid histogram", metric: model.Metrics{ID: "Latency", MType: string(model.Histogram),
+			Histogram: &model.HistogramData{Bounds: []float64{1}, Counts: []int64{1}, Count: 1}}, code: InvalidHistogram, field: "histogram"},
+		{name: "Invalid labels", metric: model.Metrics{ID: "Alloc", MType: string(model.Gauge), Value: &value, Labels: model.Labels{"": "a"}},
+			code: InvalidLabels, field: "labels"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.metric.Validate()
+			require.Error(t, err)
+
+			p := InvalidMetric(err)
+			assert.Equal(t, http.StatusBadRequest, p.Status)
+			assert.Equal(t, test.code, p.Code)
+			assert.Equal(t, test.field, p.Field)
+			assert.Equal(t, test.metric.ID, p.MetricID)
+		})
+	}
+}
+
+func TestRead(t *testing.T) {
+	responseRecorder := httptest.NewRecorder()
+	New(http.StatusBadRequest, HashMismatch, "hash mismatch").Write(responseRecorder, httptest.NewRequest(http.MethodPost, "/updates/", http.NoBody))
+
+	p, ok := Read(responseRecorder.Header().Get("Content-Type"), responseRecorder.Body)
+	require.True(t, ok)
+	assert.Equal(t, HashMismatch, p.Code)
+	assert.Equal(t, "/updates/", p.Instance)
+	assert.Equal(t, "400 hash_mismatch: hash mismatch", p.Error())
+
+	_, ok = Read("text/plain; charset=utf-8", strings.NewReader("Bad Request"))
+	assert.False(t, ok)
+	_, ok = Read(ContentType, bytes.NewReader([]byte("{")))
+	assert.False(t, ok)
+}
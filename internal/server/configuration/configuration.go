@@ -19,9 +19,13 @@ type Configuration struct {
 	// FileStoragePath path to the file where metrics will be stored on the disk.
 	FileStoragePath string `json:"file_storage_path"`
 
-	// DatabaseDsn Data Source Name for the database connection string.
+	// DatabaseDsn Data Source Name for the database connection string,
+	// sqlite://path selects SQLite database file, redis://host:port/db selects Redis shared by server replicas.
 	DatabaseDsn string `json:"database_dsn"`
 
+	// KVStoragePath path to embedded key-value database file, used as durable storage when database DSN is not set.
+	KVStoragePath string `json:"kv_storage_path"`
+
 	// CryptoKey path to private Key for request decryption
 	CryptoKey string `json:"crypto_key"`
 
@@ -34,19 +38,84 @@ type Configuration struct {
 	// StoreInterval interval (in seconds) between saving metrics to file.
 	StoreInterval int `json:"store_interval"`
 
+	// Retention tiers of metric history in format "raw:24h,1m:720h,1h:8760h" (resolution:retention).
+	Retention string `json:"retention"`
+
+	// CompactInterval interval (in seconds) between compactions of metric history, 0 disables compaction.
+	CompactInterval int `json:"compact_interval"`
+
+	// HistorySize number of raw samples kept in memory for each series, 0 disables history of in memory storage.
+	HistorySize int `json:"history_size"`
+
+	// DBMaxConns maximum number of connections in database pool.
+	DBMaxConns int `json:"db_max_conns"`
+
+	// DBMinConns minimum number of idle connections kept in database pool.
+	DBMinConns int `json:"db_min_conns"`
+
+	// DBMaxConnLifetime time (in seconds) after which database connection is closed.
+	DBMaxConnLifetime int `json:"db_max_conn_lifetime"`
+
+	// DBMaxConnIdleTime time (in seconds) after which idle database connection is closed.
+	DBMaxConnIdleTime int `json:"db_max_conn_idle_time"`
+
+	// DBHealthCheckPeriod interval (in seconds) between health checks of idle database connections.
+	DBHealthCheckPeriod int `json:"db_health_check_period"`
+
+	// DBStatementTimeout timeout (in seconds) of every database statement, 0 disables timeout.
+	DBStatementTimeout int `json:"db_statement_timeout"`
+
+	// CacheFlushInterval interval (in seconds) between writes of cached updates to database, 0 disables cache.
+	CacheFlushInterval int `json:"cache_flush_interval"`
+
+	// CacheFlushSize number of cached series after which updates are written to database before interval elapses.
+	CacheFlushSize int `json:"cache_flush_size"`
+
+	// StoragePrimary enables mirroring between database and in memory storage with file persistence:
+	// "database" or "memory" is the primary storage, empty value uses only one storage.
+	StoragePrimary string `json:"storage_primary"`
+
+	// MirrorPolicy what to do when update of secondary storage fails: fail, log or retry.
+	MirrorPolicy string `json:"mirror_policy"`
+
+	// MirrorRetryInterval interval (in seconds) between retries of queued updates of secondary storage.
+	MirrorRetryInterval int `json:"mirror_retry_interval"`
+
 	// Restore metrics from file after startup or not.
 	Restore bool `json:"restore"`
+
+	// SnapshotGzip compress metrics file with gzip or not.
+	SnapshotGzip bool `json:"snapshot_gzip"`
 }
 
 type envs struct {
 	Address         string `env:"ADDRESS"`
 	FileStoragePath string `env:"FILE_STORAGE_PATH"`
 	DatabaseDsn     string `env:"DATABASE_DSN"`
+	KVStoragePath   string `env:"KV_STORAGE_PATH"`
 	Key             string `env:"KEY"`
 	CryptoKey       string `env:"CRYPTO_KEY"`
 	Config          string `env:"CONFIG"`
 	StoreInterval   int    `env:"STORE_INTERVAL"`
+	Retention       string `env:"RETENTION"`
+	CompactInterval int    `env:"COMPACT_INTERVAL"`
+	HistorySize     int    `env:"HISTORY_SIZE"`
 	Restore         bool   `env:"RESTORE"`
+	SnapshotGzip    bool   `env:"SNAPSHOT_GZIP"`
+
+	DBMaxConns          int `env:"DB_MAX_CONNS"`
+	DBMinConns          int `env:"DB_MIN_CONNS"`
+	DBMaxConnLifetime   int `env:"DB_MAX_CONN_LIFETIME"`
+	DBMaxConnIdleTime   int `env:"DB_MAX_CONN_IDLE_TIME"`
+	DBHealthCheckPeriod int `env:"DB_HEALTH_CHECK_PERIOD"`
+	DBStatementTimeout  int `env:"DB_STATEMENT_TIMEOUT"`
+
+	CacheFlushInterval int `env:"CACHE_FLUSH_INTERVAL"`
+	CacheFlushSize     int `env:"CACHE_FLUSH_SIZE"`
+
+	StoragePrimary      string `env:"STORAGE_PRIMARY"`
+	MirrorPolicy        string `env:"MIRROR_POLICY"`
+	MirrorRetryInterval int    `env:"MIRROR_RETRY_INTERVAL"`
 }
 
 // Configure read env variables and CLI parameters to configure server
@@ -56,6 +125,16 @@ func Configure() *Configuration {
 	const defaultStoreInterval = 300
 	const defaultFileStoragePath = "/tmp/metrics-db.json"
 	const defaultRestore = true
+	const defaultRetention = "raw:24h,1m:720h,1h:8760h"
+	const defaultCompactInterval = 60
+	const defaultHistorySize = 8640
+	const defaultDBMaxConns = 10
+	const defaultDBMaxConnLifetime = 3600
+	const defaultDBMaxConnIdleTime = 1800
+	const defaultDBHealthCheckPeriod = 60
+	const defaultCacheFlushSize = 1000
+	const defaultMirrorPolicy = "log"
+	const defaultMirrorRetryInterval = 5
 
 	// Flags for config
 	flag.StringVar(&config.Config, "c", "", "Path to configuration file")
@@ -63,9 +142,25 @@ func Configure() *Configuration {
 
 	flag.StringVar(&config.ServerAddress, "a", "localhost:8080", "Server URL")
 	flag.IntVar(&config.StoreInterval, "i", defaultStoreInterval, "Store interval in seconds")
+	flag.StringVar(&config.Retention, "retention", defaultRetention, "Retention tiers of metric history")
+	flag.IntVar(&config.CompactInterval, "compact-interval", defaultCompactInterval, "Compact interval in seconds")
+	flag.IntVar(&config.HistorySize, "history-size", defaultHistorySize, "Number of raw samples kept in memory for each series")
 	flag.StringVar(&config.FileStoragePath, "f", defaultFileStoragePath, "File storage path")
 	flag.BoolVar(&config.Restore, "r", defaultRestore, "Restore")
+	flag.BoolVar(&config.SnapshotGzip, "snapshot-gzip", false, "Compress metrics file with gzip")
 	flag.StringVar(&config.DatabaseDsn, "d", "", "Database DSN")
+	flag.StringVar(&config.KVStoragePath, "kv-storage-path", "", "Embedded key-value database file path")
+	flag.IntVar(&config.DBMaxConns, "db-max-conns", defaultDBMaxConns, "Maximum number of database connections")
+	flag.IntVar(&config.DBMinConns, "db-min-conns", 0, "Minimum number of idle database connections")
+	flag.IntVar(&config.DBMaxConnLifetime, "db-max-conn-lifetime", defaultDBMaxConnLifetime, "Database connection lifetime in seconds")
+	flag.IntVar(&config.DBMaxConnIdleTime, "db-max-conn-idle-time", defaultDBMaxConnIdleTime, "Database connection idle timeout in seconds")
+	flag.IntVar(&config.DBHealthCheckPeriod, "db-health-check-period", defaultDBHealthCheckPeriod, "Database connections health check period in seconds")
+	flag.IntVar(&config.DBStatementTimeout, "db-statement-timeout", 0, "Database statement timeout in seconds")
+	flag.IntVar(&config.CacheFlushInterval, "cache-flush-interval", 0, "Database write-behind cache flush interval in seconds, 0 disables cache")
+	flag.IntVar(&config.CacheFlushSize, "cache-flush-size", defaultCacheFlushSize, "Number of cached series which triggers flush")
+	flag.StringVar(&config.StoragePrimary, "storage-primary", "", "Primary storage when database and memory storage are mirrored: database or memory")
+	flag.StringVar(&config.MirrorPolicy, "mirror-policy", defaultMirrorPolicy, "Policy on failed update of secondary storage: fail, log or retry")
+	flag.IntVar(&config.MirrorRetryInterval, "mirror-retry-interval", defaultMirrorRetryInterval, "Retry interval of secondary storage updates in seconds")
 	flag.StringVar(&config.Key, "k", "", "Key")
 	flag.StringVar(&config.CryptoKey, "crypto-key", "", "Crypto Key")
 	flag.Parse()
@@ -80,7 +175,7 @@ func Configure() *Configuration {
 	if exists && envVariables.Config != "" {
 		config.Config = envVariables.Config
 
-		configFromFile, err := loadConfigFromFile(config.Config)
+		configFromFile, err := loadConfigFromFile(config.Config, config)
 		if err != nil {
 			zap.L().Error("Failed to load configuration file", zap.Error(err))
 		} else {
@@ -88,6 +183,17 @@ func Configure() *Configuration {
 		}
 	}
 
+	// Configuration file may contain empty retention tiers and mirroring settings
+	if stringutils.IsEmpty(config.Retention) {
+		config.Retention = defaultRetention
+	}
+	if stringutils.IsEmpty(config.MirrorPolicy) {
+		config.MirrorPolicy = defaultMirrorPolicy
+	}
+	if config.MirrorRetryInterval <= 0 {
+		config.MirrorRetryInterval = defaultMirrorRetryInterval
+	}
+
 	_, exists = os.LookupEnv("ADDRESS")
 	if exists && !stringutils.IsEmpty(envVariables.Address) {
 		config.ServerAddress = envVariables.Address
@@ -98,6 +204,21 @@ func Configure() *Configuration {
 		config.StoreInterval = envVariables.StoreInterval
 	}
 
+	_, exists = os.LookupEnv("RETENTION")
+	if exists && !stringutils.IsEmpty(envVariables.Retention) {
+		config.Retention = envVariables.Retention
+	}
+
+	_, exists = os.LookupEnv("COMPACT_INTERVAL")
+	if exists {
+		config.CompactInterval = envVariables.CompactInterval
+	}
+
+	_, exists = os.LookupEnv("HISTORY_SIZE")
+	if exists {
+		config.HistorySize = envVariables.HistorySize
+	}
+
 	_, exists = os.LookupEnv("FILE_STORAGE_PATH")
 	if exists && !stringutils.IsEmpty(envVariables.FileStoragePath) {
 		config.FileStoragePath = envVariables.FileStoragePath
@@ -108,11 +229,76 @@ func Configure() *Configuration {
 		config.Restore = envVariables.Restore
 	}
 
+	_, exists = os.LookupEnv("SNAPSHOT_GZIP")
+	if exists {
+		config.SnapshotGzip = envVariables.SnapshotGzip
+	}
+
 	_, exists = os.LookupEnv("DATABASE_DSN")
 	if exists {
 		config.DatabaseDsn = envVariables.DatabaseDsn
 	}
 
+	_, exists = os.LookupEnv("KV_STORAGE_PATH")
+	if exists {
+		config.KVStoragePath = envVariables.KVStoragePath
+	}
+
+	_, exists = os.LookupEnv("DB_MAX_CONNS")
+	if exists {
+		config.DBMaxConns = envVariables.DBMaxConns
+	}
+
+	_, exists = os.LookupEnv("DB_MIN_CONNS")
+	if exists {
+		config.DBMinConns = envVariables.DBMinConns
+	}
+
+	_, exists = os.LookupEnv("DB_MAX_CONN_LIFETIME")
+	if exists {
+		config.DBMaxConnLifetime = envVariables.DBMaxConnLifetime
+	}
+
+	_, exists = os.LookupEnv("DB_MAX_CONN_IDLE_TIME")
+	if exists {
+		config.DBMaxConnIdleTime = envVariables.DBMaxConnIdleTime
+	}
+
+	_, exists = os.LookupEnv("DB_HEALTH_CHECK_PERIOD")
+	if exists {
+		config.DBHealthCheckPeriod = envVariables.DBHealthCheckPeriod
+	}
+
+	_, exists = os.LookupEnv("DB_STATEMENT_TIMEOUT")
+	if exists {
+		config.DBStatementTimeout = envVariables.DBStatementTimeout
+	}
+
+	_, exists = os.LookupEnv("CACHE_FLUSH_INTERVAL")
+	if exists {
+		config.CacheFlushInterval = envVariables.CacheFlushInterval
+	}
+
+	_, exists = os.LookupEnv("CACHE_FLUSH_SIZE")
+	if exists {
+		config.CacheFlushSize = envVariables.CacheFlushSize
+	}
+
+	_, exists = os.LookupEnv("STORAGE_PRIMARY")
+	if exists {
+		config.StoragePrimary = envVariables.StoragePrimary
+	}
+
+	_, exists = os.LookupEnv("MIRROR_POLICY")
+	if exists && !stringutils.IsEmpty(envVariables.MirrorPolicy) {
+		config.MirrorPolicy = envVariables.MirrorPolicy
+	}
+
+	_, exists = os.LookupEnv("MIRROR_RETRY_INTERVAL")
+	if exists && envVariables.MirrorRetryInterval > 0 {
+		config.MirrorRetryInterval = envVariables.MirrorRetryInterval
+	}
+
 	_, exists = os.LookupEnv("KEY")
 	if exists {
 		config.Key = envVariables.Key
@@ -127,7 +313,7 @@ func Configure() *Configuration {
 }
 
 // loadConfigFromFile loads configuration from a JSON file
-func loadConfigFromFile(filePath string) (*Configuration, error) {
+func loadConfigFromFile(filePath string, defaults Configuration) (*Configuration, error) {
 	if filePath == "" {
 		return nil, nil
 	}
@@ -138,7 +324,8 @@ func loadConfigFromFile(filePath string) (*Configuration, error) {
 	}
 	defer file.Close()
 
-	var config Configuration
+	// Fields missing in file keep values of flags and their defaults
+	config := defaults
 	decoder := json.NewDecoder(file)
 	err = decoder.Decode(&config)
 	if err != nil {
@@ -0,0 +1,23 @@
+// Package handlers contains helpers shared by handlers of all API versions
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+	"go.uber.org/zap"
+)
+
+// MirrorFailed check that update failed only in secondary storage. Primary storage keeps such update and
+// sending it again would apply it twice, so request succeeds and failure is reported with model.MirrorFailedHeader
+func MirrorFailed(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, storage.ErrMirrorFailed) {
+		return false
+	}
+
+	zap.L().Error("Update is stored in primary storage, but not mirrored", zap.Error(err))
+	w.Header().Set(model.MirrorFailedHeader, "true")
+	return true
+}
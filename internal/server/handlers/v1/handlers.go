@@ -3,13 +3,15 @@ package v1
 
 import (
 	"errors"
-	"html/template"
+	"fmt"
 	"net/http"
-	"os"
 	"strconv"
 
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/handlers"
 	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/web"
 	"github.com/zavtra-na-rabotu/gometrics/internal/utils/stringutils"
 	"go.uber.org/zap"
 )
@@ -30,14 +32,21 @@ func GetMetric(st storage.Storage) http.HandlerFunc {
 		// Validate MetricType TODO: Remove duplicates
 		if !(metricType == model.Counter || metricType == model.Gauge) {
 			zap.L().Error("Invalid metric type", zap.String("metricType", string(metricType)))
-			w.WriteHeader(http.StatusBadRequest)
+			problem.New(http.StatusBadRequest, problem.InvalidType, fmt.Sprintf("unknown metric type %q", metricType)).
+				WithField("type").WithMetric(metricName).Write(w, r)
 			return
 		}
 
 		// Validate MetricName TODO: Remove duplicates
 		if stringutils.IsEmpty(metricName) {
 			zap.L().Error("Invalid metric name", zap.String("metricName", metricName))
-			w.WriteHeader(http.StatusNotFound)
+			problem.New(http.StatusNotFound, problem.InvalidName, "empty metric name").WithField("name").Write(w, r)
+			return
+		}
+		if !model.ValidName(metricName) {
+			zap.L().Error("Invalid metric name", zap.String("metricName", metricName))
+			problem.New(http.StatusBadRequest, problem.InvalidName, "metric name contains one of "+model.SeriesKeySeparators).
+				WithField("name").WithMetric(metricName).Write(w, r)
 			return
 		}
 
@@ -45,26 +54,26 @@ func GetMetric(st storage.Storage) http.HandlerFunc {
 
 		switch metricType {
 		case model.Counter:
-			metric, err := st.GetCounter(metricName)
+			metric, err := st.GetCounter(r.Context(), metricName, nil)
 			if err != nil {
 				if errors.Is(err, storage.ErrItemNotFound) {
-					w.WriteHeader(http.StatusNotFound)
+					problem.New(http.StatusNotFound, problem.NotFound, err.Error()).WithMetric(metricName).Write(w, r)
 					return
 				}
 				zap.L().Error("Error while getting counter metric", zap.String("metricName", metricName), zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to get counter metric").WithMetric(metricName).Write(w, r)
 				return
 			}
 			response = strconv.FormatInt(metric, 10)
 		case model.Gauge:
-			metric, err := st.GetGauge(metricName)
+			metric, err := st.GetGauge(r.Context(), metricName, nil)
 			if err != nil {
 				if errors.Is(err, storage.ErrItemNotFound) {
-					w.WriteHeader(http.StatusNotFound)
+					problem.New(http.StatusNotFound, problem.NotFound, err.Error()).WithMetric(metricName).Write(w, r)
 					return
 				}
 				zap.L().Error("Error while getting gauge metric", zap.String("metricName", metricName), zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to get gauge metric").WithMetric(metricName).Write(w, r)
 				return
 			}
 			response = strconv.FormatFloat(metric, 'f', -1, 64)
@@ -91,14 +100,21 @@ func UpdateMetric(st storage.Storage) http.HandlerFunc {
 		// Validate MetricType TODO: Remove duplicates
 		if !(metricType == model.Counter || metricType == model.Gauge) {
 			zap.L().Error("Invalid metric type", zap.String("metricType", string(metricType)))
-			w.WriteHeader(http.StatusBadRequest)
+			problem.New(http.StatusBadRequest, problem.InvalidType, fmt.Sprintf("unknown metric type %q", metricType)).
+				WithField("type").WithMetric(metricName).Write(w, r)
 			return
 		}
 
 		// Validate MetricName TODO: Remove duplicates
 		if stringutils.IsEmpty(metricName) {
 			zap.L().Error("Invalid metric name", zap.String("metricName", metricName))
-			w.WriteHeader(http.StatusNotFound)
+			problem.New(http.StatusNotFound, problem.InvalidName, "empty metric name").WithField("name").Write(w, r)
+			return
+		}
+		if !model.ValidName(metricName) {
+			zap.L().Error("Invalid metric name", zap.String("metricName", metricName))
+			problem.New(http.StatusBadRequest, problem.InvalidName, "metric name contains one of "+model.SeriesKeySeparators).
+				WithField("name").WithMetric(metricName).Write(w, r)
 			return
 		}
 
@@ -107,28 +123,30 @@ func UpdateMetric(st storage.Storage) http.HandlerFunc {
 			value, err := strconv.ParseInt(metricValue, 10, 64)
 			if err != nil {
 				zap.L().Error("Failed to parse value from metric", zap.String("metricValue", metricValue), zap.Error(err))
-				w.WriteHeader(http.StatusBadRequest)
+				problem.New(http.StatusBadRequest, problem.InvalidValue, fmt.Sprintf("invalid %s value %q", metricType, metricValue)).
+					WithField("value").WithMetric(metricName).Write(w, r)
 				return
 			}
 
-			err = st.UpdateCounter(metricName, value)
-			if err != nil {
+			err = st.UpdateCounter(r.Context(), metricName, nil, value)
+			if err != nil && !handlers.MirrorFailed(w, err) {
 				zap.L().Error("Error while updating counter metric", zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to update counter metric").WithMetric(metricName).Write(w, r)
 				return
 			}
 		case model.Gauge:
 			value, err := strconv.ParseFloat(metricValue, 64)
 			if err != nil {
 				zap.L().Error("Failed to parse value from metric", zap.String("metricValue", metricValue), zap.Error(err))
-				w.WriteHeader(http.StatusBadRequest)
+				problem.New(http.StatusBadRequest, problem.InvalidValue, fmt.Sprintf("invalid %s value %q", metricType, metricValue)).
+					WithField("value").WithMetric(metricName).Write(w, r)
 				return
 			}
 
-			err = st.UpdateGauge(metricName, value)
-			if err != nil {
+			err = st.UpdateGauge(r.Context(), metricName, nil, value)
+			if err != nil && !handlers.MirrorFailed(w, err) {
 				zap.L().Error("Error while updating gauge metric", zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to update gauge metric").WithMetric(metricName).Write(w, r)
 				return
 			}
 		}
@@ -138,25 +156,58 @@ func UpdateMetric(st storage.Storage) http.HandlerFunc {
 	}
 }
 
-// RenderAllMetrics handler to render list of all metrics as html page
-func RenderAllMetrics(st storage.Storage) http.HandlerFunc {
+// DeleteMetric handler to delete metric without labels by type and name specified as path parameters
+func DeleteMetric(st storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		metricType := model.MetricType(r.PathValue("type"))
+		metricName := r.PathValue("name")
 
-		wd, _ := os.Getwd()
-		metricsTemplate, err := template.ParseFiles(wd + "/internal/server/web/metrics/metrics.tmpl")
-		if err != nil {
-			zap.L().Error("Error while parsing template", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
+		if !(metricType == model.Counter || metricType == model.Gauge || metricType == model.Histogram) {
+			zap.L().Error("Invalid metric type", zap.String("metricType", string(metricType)))
+			problem.New(http.StatusBadRequest, problem.InvalidType, fmt.Sprintf("unknown metric type %q", metricType)).
+				WithField("type").WithMetric(metricName).Write(w, r)
+			return
+		}
+
+		if stringutils.IsEmpty(metricName) {
+			zap.L().Error("Invalid metric name", zap.String("metricName", metricName))
+			problem.New(http.StatusNotFound, problem.InvalidName, "empty metric name").WithField("name").Write(w, r)
+			return
+		}
+		if !model.ValidName(metricName) {
+			zap.L().Error("Invalid metric name", zap.String("metricName", metricName))
+			problem.New(http.StatusBadRequest, problem.InvalidName, "metric name contains one of "+model.SeriesKeySeparators).
+				WithField("name").WithMetric(metricName).Write(w, r)
 			return
 		}
 
+		err := st.Delete(r.Context(), metricType, metricName, nil)
+		if err != nil && !handlers.MirrorFailed(w, err) {
+			if errors.Is(err, storage.ErrItemNotFound) {
+				problem.New(http.StatusNotFound, problem.NotFound, err.Error()).WithMetric(metricName).Write(w, r)
+				return
+			}
+			zap.L().Error("Error while deleting metric", zap.String("metricName", metricName), zap.Error(err))
+			problem.New(http.StatusInternalServerError, problem.StorageError, "failed to delete metric").WithMetric(metricName).Write(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// RenderAllMetrics handler to render list of all metrics as html page
+func RenderAllMetrics(st storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
 		var allMetrics []MetricResponse
 
-		gaugeMetrics, err := st.GetAllGauge()
+		gaugeMetrics, err := st.GetAllGauge(r.Context())
 		if err != nil {
 			zap.L().Error("Error while getting gauge metrics", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.New(http.StatusInternalServerError, problem.StorageError, "failed to get gauge metrics").Write(w, r)
 			return
 		}
 		for name, metric := range gaugeMetrics {
@@ -167,10 +218,10 @@ func RenderAllMetrics(st storage.Storage) http.HandlerFunc {
 			})
 		}
 
-		counterMetrics, err := st.GetAllCounter()
+		counterMetrics, err := st.GetAllCounter(r.Context())
 		if err != nil {
 			zap.L().Error("Error while getting counter metrics", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.New(http.StatusInternalServerError, problem.StorageError, "failed to get counter metrics").Write(w, r)
 			return
 		}
 		for name, metric := range counterMetrics {
@@ -181,7 +232,21 @@ func RenderAllMetrics(st storage.Storage) http.HandlerFunc {
 			})
 		}
 
-		err = metricsTemplate.Execute(w, allMetrics)
+		histogramMetrics, err := st.GetAllHistogram(r.Context())
+		if err != nil {
+			zap.L().Error("Error while getting histogram metrics", zap.Error(err))
+			problem.New(http.StatusInternalServerError, problem.StorageError, "failed to get histogram metrics").Write(w, r)
+			return
+		}
+		for name, metric := range histogramMetrics {
+			allMetrics = append(allMetrics, MetricResponse{
+				MetricType:  model.Histogram,
+				MetricName:  name,
+				MetricValue: metric.String(),
+			})
+		}
+
+		err = web.MetricsTemplate.Execute(w, allMetrics)
 		if err != nil {
 			zap.L().Error("Error while executing template", zap.Error(err))
 			w.WriteHeader(http.StatusInternalServerError)
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strconv"
 	"testing"
 
@@ -18,13 +17,6 @@ import (
 )
 
 func Example() {
-	// Change to project root and back after test finish
-	originalDir, _ := os.Getwd()
-
-	// TODO: Dont know how to fix it
-	os.Chdir("../../../..")
-	defer os.Chdir(originalDir)
-
 	memStorage := storage.NewMemStorage()
 
 	r := chi.NewRouter()
@@ -158,7 +150,7 @@ func TestUpdateMetric_Counter(t *testing.T) {
 			request:       model.Metrics{ID: "Counter metric", Delta: &testValue, MType: string(model.Counter)},
 			storageReturn: storageReturn{errors.New("some error")},
 			want: want{
-				contentType: "",
+				contentType: "application/problem+json",
 				statusCode:  http.StatusInternalServerError,
 			},
 		},
@@ -170,7 +162,7 @@ func TestUpdateMetric_Counter(t *testing.T) {
 
 			// Create generated mock
 			mockStorage := mock_storage.NewMockStorage(ctrl)
-			mockStorage.EXPECT().UpdateCounter(test.request.ID, *test.request.Delta).Return(test.storageReturn.err)
+			mockStorage.EXPECT().UpdateCounter(gomock.Any(), test.request.ID, nil, *test.request.Delta).Return(test.storageReturn.err)
 
 			// Create request
 			request := httptest.NewRequest(http.MethodPost, "/update/", http.NoBody)
@@ -225,7 +217,7 @@ func TestUpdateMetric_Gauge(t *testing.T) {
 			request:       model.Metrics{ID: "Gauge metric", Value: &testValue, MType: string(model.Gauge)},
 			storageReturn: storageReturn{errors.New("some error")},
 			want: want{
-				contentType: "",
+				contentType: "application/problem+json",
 				statusCode:  http.StatusInternalServerError,
 			},
 		},
@@ -237,7 +229,7 @@ func TestUpdateMetric_Gauge(t *testing.T) {
 
 			// Create generated mock
 			mockStorage := mock_storage.NewMockStorage(ctrl)
-			mockStorage.EXPECT().UpdateGauge(test.request.ID, *test.request.Value).Return(test.storageReturn.err)
+			mockStorage.EXPECT().UpdateGauge(gomock.Any(), test.request.ID, nil, *test.request.Value).Return(test.storageReturn.err)
 
 			// Create request
 			request := httptest.NewRequest(http.MethodPost, "/update/", http.NoBody)
@@ -275,7 +267,7 @@ func TestGetMetric_Common(t *testing.T) {
 			name:    "Negative scenario. Empty name",
 			request: model.Metrics{MType: string(model.Counter)},
 			want: want{
-				contentType: "",
+				contentType: "application/problem+json",
 				statusCode:  http.StatusNotFound,
 			},
 		},
@@ -283,7 +275,7 @@ func TestGetMetric_Common(t *testing.T) {
 			name:    "Negative scenario. Empty type",
 			request: model.Metrics{ID: "whatever"},
 			want: want{
-				contentType: "",
+				contentType: "application/problem+json",
 				statusCode:  http.StatusBadRequest,
 			},
 		},
@@ -291,7 +283,7 @@ func TestGetMetric_Common(t *testing.T) {
 			name:    "Negative scenario. Wrong type",
 			request: model.Metrics{ID: "whatever", MType: "whatever"},
 			want: want{
-				contentType: "",
+				contentType: "application/problem+json",
 				statusCode:  http.StatusBadRequest,
 			},
 		},
@@ -355,7 +347,7 @@ func TestGetMetric_Counter(t *testing.T) {
 			request:       model.Metrics{ID: "whatever", MType: string(model.Counter)},
 			storageReturn: storageReturn{0, storage.ErrItemNotFound},
 			want: want{
-				contentType: "",
+				contentType: "application/problem+json",
 				statusCode:  http.StatusNotFound,
 			},
 		},
@@ -364,7 +356,7 @@ func TestGetMetric_Counter(t *testing.T) {
 			request:       model.Metrics{ID: "whatever", MType: string(model.Counter)},
 			storageReturn: storageReturn{0, errors.New("some error")},
 			want: want{
-				contentType: "",
+				contentType: "application/problem+json",
 				statusCode:  http.StatusInternalServerError,
 			},
 		},
@@ -376,7 +368,7 @@ func TestGetMetric_Counter(t *testing.T) {
 
 			// Create generated mock
 			mockStorage := mock_storage.NewMockStorage(ctrl)
-			mockStorage.EXPECT().GetCounter(test.request.ID).Return(test.storageReturn.value, test.storageReturn.err)
+			mockStorage.EXPECT().GetCounter(gomock.Any(), test.request.ID, nil).Return(test.storageReturn.value, test.storageReturn.err)
 
 			// Create request
 			request := httptest.NewRequest(http.MethodGet, "/value/", http.NoBody)
@@ -429,7 +421,7 @@ func TestGetMetric_Gauge(t *testing.T) {
 			request:       model.Metrics{ID: "whatever", MType: string(model.Gauge)},
 			storageReturn: storageReturn{0, storage.ErrItemNotFound},
 			want: want{
-				contentType: "",
+				contentType: "application/problem+json",
 				statusCode:  http.StatusNotFound,
 			},
 		},
@@ -438,7 +430,7 @@ func TestGetMetric_Gauge(t *testing.T) {
 			request:       model.Metrics{ID: "whatever", MType: string(model.Gauge)},
 			storageReturn: storageReturn{0, errors.New("some error")},
 			want: want{
-				contentType: "",
+				contentType: "application/problem+json",
 				statusCode:  http.StatusInternalServerError,
 			},
 		},
@@ -450,7 +442,7 @@ func TestGetMetric_Gauge(t *testing.T) {
 
 			// Create generated mock
 			mockStorage := mock_storage.NewMockStorage(ctrl)
-			mockStorage.EXPECT().GetGauge(test.request.ID).Return(test.storageReturn.value, test.storageReturn.err)
+			mockStorage.EXPECT().GetGauge(gomock.Any(), test.request.ID, nil).Return(test.storageReturn.value, test.storageReturn.err)
 
 			// Create request
 			request := httptest.NewRequest(http.MethodGet, "/value/", http.NoBody)
@@ -488,11 +480,17 @@ func TestRenderAllMetrics_Common(t *testing.T) {
 		err   error
 	}
 
+	type histogramStorageReturn struct {
+		value map[string]model.HistogramData
+		err   error
+	}
+
 	tests := []struct {
-		name                 string
-		gaugeStorageReturn   gaugeStorageReturn
-		counterStorageReturn counterStorageReturn
-		want                 want
+		name                   string
+		gaugeStorageReturn     gaugeStorageReturn
+		counterStorageReturn   counterStorageReturn
+		histogramStorageReturn histogramStorageReturn
+		want                   want
 	}{
 		{
 			name: "Positive scenario (200)",
@@ -504,6 +502,9 @@ func TestRenderAllMetrics_Common(t *testing.T) {
 				"whatever": 12.5,
 				"bla":      1.5,
 			}, nil},
+			histogramStorageReturn: histogramStorageReturn{map[string]model.HistogramData{
+				"latency": {Bounds: []float64{0.1}, Counts: []int64{2, 1}, Sum: 0.5, Count: 3},
+			}, nil},
 			want: want{
 				contentType: "text/html; charset=utf-8",
 				statusCode:  http.StatusOK,
@@ -512,20 +513,14 @@ func TestRenderAllMetrics_Common(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			// Change to project root and back after test finish
-			originalDir, _ := os.Getwd()
-
-			// TODO: Dont know how to fix it
-			os.Chdir("../../../..")
-			defer os.Chdir(originalDir)
-
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 
 			// Create generated mock
 			mockStorage := mock_storage.NewMockStorage(ctrl)
-			mockStorage.EXPECT().GetAllGauge().Return(test.gaugeStorageReturn.value, test.gaugeStorageReturn.err)
-			mockStorage.EXPECT().GetAllCounter().Return(test.counterStorageReturn.value, test.counterStorageReturn.err)
+			mockStorage.EXPECT().GetAllGauge(gomock.Any()).Return(test.gaugeStorageReturn.value, test.gaugeStorageReturn.err)
+			mockStorage.EXPECT().GetAllCounter(gomock.Any()).Return(test.counterStorageReturn.value, test.counterStorageReturn.err)
+			mockStorage.EXPECT().GetAllHistogram(gomock.Any()).Return(test.histogramStorageReturn.value, test.histogramStorageReturn.err)
 
 			// Create request
 			request := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
@@ -541,6 +536,7 @@ func TestRenderAllMetrics_Common(t *testing.T) {
 			assert.NoError(t, err)
 			assert.Equal(t, test.want.statusCode, responseRecorder.Code)
 			assert.Equal(t, test.want.contentType, responseRecorder.Header().Get("Content-Type"))
+			assert.Contains(t, responseRecorder.Body.String(), "count=3 sum=0.5 [le 0.1: 2, &#43;Inf: 1]")
 		})
 	}
 }
@@ -575,26 +571,19 @@ func TestRenderAllMetrics_GaugeError(t *testing.T) {
 			}, nil},
 			gaugeStorageReturn: gaugeStorageReturn{nil, errors.New("some error")},
 			want: want{
-				contentType: "text/html; charset=utf-8",
+				contentType: "application/problem+json",
 				statusCode:  http.StatusInternalServerError,
 			},
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			// Change to project root and back after test finish
-			originalDir, _ := os.Getwd()
-
-			// TODO: Dont know how to fix it
-			os.Chdir("../../../..")
-			defer os.Chdir(originalDir)
-
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 
 			// Create generated mock
 			mockStorage := mock_storage.NewMockStorage(ctrl)
-			mockStorage.EXPECT().GetAllGauge().Return(test.gaugeStorageReturn.value, test.gaugeStorageReturn.err)
+			mockStorage.EXPECT().GetAllGauge(gomock.Any()).Return(test.gaugeStorageReturn.value, test.gaugeStorageReturn.err)
 
 			// Create request
 			request := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
@@ -644,27 +633,20 @@ func TestRenderAllMetrics_CounterError(t *testing.T) {
 				"bla":      1.5,
 			}, nil},
 			want: want{
-				contentType: "text/html; charset=utf-8",
+				contentType: "application/problem+json",
 				statusCode:  http.StatusInternalServerError,
 			},
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			// Change to project root and back after test finish
-			originalDir, _ := os.Getwd()
-
-			// TODO: Dont know how to fix it
-			os.Chdir("../../../..")
-			defer os.Chdir(originalDir)
-
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 
 			// Create generated mock
 			mockStorage := mock_storage.NewMockStorage(ctrl)
-			mockStorage.EXPECT().GetAllGauge().Return(test.gaugeStorageReturn.value, test.gaugeStorageReturn.err)
-			mockStorage.EXPECT().GetAllCounter().Return(test.counterStorageReturn.value, test.counterStorageReturn.err)
+			mockStorage.EXPECT().GetAllGauge(gomock.Any()).Return(test.gaugeStorageReturn.value, test.gaugeStorageReturn.err)
+			mockStorage.EXPECT().GetAllCounter(gomock.Any()).Return(test.counterStorageReturn.value, test.counterStorageReturn.err)
 
 			// Create request
 			request := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
@@ -683,3 +665,93 @@ func TestRenderAllMetrics_CounterError(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteMetric(t *testing.T) {
+	type want struct {
+		contentType string
+		statusCode  int
+	}
+
+	tests := []struct {
+		name          string
+		request       model.Metrics
+		storageReturn error
+		storageCalled bool
+		want          want
+	}{
+		{
+			name:          "Positive scenario. Histogram metric (200)",
+			request:       model.Metrics{ID: "Latency", MType: string(model.Histogram)},
+			storageCalled: true,
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				statusCode:  http.StatusOK,
+			},
+		},
+		{
+			name:          "Negative scenario. Missing metric (404)",
+			request:       model.Metrics{ID: "whatever", MType: string(model.Gauge)},
+			storageReturn: storage.ErrItemNotFound,
+			storageCalled: true,
+			want: want{
+				contentType: "application/problem+json",
+				statusCode:  http.StatusNotFound,
+			},
+		},
+		{
+			name:          "Negative scenario. Storage error (500)",
+			request:       model.Metrics{ID: "whatever", MType: string(model.Counter)},
+			storageReturn: errors.New("some error"),
+			storageCalled: true,
+			want: want{
+				contentType: "application/problem+json",
+				statusCode:  http.StatusInternalServerError,
+			},
+		},
+		{
+			name:    "Negative scenario. Wrong type (400)",
+			request: model.Metrics{ID: "whatever", MType: "whatever"},
+			want: want{
+				contentType: "application/problem+json",
+				statusCode:  http.StatusBadRequest,
+			},
+		},
+		{
+			name:    "Negative scenario. Empty name (404)",
+			request: model.Metrics{MType: string(model.Gauge)},
+			want: want{
+				contentType: "application/problem+json",
+				statusCode:  http.StatusNotFound,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// Create generated mock
+			mockStorage := mock_storage.NewMockStorage(ctrl)
+			if test.storageCalled {
+				mockStorage.EXPECT().Delete(gomock.Any(), model.MetricType(test.request.MType), test.request.ID, nil).Return(test.storageReturn)
+			}
+
+			// Create request
+			request := httptest.NewRequest(http.MethodDelete, "/value/", http.NoBody)
+			request.SetPathValue("type", test.request.MType)
+			request.SetPathValue("name", test.request.ID)
+
+			responseRecorder := httptest.NewRecorder()
+
+			handler := DeleteMetric(mockStorage)
+			handler(responseRecorder, request)
+
+			result := responseRecorder.Result()
+			err := result.Body.Close()
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.want.statusCode, responseRecorder.Code)
+			assert.Equal(t, test.want.contentType, responseRecorder.Header().Get("Content-Type"))
+		})
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/handlers"
 	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
 	"github.com/zavtra-na-rabotu/gometrics/internal/utils/stringutils"
 	"go.uber.org/zap"
@@ -19,47 +21,42 @@ func UpdateMetric(st storage.Storage) http.HandlerFunc {
 
 		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
 			zap.L().Error("Failed to read body", zap.Error(err))
-			w.WriteHeader(http.StatusBadRequest)
+			problem.New(http.StatusBadRequest, problem.MalformedBody, err.Error()).Write(w, r)
 			return
 		}
 
-		if !(metrics.MType == string(model.Counter) || metrics.MType == string(model.Gauge)) {
-			zap.L().Error("Invalid metric type", zap.String("type", metrics.MType))
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		if stringutils.IsEmpty(metrics.ID) {
-			zap.L().Error("Invalid metric name", zap.String("name", metrics.ID))
-			w.WriteHeader(http.StatusNotFound)
+		if err := metrics.Validate(); err != nil {
+			zap.L().Error("Invalid metric", zap.Error(err))
+			invalidMetric(err).Write(w, r)
 			return
 		}
 
 		switch metrics.MType {
 		case string(model.Counter):
-			if metrics.Delta == nil {
-				zap.L().Error("Empty metric", zap.String("name", metrics.ID), zap.String("type", metrics.MType))
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-
-			newDelta, err := st.UpdateCounterAndReturn(metrics.ID, *metrics.Delta)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+			newDelta, err := st.UpdateCounterAndReturn(r.Context(), metrics.ID, metrics.Labels, *metrics.Delta)
+			if err != nil && !handlers.MirrorFailed(w, err) {
+				zap.L().Error("Failed to update counter metric", zap.Error(err))
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to update counter metric").WithMetric(metrics.ID).Write(w, r)
 				return
 			}
 			*metrics.Delta = newDelta
 		case string(model.Gauge):
-			if metrics.Value == nil {
-				zap.L().Error("Empty metric", zap.String("name", metrics.ID), zap.String("type", metrics.MType))
-				w.WriteHeader(http.StatusBadRequest)
+			err := st.UpdateGauge(r.Context(), metrics.ID, metrics.Labels, *metrics.Value)
+			if err != nil && !handlers.MirrorFailed(w, err) {
+				zap.L().Error("Failed to update gauge metric", zap.Error(err))
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to update gauge metric").WithMetric(metrics.ID).Write(w, r)
 				return
 			}
-
-			err := st.UpdateGauge(metrics.ID, *metrics.Value)
-			if err != nil {
-				zap.L().Error("Failed to update gauge metric", zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+		case string(model.Histogram):
+			err := st.UpdateHistogram(r.Context(), metrics.ID, metrics.Labels, *metrics.Histogram)
+			if err != nil && !handlers.MirrorFailed(w, err) {
+				if errors.Is(err, storage.ErrHistogramBoundsMismatch) {
+					zap.L().Error("Histogram bounds mismatch", zap.String("name", metrics.ID), zap.Error(err))
+					problem.New(http.StatusBadRequest, problem.BoundsMismatch, err.Error()).WithField("histogram").WithMetric(metrics.ID).Write(w, r)
+					return
+				}
+				zap.L().Error("Failed to update histogram metric", zap.Error(err))
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to update histogram metric").WithMetric(metrics.ID).Write(w, r)
 				return
 			}
 		}
@@ -80,47 +77,53 @@ func GetMetric(st storage.Storage) http.HandlerFunc {
 
 		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
 			zap.L().Error("Failed to read body", zap.Error(err))
-			w.WriteHeader(http.StatusBadRequest)
+			problem.New(http.StatusBadRequest, problem.MalformedBody, err.Error()).Write(w, r)
 			return
 		}
 
-		if !(metrics.MType == string(model.Counter) || metrics.MType == string(model.Gauge)) {
-			zap.L().Error("Invalid metric type", zap.String("type", metrics.MType))
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		if stringutils.IsEmpty(metrics.ID) {
-			zap.L().Error("Invalid metric name", zap.String("name", metrics.ID))
-			w.WriteHeader(http.StatusNotFound)
+		if err := metrics.ValidateSeries(); err != nil {
+			zap.L().Error("Invalid metric", zap.Error(err))
+			invalidMetric(err).Write(w, r)
 			return
 		}
 
 		switch metrics.MType {
 		case string(model.Counter):
-			delta, err := st.GetCounter(metrics.ID)
+			delta, err := st.GetCounter(r.Context(), metrics.ID, metrics.Labels)
 			if err != nil {
 				if errors.Is(err, storage.ErrItemNotFound) {
-					w.WriteHeader(http.StatusNotFound)
+					problem.New(http.StatusNotFound, problem.NotFound, err.Error()).WithMetric(metrics.ID).Write(w, r)
 					return
 				}
 				zap.L().Error("Error while getting counter metric", zap.String("metricName", metrics.ID), zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to get counter metric").WithMetric(metrics.ID).Write(w, r)
 				return
 			}
 			metrics.Delta = &delta
 		case string(model.Gauge):
-			value, err := st.GetGauge(metrics.ID)
+			value, err := st.GetGauge(r.Context(), metrics.ID, metrics.Labels)
 			if err != nil {
 				if errors.Is(err, storage.ErrItemNotFound) {
-					w.WriteHeader(http.StatusNotFound)
+					problem.New(http.StatusNotFound, problem.NotFound, err.Error()).WithMetric(metrics.ID).Write(w, r)
 					return
 				}
 				zap.L().Error("Error while getting gauge metric", zap.String("metricName", metrics.ID), zap.Error(err))
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to get gauge metric").WithMetric(metrics.ID).Write(w, r)
 				return
 			}
 			metrics.Value = &value
+		case string(model.Histogram):
+			histogram, err := st.GetHistogram(r.Context(), metrics.ID, metrics.Labels)
+			if err != nil {
+				if errors.Is(err, storage.ErrItemNotFound) {
+					problem.New(http.StatusNotFound, problem.NotFound, err.Error()).WithMetric(metrics.ID).Write(w, r)
+					return
+				}
+				zap.L().Error("Error while getting histogram metric", zap.String("metricName", metrics.ID), zap.Error(err))
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to get histogram metric").WithMetric(metrics.ID).Write(w, r)
+				return
+			}
+			metrics.Histogram = &histogram
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -131,3 +134,63 @@ func GetMetric(st storage.Storage) http.HandlerFunc {
 		}
 	}
 }
+
+// invalidMetric problem of invalid metric, metric without name is not found as in API v1
+func invalidMetric(err error) *problem.Problem {
+	details := problem.InvalidMetric(err)
+	if details.Code == problem.InvalidName && stringutils.IsEmpty(details.MetricID) {
+		details.Status = http.StatusNotFound
+	}
+	return details
+}
+
+// GetMetrics handler to get many metrics in one request, request body is list of metrics with id, type and labels.
+// Response contains found metrics with values and list of metrics which are missing in storage
+func GetMetrics(st storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requested []model.Metrics
+
+		if err := json.NewDecoder(r.Body).Decode(&requested); err != nil {
+			zap.L().Error("Failed to read body", zap.Error(err))
+			problem.New(http.StatusBadRequest, problem.MalformedBody, err.Error()).Write(w, r)
+			return
+		}
+
+		for i := range requested {
+			if err := requested[i].ValidateSeries(); err != nil {
+				zap.L().Error("Invalid metric", zap.Int("position", i), zap.Error(err))
+				problem.InvalidMetric(err).Write(w, r)
+				return
+			}
+		}
+
+		found, err := st.GetMetrics(r.Context(), requested)
+		if err != nil {
+			zap.L().Error("Error while getting metrics", zap.Error(err))
+			problem.New(http.StatusInternalServerError, problem.StorageError, "failed to get metrics").Write(w, r)
+			return
+		}
+
+		if found == nil {
+			found = make([]model.Metrics, 0)
+		}
+		response := model.ValuesResponse{Metrics: found, Missing: make([]model.Metrics, 0)}
+
+		foundSeries := make(map[string]bool, len(found))
+		for _, metric := range found {
+			foundSeries[metric.MType+":"+model.SeriesKey(metric.ID, metric.Labels)] = true
+		}
+		for _, metric := range requested {
+			if !foundSeries[metric.MType+":"+model.SeriesKey(metric.ID, metric.Labels)] {
+				response.Missing = append(response.Missing, model.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&response); err != nil {
+			zap.L().Error("Failed to write response", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -2,6 +2,7 @@ package v2
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	mock_storage "github.com/zavtra-na-rabotu/gometrics/internal/mocks"
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
 
 	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
 )
@@ -111,6 +113,39 @@ func TestUpdateMetric_Common(t *testing.T) {
 				statusCode: http.StatusBadRequest,
 			},
 		},
+		{
+			name: "Negative scenario. Histogram without buckets (400)",
+			request: map[string]interface{}{
+				"id":   "Histogram metric",
+				"type": "histogram",
+			},
+			want: want{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "Negative scenario. Invalid histogram (400)",
+			request: map[string]interface{}{
+				"id":        "Histogram metric",
+				"type":      "histogram",
+				"histogram": map[string]interface{}{"bounds": []float64{1}, "counts": []int64{1}, "sum": 1, "count": 1},
+			},
+			want: want{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			name: "Negative scenario. Empty label name (400)",
+			request: map[string]interface{}{
+				"id":     "Gauge metric",
+				"value":  12,
+				"type":   "gauge",
+				"labels": map[string]string{"": "0"},
+			},
+			want: want{
+				statusCode: http.StatusBadRequest,
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -185,7 +220,7 @@ func TestUpdateMetric_Counter(t *testing.T) {
 
 			// Create generated mock
 			mockStorage := mock_storage.NewMockStorage(ctrl)
-			mockStorage.EXPECT().UpdateCounterAndReturn(test.request.ID, *test.request.Delta).Return(test.storageReturn.counter, test.storageReturn.err)
+			mockStorage.EXPECT().UpdateCounterAndReturn(gomock.Any(), test.request.ID, test.request.Labels, *test.request.Delta).Return(test.storageReturn.counter, test.storageReturn.err)
 
 			// Metrics to JSON
 			metricsJSON, _ := json.Marshal(test.request)
@@ -251,7 +286,7 @@ func TestUpdateMetric_Gauge(t *testing.T) {
 
 			// Create generated mock
 			mockStorage := mock_storage.NewMockStorage(ctrl)
-			mockStorage.EXPECT().UpdateGauge(test.request.ID, *test.request.Value).Return(test.storageReturn.err)
+			mockStorage.EXPECT().UpdateGauge(gomock.Any(), test.request.ID, test.request.Labels, *test.request.Value).Return(test.storageReturn.err)
 
 			// Metrics to JSON
 			metricsJSON, _ := json.Marshal(test.request)
@@ -326,7 +361,7 @@ func TestGetMetric_Counter(t *testing.T) {
 
 		// Create generated mock
 		mockStorage := mock_storage.NewMockStorage(ctrl)
-		mockStorage.EXPECT().GetCounter(test.request.ID).Return(test.storageReturn.delta, test.storageReturn.err)
+		mockStorage.EXPECT().GetCounter(gomock.Any(), test.request.ID, test.request.Labels).Return(test.storageReturn.delta, test.storageReturn.err)
 
 		// Metrics to JSON
 		metricsJSON, _ := json.Marshal(test.request)
@@ -400,7 +435,7 @@ func TestGetMetric_Gauge(t *testing.T) {
 
 		// Create generated mock
 		mockStorage := mock_storage.NewMockStorage(ctrl)
-		mockStorage.EXPECT().GetGauge(test.request.ID).Return(test.storageReturn.value, test.storageReturn.err)
+		mockStorage.EXPECT().GetGauge(gomock.Any(), test.request.ID, test.request.Labels).Return(test.storageReturn.value, test.storageReturn.err)
 
 		// Metrics to JSON
 		metricsJSON, _ := json.Marshal(test.request)
@@ -420,3 +455,133 @@ func TestGetMetric_Gauge(t *testing.T) {
 		assert.Equal(t, test.want.statusCode, responseRecorder.Code)
 	}
 }
+
+func TestUpdateMetric_Histogram(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	histogram := model.HistogramData{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 0}, Sum: 1.5, Count: 3}
+	labels := model.Labels{"handler": "/update/"}
+
+	// Create generated mock
+	mockStorage := mock_storage.NewMockStorage(ctrl)
+	mockStorage.EXPECT().UpdateHistogram(gomock.Any(), "Latency", labels, histogram).Return(nil)
+
+	metricsJSON, _ := json.Marshal(model.Metrics{ID: "Latency", MType: string(model.Histogram), Labels: labels, Histogram: &histogram})
+
+	request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewBuffer(metricsJSON))
+	responseRecorder := httptest.NewRecorder()
+
+	handler := UpdateMetric(mockStorage)
+	handler.ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+}
+
+func TestGetMetrics(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	assert.NoError(t, memStorage.UpdateGauge(context.Background(), "Alloc", nil, 1.5))
+	assert.NoError(t, memStorage.UpdateCounter(context.Background(), "PollCount", nil, 3))
+
+	requestJSON := `[{"id":"PollCount","type":"counter"},{"id":"Missing","type":"gauge"},{"id":"Alloc","type":"gauge"}]`
+	request := httptest.NewRequest(http.MethodPost, "/values/", bytes.NewBufferString(requestJSON))
+	responseRecorder := httptest.NewRecorder()
+
+	handler := GetMetrics(memStorage)
+	handler.ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.Equal(t, "application/json", responseRecorder.Header().Get("Content-Type"))
+
+	var response model.ValuesResponse
+	assert.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&response))
+
+	value := 1.5
+	delta := int64(3)
+	assert.Equal(t, []model.Metrics{
+		{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+		{ID: "Alloc", MType: string(model.Gauge), Value: &value},
+	}, response.Metrics)
+	assert.Equal(t, []model.Metrics{{ID: "Missing", MType: string(model.Gauge)}}, response.Missing)
+}
+
+func TestGetMetrics_Negative(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    string
+		storageErr error
+		statusCode int
+	}{
+		{
+			name:       "Negative scenario. Invalid json (400)",
+			request:    `{"id":"Alloc","type":"gauge"}`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Negative scenario. Invalid metric type (400)",
+			request:    `[{"id":"Alloc","type":"gauge"},{"id":"Alloc","type":"summary"}]`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Negative scenario. Empty metric name (400)",
+			request:    `[{"id":"","type":"gauge"}]`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Negative scenario. Storage error (500)",
+			request:    `[{"id":"Alloc","type":"gauge"}]`,
+			storageErr: errors.New("some error"),
+			statusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockStorage := mock_storage.NewMockStorage(ctrl)
+			if test.storageErr != nil {
+				mockStorage.EXPECT().GetMetrics(gomock.Any(), gomock.Any()).Return(nil, test.storageErr)
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/values/", bytes.NewBufferString(test.request))
+			responseRecorder := httptest.NewRecorder()
+
+			handler := GetMetrics(mockStorage)
+			handler.ServeHTTP(responseRecorder, request)
+
+			assert.Equal(t, test.statusCode, responseRecorder.Code)
+		})
+	}
+}
+
+func TestUpdateMetric_Problem(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		code    problem.Code
+		field   string
+	}{
+		{name: "Malformed body", request: `{"id":`, code: problem.MalformedBody},
+		{name: "Wrong type", request: `{"id":"PollCount","type":"summary","delta":1}`, code: problem.InvalidType, field: "type"},
+		{name: "Missing delta", request: `{"id":"PollCount","type":"counter"}`, code: problem.MissingValue, field: "delta"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewBufferString(test.request))
+			responseRecorder := httptest.NewRecorder()
+
+			handler := UpdateMetric(storage.NewMemStorage())
+			handler.ServeHTTP(responseRecorder, request)
+
+			assert.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+
+			details, ok := problem.Read(responseRecorder.Header().Get("Content-Type"), responseRecorder.Body)
+			if assert.True(t, ok) {
+				assert.Equal(t, test.code, details.Code)
+				assert.Equal(t, test.field, details.Field)
+				assert.Equal(t, "/update/", details.Instance)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package v3
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/handlers"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+	"go.uber.org/zap"
+)
+
+// DeleteMetrics handler to delete batch of series and all series with name starting with one of prefixes.
+// Series which do not exist are skipped, response contains number of deleted series
+func DeleteMetrics(st storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request model.DeleteRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			zap.L().Error("Failed to read body", zap.Error(err))
+			problem.New(http.StatusBadRequest, problem.MalformedBody, err.Error()).Write(w, r)
+			return
+		}
+
+		if err := request.Validate(); err != nil {
+			zap.L().Error("Invalid delete request", zap.Error(err))
+			problem.InvalidMetric(err).Write(w, r)
+			return
+		}
+
+		var response model.DeleteResponse
+
+		for _, metric := range request.Metrics {
+			err := st.Delete(r.Context(), model.MetricType(metric.MType), metric.ID, metric.Labels)
+			if errors.Is(err, storage.ErrItemNotFound) {
+				continue
+			}
+			if err != nil && !handlers.MirrorFailed(w, err) {
+				zap.L().Error("Failed to delete metric", zap.String("name", metric.ID), zap.Error(err))
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to delete metric").WithMetric(metric.ID).Write(w, r)
+				return
+			}
+			response.Deleted++
+		}
+
+		for _, prefix := range request.Prefixes {
+			deleted, err := st.DeleteByPrefix(r.Context(), prefix)
+			if err != nil && !handlers.MirrorFailed(w, err) {
+				zap.L().Error("Failed to delete metrics by prefix", zap.String("prefix", prefix), zap.Error(err))
+				problem.New(http.StatusInternalServerError, problem.StorageError, fmt.Sprintf("failed to delete metrics by prefix %q", prefix)).Write(w, r)
+				return
+			}
+			response.Deleted += deleted
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&response); err != nil {
+			zap.L().Error("Failed to write response", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
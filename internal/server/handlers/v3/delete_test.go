@@ -0,0 +1,85 @@
+package v3
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	mock_storage "github.com/zavtra-na-rabotu/gometrics/internal/mocks"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+)
+
+func TestDeleteMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mock_storage.NewMockStorage(ctrl)
+	gomock.InOrder(
+		mockStorage.EXPECT().Delete(gomock.Any(), model.Gauge, "Alloc", model.Labels{"host": "a"}).Return(nil),
+		mockStorage.EXPECT().Delete(gomock.Any(), model.Counter, "Missing", nil).Return(storage.ErrItemNotFound),
+		mockStorage.EXPECT().DeleteByPrefix(gomock.Any(), "Typo.").Return(3, nil),
+	)
+
+	body := `{"metrics":[{"id":"Alloc","type":"gauge","labels":{"host":"a"}},{"id":"Missing","type":"counter"}],"prefixes":["Typo."]}`
+	request := httptest.NewRequest(http.MethodPost, "/delete/", bytes.NewBufferString(body))
+	responseRecorder := httptest.NewRecorder()
+
+	DeleteMetrics(mockStorage).ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.Equal(t, "application/json", responseRecorder.Header().Get("Content-Type"))
+
+	// Missing series is skipped and not counted
+	var response model.DeleteResponse
+	assert.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&response))
+	assert.Equal(t, 4, response.Deleted)
+}
+
+func TestDeleteMetrics_StorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mock_storage.NewMockStorage(ctrl)
+	mockStorage.EXPECT().DeleteByPrefix(gomock.Any(), "Typo.").Return(0, errors.New("something went wrong"))
+
+	request := httptest.NewRequest(http.MethodPost, "/delete/", bytes.NewBufferString(`{"prefixes":["Typo."]}`))
+	responseRecorder := httptest.NewRecorder()
+
+	DeleteMetrics(mockStorage).ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusInternalServerError, responseRecorder.Code)
+}
+
+func TestDeleteMetrics_InvalidRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+	}{
+		{name: "Not JSON", request: `delete`},
+		{name: "Empty name", request: `{"metrics":[{"id":"","type":"gauge"}]}`},
+		{name: "Unknown type", request: `{"metrics":[{"id":"Alloc","type":"summary"}]}`},
+		{name: "Empty prefix", request: `{"prefixes":[""]}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// Storage must not be called with invalid request
+			mockStorage := mock_storage.NewMockStorage(ctrl)
+
+			request := httptest.NewRequest(http.MethodPost, "/delete/", bytes.NewBufferString(test.request))
+			responseRecorder := httptest.NewRecorder()
+
+			DeleteMetrics(mockStorage).ServeHTTP(responseRecorder, request)
+
+			assert.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+		})
+	}
+}
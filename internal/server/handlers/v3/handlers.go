@@ -3,9 +3,14 @@ package v3
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"sort"
 
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/handlers"
 	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
 	"go.uber.org/zap"
 )
@@ -13,15 +18,16 @@ import (
 // Ping verifies a connection to the database is still alive
 func Ping(st storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if _, ok := st.(*storage.DBStorage); !ok {
-			w.WriteHeader(http.StatusInternalServerError)
+		repository, ok := st.(storage.Repository)
+		if !ok {
+			problem.New(http.StatusInternalServerError, problem.NotSupported, "storage does not support ping").Write(w, r)
 			return
 		}
 
-		err := st.(*storage.DBStorage).Ping()
+		err := repository.Ping(r.Context())
 		if err != nil {
 			zap.L().Error("Database ping failed", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.New(http.StatusInternalServerError, problem.StorageError, "storage ping failed").Write(w, r)
 			return
 		}
 
@@ -29,24 +35,137 @@ func Ping(st storage.Storage) http.HandlerFunc {
 	}
 }
 
-// UpdateMetrics handler to update batch of metrics
+// UpdateMetrics handler to update batch of metrics.
+// By default batch is atomic: it is stored or rejected as a whole. With X-Batch-Mode: partial header
+// valid metrics are stored and response lists rejected metrics with reason
 func UpdateMetrics(st storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var metrics []model.Metrics
 
 		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
 			zap.L().Error("Failed to read body", zap.Error(err))
-			w.WriteHeader(http.StatusBadRequest)
+			problem.New(http.StatusBadRequest, problem.MalformedBody, err.Error()).Write(w, r)
 			return
 		}
 
-		err := st.UpdateMetrics(metrics)
+		switch mode := r.Header.Get(model.BatchModeHeader); mode {
+		case "", model.BatchModeAtomic:
+		case model.BatchModePartial:
+			updateMetricsPartially(w, r, st, metrics)
+			return
+		default:
+			zap.L().Error("Invalid batch mode", zap.String("mode", mode))
+			problem.New(http.StatusBadRequest, problem.InvalidParameter, fmt.Sprintf("unknown batch mode %q", mode)).
+				WithField(model.BatchModeHeader).Write(w, r)
+			return
+		}
+
+		if err := storage.ValidateMetrics(metrics); err != nil {
+			zap.L().Error("Invalid batch of metrics", zap.Error(err))
+			problem.InvalidMetric(err).Write(w, r)
+			return
+		}
+
+		err := st.UpdateMetrics(r.Context(), metrics)
+		if handlers.MirrorFailed(w, err) {
+			err = nil
+		}
+		if errors.Is(err, storage.ErrHistogramBoundsMismatch) {
+			zap.L().Error("Failed to apply batch of metrics", zap.Error(err))
+			problem.New(http.StatusBadRequest, problem.BoundsMismatch, err.Error()).WithField("histogram").Write(w, r)
+			return
+		}
+		if errors.Is(err, storage.ErrInvalidMetric) {
+			zap.L().Error("Failed to apply batch of metrics", zap.Error(err))
+			problem.InvalidMetric(err).Write(w, r)
+			return
+		}
 		if err != nil {
 			zap.L().Error("Failed to update metrics", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.New(http.StatusInternalServerError, problem.StorageError, "failed to update metrics").Write(w, r)
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// updateMetricsPartially store valid metrics of batch and respond with rejected ones.
+// Valid metrics are applied as one batch, when it conflicts with stored histograms they are applied one by one
+func updateMetricsPartially(w http.ResponseWriter, r *http.Request, st storage.Storage, metrics []model.Metrics) {
+	result := model.BatchResult{Rejected: make([]model.RejectedMetric, 0)}
+
+	valid := make([]model.Metrics, 0, len(metrics))
+	positions := make([]int, 0, len(metrics))
+	for i := range metrics {
+		if err := metrics[i].Validate(); err != nil {
+			result.Rejected = append(result.Rejected, rejectMetric(i, metrics[i], problem.InvalidMetric(err), false))
+			continue
+		}
+		valid = append(valid, metrics[i])
+		positions = append(positions, i)
+	}
+
+	var err error
+	if len(valid) > 0 {
+		err = st.UpdateMetrics(r.Context(), valid)
+	}
+
+	switch {
+	case err == nil:
+		result.Accepted = len(valid)
+	case handlers.MirrorFailed(w, err):
+		result.Accepted = len(valid)
+		result.Unmirrored = positions
+	case errors.Is(err, storage.ErrHistogramBoundsMismatch):
+		for j, metric := range valid {
+			err := st.UpdateMetrics(r.Context(), []model.Metrics{metric})
+			switch {
+			case err == nil:
+				result.Accepted++
+			case handlers.MirrorFailed(w, err):
+				result.Accepted++
+				result.Unmirrored = append(result.Unmirrored, positions[j])
+			case errors.Is(err, storage.ErrHistogramBoundsMismatch):
+				details := problem.New(http.StatusBadRequest, problem.BoundsMismatch, err.Error()).WithField("histogram")
+				result.Rejected = append(result.Rejected, rejectMetric(positions[j], metric, details, false))
+			default:
+				zap.L().Error("Failed to update metric", zap.String("name", metric.ID), zap.Error(err))
+				details := problem.New(http.StatusInternalServerError, problem.StorageError, "failed to update metric")
+				result.Rejected = append(result.Rejected, rejectMetric(positions[j], metric, details, true))
+			}
+		}
+	default:
+		zap.L().Error("Failed to update metrics", zap.Error(err))
+		problem.New(http.StatusInternalServerError, problem.StorageError, "failed to update metrics").Write(w, r)
+		return
+	}
+
+	sort.Slice(result.Rejected, func(i, j int) bool {
+		return result.Rejected[i].Index < result.Rejected[j].Index
+	})
+
+	if len(result.Rejected) > 0 {
+		zap.L().Warn("Metrics of batch were rejected", zap.Int("accepted", result.Accepted), zap.Int("rejected", len(result.Rejected)))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&result); err != nil {
+		zap.L().Error("Failed to write response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// rejectMetric describe rejected metric at position of batch by problem
+func rejectMetric(index int, metric model.Metrics, details *problem.Problem, retryable bool) model.RejectedMetric {
+	return model.RejectedMetric{
+		Index:     index,
+		ID:        metric.ID,
+		MType:     metric.MType,
+		Code:      string(details.Code),
+		Field:     details.Field,
+		Detail:    details.Detail,
+		Retryable: retryable,
+	}
+}
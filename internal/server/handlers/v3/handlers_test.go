@@ -2,18 +2,21 @@ package v3
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	mock_storage "github.com/zavtra-na-rabotu/gometrics/internal/mocks"
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
 	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
 )
 
@@ -88,6 +91,15 @@ func TestUpdateMetrics(t *testing.T) {
 				statusCode:  http.StatusInternalServerError,
 			},
 		},
+		{
+			name:           "Negative scenario. Histogram bounds mismatch (400)",
+			request:        []model.Metrics{},
+			storageReturns: storage.ErrHistogramBoundsMismatch,
+			want: want{
+				contentType: "text/plain; charset=utf-8",
+				statusCode:  http.StatusBadRequest,
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -96,7 +108,7 @@ func TestUpdateMetrics(t *testing.T) {
 
 			// Create generated mock
 			mockStorage := mock_storage.NewMockStorage(ctrl)
-			mockStorage.EXPECT().UpdateMetrics(test.request).Return(test.storageReturns)
+			mockStorage.EXPECT().UpdateMetrics(gomock.Any(), test.request).Return(test.storageReturns)
 
 			// Metrics to JSON
 			metricsJSON, _ := json.Marshal(test.request)
@@ -117,3 +129,236 @@ func TestUpdateMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateMetrics_InvalidBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+	}{
+		{name: "Counter without delta", request: `[{"id":"Gauge metric","type":"gauge","value":1},{"id":"Counter metric","type":"counter"}]`},
+		{name: "Gauge without value", request: `[{"id":"Gauge metric","type":"gauge"}]`},
+		{name: "Unknown type", request: `[{"id":"Metric","type":"summary","value":1}]`},
+		{name: "Empty name", request: `[{"id":"","type":"gauge","value":1}]`},
+		{name: "Histogram without value", request: `[{"id":"Latency","type":"histogram"}]`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// Storage must not be called with invalid batch
+			mockStorage := mock_storage.NewMockStorage(ctrl)
+			mockStorage.EXPECT().UpdateMetrics(gomock.Any(), gomock.Any()).Times(0)
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(test.request))
+			responseRecorder := httptest.NewRecorder()
+
+			UpdateMetrics(mockStorage).ServeHTTP(responseRecorder, request)
+
+			assert.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+		})
+	}
+}
+
+func TestUpdateMetrics_Partial(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	histogram := model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 0.5, Count: 1}
+	assert.NoError(t, memStorage.UpdateHistogram(context.Background(), "Latency", nil, histogram))
+
+	requestJSON := `[
+		{"id":"Alloc","type":"gauge","value":1.5},
+		{"id":"PollCount","type":"counter"},
+		{"id":"Latency","type":"histogram","histogram":{"bounds":[5],"counts":[1,0],"sum":1,"count":1}},
+		{"id":"Requests","type":"counter","delta":2}
+	]`
+	request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(requestJSON))
+	request.Header.Set(model.BatchModeHeader, model.BatchModePartial)
+	responseRecorder := httptest.NewRecorder()
+
+	UpdateMetrics(memStorage).ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.Equal(t, "application/json", responseRecorder.Header().Get("Content-Type"))
+
+	var result model.BatchResult
+	assert.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&result))
+	assert.Equal(t, 2, result.Accepted)
+	if assert.Len(t, result.Rejected, 2) {
+		assert.Equal(t, 1, result.Rejected[0].Index)
+		assert.Equal(t, string(problem.MissingValue), result.Rejected[0].Code)
+		assert.Equal(t, "delta", result.Rejected[0].Field)
+		assert.False(t, result.Rejected[0].Retryable)
+
+		assert.Equal(t, 2, result.Rejected[1].Index)
+		assert.Equal(t, "Latency", result.Rejected[1].ID)
+		assert.Equal(t, string(problem.BoundsMismatch), result.Rejected[1].Code)
+		assert.False(t, result.Rejected[1].Retryable)
+	}
+
+	gauge, err := memStorage.GetGauge(context.Background(), "Alloc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, gauge)
+	counter, err := memStorage.GetCounter(context.Background(), "Requests", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), counter)
+}
+
+func TestUpdateMetrics_SeriesKeyCollision(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+
+	// Name with labels syntax would get the same series key as metric with labels
+	requestJSON := `[
+		{"id":"Requests{path=\"/\"}","type":"counter","delta":1},
+		{"id":"Requests","type":"counter","delta":2,"labels":{"path":"/"}}
+	]`
+	request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(requestJSON))
+	request.Header.Set(model.BatchModeHeader, model.BatchModePartial)
+	responseRecorder := httptest.NewRecorder()
+
+	UpdateMetrics(memStorage).ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	var result model.BatchResult
+	assert.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&result))
+	assert.Equal(t, 1, result.Accepted)
+	if assert.Len(t, result.Rejected, 1) {
+		assert.Equal(t, 0, result.Rejected[0].Index)
+		assert.Equal(t, string(problem.InvalidName), result.Rejected[0].Code)
+	}
+
+	// Series with labels is not merged with rejected one
+	counter, err := memStorage.GetCounter(context.Background(), "Requests", model.Labels{"path": "/"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), counter)
+}
+
+func TestUpdateMetrics_MirrorFailed(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchMode string
+		want      string
+	}{
+		{name: "Atomic", batchMode: ""},
+		{name: "Partial", batchMode: model.BatchModePartial, want: `{"accepted":1,"rejected":[],"unmirrored":[0]}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			secondary := mock_storage.NewMockStorage(ctrl)
+			secondary.EXPECT().UpdateMetrics(gomock.Any(), gomock.Any()).Return(errors.New("secondary is down"))
+
+			primary := storage.NewMemStorage()
+			mirrored := storage.NewMirroredStorage(primary, storage.MirrorPolicyFail, time.Hour, secondary)
+
+			request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(`[{"id":"PollCount","type":"counter","delta":5}]`))
+			if test.batchMode != "" {
+				request.Header.Set(model.BatchModeHeader, test.batchMode)
+			}
+			responseRecorder := httptest.NewRecorder()
+
+			UpdateMetrics(mirrored).ServeHTTP(responseRecorder, request)
+
+			// Update is stored by primary, so it succeeds and must not be sent again
+			assert.Equal(t, http.StatusOK, responseRecorder.Code)
+			assert.Equal(t, "true", responseRecorder.Header().Get(model.MirrorFailedHeader))
+			if test.want != "" {
+				assert.JSONEq(t, test.want, responseRecorder.Body.String())
+			}
+
+			counter, err := primary.GetCounter(context.Background(), "PollCount", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(5), counter)
+		})
+	}
+}
+
+func TestUpdateMetrics_PartialMirrorFailedOneByOne(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	secondary := mock_storage.NewMockStorage(ctrl)
+	secondary.EXPECT().UpdateMetrics(gomock.Any(), gomock.Any()).Return(errors.New("secondary is down"))
+
+	primary := storage.NewMemStorage()
+	histogram := model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 0.5, Count: 1}
+	assert.NoError(t, primary.UpdateHistogram(context.Background(), "Latency", nil, histogram))
+	mirrored := storage.NewMirroredStorage(primary, storage.MirrorPolicyFail, time.Hour, secondary)
+
+	// Bounds mismatch makes metrics be applied one by one
+	requestJSON := `[
+		{"id":"Latency","type":"histogram","histogram":{"bounds":[5],"counts":[1,0],"sum":1,"count":1}},
+		{"id":"Requests","type":"counter","delta":2}
+	]`
+	request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(requestJSON))
+	request.Header.Set(model.BatchModeHeader, model.BatchModePartial)
+	responseRecorder := httptest.NewRecorder()
+
+	UpdateMetrics(mirrored).ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	var result model.BatchResult
+	assert.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&result))
+	assert.Equal(t, 1, result.Accepted)
+	assert.Equal(t, []int{1}, result.Unmirrored)
+	if assert.Len(t, result.Rejected, 1) {
+		assert.Equal(t, 0, result.Rejected[0].Index)
+		assert.Equal(t, string(problem.BoundsMismatch), result.Rejected[0].Code)
+	}
+}
+
+func TestUpdateMetrics_PartialStorageError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	value := 1.5
+	histogram := model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 0.5, Count: 1}
+	gauge := model.Metrics{ID: "Alloc", MType: string(model.Gauge), Value: &value}
+	latency := model.Metrics{ID: "Latency", MType: string(model.Histogram), Histogram: &histogram}
+
+	// Failed batch is applied item by item, storage failure of one item can be retried
+	mockStorage := mock_storage.NewMockStorage(ctrl)
+	gomock.InOrder(
+		mockStorage.EXPECT().UpdateMetrics(gomock.Any(), []model.Metrics{latency, gauge}).Return(storage.ErrHistogramBoundsMismatch),
+		mockStorage.EXPECT().UpdateMetrics(gomock.Any(), []model.Metrics{latency}).Return(storage.ErrHistogramBoundsMismatch),
+		mockStorage.EXPECT().UpdateMetrics(gomock.Any(), []model.Metrics{gauge}).Return(errors.New("connection refused")),
+	)
+
+	body, _ := json.Marshal([]model.Metrics{latency, gauge})
+	request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBuffer(body))
+	request.Header.Set(model.BatchModeHeader, model.BatchModePartial)
+	responseRecorder := httptest.NewRecorder()
+
+	UpdateMetrics(mockStorage).ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	var result model.BatchResult
+	assert.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&result))
+	assert.Equal(t, 0, result.Accepted)
+	assert.Equal(t, []model.RejectedMetric{
+		{Index: 0, ID: "Latency", MType: "histogram", Code: string(problem.BoundsMismatch), Field: "histogram",
+			Detail: storage.ErrHistogramBoundsMismatch.Error()},
+		{Index: 1, ID: "Alloc", MType: "gauge", Code: string(problem.StorageError), Detail: "failed to update metric", Retryable: true},
+	}, result.Rejected)
+}
+
+func TestUpdateMetrics_UnknownBatchMode(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockStorage := mock_storage.NewMockStorage(ctrl)
+	mockStorage.EXPECT().UpdateMetrics(gomock.Any(), gomock.Any()).Times(0)
+
+	request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(`[]`))
+	request.Header.Set(model.BatchModeHeader, "best-effort")
+	responseRecorder := httptest.NewRecorder()
+
+	UpdateMetrics(mockStorage).ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+}
@@ -0,0 +1,178 @@
+package v3
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+	"github.com/zavtra-na-rabotu/gometrics/internal/utils/stringutils"
+	"go.uber.org/zap"
+)
+
+// defaultHistoryRange range of history returned when {from} is not specified
+const defaultHistoryRange = time.Hour
+
+var errInvalidLabel = errors.New("label must be in name=value format")
+
+// GetHistory handler to get time series of metric specified by type and name as path parameters.
+// Query parameters: from and to (RFC3339 or unix seconds), step (duration like 1m or seconds) and
+// repeated label=name=value to select series by labels.
+// With resolution (duration like 1m or seconds) rollups of retention tier are returned instead of raw samples
+func GetHistory(st storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricType := model.MetricType(r.PathValue("type"))
+		metricName := r.PathValue("name")
+
+		if !(metricType == model.Counter || metricType == model.Gauge) {
+			zap.L().Error("Invalid metric type", zap.String("metricType", string(metricType)))
+			problem.New(http.StatusBadRequest, problem.InvalidType, fmt.Sprintf("history of metric type %q is not kept", metricType)).
+				WithField("type").WithMetric(metricName).Write(w, r)
+			return
+		}
+
+		if stringutils.IsEmpty(metricName) {
+			zap.L().Error("Invalid metric name", zap.String("metricName", metricName))
+			problem.New(http.StatusNotFound, problem.InvalidName, "empty metric name").WithField("name").Write(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+
+		to, err := parseTime(query.Get("to"), time.Now())
+		if err != nil {
+			zap.L().Error("Invalid to parameter", zap.String("to", query.Get("to")), zap.Error(err))
+			problem.New(http.StatusBadRequest, problem.InvalidParameter, err.Error()).WithField("to").Write(w, r)
+			return
+		}
+
+		from, err := parseTime(query.Get("from"), to.Add(-defaultHistoryRange))
+		if err != nil {
+			zap.L().Error("Invalid from parameter", zap.String("from", query.Get("from")), zap.Error(err))
+			problem.New(http.StatusBadRequest, problem.InvalidParameter, err.Error()).WithField("from").Write(w, r)
+			return
+		}
+
+		step, err := parseStep(query.Get("step"))
+		if err != nil {
+			zap.L().Error("Invalid step parameter", zap.String("step", query.Get("step")), zap.Error(err))
+			problem.New(http.StatusBadRequest, problem.InvalidParameter, err.Error()).WithField("step").Write(w, r)
+			return
+		}
+
+		resolution, err := parseStep(query.Get("resolution"))
+		if err != nil {
+			zap.L().Error("Invalid resolution parameter", zap.String("resolution", query.Get("resolution")), zap.Error(err))
+			problem.New(http.StatusBadRequest, problem.InvalidParameter, err.Error()).WithField("resolution").Write(w, r)
+			return
+		}
+
+		if step > 0 && resolution > 0 {
+			zap.L().Error("Step and resolution can not be used together")
+			problem.New(http.StatusBadRequest, problem.InvalidParameter, "step and resolution can not be used together").
+				WithField("resolution").Write(w, r)
+			return
+		}
+
+		labels, err := parseLabels(query)
+		if err != nil {
+			zap.L().Error("Invalid label parameter", zap.Error(err))
+			problem.New(http.StatusBadRequest, problem.InvalidParameter, err.Error()).WithField("label").Write(w, r)
+			return
+		}
+
+		if from.After(to) {
+			zap.L().Error("Invalid range", zap.Time("from", from), zap.Time("to", to))
+			problem.New(http.StatusBadRequest, problem.InvalidParameter, "from is after to").WithField("from").Write(w, r)
+			return
+		}
+
+		history := model.History{
+			ID:      metricName,
+			MType:   string(metricType),
+			Labels:  labels,
+			Samples: []model.Sample{},
+		}
+
+		if resolution > 0 {
+			rollups, err := st.GetRollups(r.Context(), metricType, metricName, labels, resolution, from, to)
+			if err != nil {
+				zap.L().Error("Error while getting metric rollups", zap.String("metricName", metricName), zap.Error(err))
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to get metric rollups").WithMetric(metricName).Write(w, r)
+				return
+			}
+			history.Resolution = resolution.String()
+			history.Rollups = rollups
+		} else {
+			samples, err := st.GetHistory(r.Context(), metricType, metricName, labels, from, to)
+			if err != nil {
+				zap.L().Error("Error while getting metric history", zap.String("metricName", metricName), zap.Error(err))
+				problem.New(http.StatusInternalServerError, problem.StorageError, "failed to get metric history").WithMetric(metricName).Write(w, r)
+				return
+			}
+			history.Samples = storage.Downsample(samples, metricType, from, step)
+			if step > 0 {
+				history.Step = step.String()
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&history); err != nil {
+			zap.L().Error("Failed to write response", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// parseTime parse RFC3339 or unix seconds, empty value gives defaultValue
+func parseTime(value string, defaultValue time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseStep parse duration like 1m or number of seconds, empty value means no aggregation
+func parseStep(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// parseLabels collect repeated label=name=value query parameters
+func parseLabels(query url.Values) (model.Labels, error) {
+	var labels model.Labels
+
+	for _, label := range query["label"] {
+		name, value, ok := strings.Cut(label, "=")
+		if !ok || stringutils.IsEmpty(name) {
+			return nil, errInvalidLabel
+		}
+
+		if labels == nil {
+			labels = make(model.Labels)
+		}
+		labels[name] = value
+	}
+
+	return labels, nil
+}
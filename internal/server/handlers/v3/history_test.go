@@ -0,0 +1,138 @@
+package v3
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	mock_storage "github.com/zavtra-na-rabotu/gometrics/internal/mocks"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+func TestGetHistory(t *testing.T) {
+	from := time.Unix(1700000000, 0)
+	to := from.Add(10 * time.Minute)
+	samples := []model.Sample{
+		{Timestamp: from.Add(10 * time.Second), Value: 1},
+		{Timestamp: from.Add(20 * time.Second), Value: 3},
+	}
+
+	tests := []struct {
+		name           string
+		url            string
+		callStorage    bool
+		labels         model.Labels
+		resolution     time.Duration
+		storageReturns error
+		statusCode     int
+		wantSamples    []model.Sample
+	}{
+		{
+			name:        "Positive scenario. Raw samples (200)",
+			url:         "/history/gauge/HeapAlloc?from=1700000000&to=1700000600",
+			callStorage: true,
+			statusCode:  http.StatusOK,
+			wantSamples: samples,
+		},
+		{
+			name:        "Positive scenario. Downsampled with labels (200)",
+			url:         "/history/gauge/CPUutilization?from=1700000000&to=1700000600&step=1m&label=cpu=0",
+			callStorage: true,
+			labels:      model.Labels{"cpu": "0"},
+			statusCode:  http.StatusOK,
+			wantSamples: []model.Sample{{Timestamp: from, Value: 2}},
+		},
+		{
+			name:        "Positive scenario. Rollups of retention tier (200)",
+			url:         "/history/gauge/HeapAlloc?from=1700000000&to=1700000600&resolution=1m",
+			callStorage: true,
+			resolution:  time.Minute,
+			statusCode:  http.StatusOK,
+		},
+		{
+			name:       "Negative scenario. Step with resolution (400)",
+			url:        "/history/gauge/HeapAlloc?step=1m&resolution=1m",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Negative scenario. Invalid type (400)",
+			url:        "/history/histogram/Latency",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Negative scenario. Invalid from (400)",
+			url:        "/history/gauge/HeapAlloc?from=yesterday",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Negative scenario. Invalid step (400)",
+			url:        "/history/gauge/HeapAlloc?step=often",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Negative scenario. Invalid label (400)",
+			url:        "/history/gauge/HeapAlloc?label=cpu",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Negative scenario. From after to (400)",
+			url:        "/history/gauge/HeapAlloc?from=1700000600&to=1700000000",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Negative scenario. Storage error (500)",
+			url:            "/history/counter/PollCount?from=1700000000&to=1700000600",
+			callStorage:    true,
+			storageReturns: errors.New("something went wrong"),
+			statusCode:     http.StatusInternalServerError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockStorage := mock_storage.NewMockStorage(ctrl)
+			rollups := []model.Rollup{{Timestamp: from, Min: 1, Max: 3, Avg: 2, Count: 2}}
+			if test.callStorage && test.resolution > 0 {
+				mockStorage.EXPECT().
+					GetRollups(gomock.Any(), gomock.Any(), gomock.Any(), test.labels, test.resolution, from, to).
+					Return(rollups, test.storageReturns)
+			} else if test.callStorage {
+				mockStorage.EXPECT().
+					GetHistory(gomock.Any(), gomock.Any(), gomock.Any(), test.labels, from, to).
+					Return(samples, test.storageReturns)
+			}
+
+			r := chi.NewRouter()
+			r.Get("/history/{type}/{name}", GetHistory(mockStorage))
+
+			request := httptest.NewRequest(http.MethodGet, test.url, nil)
+			responseRecorder := httptest.NewRecorder()
+			r.ServeHTTP(responseRecorder, request)
+
+			assert.Equal(t, test.statusCode, responseRecorder.Code)
+
+			if test.statusCode == http.StatusOK {
+				var history model.History
+				err := json.NewDecoder(responseRecorder.Body).Decode(&history)
+				assert.NoError(t, err)
+				assert.Equal(t, len(test.wantSamples), len(history.Samples))
+				for i, sample := range test.wantSamples {
+					assert.True(t, sample.Timestamp.Equal(history.Samples[i].Timestamp))
+					assert.Equal(t, sample.Value, history.Samples[i].Value)
+				}
+				if test.resolution > 0 {
+					assert.Equal(t, test.resolution.String(), history.Resolution)
+					assert.Len(t, history.Rollups, len(rollups))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package v3
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultListLimit page size when {limit} is not specified
+	defaultListLimit = 100
+	// maxListLimit the biggest allowed page size
+	maxListLimit = 1000
+)
+
+var errInvalidSort = errors.New("sort must be name or -name")
+
+// ListMetrics handler to list metrics as JSON page by page.
+// Query parameters: type and prefix to select metrics, sort (name or -name) to order by name, type and labels,
+// limit as page size and cursor returned in next_cursor of previous page
+func ListMetrics(st storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		metricsQuery := model.MetricsQuery{
+			Type:   model.MetricType(query.Get("type")),
+			Prefix: query.Get("prefix"),
+		}
+
+		switch metricsQuery.Type {
+		case "", model.Gauge, model.Counter, model.Histogram:
+		default:
+			zap.L().Error("Invalid metric type", zap.String("metricType", string(metricsQuery.Type)))
+			problem.New(http.StatusBadRequest, problem.InvalidType, fmt.Sprintf("unknown metric type %q", metricsQuery.Type)).
+				WithField("type").Write(w, r)
+			return
+		}
+
+		desc, err := parseSort(query.Get("sort"))
+		if err != nil {
+			zap.L().Error("Invalid sort parameter", zap.String("sort", query.Get("sort")), zap.Error(err))
+			problem.New(http.StatusBadRequest, problem.InvalidParameter, err.Error()).WithField("sort").Write(w, r)
+			return
+		}
+		metricsQuery.Desc = desc
+
+		limit, err := parseLimit(query.Get("limit"))
+		if err != nil {
+			zap.L().Error("Invalid limit parameter", zap.String("limit", query.Get("limit")), zap.Error(err))
+			problem.New(http.StatusBadRequest, problem.InvalidParameter, err.Error()).WithField("limit").Write(w, r)
+			return
+		}
+
+		after, err := decodeCursor(query.Get("cursor"))
+		if err != nil {
+			zap.L().Error("Invalid cursor parameter", zap.String("cursor", query.Get("cursor")), zap.Error(err))
+			problem.New(http.StatusBadRequest, problem.InvalidParameter, err.Error()).WithField("cursor").Write(w, r)
+			return
+		}
+		metricsQuery.After = after
+
+		// One more metric is requested to know if there is next page
+		metricsQuery.Limit = limit + 1
+
+		metrics, err := st.QueryMetrics(r.Context(), metricsQuery)
+		if err != nil {
+			zap.L().Error("Error while listing metrics", zap.Error(err))
+			problem.New(http.StatusInternalServerError, problem.StorageError, "failed to list metrics").Write(w, r)
+			return
+		}
+
+		page := model.MetricsPage{Metrics: metrics}
+		if page.Metrics == nil {
+			page.Metrics = make([]model.Metrics, 0)
+		}
+		if len(page.Metrics) > limit {
+			page.Metrics = page.Metrics[:limit]
+			page.NextCursor, err = encodeCursor(page.Metrics[limit-1])
+			if err != nil {
+				zap.L().Error("Failed to encode cursor", zap.Error(err))
+				problem.New(http.StatusInternalServerError, problem.InternalError, "failed to encode cursor").Write(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&page); err != nil {
+			zap.L().Error("Failed to write response", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// parseSort parse order of listing, empty value and name mean ascending order
+func parseSort(value string) (bool, error) {
+	switch value {
+	case "", "name":
+		return false, nil
+	case "-name":
+		return true, nil
+	default:
+		return false, errInvalidSort
+	}
+}
+
+// parseLimit parse page size from 1 to maxListLimit, empty value gives defaultListLimit
+func parseLimit(value string) (int, error) {
+	if value == "" {
+		return defaultListLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if limit < 1 || limit > maxListLimit {
+		return 0, fmt.Errorf("limit must be from 1 to %d", maxListLimit)
+	}
+
+	return limit, nil
+}
+
+// encodeCursor opaque cursor of page which starts after metric: URL safe base64 of metric id, type and labels
+func encodeCursor(metric model.Metrics) (string, error) {
+	data, err := json.Marshal(model.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor decode metric from cursor made by encodeCursor, empty cursor means first page
+func decodeCursor(cursor string) (*model.Metrics, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	var metric model.Metrics
+	if err := json.Unmarshal(data, &metric); err != nil {
+		return nil, err
+	}
+	if err := metric.ValidateSeries(); err != nil {
+		return nil, err
+	}
+
+	return &metric, nil
+}
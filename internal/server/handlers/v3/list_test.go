@@ -0,0 +1,127 @@
+package v3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mock_storage "github.com/zavtra-na-rabotu/gometrics/internal/mocks"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+)
+
+func TestListMetrics_Pages(t *testing.T) {
+	memStorage := storage.NewMemStorage()
+	ctx := context.Background()
+	require.NoError(t, memStorage.UpdateGauge(ctx, "app.Alloc", nil, 1))
+	require.NoError(t, memStorage.UpdateGauge(ctx, "app.Alloc", model.Labels{"host": "a"}, 2))
+	require.NoError(t, memStorage.UpdateCounter(ctx, "app.PollCount", nil, 3))
+	require.NoError(t, memStorage.UpdateGauge(ctx, "sys.Load", nil, 4))
+
+	handler := ListMetrics(memStorage)
+
+	var listed []string
+	cursor := ""
+	for pages := 0; pages < 10; pages++ {
+		request := httptest.NewRequest(http.MethodGet, "/api/metrics?prefix=app.&sort=-name&limit=2&cursor="+url.QueryEscape(cursor), nil)
+		responseRecorder := httptest.NewRecorder()
+		handler.ServeHTTP(responseRecorder, request)
+
+		require.Equal(t, http.StatusOK, responseRecorder.Code)
+		assert.Equal(t, "application/json", responseRecorder.Header().Get("Content-Type"))
+
+		var page model.MetricsPage
+		require.NoError(t, json.NewDecoder(responseRecorder.Body).Decode(&page))
+		for _, metric := range page.Metrics {
+			listed = append(listed, metric.MType+":"+model.SeriesKey(metric.ID, metric.Labels))
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	assert.Equal(t, []string{"counter:app.PollCount", `gauge:app.Alloc{host="a"}`, "gauge:app.Alloc"}, listed)
+}
+
+func TestListMetrics(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		callStorage    bool
+		query          model.MetricsQuery
+		storageReturns error
+		statusCode     int
+	}{
+		{
+			name:        "Positive scenario. Default query (200)",
+			url:         "/api/metrics",
+			callStorage: true,
+			query:       model.MetricsQuery{Limit: defaultListLimit + 1},
+			statusCode:  http.StatusOK,
+		},
+		{
+			name:        "Positive scenario. Filtered and sorted (200)",
+			url:         "/api/metrics?type=counter&prefix=app.&sort=-name&limit=10",
+			callStorage: true,
+			query:       model.MetricsQuery{Type: model.Counter, Prefix: "app.", Desc: true, Limit: 11},
+			statusCode:  http.StatusOK,
+		},
+		{
+			name:       "Negative scenario. Invalid type (400)",
+			url:        "/api/metrics?type=summary",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Negative scenario. Invalid sort (400)",
+			url:        "/api/metrics?sort=value",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Negative scenario. Limit out of range (400)",
+			url:        "/api/metrics?limit=1001",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Negative scenario. Invalid cursor (400)",
+			url:        "/api/metrics?cursor=not-a-cursor",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Negative scenario. Storage error (500)",
+			url:            "/api/metrics",
+			callStorage:    true,
+			query:          model.MetricsQuery{Limit: defaultListLimit + 1},
+			storageReturns: errors.New("some error"),
+			statusCode:     http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockStorage := mock_storage.NewMockStorage(ctrl)
+			if test.callStorage {
+				mockStorage.EXPECT().QueryMetrics(gomock.Any(), test.query).Return(nil, test.storageReturns)
+			}
+
+			request := httptest.NewRequest(http.MethodGet, test.url, nil)
+			responseRecorder := httptest.NewRecorder()
+
+			handler := ListMetrics(mockStorage)
+			handler.ServeHTTP(responseRecorder, request)
+
+			assert.Equal(t, test.statusCode, responseRecorder.Code)
+		})
+	}
+}
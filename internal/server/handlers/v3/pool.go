@@ -0,0 +1,34 @@
+package v3
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+	"go.uber.org/zap"
+)
+
+// GetPoolStats handler to get statistics of database connection pool, storage without pool responds with 404
+func GetPoolStats(st storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := st.(storage.PoolStatsProvider)
+		if !ok {
+			problem.New(http.StatusNotFound, problem.NotSupported, "storage has no connection pool").Write(w, r)
+			return
+		}
+
+		stats, err := provider.PoolStats()
+		if err != nil {
+			zap.L().Error("Failed to get pool stats", zap.Error(err))
+			problem.New(http.StatusNotFound, problem.NotSupported, err.Error()).Write(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			zap.L().Error("Failed to encode pool stats", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package v3
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zavtra-na-rabotu/gometrics/internal/server/storage"
+)
+
+// poolStorage storage with connection pool returning fixed statistics
+type poolStorage struct {
+	storage.Storage
+	stats storage.PoolStats
+}
+
+func (st *poolStorage) PoolStats() (storage.PoolStats, error) {
+	return st.stats, nil
+}
+
+func TestGetPoolStats(t *testing.T) {
+	st := &poolStorage{stats: storage.PoolStats{AcquireCount: 12, IdleConns: 3, TotalConns: 5, MaxConns: 10}}
+
+	request := httptest.NewRequest(http.MethodGet, "/debug/pool", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	GetPoolStats(st).ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusOK, responseRecorder.Code)
+	assert.Equal(t, "application/json", responseRecorder.Header().Get("Content-Type"))
+
+	var stats storage.PoolStats
+	err := json.NewDecoder(responseRecorder.Body).Decode(&stats)
+	assert.NoError(t, err)
+	assert.Equal(t, st.stats, stats)
+}
+
+func TestGetPoolStats_WithoutPool(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/debug/pool", nil)
+	responseRecorder := httptest.NewRecorder()
+
+	GetPoolStats(storage.NewMemStorage()).ServeHTTP(responseRecorder, request)
+
+	assert.Equal(t, http.StatusNotFound, responseRecorder.Code)
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
 	"go.uber.org/zap"
 )
 
@@ -21,7 +22,8 @@ func DecryptMiddleware(privateKey *rsa.PrivateKey) func(http.Handler) http.Handl
 			// Get encrypted AES from header
 			encryptedAESKey := r.Header.Get("Encrypted-AES-Key")
 			if encryptedAESKey == "" {
-				http.Error(w, "Missing Encrypted-AES-Key header", http.StatusBadRequest)
+				problem.New(http.StatusBadRequest, problem.DecryptionFailed, "missing Encrypted-AES-Key header").
+					WithField("Encrypted-AES-Key").Write(w, r)
 				return
 			}
 
@@ -29,7 +31,8 @@ func DecryptMiddleware(privateKey *rsa.PrivateKey) func(http.Handler) http.Handl
 			aesKey, err := decryptWithPrivateKey(encryptedAESKey, privateKey)
 			if err != nil {
 				zap.L().Error("Failed to decrypt AES key", zap.Error(err))
-				http.Error(w, "Failed to decrypt AES key", http.StatusInternalServerError)
+				problem.New(http.StatusInternalServerError, problem.DecryptionFailed, "failed to decrypt AES key").
+					WithField("Encrypted-AES-Key").Write(w, r)
 				return
 			}
 
@@ -37,7 +40,7 @@ func DecryptMiddleware(privateKey *rsa.PrivateKey) func(http.Handler) http.Handl
 			decryptedData, err := decryptWithAES(r.Body, aesKey)
 			if err != nil {
 				zap.L().Error("Failed to decrypt request body", zap.Error(err))
-				http.Error(w, "Failed to decrypt request body", http.StatusInternalServerError)
+				problem.New(http.StatusInternalServerError, problem.DecryptionFailed, "failed to decrypt request body").Write(w, r)
 				return
 			}
 
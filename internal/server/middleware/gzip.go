@@ -5,6 +5,8 @@ import (
 	"compress/gzip"
 	"net/http"
 	"strings"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
 )
 
 type compressWriter struct {
@@ -28,7 +30,7 @@ func GzipMiddleware(next http.Handler) http.Handler {
 			gzWriter := gzip.NewWriter(w)
 			defer func() {
 				if err := gzWriter.Close(); err != nil {
-					http.Error(w, "Failed to close gzip writer", http.StatusInternalServerError)
+					problem.New(http.StatusInternalServerError, problem.InternalError, "failed to close gzip writer").Write(w, r)
 					return
 				}
 			}()
@@ -43,12 +45,12 @@ func GzipMiddleware(next http.Handler) http.Handler {
 		if receivedGzip {
 			gzipReader, err := gzip.NewReader(r.Body)
 			if err != nil {
-				http.Error(w, "Failed to create gzip reader", http.StatusInternalServerError)
+				problem.New(http.StatusBadRequest, problem.MalformedBody, "failed to create gzip reader: "+err.Error()).Write(w, r)
 				return
 			}
 			defer func() {
 				if err := gzipReader.Close(); err != nil {
-					http.Error(w, "Failed to close gzip reader", http.StatusInternalServerError)
+					problem.New(http.StatusInternalServerError, problem.InternalError, "failed to close gzip reader").Write(w, r)
 					return
 				}
 			}()
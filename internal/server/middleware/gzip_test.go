@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
 )
 
 func TestGzipCompression(t *testing.T) {
@@ -85,4 +86,24 @@ func TestGzipCompression(t *testing.T) {
 
 		require.JSONEq(t, successBody, string(b))
 	})
+	t.Run("malformed_gzip", func(t *testing.T) {
+		buf := bytes.NewBufferString(requestBody)
+		r := httptest.NewRequest(http.MethodPost, server.URL, buf)
+		r.RequestURI = ""
+		r.Header.Set("Content-Encoding", "gzip")
+		r.Header.Set("Accept-Encoding", "")
+
+		resp, err := http.DefaultClient.Do(r)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		defer func() {
+			err := resp.Body.Close()
+			require.NoError(t, err)
+		}()
+
+		details, ok := problem.Read(resp.Header.Get("Content-Type"), resp.Body)
+		require.True(t, ok)
+		require.Equal(t, problem.MalformedBody, details.Code)
+	})
 }
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
 	"go.uber.org/zap"
 )
 
@@ -49,7 +50,7 @@ func RequestHashMiddleware(key string) func(http.Handler) http.Handler {
 			body, err := io.ReadAll(r.Body)
 			if err != nil {
 				zap.L().Error("Error reading body", zap.Error(err))
-				http.Error(w, "Error reading body", http.StatusInternalServerError)
+				problem.New(http.StatusInternalServerError, problem.InternalError, "error reading body").Write(w, r)
 				return
 			}
 			r.Body = io.NopCloser(bytes.NewBuffer(body))
@@ -58,7 +59,8 @@ func RequestHashMiddleware(key string) func(http.Handler) http.Handler {
 
 			if receivedHash != calculatedHash {
 				zap.L().Error("Hash mismatch", zap.String("received hash", receivedHash), zap.String("calculated hash", calculatedHash))
-				http.Error(w, "Hash mismatch", http.StatusBadRequest)
+				problem.New(http.StatusBadRequest, problem.HashMismatch, "hash of request body does not match HashSHA256 header").
+					WithField("HashSHA256").Write(w, r)
 				return
 			}
 
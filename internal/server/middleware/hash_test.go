@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/zavtra-na-rabotu/gometrics/internal/problem"
 )
 
 func TestResponseHashMiddleware(t *testing.T) {
@@ -100,4 +101,10 @@ func TestRequestHashMiddleware_InvalidHash(t *testing.T) {
 	defer resp.Body.Close()
 
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	details, ok := problem.Read(resp.Header.Get("Content-Type"), resp.Body)
+	if assert.True(t, ok) {
+		assert.Equal(t, problem.HashMismatch, details.Code)
+		assert.Equal(t, "HashSHA256", details.Field)
+	}
 }
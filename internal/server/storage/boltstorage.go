@@ -0,0 +1,739 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// boltOpenTimeout time to wait for lock of database file held by another process
+const boltOpenTimeout = time.Second
+
+var (
+	gaugeBucket     = []byte("gauge")
+	counterBucket   = []byte("counter")
+	histogramBucket = []byte("histogram")
+	historyBucket   = []byte("history")
+	rollupBucket    = []byte("rollup")
+)
+
+// metricBuckets bucket of series of every metric type
+var metricBuckets = map[model.MetricType][]byte{
+	model.Gauge:     gaugeBucket,
+	model.Counter:   counterBucket,
+	model.Histogram: histogramBucket,
+}
+
+// BoltStorage storage in embedded bbolt key-value database file.
+// Gauges, counters and histograms are kept in separate buckets keyed by model.SeriesKey with JSON encoded metric as value.
+// History bucket has nested bucket of samples for every series keyed by timestamp and sequence,
+// rollup bucket has nested bucket for every series with nested bucket for every tier resolution
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltStorage constructor to open or create bbolt database file at {path}
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key-value database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{gaugeBucket, counterBucket, histogramBucket, historyBucket, rollupBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create buckets: %w", err)
+	}
+
+	zap.L().Info("Opened key-value database", zap.String("path", path))
+
+	return &BoltStorage{db: db}, nil
+}
+
+// Start method to satisfy Lifecycle, database is opened by constructor
+func (storage *BoltStorage) Start(_ context.Context) error {
+	return nil
+}
+
+// Stop method to close database, transactions in progress are finished first
+func (storage *BoltStorage) Stop(_ context.Context) error {
+	if err := storage.db.Close(); err != nil {
+		return fmt.Errorf("could not close key-value database: %w", err)
+	}
+
+	zap.L().Info("Key-value database closed")
+	return nil
+}
+
+// Ping check that database is open
+func (storage *BoltStorage) Ping(ctx context.Context) error {
+	return storage.view(ctx, func(_ *bolt.Tx) error {
+		return nil
+	})
+}
+
+// Backup method to write consistent copy of database into {w}, updates are not blocked while copy is written
+func (storage *BoltStorage) Backup(ctx context.Context, w io.Writer) (int64, error) {
+	var written int64
+	err := storage.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		written, err = tx.WriteTo(w)
+		return err
+	})
+
+	return written, err
+}
+
+// BackupFile method to write consistent copy of database into file at {path}, existing file is replaced atomically
+func (storage *BoltStorage) BackupFile(ctx context.Context, path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create backup file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	written, err := storage.Backup(ctx, file)
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("could not write backup: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("could not sync backup file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not close backup file: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("could not replace backup file: %w", err)
+	}
+
+	zap.L().Info("Key-value database backed up", zap.String("path", path), zap.Int64("bytes", written))
+	return nil
+}
+
+// UpdateGauge method to update gauge metric
+func (storage *BoltStorage) UpdateGauge(ctx context.Context, name string, labels model.Labels, metric float64) error {
+	return storage.update(ctx, func(tx *bolt.Tx) error {
+		return boltUpdateGauge(tx, name, labels, metric, time.Now())
+	})
+}
+
+// UpdateCounter method to update counter metric
+func (storage *BoltStorage) UpdateCounter(ctx context.Context, name string, labels model.Labels, metric int64) error {
+	_, err := storage.UpdateCounterAndReturn(ctx, name, labels, metric)
+	return err
+}
+
+// UpdateCounterAndReturn method to update counter metric and return updated value
+func (storage *BoltStorage) UpdateCounterAndReturn(ctx context.Context, name string, labels model.Labels, metric int64) (int64, error) {
+	var value int64
+	err := storage.update(ctx, func(tx *bolt.Tx) error {
+		var err error
+		value, err = boltUpdateCounter(tx, name, labels, metric, time.Now())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// UpdateHistogram method to merge observations into histogram metric
+func (storage *BoltStorage) UpdateHistogram(ctx context.Context, name string, labels model.Labels, metric model.HistogramData) error {
+	return storage.update(ctx, func(tx *bolt.Tx) error {
+		return boltUpdateHistogram(tx, name, labels, &metric)
+	})
+}
+
+// UpdateMetrics method to update batch of different types of metrics in one transaction.
+// Batch is validated and merged by series first, transaction is rolled back entirely on any error
+func (storage *BoltStorage) UpdateMetrics(ctx context.Context, metrics []model.Metrics) error {
+	if err := ValidateMetrics(metrics); err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	merged, err := mergeMetrics(metrics)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return storage.update(ctx, func(tx *bolt.Tx) error {
+		for _, metric := range merged {
+			var err error
+			switch metric.MType {
+			case string(model.Gauge):
+				err = boltUpdateGauge(tx, metric.ID, metric.Labels, *metric.Value, now)
+			case string(model.Counter):
+				_, err = boltUpdateCounter(tx, metric.ID, metric.Labels, *metric.Delta, now)
+			case string(model.Histogram):
+				err = boltUpdateHistogram(tx, metric.ID, metric.Labels, metric.Histogram)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func boltUpdateGauge(tx *bolt.Tx, name string, labels model.Labels, value float64, now time.Time) error {
+	metric := model.Metrics{ID: name, MType: string(model.Gauge), Labels: labels, Value: &value}
+	if err := putMetric(tx.Bucket(gaugeBucket), metric); err != nil {
+		return err
+	}
+
+	return recordBoltSample(tx, model.Gauge, name, labels, value, now)
+}
+
+func boltUpdateCounter(tx *bolt.Tx, name string, labels model.Labels, delta int64, now time.Time) (int64, error) {
+	bucket := tx.Bucket(counterBucket)
+
+	stored, err := getMetric(bucket, name, labels)
+	if err != nil && !errors.Is(err, ErrItemNotFound) {
+		return 0, err
+	}
+
+	value := delta
+	if stored != nil {
+		value += *stored.Delta
+	}
+
+	metric := model.Metrics{ID: name, MType: string(model.Counter), Labels: labels, Delta: &value}
+	if err := putMetric(bucket, metric); err != nil {
+		return 0, err
+	}
+
+	return value, recordBoltSample(tx, model.Counter, name, labels, float64(value), now)
+}
+
+// boltUpdateHistogram merge observations into stored histogram, bucket bounds must be equal
+func boltUpdateHistogram(tx *bolt.Tx, name string, labels model.Labels, histogram *model.HistogramData) error {
+	bucket := tx.Bucket(histogramBucket)
+
+	stored, err := getMetric(bucket, name, labels)
+	if err != nil && !errors.Is(err, ErrItemNotFound) {
+		return err
+	}
+
+	merged := histogram.Clone()
+	if stored != nil {
+		merged = stored.Histogram.Clone()
+		if err := merged.Merge(histogram); err != nil {
+			return err
+		}
+	}
+
+	return putMetric(bucket, model.Metrics{ID: name, MType: string(model.Histogram), Labels: labels, Histogram: &merged})
+}
+
+// putMetric store metric JSON under its series key
+func putMetric(bucket *bolt.Bucket, metric model.Metrics) error {
+	if len(metric.Labels) == 0 {
+		metric.Labels = nil
+	}
+
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return fmt.Errorf("failed to encode metric %s: %w", metric.ID, err)
+	}
+
+	return bucket.Put([]byte(model.SeriesKey(metric.ID, metric.Labels)), data)
+}
+
+// getMetric decode metric stored under series key, missing series returns ErrItemNotFound
+func getMetric(bucket *bolt.Bucket, name string, labels model.Labels) (*model.Metrics, error) {
+	data := bucket.Get([]byte(model.SeriesKey(name, labels)))
+	if data == nil {
+		return nil, ErrItemNotFound
+	}
+
+	var metric model.Metrics
+	if err := json.Unmarshal(data, &metric); err != nil {
+		return nil, fmt.Errorf("failed to decode metric %s: %w", name, err)
+	}
+
+	return &metric, nil
+}
+
+// GetGauge method to get gauge metric by name
+func (storage *BoltStorage) GetGauge(ctx context.Context, name string, labels model.Labels) (float64, error) {
+	var value float64
+	err := storage.view(ctx, func(tx *bolt.Tx) error {
+		metric, err := getMetric(tx.Bucket(gaugeBucket), name, labels)
+		if err != nil {
+			return err
+		}
+		value = *metric.Value
+		return nil
+	})
+
+	return value, err
+}
+
+// GetCounter method to get counter metric by name
+func (storage *BoltStorage) GetCounter(ctx context.Context, name string, labels model.Labels) (int64, error) {
+	var value int64
+	err := storage.view(ctx, func(tx *bolt.Tx) error {
+		metric, err := getMetric(tx.Bucket(counterBucket), name, labels)
+		if err != nil {
+			return err
+		}
+		value = *metric.Delta
+		return nil
+	})
+
+	return value, err
+}
+
+// GetHistogram method to get histogram metric by name and labels
+func (storage *BoltStorage) GetHistogram(ctx context.Context, name string, labels model.Labels) (model.HistogramData, error) {
+	var histogram model.HistogramData
+	err := storage.view(ctx, func(tx *bolt.Tx) error {
+		metric, err := getMetric(tx.Bucket(histogramBucket), name, labels)
+		if err != nil {
+			return err
+		}
+		histogram = *metric.Histogram
+		return nil
+	})
+
+	return histogram, err
+}
+
+// GetAllGauge method to get all gauge metrics
+func (storage *BoltStorage) GetAllGauge(ctx context.Context) (map[string]float64, error) {
+	gaugeMetrics := make(map[string]float64)
+	err := storage.view(ctx, func(tx *bolt.Tx) error {
+		return forEachMetric(tx.Bucket(gaugeBucket), func(key string, metric model.Metrics) {
+			gaugeMetrics[key] = *metric.Value
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gaugeMetrics, nil
+}
+
+// GetAllCounter method to get all counter metrics
+func (storage *BoltStorage) GetAllCounter(ctx context.Context) (map[string]int64, error) {
+	counterMetrics := make(map[string]int64)
+	err := storage.view(ctx, func(tx *bolt.Tx) error {
+		return forEachMetric(tx.Bucket(counterBucket), func(key string, metric model.Metrics) {
+			counterMetrics[key] = *metric.Delta
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counterMetrics, nil
+}
+
+// GetAllHistogram method to get all histogram metrics
+func (storage *BoltStorage) GetAllHistogram(ctx context.Context) (map[string]model.HistogramData, error) {
+	histogramMetrics := make(map[string]model.HistogramData)
+	err := storage.view(ctx, func(tx *bolt.Tx) error {
+		return forEachMetric(tx.Bucket(histogramBucket), func(key string, metric model.Metrics) {
+			histogramMetrics[key] = *metric.Histogram
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return histogramMetrics, nil
+}
+
+// GetMetrics method to get values of requested series in one read transaction, missing series are skipped
+func (storage *BoltStorage) GetMetrics(ctx context.Context, metrics []model.Metrics) ([]model.Metrics, error) {
+	if err := checkRequestTypes(metrics); err != nil {
+		return nil, err
+	}
+
+	found := make([]model.Metrics, 0, len(metrics))
+	err := storage.view(ctx, func(tx *bolt.Tx) error {
+		for _, requested := range metrics {
+			metric, err := getMetric(tx.Bucket(metricBuckets[model.MetricType(requested.MType)]), requested.ID, requested.Labels)
+			if errors.Is(err, ErrItemNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			metric.MType = requested.MType
+			metric.Labels = requested.Labels
+			found = append(found, *metric)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// QueryMetrics method to get page of series selected by type and name prefix
+func (storage *BoltStorage) QueryMetrics(ctx context.Context, query model.MetricsQuery) ([]model.Metrics, error) {
+	return queryMetrics(ctx, storage, query)
+}
+
+// ExportMetrics method to get all stored series
+func (storage *BoltStorage) ExportMetrics(ctx context.Context) ([]model.Metrics, error) {
+	metrics := make([]model.Metrics, 0)
+	err := storage.view(ctx, func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{gaugeBucket, counterBucket, histogramBucket} {
+			err := forEachMetric(tx.Bucket(name), func(_ string, metric model.Metrics) {
+				metrics = append(metrics, metric)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// forEachMetric decode every metric of bucket in order of series keys
+func forEachMetric(bucket *bolt.Bucket, fn func(key string, metric model.Metrics)) error {
+	return bucket.ForEach(func(key, data []byte) error {
+		var metric model.Metrics
+		if err := json.Unmarshal(data, &metric); err != nil {
+			return fmt.Errorf("failed to decode metric %s: %w", key, err)
+		}
+		fn(string(key), metric)
+		return nil
+	})
+}
+
+// Delete method to remove series with its history and rollups in one transaction
+func (storage *BoltStorage) Delete(ctx context.Context, mType model.MetricType, name string, labels model.Labels) error {
+	if err := checkMetricType(mType); err != nil {
+		return err
+	}
+
+	return storage.update(ctx, func(tx *bolt.Tx) error {
+		return deleteBoltSeries(tx, mType, name, labels)
+	})
+}
+
+// DeleteByPrefix method to remove series of all types with name starting with prefix in one transaction
+func (storage *BoltStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	var deleted int
+	err := storage.update(ctx, func(tx *bolt.Tx) error {
+		matched := make([]model.Metrics, 0)
+
+		// Series key starts with name, so only keys with prefix are checked
+		for mType, name := range metricBuckets {
+			cursor := tx.Bucket(name).Cursor()
+			for key, data := cursor.Seek([]byte(prefix)); key != nil && strings.HasPrefix(string(key), prefix); key, data = cursor.Next() {
+				var metric model.Metrics
+				if err := json.Unmarshal(data, &metric); err != nil {
+					return fmt.Errorf("failed to decode metric %s: %w", key, err)
+				}
+				if strings.HasPrefix(metric.ID, prefix) {
+					metric.MType = string(mType)
+					matched = append(matched, metric)
+				}
+			}
+		}
+
+		for _, metric := range matched {
+			if err := deleteBoltSeries(tx, model.MetricType(metric.MType), metric.ID, metric.Labels); err != nil {
+				return err
+			}
+		}
+
+		deleted = len(matched)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// deleteBoltSeries delete series and nested buckets of its history and rollups, missing series returns ErrItemNotFound
+func deleteBoltSeries(tx *bolt.Tx, mType model.MetricType, name string, labels model.Labels) error {
+	bucket := tx.Bucket(metricBuckets[mType])
+	key := []byte(model.SeriesKey(name, labels))
+	if bucket.Get(key) == nil {
+		return fmt.Errorf("%s metric with name: %s not found %w", mType, key, ErrItemNotFound)
+	}
+
+	if err := bucket.Delete(key); err != nil {
+		return err
+	}
+
+	seriesKey := historySeriesKey(mType, name, labels)
+	for _, parent := range [][]byte{historyBucket, rollupBucket} {
+		if err := tx.Bucket(parent).DeleteBucket(seriesKey); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordBoltSample append sample to history bucket of series, sequence keeps samples with equal timestamps apart
+func recordBoltSample(tx *bolt.Tx, mType model.MetricType, name string, labels model.Labels, value float64, now time.Time) error {
+	series, err := tx.Bucket(historyBucket).CreateBucketIfNotExists(historySeriesKey(mType, name, labels))
+	if err != nil {
+		return err
+	}
+
+	sequence, err := series.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	return series.Put(sampleKey(now, sequence), binary.BigEndian.AppendUint64(nil, math.Float64bits(value)))
+}
+
+// GetHistory method to get samples of gauge or counter series recorded between from and to
+func (storage *BoltStorage) GetHistory(ctx context.Context, mType model.MetricType, name string, labels model.Labels, from time.Time, to time.Time) ([]model.Sample, error) {
+	if mType != model.Gauge && mType != model.Counter {
+		return nil, fmt.Errorf("history is not supported for metric type: %s", mType)
+	}
+
+	samples := make([]model.Sample, 0)
+	err := storage.view(ctx, func(tx *bolt.Tx) error {
+		series := tx.Bucket(historyBucket).Bucket(historySeriesKey(mType, name, labels))
+		if series == nil {
+			return nil
+		}
+
+		samples = samplesBetween(series, from, to, true)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+// GetRollups method to get rollups of gauge or counter series with given resolution between from and to
+func (storage *BoltStorage) GetRollups(ctx context.Context, mType model.MetricType, name string, labels model.Labels, resolution time.Duration, from time.Time, to time.Time) ([]model.Rollup, error) {
+	if mType != model.Gauge && mType != model.Counter {
+		return nil, fmt.Errorf("history is not supported for metric type: %s", mType)
+	}
+
+	rollups := make([]model.Rollup, 0)
+	err := storage.view(ctx, func(tx *bolt.Tx) error {
+		series := tx.Bucket(rollupBucket).Bucket(historySeriesKey(mType, name, labels))
+		if series == nil {
+			return nil
+		}
+		tier := series.Bucket(resolutionKey(resolution))
+		if tier == nil {
+			return nil
+		}
+
+		cursor := tier.Cursor()
+		for key, data := cursor.Seek(sampleKey(from, 0)); key != nil; key, data = cursor.Next() {
+			if keyTime(key).After(to) {
+				break
+			}
+
+			var rollup model.Rollup
+			if err := json.Unmarshal(data, &rollup); err != nil {
+				return fmt.Errorf("failed to decode rollup: %w", err)
+			}
+			rollups = append(rollups, rollup)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rollups, nil
+}
+
+// Compact method to roll raw samples up into retention tiers and delete expired history in one transaction
+func (storage *BoltStorage) Compact(ctx context.Context, policy RetentionPolicy, now time.Time) error {
+	err := storage.update(ctx, func(tx *bolt.Tx) error {
+		rollups := tx.Bucket(rollupBucket)
+
+		return tx.Bucket(historyBucket).ForEachBucket(func(seriesKey []byte) error {
+			raw := tx.Bucket(historyBucket).Bucket(seriesKey)
+
+			seriesRollups, err := rollups.CreateBucketIfNotExists(seriesKey)
+			if err != nil {
+				return err
+			}
+
+			if err := compactBoltSeries(raw, seriesRollups, policy, now); err != nil {
+				zap.L().Error("Failed to compact metric history", zap.ByteString("series", seriesKey), zap.Error(err))
+				return err
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	zap.L().Info("Compacted metric history", zap.Time("now", now))
+	return nil
+}
+
+// compactBoltSeries rebuild complete buckets of every tier from raw samples of one series,
+// then delete expired rollups, tiers removed from policy and expired raw samples
+func compactBoltSeries(raw *bolt.Bucket, rollups *bolt.Bucket, policy RetentionPolicy, now time.Time) error {
+	resolutions := make(map[string]bool, len(policy.Rollups))
+
+	for _, tier := range policy.Rollups {
+		key := resolutionKey(tier.Resolution)
+		resolutions[string(key)] = true
+
+		tierBucket, err := rollups.CreateBucketIfNotExists(key)
+		if err != nil {
+			return err
+		}
+
+		from, until := rollupRange(policy, tier.Resolution, time.Time{}, now)
+		for _, rollup := range buildRollups(samplesBetween(raw, from, until, false), tier.Resolution, from, until) {
+			data, err := json.Marshal(rollup)
+			if err != nil {
+				return fmt.Errorf("failed to encode rollup: %w", err)
+			}
+			if err := tierBucket.Put(sampleKey(rollup.Timestamp, 0), data); err != nil {
+				return err
+			}
+		}
+
+		if err := deleteBefore(tierBucket, now.Add(-tier.Retention)); err != nil {
+			return err
+		}
+	}
+
+	// Tiers removed from policy are not kept anymore
+	removed := make([][]byte, 0)
+	err := rollups.ForEachBucket(func(key []byte) error {
+		if !resolutions[string(key)] {
+			removed = append(removed, append([]byte(nil), key...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range removed {
+		if err := rollups.DeleteBucket(key); err != nil {
+			return err
+		}
+	}
+
+	return deleteBefore(raw, now.Add(-policy.Raw))
+}
+
+// samplesBetween samples of series bucket from {from} to {to}, {to} is included when {inclusive} is set
+func samplesBetween(series *bolt.Bucket, from time.Time, to time.Time, inclusive bool) []model.Sample {
+	samples := make([]model.Sample, 0)
+
+	cursor := series.Cursor()
+	for key, data := cursor.Seek(sampleKey(from, 0)); key != nil; key, data = cursor.Next() {
+		timestamp := keyTime(key)
+		if timestamp.After(to) || (!inclusive && timestamp.Equal(to)) {
+			break
+		}
+		samples = append(samples, model.Sample{Timestamp: timestamp, Value: math.Float64frombits(binary.BigEndian.Uint64(data))})
+	}
+
+	return samples
+}
+
+// deleteBefore delete keys of bucket with timestamp before {before}
+func deleteBefore(bucket *bolt.Bucket, before time.Time) error {
+	expired := make([][]byte, 0)
+
+	cursor := bucket.Cursor()
+	for key, _ := cursor.First(); key != nil && keyTime(key).Before(before); key, _ = cursor.Next() {
+		expired = append(expired, append([]byte(nil), key...))
+	}
+
+	for _, key := range expired {
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// historySeriesKey name of nested bucket of series history
+func historySeriesKey(mType model.MetricType, name string, labels model.Labels) []byte {
+	return []byte(string(mType) + ":" + model.SeriesKey(name, labels))
+}
+
+// resolutionKey name of nested bucket of rollup tier
+func resolutionKey(resolution time.Duration) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(resolution))
+}
+
+// sampleKey timestamp with flipped sign bit followed by sequence, so keys are ordered by time byte-wise
+func sampleKey(t time.Time, sequence uint64) []byte {
+	key := binary.BigEndian.AppendUint64(make([]byte, 0, 16), uint64(unixNanos(t))^(1<<63))
+	return binary.BigEndian.AppendUint64(key, sequence)
+}
+
+// keyTime timestamp of sample key
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])^(1<<63)))
+}
+
+// update run {fn} in read-write transaction, transaction is rolled back when {fn} returns error
+func (storage *BoltStorage) update(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return storage.db.Update(fn)
+}
+
+// view run {fn} in read-only transaction
+func (storage *BoltStorage) view(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return storage.db.View(fn)
+}
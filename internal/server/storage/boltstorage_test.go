@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// newTestBoltStorage bbolt storage in temporary directory, closed when test ends
+func newTestBoltStorage(t *testing.T) *BoltStorage {
+	t.Helper()
+
+	storage, err := NewBoltStorage(filepath.Join(t.TempDir(), "metrics.bolt"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = storage.Stop(context.Background())
+	})
+
+	return storage
+}
+
+func TestBoltStorage_GaugeAndCounter(t *testing.T) {
+	storage := newTestBoltStorage(t)
+	ctx := context.Background()
+	labels := model.Labels{"host": "a"}
+
+	_, err := storage.GetGauge(ctx, "Alloc", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 1.5))
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", labels, 7))
+
+	gauge, err := storage.GetGauge(ctx, "Alloc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, gauge)
+
+	assert.NoError(t, storage.UpdateCounter(ctx, "PollCount", nil, 10))
+	counter, err := storage.UpdateCounterAndReturn(ctx, "PollCount", nil, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), counter)
+
+	// Counter with the same name as gauge is a different series
+	_, err = storage.GetCounter(ctx, "Alloc", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	gauges, err := storage.GetAllGauge(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		model.SeriesKey("Alloc", nil):    1.5,
+		model.SeriesKey("Alloc", labels): 7,
+	}, gauges)
+
+	counters, err := storage.GetAllCounter(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{model.SeriesKey("PollCount", nil): 15}, counters)
+
+	samples, err := storage.GetHistory(ctx, model.Counter, "PollCount", nil, time.Now().Add(-time.Minute), time.Now())
+	assert.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, 10.0, samples[0].Value)
+	assert.Equal(t, 15.0, samples[1].Value)
+}
+
+func TestBoltStorage_UpdateHistogram(t *testing.T) {
+	storage := newTestBoltStorage(t)
+	ctx := context.Background()
+
+	histogram := model.HistogramData{Bounds: []float64{1, 10}, Counts: []int64{1, 2, 0}, Sum: 12, Count: 3}
+	assert.NoError(t, storage.UpdateHistogram(ctx, "Latency", nil, histogram))
+	assert.NoError(t, storage.UpdateHistogram(ctx, "Latency", nil, histogram))
+
+	stored, err := storage.GetHistogram(ctx, "Latency", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, model.HistogramData{Bounds: []float64{1, 10}, Counts: []int64{2, 4, 0}, Sum: 24, Count: 6}, stored)
+
+	err = storage.UpdateHistogram(ctx, "Latency", nil, model.HistogramData{Bounds: []float64{5}, Counts: []int64{1, 0}, Sum: 1, Count: 1})
+	assert.ErrorIs(t, err, ErrHistogramBoundsMismatch)
+}
+
+func TestBoltStorage_UpdateMetrics_Rollback(t *testing.T) {
+	storage := newTestBoltStorage(t)
+	ctx := context.Background()
+
+	err := storage.UpdateHistogram(ctx, "Latency", nil, model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 1, Count: 1})
+	assert.NoError(t, err)
+
+	delta := int64(2)
+	err = storage.UpdateMetrics(ctx, []model.Metrics{
+		{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+		{ID: "Latency", MType: string(model.Histogram), Histogram: &model.HistogramData{Bounds: []float64{5}, Counts: []int64{1, 0}, Sum: 1, Count: 1}},
+	})
+	assert.ErrorIs(t, err, ErrHistogramBoundsMismatch)
+
+	_, err = storage.GetCounter(ctx, "PollCount", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	err = storage.UpdateMetrics(ctx, []model.Metrics{
+		{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+		{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+	})
+	assert.NoError(t, err)
+
+	counter, err := storage.GetCounter(ctx, "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), counter)
+}
+
+func TestBoltStorage_Compact(t *testing.T) {
+	storage := newTestBoltStorage(t)
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Minute)
+	err := storage.db.Update(func(tx *bolt.Tx) error {
+		for i, value := range []float64{1, 3, 5} {
+			err := recordBoltSample(tx, model.Gauge, "Alloc", nil, value, now.Add(-30*time.Minute+time.Duration(i)*time.Second))
+			if err != nil {
+				return err
+			}
+		}
+		return recordBoltSample(tx, model.Gauge, "Alloc", nil, 10, now.Add(-2*time.Hour))
+	})
+	require.NoError(t, err)
+
+	policy := RetentionPolicy{Raw: time.Hour, Rollups: []RetentionTier{{Resolution: time.Minute, Retention: 24 * time.Hour}}}
+	err = storage.Compact(ctx, policy, now)
+	assert.NoError(t, err)
+
+	samples, err := storage.GetHistory(ctx, model.Gauge, "Alloc", nil, time.Time{}, now)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 3)
+
+	rollups, err := storage.GetRollups(ctx, model.Gauge, "Alloc", nil, time.Minute, now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, 3.0, rollups[0].Avg)
+	assert.Equal(t, int64(3), rollups[0].Count)
+	assert.True(t, rollups[0].Timestamp.Equal(now.Add(-30*time.Minute)))
+
+	// Tier removed from policy is deleted
+	err = storage.Compact(ctx, RetentionPolicy{Raw: time.Hour}, now)
+	assert.NoError(t, err)
+
+	rollups, err = storage.GetRollups(ctx, model.Gauge, "Alloc", nil, time.Minute, now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	assert.Empty(t, rollups)
+}
+
+func TestBoltStorage_BackupFile(t *testing.T) {
+	storage := newTestBoltStorage(t)
+	ctx := context.Background()
+
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 1.5))
+
+	backupPath := filepath.Join(t.TempDir(), "backup.bolt")
+	err := storage.BackupFile(ctx, backupPath)
+	require.NoError(t, err)
+
+	// Updates after backup are not in the copy
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 2.5))
+
+	backup, err := NewBoltStorage(backupPath)
+	require.NoError(t, err)
+	defer backup.Stop(ctx)
+
+	gauge, err := backup.GetGauge(ctx, "Alloc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, gauge)
+
+	metrics, err := backup.ExportMetrics(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 1)
+}
+
+func TestBoltStorage_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.bolt")
+	ctx := context.Background()
+
+	storage, err := NewBoltStorage(path)
+	require.NoError(t, err)
+	assert.NoError(t, storage.UpdateCounter(ctx, "PollCount", model.Labels{"host": "a"}, 3))
+	assert.NoError(t, storage.Stop(ctx))
+
+	assert.Error(t, storage.Ping(ctx))
+
+	storage, err = NewBoltStorage(path)
+	require.NoError(t, err)
+	defer storage.Stop(ctx)
+
+	counter, err := storage.GetCounter(ctx, "PollCount", model.Labels{"host": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), counter)
+}
@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"go.uber.org/zap"
+)
+
+// DefaultCacheFlushSize number of pending series after which cache is flushed before interval elapses
+const DefaultCacheFlushSize = 1000
+
+var ErrBackendNotSupported = errors.New("operation is not supported by backend storage")
+
+// CachedStorage write-behind cache in front of another storage. Current values are read from memory,
+// updates are applied to memory and coalesced by series: counter deltas are summed and the last gauge value is kept.
+// Pending updates are written to backend with one batch every {interval} or when number of pending series
+// reaches {flushSize}. History is read from backend and has one sample of series per flushed batch
+type CachedStorage struct {
+	backend   Storage
+	cache     *MemStorage
+	pending   *seriesBatch
+	flushCh   chan struct{}
+	worker    *worker
+	interval  time.Duration
+	flushSize int
+	// lock guards cache updates and pending batch together, so they get updates in the same order
+	lock sync.Mutex
+	// flushLock only one batch is written at a time, so batches reach backend in order
+	flushLock sync.Mutex
+}
+
+// NewCachedStorage constructor to create write-behind cache of {backend}
+func NewCachedStorage(backend Storage, interval time.Duration, flushSize int) *CachedStorage {
+	if flushSize <= 0 {
+		flushSize = DefaultCacheFlushSize
+	}
+
+	cache := NewMemStorage()
+	// History is served by backend
+	cache.SetHistorySize(0)
+
+	return &CachedStorage{
+		backend:   backend,
+		cache:     cache,
+		pending:   newSeriesBatch(flushSize),
+		flushCh:   make(chan struct{}, 1),
+		interval:  interval,
+		flushSize: flushSize,
+	}
+}
+
+// Start method to load all metrics of backend into memory and start background flushing,
+// backend must implement Exporter
+func (cached *CachedStorage) Start(ctx context.Context) error {
+	exporter, ok := cached.backend.(Exporter)
+	if !ok {
+		return fmt.Errorf("could not warm cache: %w", ErrBackendNotSupported)
+	}
+
+	metrics, err := exporter.ExportMetrics(ctx)
+	if err != nil {
+		return fmt.Errorf("could not warm cache: %w", err)
+	}
+
+	for _, metric := range metrics {
+		if err := cached.cache.restoreMetric(metric); err != nil {
+			return fmt.Errorf("could not warm cache: %w", err)
+		}
+	}
+
+	zap.L().Info("Warmed cache from backend storage", zap.Int("series", len(metrics)))
+
+	cached.worker = startWorker(ctx, cached.run)
+	return nil
+}
+
+// Stop method to stop background flushing and write all pending updates to backend
+func (cached *CachedStorage) Stop(ctx context.Context) error {
+	var err error
+	if cached.worker != nil {
+		err = cached.worker.stop(ctx)
+	}
+
+	return errors.Join(err, cached.Flush(ctx))
+}
+
+// run flush pending updates every interval or when flush size is reached until context is done
+func (cached *CachedStorage) run(ctx context.Context) {
+	ticker := time.NewTicker(cached.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cached.flushCh:
+		}
+
+		if err := cached.Flush(ctx); err != nil {
+			zap.L().Error("Failed to flush cache", zap.Error(err))
+		}
+	}
+}
+
+// Flush method to write pending updates to backend with one batch.
+// On transient failure updates are returned to pending batch and written with the next flush,
+// batch rejected by backend would be rejected again, so it is dropped
+func (cached *CachedStorage) Flush(ctx context.Context) error {
+	cached.flushLock.Lock()
+	defer cached.flushLock.Unlock()
+
+	cached.lock.Lock()
+	batch := cached.pending
+	cached.pending = newSeriesBatch(cached.flushSize)
+	cached.lock.Unlock()
+
+	if batch.len() == 0 {
+		return nil
+	}
+
+	if err := cached.backend.UpdateMetrics(ctx, batch.metrics); err != nil {
+		if isRejectedUpdate(err) {
+			zap.L().Error("Dropped cached updates rejected by backend", zap.Int("series", batch.len()), zap.Error(err))
+			return fmt.Errorf("could not flush %d series, updates are dropped: %w", batch.len(), err)
+		}
+		cached.requeue(batch)
+		return fmt.Errorf("could not flush %d series: %w", batch.len(), err)
+	}
+
+	zap.L().Info("Flushed cache", zap.Int("series", batch.len()))
+	return nil
+}
+
+// isRejectedUpdate error of invalid updates which fail again on the next flush
+func isRejectedUpdate(err error) bool {
+	return errors.Is(err, ErrInvalidMetric) ||
+		errors.Is(err, model.ErrInvalidHistogram) ||
+		errors.Is(err, ErrHistogramBoundsMismatch) ||
+		errors.Is(err, ErrUnknownMetricType)
+}
+
+// requeue put updates of failed batch before updates received during flush
+func (cached *CachedStorage) requeue(failed *seriesBatch) {
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+
+	for _, metric := range cached.pending.metrics {
+		if err := failed.add(metric); err != nil {
+			zap.L().Error("Failed to requeue update", zap.String("name", metric.ID), zap.Error(err))
+		}
+	}
+	cached.pending = failed
+}
+
+// addPending add updates to pending batch and request flush when flush size is reached,
+// caller must hold the lock
+func (cached *CachedStorage) addPending(metrics ...model.Metrics) error {
+	for _, metric := range metrics {
+		if err := cached.pending.add(metric); err != nil {
+			return err
+		}
+	}
+
+	if cached.pending.len() >= cached.flushSize {
+		select {
+		case cached.flushCh <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// UpdateGauge method to update gauge metric
+func (cached *CachedStorage) UpdateGauge(ctx context.Context, name string, labels model.Labels, metric float64) error {
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+
+	if err := cached.cache.UpdateGauge(ctx, name, labels, metric); err != nil {
+		return err
+	}
+
+	return cached.addPending(model.Metrics{ID: name, MType: string(model.Gauge), Labels: labels, Value: &metric})
+}
+
+// UpdateCounter method to update counter metric
+func (cached *CachedStorage) UpdateCounter(ctx context.Context, name string, labels model.Labels, metric int64) error {
+	_, err := cached.UpdateCounterAndReturn(ctx, name, labels, metric)
+	return err
+}
+
+// UpdateCounterAndReturn method to update counter metric and return updated value
+func (cached *CachedStorage) UpdateCounterAndReturn(ctx context.Context, name string, labels model.Labels, metric int64) (int64, error) {
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+
+	value, err := cached.cache.UpdateCounterAndReturn(ctx, name, labels, metric)
+	if err != nil {
+		return 0, err
+	}
+
+	return value, cached.addPending(model.Metrics{ID: name, MType: string(model.Counter), Labels: labels, Delta: &metric})
+}
+
+// UpdateHistogram method to merge observations into histogram metric
+func (cached *CachedStorage) UpdateHistogram(ctx context.Context, name string, labels model.Labels, metric model.HistogramData) error {
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+
+	if err := cached.cache.UpdateHistogram(ctx, name, labels, metric); err != nil {
+		return err
+	}
+
+	return cached.addPending(model.Metrics{ID: name, MType: string(model.Histogram), Labels: labels, Histogram: &metric})
+}
+
+// UpdateMetrics method to update batch of metrics, batch is applied to memory atomically
+func (cached *CachedStorage) UpdateMetrics(ctx context.Context, metrics []model.Metrics) error {
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+
+	if err := cached.cache.UpdateMetrics(ctx, metrics); err != nil {
+		return err
+	}
+
+	return cached.addPending(metrics...)
+}
+
+// Delete method to remove series from backend, then from memory and pending updates, so series is kept in memory
+// when backend fails. Flush is blocked during deletion, so updates taken for flush are not written
+// after series is deleted in backend, updates are not blocked while backend is called
+func (cached *CachedStorage) Delete(ctx context.Context, mType model.MetricType, name string, labels model.Labels) error {
+	cached.flushLock.Lock()
+	defer cached.flushLock.Unlock()
+
+	// Series which was never flushed is not in backend
+	backendErr := cached.backend.Delete(ctx, mType, name, labels)
+	if backendErr != nil && !errors.Is(backendErr, ErrItemNotFound) {
+		return fmt.Errorf("could not delete series from backend: %w", backendErr)
+	}
+
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+
+	cached.pending.remove(matchSeries(mType, name, labels))
+	err := cached.cache.Delete(ctx, mType, name, labels)
+	if errors.Is(err, ErrItemNotFound) && backendErr == nil {
+		return nil
+	}
+	return err
+}
+
+// DeleteByPrefix method to remove series with name starting with prefix from backend, then from memory
+// and pending updates, returns number of series removed from memory
+func (cached *CachedStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	cached.flushLock.Lock()
+	defer cached.flushLock.Unlock()
+
+	if _, err := cached.backend.DeleteByPrefix(ctx, prefix); err != nil {
+		return 0, fmt.Errorf("could not delete series from backend: %w", err)
+	}
+
+	cached.lock.Lock()
+	defer cached.lock.Unlock()
+
+	cached.pending.remove(matchPrefix(prefix))
+	return cached.cache.DeleteByPrefix(ctx, prefix)
+}
+
+// GetGauge method to get gauge metric by name
+func (cached *CachedStorage) GetGauge(ctx context.Context, name string, labels model.Labels) (float64, error) {
+	return cached.cache.GetGauge(ctx, name, labels)
+}
+
+// GetCounter method to get counter metric by name
+func (cached *CachedStorage) GetCounter(ctx context.Context, name string, labels model.Labels) (int64, error) {
+	return cached.cache.GetCounter(ctx, name, labels)
+}
+
+// GetAllGauge method to get all gauge metrics
+func (cached *CachedStorage) GetAllGauge(ctx context.Context) (map[string]float64, error) {
+	return cached.cache.GetAllGauge(ctx)
+}
+
+// GetAllCounter method to get all counter metrics
+func (cached *CachedStorage) GetAllCounter(ctx context.Context) (map[string]int64, error) {
+	return cached.cache.GetAllCounter(ctx)
+}
+
+// GetHistogram method to get histogram metric by name and labels
+func (cached *CachedStorage) GetHistogram(ctx context.Context, name string, labels model.Labels) (model.HistogramData, error) {
+	return cached.cache.GetHistogram(ctx, name, labels)
+}
+
+// GetAllHistogram method to get all histogram metrics
+func (cached *CachedStorage) GetAllHistogram(ctx context.Context) (map[string]model.HistogramData, error) {
+	return cached.cache.GetAllHistogram(ctx)
+}
+
+// GetMetrics method to get values of requested series from memory
+func (cached *CachedStorage) GetMetrics(ctx context.Context, metrics []model.Metrics) ([]model.Metrics, error) {
+	return cached.cache.GetMetrics(ctx, metrics)
+}
+
+// QueryMetrics method to get page of series from memory
+func (cached *CachedStorage) QueryMetrics(ctx context.Context, query model.MetricsQuery) ([]model.Metrics, error) {
+	return cached.cache.QueryMetrics(ctx, query)
+}
+
+// GetHistory method to get samples of series from backend, updates which are not flushed yet are not included
+func (cached *CachedStorage) GetHistory(ctx context.Context, mType model.MetricType, name string, labels model.Labels, from time.Time, to time.Time) ([]model.Sample, error) {
+	return cached.backend.GetHistory(ctx, mType, name, labels, from, to)
+}
+
+// GetRollups method to get rollups of series from backend
+func (cached *CachedStorage) GetRollups(ctx context.Context, mType model.MetricType, name string, labels model.Labels, resolution time.Duration, from time.Time, to time.Time) ([]model.Rollup, error) {
+	return cached.backend.GetRollups(ctx, mType, name, labels, resolution, from, to)
+}
+
+// ExportMetrics method to get all series from memory
+func (cached *CachedStorage) ExportMetrics(ctx context.Context) ([]model.Metrics, error) {
+	return cached.cache.ExportMetrics(ctx)
+}
+
+// Compact method to compact history of backend
+func (cached *CachedStorage) Compact(ctx context.Context, policy RetentionPolicy, now time.Time) error {
+	compactor, ok := cached.backend.(Compactor)
+	if !ok {
+		return ErrBackendNotSupported
+	}
+	return compactor.Compact(ctx, policy, now)
+}
+
+// Ping verifies a connection to the backend is still alive
+func (cached *CachedStorage) Ping(ctx context.Context) error {
+	repository, ok := cached.backend.(Repository)
+	if !ok {
+		return ErrBackendNotSupported
+	}
+	return repository.Ping(ctx)
+}
+
+// PoolStats method to get statistics of backend connection pool
+func (cached *CachedStorage) PoolStats() (PoolStats, error) {
+	provider, ok := cached.backend.(PoolStatsProvider)
+	if !ok {
+		return PoolStats{}, ErrNoPool
+	}
+	return provider.PoolStats()
+}
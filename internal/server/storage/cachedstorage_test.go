@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+// recordingStorage backend which records written batches and fails while err is set
+type recordingStorage struct {
+	*MemStorage
+	err     error
+	batches [][]model.Metrics
+	lock    sync.Mutex
+}
+
+func newRecordingStorage() *recordingStorage {
+	return &recordingStorage{MemStorage: NewMemStorage()}
+}
+
+func (st *recordingStorage) UpdateMetrics(ctx context.Context, metrics []model.Metrics) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	if st.err != nil {
+		return st.err
+	}
+	st.batches = append(st.batches, metrics)
+	return st.MemStorage.UpdateMetrics(ctx, metrics)
+}
+
+func (st *recordingStorage) Delete(ctx context.Context, mType model.MetricType, name string, labels model.Labels) error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	if st.err != nil {
+		return st.err
+	}
+	return st.MemStorage.Delete(ctx, mType, name, labels)
+}
+
+func (st *recordingStorage) setErr(err error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.err = err
+}
+
+func (st *recordingStorage) batchCount() int {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	return len(st.batches)
+}
+
+func TestCachedStorage_WriteBehind(t *testing.T) {
+	backend := newRecordingStorage()
+	cached := NewCachedStorage(backend, time.Hour, 100)
+
+	err := cached.Start(context.Background())
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = cached.UpdateCounter(context.Background(), "PollCount", nil, 2)
+		assert.NoError(t, err)
+		err = cached.UpdateGauge(context.Background(), "Alloc", model.Labels{"host": "a"}, float64(i))
+		assert.NoError(t, err)
+	}
+
+	// Reads are served from memory before flush
+	counter, err := cached.GetCounter(context.Background(), "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), counter)
+
+	_, err = backend.GetCounter(context.Background(), "PollCount", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	err = cached.Flush(context.Background())
+	assert.NoError(t, err)
+
+	// Updates are coalesced into one batch with one metric per series
+	assert.Equal(t, 1, backend.batchCount())
+	assert.Len(t, backend.batches[0], 2)
+
+	counter, err = backend.GetCounter(context.Background(), "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), counter)
+
+	gauge, err := backend.GetGauge(context.Background(), "Alloc", model.Labels{"host": "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, gauge)
+
+	// Nothing pending, nothing is written
+	err = cached.Flush(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, backend.batchCount())
+
+	err = cached.Stop(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestCachedStorage_Warm(t *testing.T) {
+	backend := newRecordingStorage()
+	err := backend.MemStorage.UpdateCounter(context.Background(), "PollCount", model.Labels{"host": "a"}, 10)
+	assert.NoError(t, err)
+	err = backend.MemStorage.UpdateHistogram(context.Background(), "Latency", nil,
+		model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 0.5, Count: 1})
+	assert.NoError(t, err)
+
+	cached := NewCachedStorage(backend, time.Hour, 100)
+	err = cached.Start(context.Background())
+	assert.NoError(t, err)
+	defer cached.Stop(context.Background())
+
+	counter, err := cached.UpdateCounterAndReturn(context.Background(), "PollCount", model.Labels{"host": "a"}, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), counter)
+
+	// Bounds are checked against warmed histogram
+	err = cached.UpdateHistogram(context.Background(), "Latency", nil,
+		model.HistogramData{Bounds: []float64{2}, Counts: []int64{1, 0}, Sum: 0.5, Count: 1})
+	assert.ErrorIs(t, err, ErrHistogramBoundsMismatch)
+}
+
+func TestCachedStorage_FlushFailure(t *testing.T) {
+	backend := newRecordingStorage()
+	cached := NewCachedStorage(backend, time.Hour, 100)
+
+	err := cached.Start(context.Background())
+	assert.NoError(t, err)
+
+	err = cached.UpdateCounter(context.Background(), "PollCount", nil, 3)
+	assert.NoError(t, err)
+	err = cached.UpdateGauge(context.Background(), "Alloc", nil, 1)
+	assert.NoError(t, err)
+
+	backend.setErr(errors.New("something went wrong"))
+	err = cached.Flush(context.Background())
+	assert.Error(t, err)
+
+	// Failed updates are kept and merged with newer ones
+	err = cached.UpdateCounter(context.Background(), "PollCount", nil, 4)
+	assert.NoError(t, err)
+	err = cached.UpdateGauge(context.Background(), "Alloc", nil, 2)
+	assert.NoError(t, err)
+
+	backend.setErr(nil)
+	err = cached.Stop(context.Background())
+	assert.NoError(t, err)
+
+	counter, err := backend.GetCounter(context.Background(), "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), counter)
+
+	gauge, err := backend.GetGauge(context.Background(), "Alloc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, gauge)
+}
+
+func TestCachedStorage_FlushRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "Invalid metric", err: fmt.Errorf("%w: missing delta", ErrInvalidMetric)},
+		{name: "Bounds mismatch", err: fmt.Errorf("%w: 2 bounds instead of 3", ErrHistogramBoundsMismatch)},
+		{name: "Unknown metric type", err: ErrUnknownMetricType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := newRecordingStorage()
+			cached := NewCachedStorage(backend, time.Hour, 100)
+
+			err := cached.Start(context.Background())
+			assert.NoError(t, err)
+
+			err = cached.UpdateCounter(context.Background(), "PollCount", nil, 3)
+			assert.NoError(t, err)
+
+			backend.setErr(test.err)
+			err = cached.Flush(context.Background())
+			assert.ErrorIs(t, err, test.err)
+
+			// Rejected batch is dropped instead of being written with the next flush
+			backend.setErr(nil)
+			err = cached.Stop(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 0, backend.batchCount())
+
+			_, err = backend.GetCounter(context.Background(), "PollCount", nil)
+			assert.ErrorIs(t, err, ErrItemNotFound)
+		})
+	}
+}
+
+func TestCachedStorage_DeleteFailure(t *testing.T) {
+	backend := newRecordingStorage()
+	cached := NewCachedStorage(backend, time.Hour, 100)
+
+	err := cached.Start(context.Background())
+	assert.NoError(t, err)
+
+	assert.NoError(t, cached.UpdateGauge(context.Background(), "Alloc", nil, 1))
+	assert.NoError(t, cached.Flush(context.Background()))
+	assert.NoError(t, cached.UpdateGauge(context.Background(), "Alloc", nil, 2))
+
+	// Series is kept in memory and pending updates while it is still in backend
+	backend.setErr(errors.New("something went wrong"))
+	err = cached.Delete(context.Background(), model.Gauge, "Alloc", nil)
+	assert.Error(t, err)
+
+	gauge, err := cached.GetGauge(context.Background(), "Alloc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, gauge)
+
+	backend.setErr(nil)
+	err = cached.Stop(context.Background())
+	assert.NoError(t, err)
+
+	gauge, err = backend.GetGauge(context.Background(), "Alloc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, gauge)
+}
+
+func TestCachedStorage_FlushSize(t *testing.T) {
+	backend := newRecordingStorage()
+	cached := NewCachedStorage(backend, time.Hour, 2)
+
+	err := cached.Start(context.Background())
+	assert.NoError(t, err)
+	defer cached.Stop(context.Background())
+
+	value := 1.0
+	err = cached.UpdateMetrics(context.Background(), []model.Metrics{
+		{ID: "Alloc", MType: string(model.Gauge), Value: &value},
+		{ID: "HeapAlloc", MType: string(model.Gauge), Value: &value},
+	})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return backend.batchCount() == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCachedStorage_Delete(t *testing.T) {
+	backend := newRecordingStorage()
+	cached := NewCachedStorage(backend, time.Hour, 100)
+
+	err := cached.Start(context.Background())
+	assert.NoError(t, err)
+
+	assert.NoError(t, cached.UpdateGauge(context.Background(), "Alloc", nil, 1))
+	assert.NoError(t, cached.Flush(context.Background()))
+	assert.NoError(t, cached.UpdateGauge(context.Background(), "Alloc", nil, 2))
+	assert.NoError(t, cached.UpdateCounter(context.Background(), "PollCount", nil, 3))
+
+	// Flushed value is deleted in backend and pending update is dropped
+	err = cached.Delete(context.Background(), model.Gauge, "Alloc", nil)
+	assert.NoError(t, err)
+
+	err = cached.Flush(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, backend.batchCount())
+	assert.Len(t, backend.batches[1], 1)
+
+	_, err = backend.GetGauge(context.Background(), "Alloc", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+	_, err = cached.GetGauge(context.Background(), "Alloc", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	counter, err := backend.GetCounter(context.Background(), "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), counter)
+
+	err = cached.Stop(context.Background())
+	assert.NoError(t, err)
+}
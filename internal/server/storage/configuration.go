@@ -1,49 +1,163 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-// ConfigureStorage method to configure metrics persistence on disk
-func ConfigureStorage(memStorage *MemStorage, fileStoragePath string, restore bool, storeInterval int) error {
-	if fileStoragePath == "" {
+// walCheckpointInterval interval between compactions of write-ahead log into snapshot in synchronous mode
+const walCheckpointInterval = time.Minute
+
+// WALPath path of write-ahead log kept next to snapshot file
+func WALPath(fileStoragePath string) string {
+	return fileStoragePath + ".wal"
+}
+
+// Persistence lifecycle of MemStorage persistence on disk.
+// With zero storeInterval every update is appended to write-ahead log which is periodically compacted into snapshot,
+// otherwise snapshot is written every storeInterval seconds. Stop writes the last snapshot and closes write-ahead log
+type Persistence struct {
+	memStorage      *MemStorage
+	wal             *WAL
+	worker          *worker
+	fileStoragePath string
+	storeInterval   int
+	restore         bool
+}
+
+// NewPersistence constructor to create persistence of mem storage, empty {fileStoragePath} disables it
+func NewPersistence(memStorage *MemStorage, fileStoragePath string, restore bool, storeInterval int) *Persistence {
+	return &Persistence{
+		memStorage:      memStorage,
+		fileStoragePath: fileStoragePath,
+		restore:         restore,
+		storeInterval:   storeInterval,
+	}
+}
+
+// Start method to restore snapshot and records of write-ahead log written after it and start background writes
+func (persistence *Persistence) Start(ctx context.Context) error {
+	if persistence.fileStoragePath == "" {
 		return nil
 	}
 
-	// Try to restore metrics from file
-	if restore {
-		err := RestoreMetricsFromFile(memStorage, fileStoragePath)
+	// Corrupted snapshots are not overwritten, so they can be inspected
+	if persistence.restore {
+		err := RestoreMetricsFromFile(ctx, persistence.memStorage, persistence.fileStoragePath)
 		if err != nil {
-			zap.L().Error("Error restoring metrics", zap.Error(err))
+			return fmt.Errorf("could not restore metrics: %w", err)
 		}
 	}
 
-	if storeInterval == 0 {
-		writer, err := NewWriter(fileStoragePath)
+	wal, err := OpenWAL(WALPath(persistence.fileStoragePath))
+	if err != nil {
+		return fmt.Errorf("could not open write-ahead log: %w", err)
+	}
+
+	if persistence.restore {
+		err = wal.Replay(persistence.memStorage.restoreMetric, persistence.memStorage.removeMetric)
 		if err != nil {
-			return fmt.Errorf("could not create file writer: %w", err)
+			if closeErr := wal.Close(); closeErr != nil {
+				zap.L().Error("Failed to close write-ahead log", zap.Error(closeErr))
+			}
+			return fmt.Errorf("could not replay write-ahead log: %w", err)
 		}
+	}
+
+	// Fold replayed records into snapshot, so log starts empty
+	persistence.memStorage.SetWAL(wal)
+	err = persistence.memStorage.Checkpoint(ctx, persistence.fileStoragePath)
+	if err != nil {
+		return fmt.Errorf("could not write snapshot: %w", err)
+	}
+
+	if persistence.storeInterval == 0 {
+		persistence.wal = wal
+		persistence.worker = startWorker(ctx, persistence.runCheckpoints)
+		return nil
+	}
 
-		memStorage.SetSyncMode(true)
-		memStorage.SetFileWriter(writer)
+	// Write-ahead log is used only in synchronous mode
+	persistence.memStorage.SetWAL(nil)
+	if err := wal.Close(); err != nil {
+		zap.L().Error("Failed to close write-ahead log", zap.Error(err))
+	}
+	if err := os.Remove(WALPath(persistence.fileStoragePath)); err != nil {
+		zap.L().Error("Failed to remove write-ahead log", zap.Error(err))
+	}
+
+	persistence.worker = startWorker(ctx, persistence.runSnapshots)
+	return nil
+}
 
+// Stop method to stop background writes, write the last snapshot and close write-ahead log
+func (persistence *Persistence) Stop(ctx context.Context) error {
+	if persistence.worker == nil {
 		return nil
 	}
 
-	// Save metrics to file every {storeInterval} seconds
-	storeToFileTicker := time.NewTicker(time.Duration(storeInterval) * time.Second)
-	go func() {
-		for {
-			<-storeToFileTicker.C
-			err := WriteMetricsToFile(memStorage, fileStoragePath)
+	var errs []error
+
+	if err := persistence.worker.stop(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	// In synchronous mode the last snapshot also empties write-ahead log
+	if err := persistence.memStorage.Checkpoint(ctx, persistence.fileStoragePath); err != nil {
+		errs = append(errs, fmt.Errorf("could not write last snapshot: %w", err))
+	} else {
+		zap.L().Info("Last snapshot written", zap.String("path", persistence.fileStoragePath))
+	}
+
+	if persistence.wal != nil {
+		if err := persistence.wal.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("could not close write-ahead log: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// runSnapshots save metrics to file every storeInterval seconds until context is done
+func (persistence *Persistence) runSnapshots(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(persistence.storeInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := WriteMetricsToFile(ctx, persistence.memStorage, persistence.fileStoragePath)
 			if err != nil {
 				zap.L().Error("Error storing metrics", zap.Error(err))
 			}
 		}
-	}()
+	}
+}
 
-	return nil
+// runCheckpoints compact non-empty write-ahead log into snapshot every walCheckpointInterval until context is done
+func (persistence *Persistence) runCheckpoints(ctx context.Context) {
+	ticker := time.NewTicker(walCheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if persistence.wal.Size() == 0 {
+				continue
+			}
+			err := persistence.memStorage.Checkpoint(ctx, persistence.fileStoragePath)
+			if err != nil {
+				zap.L().Error("Error compacting write-ahead log", zap.Error(err))
+			}
+		}
+	}
 }
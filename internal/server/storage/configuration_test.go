@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+func TestPersistence_SyncModeRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	ctx := context.Background()
+
+	// First run writes every update to write-ahead log and crashes without Stop
+	memStorage := NewMemStorage()
+	persistence := NewPersistence(memStorage, path, true, 0)
+	err := persistence.Start(ctx)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = memStorage.UpdateCounter(ctx, "PollCount", nil, 5)
+		assert.NoError(t, err)
+	}
+	err = memStorage.UpdateGauge(ctx, "HeapAlloc", model.Labels{"host": "a"}, 2.5)
+	assert.NoError(t, err)
+	err = memStorage.UpdateHistogram(ctx, "Latency", nil, model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 0.5, Count: 1})
+	assert.NoError(t, err)
+	persistence.worker.cancel()
+	assert.NoError(t, persistence.wal.Close())
+
+	// Restart restores state exactly, replaying the log does not add counter totals together
+	for i := 0; i < 2; i++ {
+		memStorage = NewMemStorage()
+		persistence = NewPersistence(memStorage, path, true, 0)
+		err = persistence.Start(ctx)
+		assert.NoError(t, err)
+
+		counter, err := memStorage.GetCounter(ctx, "PollCount", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(15), counter)
+
+		gauge, err := memStorage.GetGauge(ctx, "HeapAlloc", model.Labels{"host": "a"})
+		assert.NoError(t, err)
+		assert.Equal(t, 2.5, gauge)
+
+		histogram, err := memStorage.GetHistogram(ctx, "Latency", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), histogram.Count)
+
+		assert.Equal(t, int64(0), persistence.wal.Size())
+		assert.NoError(t, persistence.Stop(ctx))
+	}
+}
+
+func TestPersistence_StopWritesLastSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	ctx := context.Background()
+
+	memStorage := NewMemStorage()
+	persistence := NewPersistence(memStorage, path, true, 300)
+	err := persistence.Start(ctx)
+	assert.NoError(t, err)
+
+	err = memStorage.UpdateCounter(ctx, "PollCount", nil, 5)
+	assert.NoError(t, err)
+
+	// Update made long before the next tick is saved on Stop
+	err = persistence.Stop(ctx)
+	assert.NoError(t, err)
+
+	restored := NewMemStorage()
+	err = RestoreMetricsFromFile(ctx, restored, path)
+	assert.NoError(t, err)
+
+	counter, err := restored.GetCounter(ctx, "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), counter)
+}
+
+func TestPersistence_SyncModeStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	ctx := context.Background()
+
+	memStorage := NewMemStorage()
+	persistence := NewPersistence(memStorage, path, false, 0)
+	err := persistence.Start(ctx)
+	assert.NoError(t, err)
+
+	err = persistence.Stop(ctx)
+	assert.NoError(t, err)
+
+	// Write-ahead log is closed, updates are not accepted silently
+	err = memStorage.UpdateCounter(ctx, "PollCount", nil, 5)
+	assert.ErrorIs(t, err, ErrWALClosed)
+}
+
+func TestPersistence_WithoutFile(t *testing.T) {
+	persistence := NewPersistence(NewMemStorage(), "", true, 0)
+	assert.NoError(t, persistence.Start(context.Background()))
+	assert.NoError(t, persistence.Stop(context.Background()))
+}
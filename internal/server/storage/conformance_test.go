@@ -0,0 +1,392 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+// storageFactory creates empty storage for one conformance test, resources are released by test cleanup
+type storageFactory func(t *testing.T) Storage
+
+// conformanceBackends every Storage implementation with its factory, new backends are added here.
+// SQLite is the in-process SQL backend, PostgreSQL runs when DATABASE_DSN is set, CI sets it to its Postgres service
+var conformanceBackends = map[string]storageFactory{
+	"Memory": func(_ *testing.T) Storage {
+		return NewMemStorage()
+	},
+	"SQLite": func(t *testing.T) Storage {
+		return newTestSQLiteStorage(t)
+	},
+	"Bolt": func(t *testing.T) Storage {
+		return newTestBoltStorage(t)
+	},
+	"Redis": func(t *testing.T) Storage {
+		storage, _ := newTestRedisStorage(t)
+		return storage
+	},
+	"Cached": func(t *testing.T) Storage {
+		storage := NewCachedStorage(NewMemStorage(), time.Hour, DefaultCacheFlushSize)
+		require.NoError(t, storage.Start(context.Background()))
+		t.Cleanup(func() {
+			assert.NoError(t, storage.Stop(context.Background()))
+		})
+		return storage
+	},
+	"Mirrored": func(_ *testing.T) Storage {
+		return NewMirroredStorage(NewMemStorage(), MirrorPolicyFail, time.Hour, NewMemStorage())
+	},
+	"Postgres": newTestPostgresStorage,
+}
+
+// newTestPostgresStorage database storage with empty tables, test is skipped without DATABASE_DSN
+func newTestPostgresStorage(t *testing.T) Storage {
+	dsn, ok := os.LookupEnv("DATABASE_DSN")
+	if !ok {
+		t.Skip("DATABASE_DSN is not set")
+	}
+
+	storage, err := NewDBStorage(dsn, PoolConfig{})
+	require.NoError(t, err)
+	require.NoError(t, storage.Start(context.Background()))
+
+	_, err = storage.db.Exec(context.Background(), `TRUNCATE gauge, counter, histogram, metric_history, metric_history_rollup`)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		assert.NoError(t, storage.Stop(context.Background()))
+	})
+
+	return storage
+}
+
+func TestStorageConformance(t *testing.T) {
+	for name, newStorage := range conformanceBackends {
+		t.Run(name, func(t *testing.T) {
+			runConformance(t, newStorage)
+		})
+	}
+}
+
+// runConformance check behaviors every Storage implementation must have
+func runConformance(t *testing.T, newStorage storageFactory) {
+	t.Run("GaugeOverwrite", func(t *testing.T) {
+		storage := newStorage(t)
+		ctx := context.Background()
+
+		require.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 1.5))
+		require.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, -2.25))
+
+		gauge, err := storage.GetGauge(ctx, "Alloc", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, -2.25, gauge)
+	})
+
+	t.Run("CounterAccumulation", func(t *testing.T) {
+		storage := newStorage(t)
+		ctx := context.Background()
+
+		require.NoError(t, storage.UpdateCounter(ctx, "PollCount", nil, 10))
+
+		// Returned value is accumulated total, not the delta
+		total, err := storage.UpdateCounterAndReturn(ctx, "PollCount", nil, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(15), total)
+
+		counter, err := storage.GetCounter(ctx, "PollCount", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(15), counter)
+
+		counters, err := storage.GetAllCounter(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int64{model.SeriesKey("PollCount", nil): 15}, counters)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		storage := newStorage(t)
+		ctx := context.Background()
+
+		_, err := storage.GetGauge(ctx, "Alloc", nil)
+		assert.ErrorIs(t, err, ErrItemNotFound)
+		_, err = storage.GetCounter(ctx, "PollCount", nil)
+		assert.ErrorIs(t, err, ErrItemNotFound)
+		_, err = storage.GetHistogram(ctx, "Latency", nil)
+		assert.ErrorIs(t, err, ErrItemNotFound)
+
+		// Series of one type or labels is not visible as another one
+		require.NoError(t, storage.UpdateGauge(ctx, "Alloc", model.Labels{"host": "a"}, 1))
+		_, err = storage.GetCounter(ctx, "Alloc", model.Labels{"host": "a"})
+		assert.ErrorIs(t, err, ErrItemNotFound)
+		_, err = storage.GetGauge(ctx, "Alloc", nil)
+		assert.ErrorIs(t, err, ErrItemNotFound)
+
+		gauges, err := storage.GetAllGauge(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]float64{model.SeriesKey("Alloc", model.Labels{"host": "a"}): 1}, gauges)
+	})
+
+	t.Run("Histogram", func(t *testing.T) {
+		storage := newStorage(t)
+		ctx := context.Background()
+
+		histogram := model.HistogramData{Bounds: []float64{1, 10}, Counts: []int64{1, 2, 0}, Sum: 12, Count: 3}
+		require.NoError(t, storage.UpdateHistogram(ctx, "Latency", nil, histogram))
+		require.NoError(t, storage.UpdateHistogram(ctx, "Latency", nil, histogram))
+
+		err := storage.UpdateHistogram(ctx, "Latency", nil, model.HistogramData{Bounds: []float64{5}, Counts: []int64{1, 0}, Sum: 1, Count: 1})
+		assert.ErrorIs(t, err, ErrHistogramBoundsMismatch)
+
+		stored, err := storage.GetHistogram(ctx, "Latency", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, model.HistogramData{Bounds: []float64{1, 10}, Counts: []int64{2, 4, 0}, Sum: 24, Count: 6}, stored)
+	})
+
+	t.Run("BatchMerge", func(t *testing.T) {
+		storage := newStorage(t)
+		ctx := context.Background()
+
+		first, second := 1.0, 2.0
+		delta := int64(3)
+		err := storage.UpdateMetrics(ctx, []model.Metrics{
+			{ID: "Alloc", MType: string(model.Gauge), Value: &first},
+			{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+			{ID: "Alloc", MType: string(model.Gauge), Value: &second},
+			{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+		})
+		require.NoError(t, err)
+
+		gauge, err := storage.GetGauge(ctx, "Alloc", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, second, gauge)
+
+		counter, err := storage.GetCounter(ctx, "PollCount", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(6), counter)
+	})
+
+	t.Run("BatchAtomicity", func(t *testing.T) {
+		storage := newStorage(t)
+		ctx := context.Background()
+
+		require.NoError(t, storage.UpdateHistogram(ctx, "Latency", nil, model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 1, Count: 1}))
+
+		gauge := 1.0
+		delta := int64(2)
+
+		// Invalid metric at the end rejects the whole batch
+		err := storage.UpdateMetrics(ctx, []model.Metrics{
+			{ID: "Alloc", MType: string(model.Gauge), Value: &gauge},
+			{ID: "PollCount", MType: string(model.Counter)},
+		})
+		assert.ErrorIs(t, err, ErrInvalidMetric)
+
+		// Histogram which can not be merged rolls back updates before it
+		err = storage.UpdateMetrics(ctx, []model.Metrics{
+			{ID: "Alloc", MType: string(model.Gauge), Value: &gauge},
+			{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+			{ID: "Latency", MType: string(model.Histogram), Histogram: &model.HistogramData{Bounds: []float64{5}, Counts: []int64{1, 0}, Sum: 1, Count: 1}},
+		})
+		assert.ErrorIs(t, err, ErrHistogramBoundsMismatch)
+
+		_, err = storage.GetGauge(ctx, "Alloc", nil)
+		assert.ErrorIs(t, err, ErrItemNotFound)
+		_, err = storage.GetCounter(ctx, "PollCount", nil)
+		assert.ErrorIs(t, err, ErrItemNotFound)
+
+		histogram, err := storage.GetHistogram(ctx, "Latency", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), histogram.Count)
+	})
+
+	t.Run("ConcurrentAccess", func(t *testing.T) {
+		storage := newStorage(t)
+		ctx := context.Background()
+
+		const workers = 8
+		const updates = 25
+
+		var wg sync.WaitGroup
+		for worker := 0; worker < workers; worker++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				for i := 0; i < updates; i++ {
+					var err error
+					if i%2 == 0 {
+						err = storage.UpdateCounter(ctx, "PollCount", nil, 1)
+					} else {
+						delta := int64(1)
+						value := float64(worker)
+						err = storage.UpdateMetrics(ctx, []model.Metrics{
+							{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+							{ID: "Alloc", MType: string(model.Gauge), Value: &value},
+						})
+					}
+					assert.NoError(t, err)
+
+					_, err = storage.GetCounter(ctx, "PollCount", nil)
+					assert.NoError(t, err)
+				}
+			}(worker)
+		}
+		wg.Wait()
+
+		counter, err := storage.GetCounter(ctx, "PollCount", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(workers*updates), counter)
+
+		gauge, err := storage.GetGauge(ctx, "Alloc", nil)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, gauge, 0.0)
+		assert.Less(t, gauge, float64(workers))
+	})
+
+	t.Run("GetMetrics", func(t *testing.T) {
+		storage := newStorage(t)
+		ctx := context.Background()
+		labels := model.Labels{"host": "a"}
+		histogram := model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 0.5, Count: 1}
+
+		require.NoError(t, storage.UpdateGauge(ctx, "Alloc", labels, 1.5))
+		require.NoError(t, storage.UpdateCounter(ctx, "PollCount", nil, 3))
+		require.NoError(t, storage.UpdateHistogram(ctx, "Latency", nil, histogram))
+
+		metrics, err := storage.GetMetrics(ctx, []model.Metrics{
+			{ID: "PollCount", MType: string(model.Counter)},
+			{ID: "Alloc", MType: string(model.Gauge)},
+			{ID: "Latency", MType: string(model.Histogram)},
+			{ID: "Alloc", MType: string(model.Gauge), Labels: labels},
+			{ID: "PollCount", MType: string(model.Gauge)},
+		})
+		require.NoError(t, err)
+
+		// Missing series, other labels and other type are skipped, found are in request order
+		value := 1.5
+		delta := int64(3)
+		assert.Equal(t, []model.Metrics{
+			{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+			{ID: "Latency", MType: string(model.Histogram), Histogram: &histogram},
+			{ID: "Alloc", MType: string(model.Gauge), Labels: labels, Value: &value},
+		}, metrics)
+
+		_, err = storage.GetMetrics(ctx, []model.Metrics{{ID: "Alloc", MType: "summary"}})
+		assert.ErrorIs(t, err, ErrUnknownMetricType)
+	})
+
+	t.Run("QueryMetrics", func(t *testing.T) {
+		storage := newStorage(t)
+		ctx := context.Background()
+		labels := model.Labels{"host": "a"}
+
+		require.NoError(t, storage.UpdateGauge(ctx, "app.Alloc", labels, 2))
+		require.NoError(t, storage.UpdateGauge(ctx, "app.Alloc", nil, 1))
+		require.NoError(t, storage.UpdateCounter(ctx, "app.Alloc", nil, 3))
+		require.NoError(t, storage.UpdateCounter(ctx, "app.PollCount", nil, 4))
+		require.NoError(t, storage.UpdateGauge(ctx, "sys.Load", nil, 5))
+
+		series := func(metrics []model.Metrics) []string {
+			keys := make([]string, 0, len(metrics))
+			for _, metric := range metrics {
+				keys = append(keys, metric.MType+":"+model.SeriesKey(metric.ID, metric.Labels))
+			}
+			return keys
+		}
+
+		// Pages follow each other without gaps and duplicates
+		first, err := storage.QueryMetrics(ctx, model.MetricsQuery{Prefix: "app.", Limit: 3})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"counter:app.Alloc", "gauge:app.Alloc", `gauge:app.Alloc{host="a"}`}, series(first))
+		second, err := storage.QueryMetrics(ctx, model.MetricsQuery{Prefix: "app.", Limit: 3, After: &first[2]})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"counter:app.PollCount"}, series(second))
+		require.NotNil(t, second[0].Delta)
+		assert.Equal(t, int64(4), *second[0].Delta)
+
+		desc, err := storage.QueryMetrics(ctx, model.MetricsQuery{Type: model.Gauge, Desc: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"gauge:sys.Load", `gauge:app.Alloc{host="a"}`, "gauge:app.Alloc"}, series(desc))
+		desc, err = storage.QueryMetrics(ctx, model.MetricsQuery{Type: model.Gauge, Desc: true, Limit: 1, After: &desc[1]})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"gauge:app.Alloc"}, series(desc))
+
+		empty, err := storage.QueryMetrics(ctx, model.MetricsQuery{Prefix: "none."})
+		assert.NoError(t, err)
+		assert.Empty(t, empty)
+
+		_, err = storage.QueryMetrics(ctx, model.MetricsQuery{Type: "summary"})
+		assert.ErrorIs(t, err, ErrUnknownMetricType)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		storage := newStorage(t)
+		ctx := context.Background()
+		labels := model.Labels{"host": "a"}
+
+		require.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 1))
+		require.NoError(t, storage.UpdateGauge(ctx, "Alloc", labels, 2))
+		require.NoError(t, storage.UpdateCounter(ctx, "Alloc", nil, 3))
+
+		require.NoError(t, storage.Delete(ctx, model.Gauge, "Alloc", nil))
+
+		_, err := storage.GetGauge(ctx, "Alloc", nil)
+		assert.ErrorIs(t, err, ErrItemNotFound)
+		samples, err := storage.GetHistory(ctx, model.Gauge, "Alloc", nil, time.Time{}, time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		assert.Empty(t, samples)
+
+		// Series of other labels and type are kept
+		gauge, err := storage.GetGauge(ctx, "Alloc", labels)
+		assert.NoError(t, err)
+		assert.Equal(t, 2.0, gauge)
+		counter, err := storage.GetCounter(ctx, "Alloc", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), counter)
+
+		err = storage.Delete(ctx, model.Gauge, "Alloc", nil)
+		assert.ErrorIs(t, err, ErrItemNotFound)
+		err = storage.Delete(ctx, model.MetricType("summary"), "Alloc", nil)
+		assert.ErrorIs(t, err, ErrUnknownMetricType)
+
+		// Deleted counter starts again from zero
+		require.NoError(t, storage.Delete(ctx, model.Counter, "Alloc", nil))
+		total, err := storage.UpdateCounterAndReturn(ctx, "Alloc", nil, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+	})
+
+	t.Run("DeleteByPrefix", func(t *testing.T) {
+		storage := newStorage(t)
+		ctx := context.Background()
+
+		require.NoError(t, storage.UpdateGauge(ctx, "Typo.Alloc", nil, 1))
+		require.NoError(t, storage.UpdateGauge(ctx, "Typo.Alloc", model.Labels{"host": "a"}, 1))
+		require.NoError(t, storage.UpdateCounter(ctx, "Typo.PollCount", nil, 1))
+		require.NoError(t, storage.UpdateHistogram(ctx, "Typo.Latency", nil, model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 1, Count: 1}))
+		require.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 2))
+		require.NoError(t, storage.UpdateGauge(ctx, "Typo_Alloc", nil, 3))
+
+		deleted, err := storage.DeleteByPrefix(ctx, "Typo.")
+		assert.NoError(t, err)
+		assert.Equal(t, 4, deleted)
+
+		gauges, err := storage.GetAllGauge(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]float64{"Alloc": 2, "Typo_Alloc": 3}, gauges)
+		counters, err := storage.GetAllCounter(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, counters)
+		histograms, err := storage.GetAllHistogram(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, histograms)
+
+		deleted, err = storage.DeleteByPrefix(ctx, "Typo.")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, deleted)
+	})
+}
@@ -1,17 +1,18 @@
 package storage
 
 import (
-	"database/sql"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
 	"go.uber.org/zap"
 )
@@ -21,112 +22,222 @@ const maxRetries = 3
 var delays = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
 
 type DBStorage struct {
-	DB *sql.DB
+	db   pgxConn
+	pool *pgxpool.Pool
+}
+
+// pgxConn operations of pgxpool.Pool used by queries, implemented by pgxmock in tests
+type pgxConn interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, batch *pgx.Batch) pgx.BatchResults
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Ping(ctx context.Context) error
+	Close()
+}
+
+// PoolConfig settings of database connection pool, zero value of setting keeps pgxpool default
+type PoolConfig struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	StatementTimeout  time.Duration
+}
+
+// PoolStats snapshot of database connection pool statistics
+type PoolStats struct {
+	AcquireCount            int64   `json:"acquire_count"`
+	AcquireDurationMs       float64 `json:"acquire_duration_ms"`
+	CanceledAcquireCount    int64   `json:"canceled_acquire_count"`
+	EmptyAcquireCount       int64   `json:"empty_acquire_count"`
+	NewConnsCount           int64   `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64   `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64   `json:"max_idle_destroy_count"`
+	AcquiredConns           int32   `json:"acquired_conns"`
+	ConstructingConns       int32   `json:"constructing_conns"`
+	IdleConns               int32   `json:"idle_conns"`
+	TotalConns              int32   `json:"total_conns"`
+	MaxConns                int32   `json:"max_conns"`
 }
 
 var ErrRetriesFailed = errors.New("retries failed")
-var ErrMigrationsFailed = errors.New("migrations failed")
+var ErrNoPool = errors.New("database storage has no connection pool")
 
 type Repository interface {
-	Ping() error
+	Ping(ctx context.Context) error
 }
 
-// NewDBStorage method to open new PostgresSQL connection
-func NewDBStorage(databaseDsn string) (*DBStorage, error) {
-	db, err := sql.Open("pgx", databaseDsn)
-	if err != nil {
-		return nil, err
-	}
-	return &DBStorage{DB: db}, nil
+// PoolStatsProvider storage with database connection pool
+type PoolStatsProvider interface {
+	PoolStats() (PoolStats, error)
 }
 
-// RunMigrations method to run all migrations
-func (storage *DBStorage) RunMigrations() error {
-	driver, err := postgres.WithInstance(storage.DB, &postgres.Config{})
+// NewDBStorage method to open pgxpool connection pool to PostgresSQL, connections are established lazily
+func NewDBStorage(databaseDsn string, poolConfig PoolConfig) (*DBStorage, error) {
+	config, err := pgxpool.ParseConfig(databaseDsn)
 	if err != nil {
-		zap.L().Info("Failed to create migration driver", zap.Error(err))
-		return ErrMigrationsFailed
+		return nil, fmt.Errorf("failed to parse database DSN: %w", err)
 	}
 
-	migration, err := migrate.NewWithDatabaseInstance("file://internal/server/storage/migrations", "public", driver)
-	if err != nil {
-		zap.L().Info("Failed to create migrate instance", zap.Error(err))
-		return ErrMigrationsFailed
+	if poolConfig.MaxConns > 0 {
+		config.MaxConns = poolConfig.MaxConns
+	}
+	if poolConfig.MinConns > 0 {
+		config.MinConns = poolConfig.MinConns
+	}
+	if poolConfig.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = poolConfig.MaxConnLifetime
+	}
+	if poolConfig.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = poolConfig.MaxConnIdleTime
 	}
-	err = migration.Up()
-	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		zap.L().Info("Failed to run migrations", zap.Error(err))
-		return ErrMigrationsFailed
+	if poolConfig.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = poolConfig.HealthCheckPeriod
+	}
+	if poolConfig.StatementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(poolConfig.StatementTimeout.Milliseconds(), 10)
 	}
 
-	if errors.Is(err, migrate.ErrNoChange) {
-		zap.L().Info("No migrations to run")
-	} else {
-		zap.L().Info("Successfully ran migrations")
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection pool: %w", err)
 	}
 
-	return nil
+	zap.L().Info("Created database connection pool",
+		zap.Int32("maxConns", config.MaxConns),
+		zap.Int32("minConns", config.MinConns),
+		zap.Duration("maxConnLifetime", config.MaxConnLifetime),
+		zap.Duration("maxConnIdleTime", config.MaxConnIdleTime),
+		zap.Duration("healthCheckPeriod", config.HealthCheckPeriod),
+		zap.Duration("statementTimeout", poolConfig.StatementTimeout))
+
+	return &DBStorage{db: pool, pool: pool}, nil
 }
 
-func (storage *DBStorage) Close() {
-	err := storage.DB.Close()
-	if err != nil {
-		zap.L().Info("Failed to close database", zap.Error(err))
+// PoolStats method to get statistics of connection pool
+func (storage *DBStorage) PoolStats() (PoolStats, error) {
+	if storage.pool == nil {
+		return PoolStats{}, ErrNoPool
 	}
+
+	stat := storage.pool.Stat()
+	return PoolStats{
+		AcquireCount:            stat.AcquireCount(),
+		AcquireDurationMs:       float64(stat.AcquireDuration()) / float64(time.Millisecond),
+		CanceledAcquireCount:    stat.CanceledAcquireCount(),
+		EmptyAcquireCount:       stat.EmptyAcquireCount(),
+		NewConnsCount:           stat.NewConnsCount(),
+		MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+		AcquiredConns:           stat.AcquiredConns(),
+		ConstructingConns:       stat.ConstructingConns(),
+		IdleConns:               stat.IdleConns(),
+		TotalConns:              stat.TotalConns(),
+		MaxConns:                stat.MaxConns(),
+	}, nil
+}
+
+// Close method to close connection pool
+func (storage *DBStorage) Close() {
+	storage.db.Close()
+}
+
+// Start method to run migrations before storage is used
+func (storage *DBStorage) Start(ctx context.Context) error {
+	return storage.MigrateUp(ctx)
+}
+
+// Stop method to close database connections, queries in progress are finished first
+func (storage *DBStorage) Stop(_ context.Context) error {
+	// Closing pool waits until all acquired connections are released
+	storage.db.Close()
+
+	zap.L().Info("Database closed")
+	return nil
 }
 
 // Ping verifies a connection to the database is still alive
-func (storage *DBStorage) Ping() error {
-	return storage.DB.Ping()
+func (storage *DBStorage) Ping(ctx context.Context) error {
+	return storage.db.Ping(ctx)
 }
 
+// upsertGaugeQuery updates gauge and records accepted value in metric_history
+const upsertGaugeQuery = `
+	WITH updated AS (
+		INSERT INTO gauge (name, labels, value) VALUES ($1, $2, $3)
+		ON CONFLICT (name, labels) DO UPDATE SET value = EXCLUDED.value
+		RETURNING name, labels, value
+	)
+	INSERT INTO metric_history (type, name, labels, value)
+	SELECT 'gauge', name, labels, value FROM updated;
+`
+
+// upsertCounterQuery adds delta to counter, records accumulated value in metric_history and returns it
+const upsertCounterQuery = `
+	WITH updated AS (
+		INSERT INTO counter (name, labels, value) VALUES ($1, $2, $3)
+		ON CONFLICT (name, labels) DO UPDATE SET value = counter.value + EXCLUDED.value
+		RETURNING name, labels, value
+	), recorded AS (
+		INSERT INTO metric_history (type, name, labels, value)
+		SELECT 'counter', name, labels, value FROM updated
+	)
+	SELECT value FROM updated;
+`
+
 // UpdateGauge method to update gauge metric
-func (storage *DBStorage) UpdateGauge(name string, metric float64) error {
-	return storage.retryableExec(`
-		INSERT INTO gauge (name, value) VALUES ($1, $2)
-		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value;
-	`, name, metric)
+func (storage *DBStorage) UpdateGauge(ctx context.Context, name string, labels model.Labels, metric float64) error {
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	return storage.retryableExec(ctx, upsertGaugeQuery, name, encodedLabels, metric)
 }
 
-func (storage *DBStorage) UpdateCounter(name string, metric int64) error {
-	return storage.retryableExec(`
-		INSERT INTO counter (name, value) VALUES ($1, $2)
-		ON CONFLICT (name) DO UPDATE SET value = counter.value + EXCLUDED.value;
-	`, name, metric)
+// UpdateCounter method to update counter metric
+func (storage *DBStorage) UpdateCounter(ctx context.Context, name string, labels model.Labels, metric int64) error {
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	return storage.retryableExec(ctx, upsertCounterQuery, name, encodedLabels, metric)
 }
 
 // UpdateCounterAndReturn method to update counter metric and return updated value
-func (storage *DBStorage) UpdateCounterAndReturn(name string, metric int64) (int64, error) {
+func (storage *DBStorage) UpdateCounterAndReturn(ctx context.Context, name string, labels model.Labels, metric int64) (int64, error) {
 	var value int64
 
-	row, err := storage.retryableQueryRow(`
-		INSERT INTO counter (name, value) VALUES ($1, $2)
-		ON CONFLICT (name) DO UPDATE SET value = counter.value + EXCLUDED.value
-		RETURNING value;
-	`, name, metric)
+	encodedLabels, err := labelsToJSON(labels)
 	if err != nil {
 		return 0, err
 	}
 
-	err = row.Scan(&value)
+	err = storage.retryableQueryRow(ctx, scanInto(&value), upsertCounterQuery, name, encodedLabels, metric)
 	if err != nil {
 		zap.L().Error("Failed to update and return counter metric", zap.Error(err))
 		return 0, err
 	}
 
-	return metric, nil
+	return value, nil
 }
 
 // GetGauge method to get gauge metric by name
-func (storage *DBStorage) GetGauge(name string) (float64, error) {
+func (storage *DBStorage) GetGauge(ctx context.Context, name string, labels model.Labels) (float64, error) {
 	var value float64
-	row, err := storage.retryableQueryRow(`SELECT value FROM gauge WHERE name = $1`, name)
+
+	encodedLabels, err := labelsToJSON(labels)
 	if err != nil {
 		return 0, err
 	}
-	err = row.Scan(&value)
+
+	err = storage.retryableQueryRow(ctx, scanInto(&value), `SELECT value FROM gauge WHERE name = $1 AND labels = $2`, name, encodedLabels)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return 0, ErrItemNotFound
 		}
 		zap.L().Error("Failed to select gauge metric", zap.Error(err))
@@ -137,15 +248,17 @@ func (storage *DBStorage) GetGauge(name string) (float64, error) {
 }
 
 // GetCounter method to get counter metric by name
-func (storage *DBStorage) GetCounter(name string) (int64, error) {
+func (storage *DBStorage) GetCounter(ctx context.Context, name string, labels model.Labels) (int64, error) {
 	var value int64
-	row, err := storage.retryableQueryRow(`SELECT value FROM counter WHERE name = $1`, name)
+
+	encodedLabels, err := labelsToJSON(labels)
 	if err != nil {
 		return 0, err
 	}
-	err = row.Scan(&value)
+
+	err = storage.retryableQueryRow(ctx, scanInto(&value), `SELECT value FROM counter WHERE name = $1 AND labels = $2`, name, encodedLabels)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return 0, ErrItemNotFound
 		}
 		zap.L().Error("Failed to select counter metric", zap.Error(err))
@@ -156,8 +269,8 @@ func (storage *DBStorage) GetCounter(name string) (int64, error) {
 }
 
 // GetAllGauge method to get all gauge metrics
-func (storage *DBStorage) GetAllGauge() (map[string]float64, error) {
-	rows, err := storage.retryableQuery(`SELECT name, value FROM gauge`)
+func (storage *DBStorage) GetAllGauge(ctx context.Context) (map[string]float64, error) {
+	rows, err := storage.retryableQuery(ctx, `SELECT name, labels, value FROM gauge`)
 	if err != nil {
 		zap.L().Error("Failed to get all gauge metrics")
 		return nil, err
@@ -169,12 +282,18 @@ func (storage *DBStorage) GetAllGauge() (map[string]float64, error) {
 
 	for rows.Next() {
 		var name string
+		var encodedLabels []byte
 		var value float64
-		if err := rows.Scan(&name, &value); err != nil {
+		if err := rows.Scan(&name, &encodedLabels, &value); err != nil {
+			zap.L().Error("Failed to get all gauge metrics", zap.Error(err))
+			return nil, err
+		}
+		labels, err := labelsFromJSON(encodedLabels)
+		if err != nil {
 			zap.L().Error("Failed to get all gauge metrics", zap.Error(err))
 			return nil, err
 		}
-		gaugeMetrics[name] = value
+		gaugeMetrics[model.SeriesKey(name, labels)] = value
 	}
 
 	if err := rows.Err(); err != nil {
@@ -186,8 +305,8 @@ func (storage *DBStorage) GetAllGauge() (map[string]float64, error) {
 }
 
 // GetAllCounter method to get all counter metrics
-func (storage *DBStorage) GetAllCounter() (map[string]int64, error) {
-	rows, err := storage.retryableQuery(`SELECT name, value FROM counter`)
+func (storage *DBStorage) GetAllCounter(ctx context.Context) (map[string]int64, error) {
+	rows, err := storage.retryableQuery(ctx, `SELECT name, labels, value FROM counter`)
 	if err != nil {
 		zap.L().Error("Failed to get all counter metrics")
 		return nil, err
@@ -199,12 +318,18 @@ func (storage *DBStorage) GetAllCounter() (map[string]int64, error) {
 
 	for rows.Next() {
 		var name string
+		var encodedLabels []byte
 		var value int64
-		if err := rows.Scan(&name, &value); err != nil {
+		if err := rows.Scan(&name, &encodedLabels, &value); err != nil {
+			zap.L().Error("Failed to get all counter metrics", zap.Error(err))
+			return nil, err
+		}
+		labels, err := labelsFromJSON(encodedLabels)
+		if err != nil {
 			zap.L().Error("Failed to get all counter metrics", zap.Error(err))
 			return nil, err
 		}
-		counterMetrics[name] = value
+		counterMetrics[model.SeriesKey(name, labels)] = value
 	}
 
 	if err := rows.Err(); err != nil {
@@ -215,70 +340,759 @@ func (storage *DBStorage) GetAllCounter() (map[string]int64, error) {
 	return counterMetrics, nil
 }
 
-// UpdateMetrics method to update batch of different types of metrics
-func (storage *DBStorage) UpdateMetrics(metrics []model.Metrics) error {
-	tx, err := storage.DB.Begin()
+// upsertHistogramQuery merges bucket counts element-wise, row is updated only when bucket bounds are equal
+const upsertHistogramQuery = `
+	INSERT INTO histogram (name, labels, bounds, counts, sum, count) VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (name, labels) DO UPDATE SET
+		counts = ARRAY(
+			SELECT stored + received
+			FROM unnest(histogram.counts, EXCLUDED.counts) WITH ORDINALITY AS buckets(stored, received, position)
+			ORDER BY position
+		),
+		sum = histogram.sum + EXCLUDED.sum,
+		count = histogram.count + EXCLUDED.count
+	WHERE histogram.bounds = EXCLUDED.bounds
+	RETURNING count;
+`
+
+// UpdateHistogram method to merge observations into histogram metric
+func (storage *DBStorage) UpdateHistogram(ctx context.Context, name string, labels model.Labels, metric model.HistogramData) error {
+	encodedLabels, err := labelsToJSON(labels)
 	if err != nil {
 		return err
 	}
-	defer func() {
+
+	return storage.retryableQueryRow(ctx, scanHistogramUpsert, upsertHistogramQuery,
+		name, encodedLabels, metric.Bounds, metric.Counts, metric.Sum, metric.Count)
+}
+
+// scanHistogramUpsert check result of upsertHistogramQuery, no returned row means bucket bounds mismatch
+func scanHistogramUpsert(row pgx.Row) error {
+	var count int64
+	err := row.Scan(&count)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrHistogramBoundsMismatch
+		}
+		zap.L().Error("Failed to update histogram metric", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetHistogram method to get histogram metric by name and labels
+func (storage *DBStorage) GetHistogram(ctx context.Context, name string, labels model.Labels) (model.HistogramData, error) {
+	var histogram model.HistogramData
+
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return histogram, err
+	}
+
+	err = storage.retryableQueryRow(ctx, scanInto(&histogram.Bounds, &histogram.Counts, &histogram.Sum, &histogram.Count),
+		`SELECT bounds, counts, sum, count FROM histogram WHERE name = $1 AND labels = $2`, name, encodedLabels)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return histogram, ErrItemNotFound
+		}
+		zap.L().Error("Failed to select histogram metric", zap.Error(err))
+		return histogram, err
+	}
+
+	return histogram, nil
+}
+
+// GetAllHistogram method to get all histogram metrics
+func (storage *DBStorage) GetAllHistogram(ctx context.Context) (map[string]model.HistogramData, error) {
+	rows, err := storage.retryableQuery(ctx, `SELECT name, labels, bounds, counts, sum, count FROM histogram`)
+	if err != nil {
+		zap.L().Error("Failed to get all histogram metrics")
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	histogramMetrics := make(map[string]model.HistogramData)
+
+	for rows.Next() {
+		var name string
+		var encodedLabels []byte
+		var histogram model.HistogramData
+		err := rows.Scan(&name, &encodedLabels, &histogram.Bounds, &histogram.Counts, &histogram.Sum, &histogram.Count)
+		if err != nil {
+			zap.L().Error("Failed to get all histogram metrics", zap.Error(err))
+			return nil, err
+		}
+		labels, err := labelsFromJSON(encodedLabels)
 		if err != nil {
-			err := tx.Rollback()
-			if err != nil {
-				zap.L().Error("Failed to rollback transaction", zap.Error(err))
-			}
-		} else {
-			err := tx.Commit()
-			if err != nil {
-				zap.L().Error("Failed to commit transaction", zap.Error(err))
-			}
-		}
-	}()
+			zap.L().Error("Failed to get all histogram metrics", zap.Error(err))
+			return nil, err
+		}
+		histogramMetrics[model.SeriesKey(name, labels)] = histogram
+	}
+
+	if err := rows.Err(); err != nil {
+		zap.L().Error("Failed to get all histogram metrics", zap.Error(err))
+		return nil, err
+	}
+
+	return histogramMetrics, nil
+}
+
+// metricsTable series of all types as one table with type column, columns of other types are NULL
+const metricsTable = `
+	SELECT 'gauge' AS type, name, labels, value, NULL::bigint AS delta, NULL::double precision[] AS bounds,
+		NULL::bigint[] AS counts, NULL::double precision AS sum, NULL::bigint AS count
+	FROM gauge
+	UNION ALL
+	SELECT 'counter', name, labels, NULL::double precision, value, NULL::double precision[], NULL::bigint[], NULL::double precision, NULL::bigint
+	FROM counter
+	UNION ALL
+	SELECT 'histogram', name, labels, NULL::double precision, NULL::bigint, bounds, counts, sum, count
+	FROM histogram
+`
+
+// getMetricsQuery selects series of all types with name in $1
+const getMetricsQuery = `SELECT type, name, labels, value, delta, bounds, counts, sum, count FROM (` + metricsTable + `) AS metrics
+	WHERE name = ANY($1)`
+
+// queryMetricsQuery selects page of series with type $1 (empty for all types) and name prefix $2 after series ($3, $4, $5),
+// %[1]s is comparison operator and %[2]s is direction of ordering
+const queryMetricsQuery = `SELECT type, name, labels, value, delta, bounds, counts, sum, count FROM (` + metricsTable + `) AS metrics
+	WHERE ($1::text = '' OR type = $1::text) AND starts_with(name, $2)
+		AND ($3::text IS NULL OR (name, type, labels) %[1]s ($3::text, $4::text, $5::jsonb))
+	ORDER BY name %[2]s, type %[2]s, labels %[2]s
+	LIMIT $6`
+
+// GetMetrics method to get values of requested series with one query, missing series are skipped
+func (storage *DBStorage) GetMetrics(ctx context.Context, metrics []model.Metrics) ([]model.Metrics, error) {
+	if err := checkRequestTypes(metrics); err != nil {
+		return nil, err
+	}
 
+	requested := make(map[string]struct{}, len(metrics))
+	names := make([]string, 0, len(metrics))
 	for _, metric := range metrics {
+		requested[seriesID(metric)] = struct{}{}
+		names = append(names, metric.ID)
+	}
+
+	rows, err := storage.retryableQuery(ctx, getMetricsQuery, names)
+	if err != nil {
+		zap.L().Error("Failed to get metrics", zap.Error(err))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	stored := make(map[string]model.Metrics, len(metrics))
+
+	for rows.Next() {
+		metric, err := scanMetric(rows)
+		if err != nil {
+			zap.L().Error("Failed to get metrics", zap.Error(err))
+			return nil, err
+		}
+
+		// Rows of series with requested name but other type or labels are skipped
+		key := seriesID(metric)
+		if _, ok := requested[key]; ok {
+			stored[key] = metric
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		zap.L().Error("Failed to get metrics", zap.Error(err))
+		return nil, err
+	}
+
+	return inRequestOrder(metrics, stored), nil
+}
+
+// QueryMetrics method to get page of series with filtering, ordering and limit done by database
+func (storage *DBStorage) QueryMetrics(ctx context.Context, query model.MetricsQuery) ([]model.Metrics, error) {
+	if query.Type != "" {
+		if err := checkMetricType(query.Type); err != nil {
+			return nil, err
+		}
+	}
+
+	comparison, direction := ">", "ASC"
+	if query.Desc {
+		comparison, direction = "<", "DESC"
+	}
+
+	var afterName, afterType, afterLabels, limit any
+	if query.After != nil {
+		encodedLabels, err := labelsToJSON(query.After.Labels)
+		if err != nil {
+			return nil, err
+		}
+		afterName, afterType, afterLabels = query.After.ID, query.After.MType, encodedLabels
+	}
+	if query.Limit > 0 {
+		limit = query.Limit
+	}
+
+	rows, err := storage.retryableQuery(ctx, fmt.Sprintf(queryMetricsQuery, comparison, direction),
+		string(query.Type), query.Prefix, afterName, afterType, afterLabels, limit)
+	if err != nil {
+		zap.L().Error("Failed to query metrics", zap.Error(err))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	metrics := make([]model.Metrics, 0)
+
+	for rows.Next() {
+		metric, err := scanMetric(rows)
+		if err != nil {
+			zap.L().Error("Failed to query metrics", zap.Error(err))
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+
+	if err := rows.Err(); err != nil {
+		zap.L().Error("Failed to query metrics", zap.Error(err))
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// scanMetric scan row of metricsTable into metric of its type
+func scanMetric(rows pgx.Rows) (model.Metrics, error) {
+	var metric model.Metrics
+	var encodedLabels []byte
+	var value, sum pgtype.Float8
+	var delta, count pgtype.Int8
+	var histogram model.HistogramData
+
+	err := rows.Scan(&metric.MType, &metric.ID, &encodedLabels, &value, &delta, &histogram.Bounds, &histogram.Counts, &sum, &count)
+	if err != nil {
+		return metric, err
+	}
+	labels, err := labelsFromJSON(encodedLabels)
+	if err != nil {
+		return metric, err
+	}
+	if len(labels) > 0 {
+		metric.Labels = labels
+	}
+
+	switch metric.MType {
+	case string(model.Gauge):
+		metric.Value = &value.Float64
+	case string(model.Counter):
+		metric.Delta = &delta.Int64
+	case string(model.Histogram):
+		histogram.Sum = sum.Float64
+		histogram.Count = count.Int64
+		metric.Histogram = &histogram
+	}
+
+	return metric, nil
+}
+
+// ExportMetrics method to get all stored series, database without migrations gives ErrSchemaMissing
+func (storage *DBStorage) ExportMetrics(ctx context.Context) ([]model.Metrics, error) {
+	metrics, err := storage.exportMetrics(ctx)
+
+	var pgError *pgconn.PgError
+	if errors.As(err, &pgError) && pgError.Code == pgerrcode.UndefinedTable {
+		return nil, fmt.Errorf("%w: %w", ErrSchemaMissing, err)
+	}
+	return metrics, err
+}
+
+// exportMetrics get all stored series of every type
+func (storage *DBStorage) exportMetrics(ctx context.Context) ([]model.Metrics, error) {
+	metrics := make([]model.Metrics, 0)
+
+	gauges, err := storage.retryableQuery(ctx, `SELECT name, labels, value FROM gauge`)
+	if err != nil {
+		return nil, err
+	}
+	defer gauges.Close()
+	metrics, err = scanExported(gauges, metrics, func(metric *model.Metrics) []any {
+		metric.MType = string(model.Gauge)
+		metric.Value = new(float64)
+		return []any{metric.Value}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counters, err := storage.retryableQuery(ctx, `SELECT name, labels, value FROM counter`)
+	if err != nil {
+		return nil, err
+	}
+	defer counters.Close()
+	metrics, err = scanExported(counters, metrics, func(metric *model.Metrics) []any {
+		metric.MType = string(model.Counter)
+		metric.Delta = new(int64)
+		return []any{metric.Delta}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	histograms, err := storage.retryableQuery(ctx, `SELECT name, labels, bounds, counts, sum, count FROM histogram`)
+	if err != nil {
+		return nil, err
+	}
+	defer histograms.Close()
+	return scanExported(histograms, metrics, func(metric *model.Metrics) []any {
+		metric.MType = string(model.Histogram)
+		metric.Histogram = &model.HistogramData{}
+		return []any{&metric.Histogram.Bounds, &metric.Histogram.Counts, &metric.Histogram.Sum, &metric.Histogram.Count}
+	})
+}
+
+// exportedRows rows of pgx and database/sql scanned by scanExported
+type exportedRows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// scanExported scan rows of name, labels and value columns returned by {values} into metrics
+func scanExported(rows exportedRows, metrics []model.Metrics, values func(metric *model.Metrics) []any) ([]model.Metrics, error) {
+	for rows.Next() {
+		var metric model.Metrics
+		var encodedLabels []byte
+
+		dest := append([]any{&metric.ID, &encodedLabels}, values(&metric)...)
+		if err := rows.Scan(dest...); err != nil {
+			zap.L().Error("Failed to export metrics", zap.Error(err))
+			return nil, err
+		}
+
+		labels, err := labelsFromJSON(encodedLabels)
+		if err != nil {
+			zap.L().Error("Failed to export metrics", zap.Error(err))
+			return nil, err
+		}
+		if len(labels) > 0 {
+			metric.Labels = labels
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	if err := rows.Err(); err != nil {
+		zap.L().Error("Failed to export metrics", zap.Error(err))
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// GetHistory method to get samples of gauge or counter series recorded between from and to
+func (storage *DBStorage) GetHistory(ctx context.Context, mType model.MetricType, name string, labels model.Labels, from time.Time, to time.Time) ([]model.Sample, error) {
+	if mType != model.Gauge && mType != model.Counter {
+		return nil, fmt.Errorf("history is not supported for metric type: %s", mType)
+	}
+
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := storage.retryableQuery(ctx, `
+		SELECT created_at, value FROM metric_history
+		WHERE type = $1 AND name = $2 AND labels = $3 AND created_at BETWEEN $4 AND $5
+		ORDER BY created_at;
+	`, string(mType), name, encodedLabels, from, to)
+	if err != nil {
+		zap.L().Error("Failed to get metric history")
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	samples := make([]model.Sample, 0)
+
+	for rows.Next() {
+		var sample model.Sample
+		if err := rows.Scan(&sample.Timestamp, &sample.Value); err != nil {
+			zap.L().Error("Failed to get metric history", zap.Error(err))
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+
+	if err := rows.Err(); err != nil {
+		zap.L().Error("Failed to get metric history", zap.Error(err))
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+// GetRollups method to get rollups of gauge or counter series with given resolution between from and to
+func (storage *DBStorage) GetRollups(ctx context.Context, mType model.MetricType, name string, labels model.Labels, resolution time.Duration, from time.Time, to time.Time) ([]model.Rollup, error) {
+	if mType != model.Gauge && mType != model.Counter {
+		return nil, fmt.Errorf("history is not supported for metric type: %s", mType)
+	}
+
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := storage.retryableQuery(ctx, `
+		SELECT bucket, min, max, avg, count FROM metric_history_rollup
+		WHERE type = $1 AND name = $2 AND labels = $3 AND resolution = $4 AND bucket BETWEEN $5 AND $6
+		ORDER BY bucket;
+	`, string(mType), name, encodedLabels, int64(resolution/time.Second), from, to)
+	if err != nil {
+		zap.L().Error("Failed to get metric rollups", zap.Error(err))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	rollups := make([]model.Rollup, 0)
+
+	for rows.Next() {
+		var rollup model.Rollup
+		if err := rows.Scan(&rollup.Timestamp, &rollup.Min, &rollup.Max, &rollup.Avg, &rollup.Count); err != nil {
+			zap.L().Error("Failed to get metric rollups", zap.Error(err))
+			return nil, err
+		}
+		rollups = append(rollups, rollup)
+	}
+
+	if err := rows.Err(); err != nil {
+		zap.L().Error("Failed to get metric rollups", zap.Error(err))
+		return nil, err
+	}
+
+	return rollups, nil
+}
+
+// rollupHistoryQuery rebuilds buckets of one resolution from raw samples in [$2, $3)
+const rollupHistoryQuery = `
+	INSERT INTO metric_history_rollup (type, name, labels, resolution, bucket, min, max, avg, count)
+	SELECT type, name, labels, $1::bigint, to_timestamp(floor(extract(epoch FROM created_at) / $1::bigint) * $1::bigint) AS bucket,
+		min(value), max(value), avg(value), count(*)
+	FROM metric_history
+	WHERE created_at >= $2 AND created_at < $3
+	GROUP BY type, name, labels, bucket
+	ON CONFLICT (type, name, labels, resolution, bucket) DO UPDATE
+	SET min = EXCLUDED.min, max = EXCLUDED.max, avg = EXCLUDED.avg, count = EXCLUDED.count;
+`
+
+// Compact method to roll raw samples up into retention tiers and delete expired history in one transaction
+func (storage *DBStorage) Compact(ctx context.Context, policy RetentionPolicy, now time.Time) error {
+	tx, err := storage.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := compactInTransaction(ctx, tx, policy, now); err != nil {
+		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+			zap.L().Error("Failed to rollback transaction", zap.Error(rollbackErr))
+		}
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		zap.L().Error("Failed to commit transaction", zap.Error(err))
+		return err
+	}
+
+	zap.L().Info("Compacted metric history", zap.Time("now", now))
+	return nil
+}
+
+func compactInTransaction(ctx context.Context, tx pgx.Tx, policy RetentionPolicy, now time.Time) error {
+	resolutions := make([]int64, 0, len(policy.Rollups))
+
+	for _, tier := range policy.Rollups {
+		resolution := int64(tier.Resolution / time.Second)
+		resolutions = append(resolutions, resolution)
+
+		// Raw samples of buckets before {from} may be already deleted, so only later buckets are rebuilt
+		from, until := rollupRange(policy, tier.Resolution, time.Time{}, now)
+		_, err := tx.Exec(ctx, rollupHistoryQuery, resolution, from, until)
+		if err != nil {
+			zap.L().Error("Failed to roll metric history up", zap.Duration("resolution", tier.Resolution), zap.Error(err))
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM metric_history_rollup WHERE resolution = $1 AND bucket < $2;`, resolution, now.Add(-tier.Retention))
+		if err != nil {
+			zap.L().Error("Failed to delete expired rollups", zap.Duration("resolution", tier.Resolution), zap.Error(err))
+			return err
+		}
+	}
+
+	// Tiers removed from policy are not kept anymore
+	_, err := tx.Exec(ctx, `DELETE FROM metric_history_rollup WHERE resolution <> ALL($1);`, resolutions)
+	if err != nil {
+		zap.L().Error("Failed to delete rollups of removed tiers", zap.Error(err))
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM metric_history WHERE created_at < $1;`, now.Add(-policy.Raw))
+	if err != nil {
+		zap.L().Error("Failed to delete expired metric history", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// upsertBatchQuery updates gauges and counters of merged batch with one statement and records accepted values in metric_history.
+// Batch must not contain the same series twice, otherwise ON CONFLICT would affect the same row twice
+const upsertBatchQuery = `
+	WITH gauges AS (
+		INSERT INTO gauge (name, labels, value)
+		SELECT name, labels::jsonb, value FROM unnest($1::text[], $2::text[], $3::double precision[]) AS batch(name, labels, value)
+		ON CONFLICT (name, labels) DO UPDATE SET value = EXCLUDED.value
+		RETURNING name, labels, value
+	), counters AS (
+		INSERT INTO counter (name, labels, value)
+		SELECT name, labels::jsonb, value FROM unnest($4::text[], $5::text[], $6::bigint[]) AS batch(name, labels, value)
+		ON CONFLICT (name, labels) DO UPDATE SET value = counter.value + EXCLUDED.value
+		RETURNING name, labels, value
+	)
+	INSERT INTO metric_history (type, name, labels, value)
+	SELECT 'gauge', name, labels, value FROM gauges
+	UNION ALL
+	SELECT 'counter', name, labels, value FROM counters;
+`
+
+// metricsBatch columns of merged gauges and counters passed to upsertBatchQuery as arrays
+type metricsBatch struct {
+	gaugeNames    []string
+	gaugeLabels   []string
+	gaugeValues   []float64
+	counterNames  []string
+	counterLabels []string
+	counterDeltas []int64
+	histograms    []model.Metrics
+}
+
+// newMetricsBatch merge metrics of the same series and split batch into columns
+func newMetricsBatch(metrics []model.Metrics) (*metricsBatch, error) {
+	merged, err := mergeMetrics(metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &metricsBatch{}
+	for _, metric := range merged {
+		encodedLabels, err := labelsToJSON(metric.Labels)
+		if err != nil {
+			return nil, err
+		}
+
 		switch metric.MType {
 		case string(model.Gauge):
-			err := updateGaugeInTransaction(tx, metric.ID, *metric.Value)
-			if err != nil {
-				return err
-			}
+			batch.gaugeNames = append(batch.gaugeNames, metric.ID)
+			batch.gaugeLabels = append(batch.gaugeLabels, encodedLabels)
+			batch.gaugeValues = append(batch.gaugeValues, *metric.Value)
 		case string(model.Counter):
-			err := updateCounterInTransaction(tx, metric.ID, *metric.Delta)
-			if err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("unknown metric type: %s", metric.MType)
+			batch.counterNames = append(batch.counterNames, metric.ID)
+			batch.counterLabels = append(batch.counterLabels, encodedLabels)
+			batch.counterDeltas = append(batch.counterDeltas, *metric.Delta)
+		case string(model.Histogram):
+			batch.histograms = append(batch.histograms, metric)
 		}
 	}
 
-	return nil
+	return batch, nil
+}
+
+// args arguments of upsertBatchQuery, empty columns are sent as empty arrays instead of NULL
+func (batch *metricsBatch) args() []any {
+	return []any{
+		nonNil(batch.gaugeNames), nonNil(batch.gaugeLabels), nonNil(batch.gaugeValues),
+		nonNil(batch.counterNames), nonNil(batch.counterLabels), nonNil(batch.counterDeltas),
+	}
 }
 
-func updateGaugeInTransaction(tx *sql.Tx, name string, metric float64) error {
-	_, err := tx.Exec(`
-		INSERT INTO gauge (name, value) VALUES ($1, $2)
-		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value;
-	`, name, metric)
+func nonNil[T any](values []T) []T {
+	if values == nil {
+		return []T{}
+	}
+	return values
+}
+
+// UpdateMetrics method to update batch of different types of metrics.
+// Batch is validated and merged by series first, then gauges and counters are written with one multi-row upsert.
+// Batch without histograms is a single statement and takes one round trip,
+// histograms are upserted in the same transaction with one batch of statements, which is rolled back entirely on any error
+func (storage *DBStorage) UpdateMetrics(ctx context.Context, metrics []model.Metrics) error {
+	if err := ValidateMetrics(metrics); err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	batch, err := newMetricsBatch(metrics)
+	if err != nil {
+		return err
+	}
+
+	if len(batch.histograms) == 0 {
+		return storage.retryableExec(ctx, upsertBatchQuery, batch.args()...)
+	}
+
+	tx, err := storage.db.Begin(ctx)
 	if err != nil {
-		zap.L().Error("Failed to update gauge metric in transaction", zap.Error(err))
 		return err
 	}
 
-	return err
+	if err := updateBatchInTransaction(ctx, tx, batch); err != nil {
+		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+			zap.L().Error("Failed to rollback transaction", zap.Error(rollbackErr))
+		}
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		zap.L().Error("Failed to commit transaction", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// updateBatchInTransaction send upsert of gauges and counters and upserts of histograms in one round trip
+func updateBatchInTransaction(ctx context.Context, tx pgx.Tx, batch *metricsBatch) error {
+	queued := &pgx.Batch{}
+	queued.Queue(upsertBatchQuery, batch.args()...)
+	for _, metric := range batch.histograms {
+		encodedLabels, err := labelsToJSON(metric.Labels)
+		if err != nil {
+			return err
+		}
+		queued.Queue(upsertHistogramQuery,
+			metric.ID, encodedLabels, metric.Histogram.Bounds, metric.Histogram.Counts, metric.Histogram.Sum, metric.Histogram.Count)
+	}
+
+	results := tx.SendBatch(ctx, queued)
+	if err := readBatchResults(results, len(batch.histograms)); err != nil {
+		_ = results.Close()
+		return err
+	}
+
+	return results.Close()
+}
+
+// readBatchResults check results of batch queued by updateBatchInTransaction
+func readBatchResults(results pgx.BatchResults, histograms int) error {
+	if _, err := results.Exec(); err != nil {
+		zap.L().Error("Failed to update batch of metrics in transaction", zap.Error(err))
+		return err
+	}
+
+	for i := 0; i < histograms; i++ {
+		if err := scanHistogramUpsert(results.QueryRow()); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func updateCounterInTransaction(tx *sql.Tx, name string, metric int64) error {
-	_, err := tx.Exec(`
-		INSERT INTO counter (name, value) VALUES ($1, $2)
-		ON CONFLICT (name) DO UPDATE SET value = counter.value + EXCLUDED.value;
-	`, name, metric)
+// deleteSeriesQuery deletes series from table of its type with its history and rollups and returns number of deleted series,
+// table name is formatted with metric type
+const deleteSeriesQuery = `
+	WITH deleted AS (
+		DELETE FROM %[1]s WHERE name = $1 AND labels = $2
+		RETURNING name
+	), history AS (
+		DELETE FROM metric_history WHERE type = '%[1]s' AND name = $1 AND labels = $2
+	), rollups AS (
+		DELETE FROM metric_history_rollup WHERE type = '%[1]s' AND name = $1 AND labels = $2
+	)
+	SELECT count(*) FROM deleted;
+`
+
+// deleteByPrefixQuery deletes series of all types with name starting with $1 with their history and rollups
+// and returns number of deleted series
+const deleteByPrefixQuery = `
+	WITH gauges AS (
+		DELETE FROM gauge WHERE starts_with(name, $1) RETURNING name
+	), counters AS (
+		DELETE FROM counter WHERE starts_with(name, $1) RETURNING name
+	), histograms AS (
+		DELETE FROM histogram WHERE starts_with(name, $1) RETURNING name
+	), history AS (
+		DELETE FROM metric_history WHERE starts_with(name, $1)
+	), rollups AS (
+		DELETE FROM metric_history_rollup WHERE starts_with(name, $1)
+	)
+	SELECT (SELECT count(*) FROM gauges) + (SELECT count(*) FROM counters) + (SELECT count(*) FROM histograms);
+`
+
+// Delete method to remove series with its history and rollups with one statement
+func (storage *DBStorage) Delete(ctx context.Context, mType model.MetricType, name string, labels model.Labels) error {
+	if err := checkMetricType(mType); err != nil {
+		return err
+	}
+
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	var deleted int64
+	err = storage.retryableQueryRow(ctx, scanInto(&deleted), fmt.Sprintf(deleteSeriesQuery, mType), name, encodedLabels)
 	if err != nil {
-		zap.L().Error("Failed to update counter metric in transaction", zap.Error(err))
+		zap.L().Error("Failed to delete metric", zap.Error(err))
 		return err
 	}
+	if deleted == 0 {
+		return fmt.Errorf("%s metric with name: %s not found %w", mType, model.SeriesKey(name, labels), ErrItemNotFound)
+	}
+
+	return nil
+}
+
+// DeleteByPrefix method to remove series of all types with name starting with prefix with one statement
+func (storage *DBStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	var deleted int
+	err := storage.retryableQueryRow(ctx, scanInto(&deleted), deleteByPrefixQuery, prefix)
+	if err != nil {
+		zap.L().Error("Failed to delete metrics by prefix", zap.Error(err))
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// labelsToJSON encode labels for JSONB column, metric without labels is stored with empty object
+func labelsToJSON(labels model.Labels) (string, error) {
+	if len(labels) == 0 {
+		return "{}", nil
+	}
 
-	return err
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode labels: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// labelsFromJSON decode labels from JSONB column
+func labelsFromJSON(data []byte) (model.Labels, error) {
+	var labels model.Labels
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil, fmt.Errorf("failed to decode labels: %w", err)
+	}
+
+	return labels, nil
 }
 
 // isNetworkError Only Class 08 — Connection Exception
@@ -299,10 +1113,24 @@ func isNetworkError(err error) bool {
 	return false
 }
 
-func (storage *DBStorage) retryableExec(query string, args ...interface{}) error {
+// waitForRetry sleeps for the retry delay unless the context is cancelled first
+func waitForRetry(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		zap.L().Info("Retry cancelled", zap.Error(ctx.Err()))
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (storage *DBStorage) retryableExec(ctx context.Context, query string, args ...interface{}) error {
 	for i, delay := range delays {
 		zap.L().Info("Trying to execute Exec", zap.Int("Retry count", i))
-		_, err := storage.DB.Exec(query, args...)
+		_, err := storage.db.Exec(ctx, query, args...)
 		if err == nil {
 			return nil
 		}
@@ -310,38 +1138,48 @@ func (storage *DBStorage) retryableExec(query string, args ...interface{}) error
 			zap.L().Error("Failed to execute Exec", zap.Error(err))
 			return err
 		}
-		time.Sleep(delay)
+		if err := waitForRetry(ctx, delay); err != nil {
+			return err
+		}
 	}
 
 	return ErrRetriesFailed
 }
 
-func (storage *DBStorage) retryableQueryRow(query string, args ...interface{}) (*sql.Row, error) {
+// scanInto scan function of retryableQueryRow to copy columns of row into dest
+func scanInto(dest ...any) func(row pgx.Row) error {
+	return func(row pgx.Row) error {
+		return row.Scan(dest...)
+	}
+}
+
+// retryableQueryRow pgx reports errors of QueryRow only on scan, so row is scanned with {scan} inside retries
+func (storage *DBStorage) retryableQueryRow(ctx context.Context, scan func(row pgx.Row) error, query string, args ...interface{}) error {
 	for i, delay := range delays {
 		zap.L().Info("Trying to execute QueryRow", zap.Int("Retry count", i))
 
-		row := storage.DB.QueryRow(query, args...)
-		err := row.Err()
+		err := scan(storage.db.QueryRow(ctx, query, args...))
 		if err == nil {
-			return row, nil
+			return nil
 		}
 
 		if !isNetworkError(err) || i == maxRetries-1 {
-			zap.L().Error("Failed to execute QueryRow", zap.Error(err))
-			return nil, err
+			return err
 		}
 
-		time.Sleep(delay)
+		if err := waitForRetry(ctx, delay); err != nil {
+			return err
+		}
 	}
 
-	return nil, ErrRetriesFailed
+	return ErrRetriesFailed
 }
 
-func (storage *DBStorage) retryableQuery(query string, args ...interface{}) (*sql.Rows, error) {
+func (storage *DBStorage) retryableQuery(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
 	for i, delay := range delays {
 		zap.L().Info("Trying to execute Query", zap.Int("Retry count", i))
 
-		rows, err := storage.DB.Query(query, args...)
+		rows, err := storage.db.Query(ctx, query, args...)
 		if err == nil {
 			return rows, nil
 		}
@@ -351,7 +1189,9 @@ func (storage *DBStorage) retryableQuery(query string, args ...interface{}) (*sq
 			return nil, err
 		}
 
-		time.Sleep(delay)
+		if err := waitForRetry(ctx, delay); err != nil {
+			return nil, err
+		}
 	}
 
 	return nil, ErrRetriesFailed
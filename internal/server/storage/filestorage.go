@@ -2,11 +2,17 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
 	"go.uber.org/zap"
@@ -82,78 +88,277 @@ func (c *Reader) Close() error {
 	return c.file.Close()
 }
 
+// snapshotVersion current version of snapshot format
+const snapshotVersion = 1
+
+// snapshotCompressionGzip snapshot body is compressed with gzip
+const snapshotCompressionGzip = "gzip"
+
+// maxSnapshotLineSize longest metric line accepted in snapshot
+const maxSnapshotLineSize = 16 << 20
+
+var ErrCorruptedSnapshot = errors.New("corrupted snapshot")
+
+// snapshotHeader first line of snapshot file, followed by body with one JSON metric per line.
+// Checksum is CRC-32 of uncompressed body
+type snapshotHeader struct {
+	CreatedAt   time.Time `json:"created_at"`
+	Compression string    `json:"compression,omitempty"`
+	Checksum    string    `json:"checksum"`
+	Version     int       `json:"version"`
+	Count       int       `json:"count"`
+}
+
+// PreviousSnapshotPath path of rotated snapshot used when the current one is corrupted
+func PreviousSnapshotPath(fileStoragePath string) string {
+	return fileStoragePath + ".1"
+}
+
 // WriteMetricsToFile method to write all metrics from mem storage to specified file
-func WriteMetricsToFile(memStorage *MemStorage, fileStoragePath string) error {
+func WriteMetricsToFile(ctx context.Context, memStorage *MemStorage, fileStoragePath string) error {
+	return WriteSnapshot(ctx, memStorage.snapshot(), fileStoragePath, memStorage.snapshotGzip)
+}
+
+// WriteSnapshot write metrics to temporary file, sync it and rename to specified file.
+// Current snapshot is rotated to PreviousSnapshotPath, so crash during writing never leaves partially written snapshot
+func WriteSnapshot(ctx context.Context, metrics []model.Metrics, fileStoragePath string, compress bool) error {
 	if fileStoragePath == "" {
 		return ErrFileStoragePathNotProvided
 	}
 
-	writer, err := NewWriter(fileStoragePath)
-	if err != nil {
-		return fmt.Errorf("could not create file writer: %w", err)
+	var body bytes.Buffer
+	for _, metric := range metrics {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(&metric)
+		if err != nil {
+			return fmt.Errorf("could not marshal %s metric: %w", metric.MType, err)
+		}
+		body.Write(data)
+		body.WriteByte('\n')
 	}
 
-	gaugeMetrics, _ := memStorage.GetAllGauge()
-	counterMetrics, _ := memStorage.GetAllCounter()
+	header := snapshotHeader{
+		Version:   snapshotVersion,
+		CreatedAt: time.Now().UTC(),
+		Count:     len(metrics),
+		Checksum:  fmt.Sprintf("%08x", crc32.ChecksumIEEE(body.Bytes())),
+	}
+	if compress {
+		header.Compression = snapshotCompressionGzip
+	}
 
-	for name, metric := range gaugeMetrics {
-		err = writer.WriteMetric(model.Metrics{ID: name, MType: string(model.Gauge), Value: &metric})
-		if err != nil {
-			return fmt.Errorf("could not write gauge metric: %w", err)
+	tempPath := fileStoragePath + ".tmp"
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not create snapshot: %w", err)
+	}
+
+	err = writeSnapshotFile(file, header, body.Bytes())
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("could not close snapshot: %w", closeErr)
+	}
+	if err != nil {
+		if removeErr := os.Remove(tempPath); removeErr != nil {
+			zap.L().Error("Failed to remove temporary snapshot", zap.Error(removeErr))
 		}
+		return err
 	}
 
-	for name, metric := range counterMetrics {
-		err = writer.WriteMetric(model.Metrics{ID: name, MType: string(model.Counter), Delta: &metric})
-		if err != nil {
-			return fmt.Errorf("could not write counter metric: %w", err)
+	if err := os.Rename(fileStoragePath, PreviousSnapshotPath(fileStoragePath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not rotate snapshot: %w", err)
+	}
+	if err := os.Rename(tempPath, fileStoragePath); err != nil {
+		return fmt.Errorf("could not replace snapshot: %w", err)
+	}
+	syncDir(filepath.Dir(fileStoragePath))
+
+	zap.L().Info("Snapshot written", zap.String("path", fileStoragePath), zap.Int("count", header.Count))
+	return nil
+}
+
+// writeSnapshotFile write header and optionally compressed body and sync file to disk
+func writeSnapshotFile(file *os.File, header snapshotHeader, body []byte) error {
+	writer := bufio.NewWriter(file)
+
+	encodedHeader, err := json.Marshal(&header)
+	if err != nil {
+		return fmt.Errorf("could not marshal snapshot header: %w", err)
+	}
+	if _, err := writer.Write(append(encodedHeader, '\n')); err != nil {
+		return fmt.Errorf("could not write snapshot header: %w", err)
+	}
+
+	if header.Compression == snapshotCompressionGzip {
+		gzipWriter := gzip.NewWriter(writer)
+		if _, err := gzipWriter.Write(body); err != nil {
+			return fmt.Errorf("could not write snapshot body: %w", err)
+		}
+		if err := gzipWriter.Close(); err != nil {
+			return fmt.Errorf("could not write snapshot body: %w", err)
 		}
+	} else if _, err := writer.Write(body); err != nil {
+		return fmt.Errorf("could not write snapshot body: %w", err)
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("could not write snapshot: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("could not sync snapshot: %w", err)
 	}
 
 	return nil
 }
 
-// RestoreMetricsFromFile method to restore all metrics from specified file
-func RestoreMetricsFromFile(memStorage *MemStorage, fileStoragePath string) error {
-	if fileStoragePath == "" {
-		return ErrFileStoragePathNotProvided
+// syncDir sync directory so renames survive crash, failure is only logged because not every platform supports it
+func syncDir(path string) {
+	dir, err := os.Open(path)
+	if err != nil {
+		zap.L().Warn("Failed to open snapshot directory", zap.Error(err))
+		return
+	}
+	defer dir.Close()
+
+	if err := dir.Sync(); err != nil {
+		zap.L().Warn("Failed to sync snapshot directory", zap.Error(err))
 	}
+}
 
-	reader, err := NewReader(fileStoragePath)
+// ReadSnapshot read and verify all metrics of snapshot. Files without header written by older versions are read as plain metric lines
+func ReadSnapshot(fileStoragePath string) ([]model.Metrics, error) {
+	file, err := os.Open(fileStoragePath)
 	if err != nil {
-		zap.L().Error("Failed to create new reader", zap.Error(err))
-		return fmt.Errorf("failed to create new reader: %w", err)
+		return nil, err
 	}
-	defer func() {
-		if err := reader.Close(); err != nil {
-			zap.L().Error("failed to close reader", zap.Error(err))
-		}
-	}()
+	defer file.Close()
 
-	for {
-		metric, err := reader.ReadMetric()
+	reader := bufio.NewReader(file)
+
+	firstLine, err := reader.ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not read snapshot: %w", err)
+	}
+	if len(bytes.TrimSpace(firstLine)) == 0 {
+		return []model.Metrics{}, nil
+	}
+
+	var header snapshotHeader
+	if err := json.Unmarshal(firstLine, &header); err != nil {
+		return nil, fmt.Errorf("%w: invalid header: %w", ErrCorruptedSnapshot, err)
+	}
+
+	if header.Version == 0 {
+		zap.L().Warn("Reading snapshot without header", zap.String("path", fileStoragePath))
+		return parseSnapshotBody(io.MultiReader(bytes.NewReader(firstLine), reader))
+	}
+
+	if header.Version > snapshotVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrCorruptedSnapshot, header.Version)
+	}
+
+	var bodyReader io.Reader = reader
+	switch header.Compression {
+	case "":
+	case snapshotCompressionGzip:
+		gzipReader, err := gzip.NewReader(reader)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
+			return nil, fmt.Errorf("%w: invalid gzip body: %w", ErrCorruptedSnapshot, err)
+		}
+		defer gzipReader.Close()
+		bodyReader = gzipReader
+	default:
+		return nil, fmt.Errorf("%w: unsupported compression %q", ErrCorruptedSnapshot, header.Compression)
+	}
+
+	body, err := io.ReadAll(bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not read body: %w", ErrCorruptedSnapshot, err)
+	}
+
+	if checksum := fmt.Sprintf("%08x", crc32.ChecksumIEEE(body)); checksum != header.Checksum {
+		return nil, fmt.Errorf("%w: checksum %s does not match header checksum %s", ErrCorruptedSnapshot, checksum, header.Checksum)
+	}
+
+	metrics, err := parseSnapshotBody(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metrics) != header.Count {
+		return nil, fmt.Errorf("%w: read %d metrics, header count is %d", ErrCorruptedSnapshot, len(metrics), header.Count)
+	}
+
+	return metrics, nil
+}
+
+// parseSnapshotBody parse one metric per line, any invalid line makes the whole snapshot invalid
+func parseSnapshotBody(body io.Reader) ([]model.Metrics, error) {
+	metrics := make([]model.Metrics, 0)
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxSnapshotLineSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
 			continue
 		}
-		if metric == nil {
-			break
+
+		var metric model.Metrics
+		if err := json.Unmarshal(scanner.Bytes(), &metric); err != nil {
+			return nil, fmt.Errorf("%w: invalid metric on line %d: %w", ErrCorruptedSnapshot, line, err)
 		}
-		if metric.MType == string(model.Gauge) {
-			err = memStorage.UpdateGauge(metric.ID, *metric.Value)
-			if err != nil {
-				return fmt.Errorf("could not update gauge: %w", err)
-			}
-			zap.L().Info("Gauge read from file", zap.String("name", metric.ID), zap.Float64("value", *metric.Value))
-		} else if metric.MType == string(model.Counter) {
-			err = memStorage.UpdateCounter(metric.ID, *metric.Delta)
-			if err != nil {
-				return fmt.Errorf("could not update counter: %w", err)
+		metrics = append(metrics, metric)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptedSnapshot, err)
+	}
+
+	return metrics, nil
+}
+
+// RestoreMetricsFromFile method to restore all metrics from specified file.
+// Corrupted snapshot is reported and the previous rotated snapshot is used instead, missing snapshot means empty storage
+func RestoreMetricsFromFile(ctx context.Context, memStorage *MemStorage, fileStoragePath string) error {
+	if fileStoragePath == "" {
+		return ErrFileStoragePathNotProvided
+	}
+
+	metrics, err := ReadSnapshot(fileStoragePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		zap.L().Error("Snapshot is corrupted, falling back to previous snapshot", zap.String("path", fileStoragePath), zap.Error(err))
+	}
+
+	if err != nil {
+		previousPath := PreviousSnapshotPath(fileStoragePath)
+
+		var previousErr error
+		metrics, previousErr = ReadSnapshot(previousPath)
+		if previousErr != nil {
+			if errors.Is(err, os.ErrNotExist) && errors.Is(previousErr, os.ErrNotExist) {
+				zap.L().Info("No snapshot to restore", zap.String("path", fileStoragePath))
+				return nil
 			}
-			zap.L().Info("Counter read from file", zap.String("name", metric.ID), zap.Int64("delta", *metric.Delta))
+			return fmt.Errorf("could not restore snapshot: %w", errors.Join(err, previousErr))
+		}
+
+		zap.L().Warn("Restoring previous snapshot", zap.String("path", previousPath))
+	}
+
+	for _, metric := range metrics {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Snapshot keeps absolute values, so metrics are set and not added to existing ones
+		err = memStorage.restoreMetric(metric)
+		if err != nil {
+			return fmt.Errorf("could not restore %s metric: %w", metric.MType, err)
 		}
+		zap.L().Info("Metric read from file", zap.String("type", metric.MType), zap.String("name", model.SeriesKey(metric.ID, metric.Labels)))
 	}
 
 	return nil
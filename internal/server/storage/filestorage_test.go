@@ -1,10 +1,13 @@
 package storage
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
 )
 
@@ -79,19 +82,156 @@ func TestWriteMetricsToFile_RestoreMetricsFromFile(t *testing.T) {
 	expectedCounter := int64(10)
 
 	writerMemStorage := NewMemStorage()
-	writerMemStorage.UpdateGauge("gauge_metric", expectedGauge)
-	writerMemStorage.UpdateCounter("counter_metric", expectedCounter)
+	writerMemStorage.UpdateGauge(context.Background(), "gauge_metric", nil, expectedGauge)
+	writerMemStorage.UpdateCounter(context.Background(), "counter_metric", nil, expectedCounter)
+	writerMemStorage.UpdateGauge(context.Background(), "labeled_metric", model.Labels{"cpu": "0"}, expectedGauge)
 
-	err = WriteMetricsToFile(writerMemStorage, tempFile.Name())
+	err = WriteMetricsToFile(context.Background(), writerMemStorage, tempFile.Name())
 	assert.NoError(t, err)
 
 	readerMemStorage := NewMemStorage()
-	err = RestoreMetricsFromFile(readerMemStorage, tempFile.Name())
+	err = RestoreMetricsFromFile(context.Background(), readerMemStorage, tempFile.Name())
 	assert.NoError(t, err)
 
-	gauge, _ := readerMemStorage.GetGauge("gauge_metric")
+	gauge, _ := readerMemStorage.GetGauge(context.Background(), "gauge_metric", nil)
 	assert.Equal(t, expectedGauge, gauge)
 
-	counter, _ := readerMemStorage.GetCounter("counter_metric")
+	counter, _ := readerMemStorage.GetCounter(context.Background(), "counter_metric", nil)
 	assert.Equal(t, expectedCounter, counter)
+
+	labeled, _ := readerMemStorage.GetGauge(context.Background(), "labeled_metric", model.Labels{"cpu": "0"})
+	assert.Equal(t, expectedGauge, labeled)
+}
+
+func TestWriteMetricsToFile_Deleted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	ctx := context.Background()
+
+	memStorage := NewMemStorage()
+	assert.NoError(t, memStorage.UpdateGauge(ctx, "Alloc", nil, 1))
+	assert.NoError(t, memStorage.UpdateGauge(ctx, "Typo.Alloc", nil, 2))
+	assert.NoError(t, WriteMetricsToFile(ctx, memStorage, path))
+
+	deleted, err := memStorage.DeleteByPrefix(ctx, "Typo.")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	// Next snapshot does not contain deleted series
+	assert.NoError(t, WriteMetricsToFile(ctx, memStorage, path))
+
+	metrics, err := ReadSnapshot(path)
+	assert.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "Alloc", metrics[0].ID)
+}
+
+func TestWriteMetricsToFile_Gzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	writerMemStorage := NewMemStorage()
+	writerMemStorage.SetSnapshotGzip(true)
+	err := writerMemStorage.UpdateCounter(context.Background(), "counter_metric", nil, 10)
+	assert.NoError(t, err)
+
+	err = WriteMetricsToFile(context.Background(), writerMemStorage, path)
+	assert.NoError(t, err)
+
+	// Temporary file is renamed
+	_, err = os.Stat(path + ".tmp")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	readerMemStorage := NewMemStorage()
+	err = RestoreMetricsFromFile(context.Background(), readerMemStorage, path)
+	assert.NoError(t, err)
+
+	counter, err := readerMemStorage.GetCounter(context.Background(), "counter_metric", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), counter)
+}
+
+func TestRestoreMetricsFromFile_FallbackToPreviousSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	writerMemStorage := NewMemStorage()
+	err := writerMemStorage.UpdateCounter(context.Background(), "counter_metric", nil, 10)
+	assert.NoError(t, err)
+	err = WriteMetricsToFile(context.Background(), writerMemStorage, path)
+	assert.NoError(t, err)
+
+	err = writerMemStorage.UpdateCounter(context.Background(), "counter_metric", nil, 5)
+	assert.NoError(t, err)
+	err = WriteMetricsToFile(context.Background(), writerMemStorage, path)
+	assert.NoError(t, err)
+
+	// Damage the body of the current snapshot
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	data[len(data)-3] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+
+	readerMemStorage := NewMemStorage()
+	err = RestoreMetricsFromFile(context.Background(), readerMemStorage, path)
+	assert.NoError(t, err)
+
+	counter, err := readerMemStorage.GetCounter(context.Background(), "counter_metric", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), counter)
+}
+
+func TestRestoreMetricsFromFile_Corrupted(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "Invalid header",
+			content: "{\"version\":\n",
+		},
+		{
+			name:    "Checksum mismatch",
+			content: "{\"version\":1,\"checksum\":\"00000000\",\"count\":1}\n{\"id\":\"a\",\"type\":\"gauge\",\"value\":1}\n",
+		},
+		{
+			name:    "Unsupported version",
+			content: "{\"version\":99,\"checksum\":\"00000000\",\"count\":0}\n",
+		},
+		{
+			name:    "Invalid line without header",
+			content: "{\"id\":\"a\",\"type\":\"gauge\",\"value\":1}\nnot json\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics.json")
+			assert.NoError(t, os.WriteFile(path, []byte(test.content), 0o600))
+
+			err := RestoreMetricsFromFile(context.Background(), NewMemStorage(), path)
+			assert.ErrorIs(t, err, ErrCorruptedSnapshot)
+		})
+	}
+}
+
+func TestRestoreMetricsFromFile_WithoutHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	content := "{\"id\":\"a\",\"type\":\"gauge\",\"value\":1.5}\n{\"id\":\"b\",\"type\":\"counter\",\"delta\":3}\n"
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	memStorage := NewMemStorage()
+	err := RestoreMetricsFromFile(context.Background(), memStorage, path)
+	assert.NoError(t, err)
+
+	gauge, err := memStorage.GetGauge(context.Background(), "a", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, gauge)
+
+	counter, err := memStorage.GetCounter(context.Background(), "b", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), counter)
+}
+
+func TestRestoreMetricsFromFile_Missing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	err := RestoreMetricsFromFile(context.Background(), NewMemStorage(), path)
+	assert.NoError(t, err)
 }
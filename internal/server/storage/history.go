@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+// defaultHistorySize number of samples kept in memory for each series, one day of samples reported every 10 seconds
+const defaultHistorySize = 8640
+
+// seriesHistory raw samples of one series and its rollups keyed by tier resolution.
+// rolledUntil is end of the last bucket of the finest tier rebuilt from raw samples
+type seriesHistory struct {
+	raw         *sampleRing
+	rollups     map[time.Duration][]model.Rollup
+	rolledUntil time.Time
+}
+
+func newSeriesHistory(capacity int) *seriesHistory {
+	return &seriesHistory{raw: newSampleRing(capacity), rollups: make(map[time.Duration][]model.Rollup)}
+}
+
+// push add sample to raw tier. When ring is full and its oldest sample is not rolled up yet,
+// history is compacted first, so overwritten sample is kept in rollups
+func (history *seriesHistory) push(sample model.Sample, policy *RetentionPolicy) {
+	if policy != nil && len(policy.Rollups) > 0 && history.raw.full() {
+		finest := policy.Rollups[0].Resolution
+		if !history.raw.oldest().Timestamp.Before(history.rolledUntil) && history.rolledUntil.Before(bucketStart(sample.Timestamp, finest)) {
+			history.compact(*policy, sample.Timestamp)
+		}
+	}
+
+	history.raw.push(sample)
+}
+
+// compact rebuild complete buckets of every tier from raw samples, then delete expired rollups and raw samples
+func (history *seriesHistory) compact(policy RetentionPolicy, now time.Time) {
+	resolutions := make(map[time.Duration]bool, len(policy.Rollups))
+
+	for i, tier := range policy.Rollups {
+		resolutions[tier.Resolution] = true
+
+		from, until := rollupRange(policy, tier.Resolution, history.raw.truncatedAt, now)
+		if i == 0 {
+			history.rolledUntil = until
+		}
+		rebuilt := buildRollups(history.raw.between(from, until), tier.Resolution, from, until)
+		history.rollups[tier.Resolution] = mergeRollups(history.rollups[tier.Resolution], rebuilt, now.Add(-tier.Retention))
+	}
+
+	// Tiers removed from policy are not kept anymore
+	for resolution := range history.rollups {
+		if !resolutions[resolution] {
+			delete(history.rollups, resolution)
+		}
+	}
+
+	history.raw.dropBefore(now.Add(-policy.Raw))
+}
+
+// rollupsBetween returns copy of rollups of given resolution with timestamp in [from, to]
+func (history *seriesHistory) rollupsBetween(resolution time.Duration, from time.Time, to time.Time) []model.Rollup {
+	result := make([]model.Rollup, 0)
+	for _, rollup := range history.rollups[resolution] {
+		if rollup.Timestamp.Before(from) || rollup.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, rollup)
+	}
+
+	return result
+}
+
+// sampleRing ring buffer of at most capacity samples ordered by time, the oldest sample is overwritten when full.
+// Buffer grows up to capacity as samples are added. truncatedAt is timestamp of the newest sample overwritten or dropped by retention
+type sampleRing struct {
+	truncatedAt time.Time
+	samples     []model.Sample
+	capacity    int
+	start       int
+	size        int
+}
+
+func newSampleRing(capacity int) *sampleRing {
+	return &sampleRing{capacity: capacity}
+}
+
+// full returns true when next pushed sample overwrites the oldest one
+func (ring *sampleRing) full() bool {
+	return ring.size > 0 && ring.size == ring.capacity
+}
+
+// oldest returns the oldest sample of not empty ring
+func (ring *sampleRing) oldest() model.Sample {
+	return ring.samples[ring.start]
+}
+
+// push add sample to the end of ring
+func (ring *sampleRing) push(sample model.Sample) {
+	if ring.capacity <= 0 {
+		return
+	}
+
+	if ring.size == len(ring.samples) && ring.size < ring.capacity {
+		ring.grow()
+	}
+
+	end := (ring.start + ring.size) % len(ring.samples)
+
+	if ring.size < len(ring.samples) {
+		ring.size++
+	} else {
+		ring.truncatedAt = ring.samples[ring.start].Timestamp
+		ring.start = (ring.start + 1) % len(ring.samples)
+	}
+	ring.samples[end] = sample
+}
+
+// grow reallocate buffer twice as large, but not larger than capacity, and move samples to its beginning
+func (ring *sampleRing) grow() {
+	samples := make([]model.Sample, min(max(2*len(ring.samples), 16), ring.capacity))
+	for i := 0; i < ring.size; i++ {
+		samples[i] = ring.samples[(ring.start+i)%len(ring.samples)]
+	}
+
+	ring.samples = samples
+	ring.start = 0
+}
+
+// dropBefore remove samples with timestamp before {t}
+func (ring *sampleRing) dropBefore(t time.Time) {
+	for ring.size > 0 && ring.samples[ring.start].Timestamp.Before(t) {
+		ring.truncatedAt = ring.samples[ring.start].Timestamp
+		ring.samples[ring.start] = model.Sample{}
+		ring.start = (ring.start + 1) % len(ring.samples)
+		ring.size--
+	}
+}
+
+// between returns copy of samples with timestamp in [from, to]
+func (ring *sampleRing) between(from time.Time, to time.Time) []model.Sample {
+	result := make([]model.Sample, 0)
+	for i := 0; i < ring.size; i++ {
+		sample := ring.samples[(ring.start+i)%len(ring.samples)]
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, sample)
+	}
+
+	return result
+}
+
+// Downsample aggregate samples into step wide windows starting from {from}.
+// Gauge windows are averaged, counter windows keep the last accumulated value. Empty windows are skipped
+func Downsample(samples []model.Sample, mType model.MetricType, from time.Time, step time.Duration) []model.Sample {
+	if step <= 0 || len(samples) == 0 {
+		return samples
+	}
+
+	result := make([]model.Sample, 0)
+
+	var windowStart time.Time
+	var sum float64
+	var count int
+	var last float64
+
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		value := last
+		if mType == model.Gauge {
+			value = sum / float64(count)
+		}
+		result = append(result, model.Sample{Timestamp: windowStart, Value: value})
+	}
+
+	for _, sample := range samples {
+		start := from.Add(sample.Timestamp.Sub(from) / step * step)
+		if count > 0 && !start.Equal(windowStart) {
+			flush()
+			sum, count = 0, 0
+		}
+		windowStart = start
+		sum += sample.Value
+		last = sample.Value
+		count++
+	}
+	flush()
+
+	return result
+}
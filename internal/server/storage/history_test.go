@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+func TestDownsample(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := []model.Sample{
+		{Timestamp: from.Add(10 * time.Second), Value: 1},
+		{Timestamp: from.Add(20 * time.Second), Value: 3},
+		{Timestamp: from.Add(70 * time.Second), Value: 10},
+		{Timestamp: from.Add(190 * time.Second), Value: 20},
+	}
+
+	gauge := Downsample(samples, model.Gauge, from, time.Minute)
+	assert.Equal(t, []model.Sample{
+		{Timestamp: from, Value: 2},
+		{Timestamp: from.Add(time.Minute), Value: 10},
+		{Timestamp: from.Add(3 * time.Minute), Value: 20},
+	}, gauge)
+
+	counter := Downsample(samples, model.Counter, from, time.Minute)
+	assert.Equal(t, []model.Sample{
+		{Timestamp: from, Value: 3},
+		{Timestamp: from.Add(time.Minute), Value: 10},
+		{Timestamp: from.Add(3 * time.Minute), Value: 20},
+	}, counter)
+
+	assert.Equal(t, samples, Downsample(samples, model.Gauge, from, 0))
+}
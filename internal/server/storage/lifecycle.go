@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// Lifecycle storage component with background work or resources driven by main.
+// Context of Start bounds only startup, background work runs until Stop.
+// Stop releases resources, context of Stop bounds waiting for background work
+type Lifecycle interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// worker background loop which can be stopped and waited for
+type worker struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startWorker run {loop} in separate goroutine until worker is stopped
+func startWorker(ctx context.Context, loop func(ctx context.Context)) *worker {
+	loopCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	w := &worker{cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(w.done)
+		loop(loopCtx)
+	}()
+
+	return w
+}
+
+// stop cancel loop and wait until it returns
+func (w *worker) stop(ctx context.Context) error {
+	w.cancel()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background work did not stop: %w", ctx.Err())
+	}
+}
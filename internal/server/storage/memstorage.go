@@ -1,169 +1,788 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
 	"go.uber.org/zap"
 )
 
-// MemStorage structure to store all metrics in ram with locks and file writer for disk persistence
+// defaultShardCount number of shards of mem storage, updates of series in different shards do not wait for each other
+const defaultShardCount = 64
+
+// series identity of one stored time series
+type series struct {
+	labels model.Labels
+	name   string
+}
+
+// memShard part of metrics with own lock. Metrics are keyed by model.SeriesKey,
+// gaugeSeries, counterSeries and histogramSeries keep name and labels of every key.
+// gaugeHistory and counterHistory keep last historySize samples and rollups of every series
+type memShard struct {
+	gauge           map[string]float64
+	counter         map[string]int64
+	histogram       map[string]model.HistogramData
+	gaugeSeries     map[string]series
+	counterSeries   map[string]series
+	histogramSeries map[string]series
+	gaugeHistory    map[string]*seriesHistory
+	counterHistory  map[string]*seriesHistory
+	lock            sync.RWMutex
+}
+
+func newMemShard() *memShard {
+	return &memShard{
+		gauge:           make(map[string]float64),
+		counter:         make(map[string]int64),
+		histogram:       make(map[string]model.HistogramData),
+		gaugeSeries:     make(map[string]series),
+		counterSeries:   make(map[string]series),
+		histogramSeries: make(map[string]series),
+		gaugeHistory:    make(map[string]*seriesHistory),
+		counterHistory:  make(map[string]*seriesHistory),
+	}
+}
+
+// historyOf returns history map of gauge or counter metrics
+func (shard *memShard) historyOf(mType model.MetricType) (map[string]*seriesHistory, error) {
+	switch mType {
+	case model.Gauge:
+		return shard.gaugeHistory, nil
+	case model.Counter:
+		return shard.counterHistory, nil
+	default:
+		return nil, fmt.Errorf("history is not supported for metric type: %s", mType)
+	}
+}
+
+// has reports whether series of given type is stored
+func (shard *memShard) has(mType model.MetricType, key string) bool {
+	var ok bool
+	switch mType {
+	case model.Gauge:
+		_, ok = shard.gauge[key]
+	case model.Counter:
+		_, ok = shard.counter[key]
+	case model.Histogram:
+		_, ok = shard.histogram[key]
+	}
+	return ok
+}
+
+// remove delete series of given type with its history
+func (shard *memShard) remove(mType model.MetricType, key string) {
+	switch mType {
+	case model.Gauge:
+		delete(shard.gauge, key)
+		delete(shard.gaugeSeries, key)
+		delete(shard.gaugeHistory, key)
+	case model.Counter:
+		delete(shard.counter, key)
+		delete(shard.counterSeries, key)
+		delete(shard.counterHistory, key)
+	case model.Histogram:
+		delete(shard.histogram, key)
+		delete(shard.histogramSeries, key)
+	}
+}
+
+// MemStorage structure to store all metrics in ram and write-ahead log for disk persistence.
+// Series are spread over shards by hash of series key, every shard has its own lock.
+// Methods returning all metrics lock all shards in order, so they see consistent state
 type MemStorage struct {
-	gauge       map[string]float64
-	counter     map[string]int64
-	fileWriter  *Writer
-	gaugeLock   sync.RWMutex
-	counterLock sync.RWMutex
-	syncMode    bool
+	wal            *WAL
+	shards         []*memShard
+	retention      *RetentionPolicy
+	checkpointLock sync.Mutex
+	historySize    int
+	snapshotGzip   bool
 }
 
 // NewMemStorage constructor to create mem storage
 func NewMemStorage() *MemStorage {
-	return &MemStorage{
-		make(map[string]float64),
-		make(map[string]int64),
-		nil,
-		sync.RWMutex{},
-		sync.RWMutex{},
-		false,
+	return newMemStorageWithShards(defaultShardCount)
+}
+
+// newMemStorageWithShards constructor to create mem storage with given number of shards
+func newMemStorageWithShards(shardCount int) *MemStorage {
+	shards := make([]*memShard, shardCount)
+	for i := range shards {
+		shards[i] = newMemShard()
 	}
+
+	return &MemStorage{shards: shards, historySize: defaultHistorySize}
+}
+
+// SetHistorySize method to set number of samples kept for each series, applies to new series. 0 disables history
+func (storage *MemStorage) SetHistorySize(historySize int) {
+	storage.historySize = historySize
 }
 
-// SetSyncMode method to enable/disable synchronous method
-func (storage *MemStorage) SetSyncMode(syncMode bool) {
-	storage.syncMode = syncMode
+// SetRetentionPolicy method to set policy used to roll samples up before they are overwritten in full history
+func (storage *MemStorage) SetRetentionPolicy(policy RetentionPolicy) {
+	storage.retention = &policy
 }
 
-// SetFileWriter method to set file writer
-func (storage *MemStorage) SetFileWriter(fileWriter *Writer) {
-	storage.fileWriter = fileWriter
+// SetSnapshotGzip method to enable/disable gzip compression of snapshots
+func (storage *MemStorage) SetSnapshotGzip(snapshotGzip bool) {
+	storage.snapshotGzip = snapshotGzip
+}
+
+// SetWAL method to enable synchronous mode, every update is appended to write-ahead log before it is applied
+func (storage *MemStorage) SetWAL(wal *WAL) {
+	storage.wal = wal
+}
+
+// shardIndex returns index of shard of series key
+func (storage *MemStorage) shardIndex(key string) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(len(storage.shards)))
+}
+
+// shardOf returns shard of series key
+func (storage *MemStorage) shardOf(key string) *memShard {
+	return storage.shards[storage.shardIndex(key)]
+}
+
+// rLockAll read lock all shards in order
+func (storage *MemStorage) rLockAll() {
+	for _, shard := range storage.shards {
+		shard.lock.RLock()
+	}
+}
+
+// rUnlockAll release read locks of all shards
+func (storage *MemStorage) rUnlockAll() {
+	for _, shard := range storage.shards {
+		shard.lock.RUnlock()
+	}
+}
+
+// lockAll lock all shards in order
+func (storage *MemStorage) lockAll() {
+	for _, shard := range storage.shards {
+		shard.lock.Lock()
+	}
+}
+
+// unlockAll release locks of all shards
+func (storage *MemStorage) unlockAll() {
+	for _, shard := range storage.shards {
+		shard.lock.Unlock()
+	}
 }
 
 // UpdateGauge method to update gauge metric
-func (storage *MemStorage) UpdateGauge(name string, metric float64) error {
-	storage.gaugeLock.Lock()
-	defer storage.gaugeLock.Unlock()
+func (storage *MemStorage) UpdateGauge(_ context.Context, name string, labels model.Labels, metric float64) error {
+	key := model.SeriesKey(name, labels)
+	shard := storage.shardOf(key)
 
-	storage.gauge[name] = metric
-	if storage.syncMode {
-		err := storage.fileWriter.WriteMetric(model.Metrics{ID: name, MType: string(model.Gauge), Value: &metric})
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	if storage.wal != nil {
+		err := storage.wal.Append(model.Metrics{ID: name, MType: string(model.Gauge), Labels: labels, Value: &metric})
 		if err != nil {
-			zap.L().Error("Failed to write gauge to file", zap.String("name", name), zap.Float64("metric", metric), zap.Error(err))
-			return fmt.Errorf("failed to write gauge to file: %w", err)
+			zap.L().Error("Failed to write gauge to write-ahead log", zap.String("name", key), zap.Float64("metric", metric), zap.Error(err))
+			return fmt.Errorf("failed to write gauge to write-ahead log: %w", err)
 		}
 	}
-	zap.L().Info("Updated gauge", zap.String("name", name), zap.Float64("metric", metric))
+
+	if _, ok := shard.gaugeSeries[key]; !ok {
+		shard.gaugeSeries[key] = series{name: name, labels: labels.Clone()}
+	}
+	shard.gauge[key] = metric
+	storage.recordSample(shard.gaugeHistory, key, metric)
+
+	zap.L().Info("Updated gauge", zap.String("name", key), zap.Float64("metric", metric))
 	return nil
 }
 
 // UpdateCounter method to update counter metric
-func (storage *MemStorage) UpdateCounter(name string, metric int64) error {
-	storage.counterLock.Lock()
-	defer storage.counterLock.Unlock()
-
-	storage.counter[name] += metric
-	metric = storage.counter[name]
-	if storage.syncMode {
-		err := storage.fileWriter.WriteMetric(model.Metrics{ID: name, MType: string(model.Counter), Delta: &metric})
-		if err != nil {
-			zap.L().Error("Failed to write counter to file", zap.String("name", name), zap.Int64("metric", metric), zap.Error(err))
-			return fmt.Errorf("failed to write counter to file: %w", err)
-		}
-	}
-	zap.L().Info("Updated counter", zap.String("name", name), zap.Int64("metric", metric))
-	return nil
+func (storage *MemStorage) UpdateCounter(ctx context.Context, name string, labels model.Labels, metric int64) error {
+	_, err := storage.UpdateCounterAndReturn(ctx, name, labels, metric)
+	return err
 }
 
 // UpdateCounterAndReturn method to update counter and return updated value
-func (storage *MemStorage) UpdateCounterAndReturn(name string, metric int64) (int64, error) {
-	storage.counterLock.Lock()
-	defer storage.counterLock.Unlock()
-
-	storage.counter[name] += metric
-	metric = storage.counter[name]
-	if storage.syncMode {
-		err := storage.fileWriter.WriteMetric(model.Metrics{ID: name, MType: string(model.Counter), Delta: &metric})
+func (storage *MemStorage) UpdateCounterAndReturn(_ context.Context, name string, labels model.Labels, metric int64) (int64, error) {
+	key := model.SeriesKey(name, labels)
+	shard := storage.shardOf(key)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	// Write-ahead log keeps accumulated value, so replay restores counter exactly
+	total := shard.counter[key] + metric
+	if storage.wal != nil {
+		err := storage.wal.Append(model.Metrics{ID: name, MType: string(model.Counter), Labels: labels, Delta: &total})
 		if err != nil {
-			zap.L().Error("Failed to write counter to file", zap.String("name", name), zap.Int64("metric", metric), zap.Error(err))
-			return metric, fmt.Errorf("failed to write counter to file: %w", err)
+			zap.L().Error("Failed to write counter to write-ahead log", zap.String("name", key), zap.Int64("metric", total), zap.Error(err))
+			return shard.counter[key], fmt.Errorf("failed to write counter to write-ahead log: %w", err)
 		}
 	}
-	zap.L().Info("Updated counter", zap.String("name", name), zap.Int64("metric", metric))
 
-	return storage.counter[name], nil
+	if _, ok := shard.counterSeries[key]; !ok {
+		shard.counterSeries[key] = series{name: name, labels: labels.Clone()}
+	}
+	shard.counter[key] = total
+	storage.recordSample(shard.counterHistory, key, float64(total))
+
+	zap.L().Info("Updated counter", zap.String("name", key), zap.Int64("metric", total))
+	return total, nil
 }
 
-// GetGauge method to get one gauge metric by name
-func (storage *MemStorage) GetGauge(name string) (float64, error) {
-	storage.gaugeLock.RLock()
-	defer storage.gaugeLock.RUnlock()
+// GetGauge method to get one gauge metric by name and labels
+func (storage *MemStorage) GetGauge(_ context.Context, name string, labels model.Labels) (float64, error) {
+	key := model.SeriesKey(name, labels)
+	shard := storage.shardOf(key)
 
-	value, ok := storage.gauge[name]
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+
+	value, ok := shard.gauge[key]
 	if !ok {
-		return 0, fmt.Errorf("gauge metric with name: %s not found %w", name, ErrItemNotFound)
+		return 0, fmt.Errorf("gauge metric with name: %s not found %w", key, ErrItemNotFound)
 	}
 
 	return value, nil
 }
 
-// GetCounter method to get one counter metric by name
-func (storage *MemStorage) GetCounter(name string) (int64, error) {
-	storage.counterLock.RLock()
-	defer storage.counterLock.RUnlock()
+// GetCounter method to get one counter metric by name and labels
+func (storage *MemStorage) GetCounter(_ context.Context, name string, labels model.Labels) (int64, error) {
+	key := model.SeriesKey(name, labels)
+	shard := storage.shardOf(key)
+
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
 
-	value, ok := storage.counter[name]
+	value, ok := shard.counter[key]
 	if !ok {
-		return 0, fmt.Errorf("counter metric with name: %s not found %w", name, ErrItemNotFound)
+		return 0, fmt.Errorf("counter metric with name: %s not found %w", key, ErrItemNotFound)
 	}
 
 	return value, nil
 }
 
 // GetAllGauge method to get all gauge metrics
-func (storage *MemStorage) GetAllGauge() (map[string]float64, error) {
-	storage.gaugeLock.RLock()
-	defer storage.gaugeLock.RUnlock()
+func (storage *MemStorage) GetAllGauge(_ context.Context) (map[string]float64, error) {
+	storage.rLockAll()
+	defer storage.rUnlockAll()
 
-	gaugeCopy := make(map[string]float64, len(storage.gauge))
-	for key, value := range storage.gauge {
-		gaugeCopy[key] = value
+	gaugeCopy := make(map[string]float64)
+	for _, shard := range storage.shards {
+		for key, value := range shard.gauge {
+			gaugeCopy[key] = value
+		}
 	}
 
 	return gaugeCopy, nil
 }
 
 // GetAllCounter method to get all counter metrics
-func (storage *MemStorage) GetAllCounter() (map[string]int64, error) {
-	storage.counterLock.RLock()
-	defer storage.counterLock.RUnlock()
+func (storage *MemStorage) GetAllCounter(_ context.Context) (map[string]int64, error) {
+	storage.rLockAll()
+	defer storage.rUnlockAll()
 
-	counterCopy := make(map[string]int64, len(storage.counter))
-	for key, value := range storage.counter {
-		counterCopy[key] = value
+	counterCopy := make(map[string]int64)
+	for _, shard := range storage.shards {
+		for key, value := range shard.counter {
+			counterCopy[key] = value
+		}
 	}
 
 	return counterCopy, nil
 }
 
-// UpdateMetrics method to update batch of metrics
-func (storage *MemStorage) UpdateMetrics(metrics []model.Metrics) error {
+// UpdateHistogram method to merge observations into histogram metric
+func (storage *MemStorage) UpdateHistogram(_ context.Context, name string, labels model.Labels, metric model.HistogramData) error {
+	key := model.SeriesKey(name, labels)
+	shard := storage.shardOf(key)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	histogram, ok := shard.histogram[key]
+	if ok {
+		histogram = histogram.Clone()
+		if err := histogram.Merge(&metric); err != nil {
+			return fmt.Errorf("failed to merge histogram %s: %w", key, err)
+		}
+	} else {
+		histogram = metric.Clone()
+	}
+
+	if storage.wal != nil {
+		err := storage.wal.Append(model.Metrics{ID: name, MType: string(model.Histogram), Labels: labels, Histogram: &histogram})
+		if err != nil {
+			zap.L().Error("Failed to write histogram to write-ahead log", zap.String("name", key), zap.Error(err))
+			return fmt.Errorf("failed to write histogram to write-ahead log: %w", err)
+		}
+	}
+
+	if !ok {
+		shard.histogramSeries[key] = series{name: name, labels: labels.Clone()}
+	}
+	shard.histogram[key] = histogram
+
+	zap.L().Info("Updated histogram", zap.String("name", key), zap.Int64("count", histogram.Count))
+	return nil
+}
+
+// GetHistogram method to get one histogram metric by name and labels
+func (storage *MemStorage) GetHistogram(_ context.Context, name string, labels model.Labels) (model.HistogramData, error) {
+	key := model.SeriesKey(name, labels)
+	shard := storage.shardOf(key)
+
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+
+	value, ok := shard.histogram[key]
+	if !ok {
+		return model.HistogramData{}, fmt.Errorf("histogram metric with name: %s not found %w", key, ErrItemNotFound)
+	}
+
+	return value.Clone(), nil
+}
+
+// GetAllHistogram method to get all histogram metrics
+func (storage *MemStorage) GetAllHistogram(_ context.Context) (map[string]model.HistogramData, error) {
+	storage.rLockAll()
+	defer storage.rUnlockAll()
+
+	histogramCopy := make(map[string]model.HistogramData)
+	for _, shard := range storage.shards {
+		for key, value := range shard.histogram {
+			histogramCopy[key] = value.Clone()
+		}
+	}
+
+	return histogramCopy, nil
+}
+
+// GetMetrics method to get values of requested series, missing series are skipped
+func (storage *MemStorage) GetMetrics(ctx context.Context, metrics []model.Metrics) ([]model.Metrics, error) {
+	return getMetrics(ctx, storage, metrics)
+}
+
+// QueryMetrics method to get page of series selected by type and name prefix
+func (storage *MemStorage) QueryMetrics(ctx context.Context, query model.MetricsQuery) ([]model.Metrics, error) {
+	return queryMetrics(ctx, storage, query)
+}
+
+// GetHistory method to get samples of gauge or counter series recorded between from and to
+func (storage *MemStorage) GetHistory(_ context.Context, mType model.MetricType, name string, labels model.Labels, from time.Time, to time.Time) ([]model.Sample, error) {
+	key := model.SeriesKey(name, labels)
+	shard := storage.shardOf(key)
+
+	history, err := shard.historyOf(mType)
+	if err != nil {
+		return nil, err
+	}
+
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+
+	seriesHistory, ok := history[key]
+	if !ok {
+		return []model.Sample{}, nil
+	}
+
+	return seriesHistory.raw.between(from, to), nil
+}
+
+// GetRollups method to get rollups of gauge or counter series with given resolution between from and to
+func (storage *MemStorage) GetRollups(_ context.Context, mType model.MetricType, name string, labels model.Labels, resolution time.Duration, from time.Time, to time.Time) ([]model.Rollup, error) {
+	key := model.SeriesKey(name, labels)
+	shard := storage.shardOf(key)
+
+	history, err := shard.historyOf(mType)
+	if err != nil {
+		return nil, err
+	}
+
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+
+	seriesHistory, ok := history[key]
+	if !ok {
+		return []model.Rollup{}, nil
+	}
+
+	return seriesHistory.rollupsBetween(resolution, from, to), nil
+}
+
+// Compact method to roll raw samples up into retention tiers and delete expired history.
+// Shards are compacted one by one, so updates of other shards are not blocked
+func (storage *MemStorage) Compact(ctx context.Context, policy RetentionPolicy, now time.Time) error {
+	for _, shard := range storage.shards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		shard.lock.Lock()
+		for _, history := range []map[string]*seriesHistory{shard.gaugeHistory, shard.counterHistory} {
+			for _, seriesHistory := range history {
+				seriesHistory.compact(policy, now)
+			}
+		}
+		shard.lock.Unlock()
+	}
+
+	zap.L().Info("Compacted metric history", zap.Time("now", now))
+	return nil
+}
+
+// Delete method to remove series with its history
+func (storage *MemStorage) Delete(_ context.Context, mType model.MetricType, name string, labels model.Labels) error {
+	if err := checkMetricType(mType); err != nil {
+		return err
+	}
+
+	key := model.SeriesKey(name, labels)
+	shard := storage.shardOf(key)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	if !shard.has(mType, key) {
+		return fmt.Errorf("%s metric with name: %s not found %w", mType, key, ErrItemNotFound)
+	}
+
+	if storage.wal != nil {
+		err := storage.wal.AppendDelete(model.Metrics{ID: name, MType: string(mType), Labels: labels})
+		if err != nil {
+			zap.L().Error("Failed to write deletion to write-ahead log", zap.String("name", key), zap.Error(err))
+			return fmt.Errorf("failed to write deletion to write-ahead log: %w", err)
+		}
+	}
+
+	shard.remove(mType, key)
+
+	zap.L().Info("Deleted metric", zap.String("type", string(mType)), zap.String("name", key))
+	return nil
+}
+
+// DeleteByPrefix method to remove all series with name starting with prefix, all shards are locked,
+// so series created during deletion are either removed or kept entirely
+func (storage *MemStorage) DeleteByPrefix(_ context.Context, prefix string) (int, error) {
+	storage.lockAll()
+	defer storage.unlockAll()
+
+	deleted := make([]model.Metrics, 0)
+	for _, shard := range storage.shards {
+		for mType, seriesMap := range map[model.MetricType]map[string]series{
+			model.Gauge:     shard.gaugeSeries,
+			model.Counter:   shard.counterSeries,
+			model.Histogram: shard.histogramSeries,
+		} {
+			for _, s := range seriesMap {
+				if strings.HasPrefix(s.name, prefix) {
+					deleted = append(deleted, model.Metrics{ID: s.name, MType: string(mType), Labels: s.labels})
+				}
+			}
+		}
+	}
+
+	if len(deleted) == 0 {
+		return 0, nil
+	}
+
+	if storage.wal != nil {
+		if err := storage.wal.AppendDelete(deleted...); err != nil {
+			zap.L().Error("Failed to write deletion to write-ahead log", zap.String("prefix", prefix), zap.Error(err))
+			return 0, fmt.Errorf("failed to write deletion to write-ahead log: %w", err)
+		}
+	}
+
+	for _, metric := range deleted {
+		key := model.SeriesKey(metric.ID, metric.Labels)
+		storage.shardOf(key).remove(model.MetricType(metric.MType), key)
+	}
+
+	zap.L().Info("Deleted metrics by prefix", zap.String("prefix", prefix), zap.Int("series", len(deleted)))
+	return len(deleted), nil
+}
+
+// recordSample append sample to series history, caller must hold the lock of shard
+func (storage *MemStorage) recordSample(history map[string]*seriesHistory, key string, value float64) {
+	if storage.historySize <= 0 {
+		return
+	}
+
+	seriesHistory, ok := history[key]
+	if !ok {
+		seriesHistory = newSeriesHistory(storage.historySize)
+		history[key] = seriesHistory
+	}
+
+	seriesHistory.push(model.Sample{Timestamp: time.Now(), Value: value}, storage.retention)
+}
+
+// stagedMetric new absolute value of series computed for batch before it is applied
+type stagedMetric struct {
+	shard  *memShard
+	key    string
+	metric model.Metrics
+}
+
+// UpdateMetrics method to update batch of metrics atomically.
+// Batch is validated, shards of all its series are locked and new values are computed first,
+// so invalid batch or histogram bounds mismatch leaves storage unchanged
+func (storage *MemStorage) UpdateMetrics(ctx context.Context, metrics []model.Metrics) error {
+	if err := ValidateMetrics(metrics); err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	// Shards are locked in order of their index, the same order as in Checkpoint, to avoid deadlocks
+	shardIndexes := make(map[int]bool)
 	for _, metric := range metrics {
+		shardIndexes[storage.shardIndex(model.SeriesKey(metric.ID, metric.Labels))] = true
+	}
+	locked := make([]int, 0, len(shardIndexes))
+	for index := range shardIndexes {
+		locked = append(locked, index)
+	}
+	sort.Ints(locked)
+
+	for _, index := range locked {
+		storage.shards[index].lock.Lock()
+	}
+	defer func() {
+		for _, index := range locked {
+			storage.shards[index].lock.Unlock()
+		}
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	staged, err := storage.stageMetrics(metrics)
+	if err != nil {
+		return err
+	}
+
+	if storage.wal != nil {
+		records := make([]model.Metrics, 0, len(staged))
+		for _, stagedMetric := range staged {
+			records = append(records, stagedMetric.metric)
+		}
+		if err := storage.wal.Append(records...); err != nil {
+			zap.L().Error("Failed to write batch to write-ahead log", zap.Int("size", len(records)), zap.Error(err))
+			return fmt.Errorf("failed to write batch to write-ahead log: %w", err)
+		}
+	}
+
+	for _, stagedMetric := range staged {
+		storage.applyStaged(stagedMetric)
+	}
+
+	zap.L().Info("Updated batch", zap.Int("size", len(metrics)), zap.Int("series", len(staged)))
+	return nil
+}
+
+// stageMetrics compute absolute value of every series of batch in order of first appearance without changing storage,
+// caller must hold locks of all shards of batch
+func (storage *MemStorage) stageMetrics(metrics []model.Metrics) ([]*stagedMetric, error) {
+	merged, err := mergeMetrics(metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	staged := make([]*stagedMetric, 0, len(merged))
+	for _, metric := range merged {
+		key := model.SeriesKey(metric.ID, metric.Labels)
+		shard := storage.shardOf(key)
+
 		switch metric.MType {
-		case string(model.Gauge):
-			err := storage.UpdateGauge(metric.ID, *metric.Value)
-			if err != nil {
-				return err
-			}
 		case string(model.Counter):
-			err := storage.UpdateCounter(metric.ID, *metric.Delta)
-			if err != nil {
-				return err
+			*metric.Delta += shard.counter[key]
+		case string(model.Histogram):
+			if stored, exists := shard.histogram[key]; exists {
+				histogram := stored.Clone()
+				if err := histogram.Merge(metric.Histogram); err != nil {
+					return nil, fmt.Errorf("failed to merge histogram %s: %w", key, err)
+				}
+				metric.Histogram = &histogram
 			}
-		default:
-			return fmt.Errorf("unknown metric type: %s", metric.MType)
 		}
+
+		staged = append(staged, &stagedMetric{shard: shard, key: key, metric: metric})
+	}
+
+	return staged, nil
+}
+
+// applyStaged store computed value of series, caller must hold lock of its shard
+func (storage *MemStorage) applyStaged(staged *stagedMetric) {
+	shard, key, metric := staged.shard, staged.key, staged.metric
+	s := series{name: metric.ID, labels: metric.Labels}
+
+	switch metric.MType {
+	case string(model.Gauge):
+		if _, ok := shard.gaugeSeries[key]; !ok {
+			shard.gaugeSeries[key] = s
+		}
+		shard.gauge[key] = *metric.Value
+		storage.recordSample(shard.gaugeHistory, key, *metric.Value)
+	case string(model.Counter):
+		if _, ok := shard.counterSeries[key]; !ok {
+			shard.counterSeries[key] = s
+		}
+		shard.counter[key] = *metric.Delta
+		storage.recordSample(shard.counterHistory, key, float64(*metric.Delta))
+	case string(model.Histogram):
+		if _, ok := shard.histogramSeries[key]; !ok {
+			shard.histogramSeries[key] = s
+		}
+		shard.histogram[key] = *metric.Histogram
+	}
+}
+
+// snapshot returns all stored metrics with their names and labels
+func (storage *MemStorage) snapshot() []model.Metrics {
+	storage.rLockAll()
+	defer storage.rUnlockAll()
+
+	return storage.snapshotLocked()
+}
+
+// snapshotLocked returns all stored metrics, caller must hold locks of all shards
+func (storage *MemStorage) snapshotLocked() []model.Metrics {
+	metrics := make([]model.Metrics, 0)
+
+	for _, shard := range storage.shards {
+		for key, value := range shard.gauge {
+			s := shard.gaugeSeries[key]
+			metrics = append(metrics, model.Metrics{ID: s.name, MType: string(model.Gauge), Labels: s.labels.Clone(), Value: &value})
+		}
+
+		for key, delta := range shard.counter {
+			s := shard.counterSeries[key]
+			metrics = append(metrics, model.Metrics{ID: s.name, MType: string(model.Counter), Labels: s.labels.Clone(), Delta: &delta})
+		}
+
+		for key, value := range shard.histogram {
+			histogram := value.Clone()
+			s := shard.histogramSeries[key]
+			metrics = append(metrics, model.Metrics{ID: s.name, MType: string(model.Histogram), Labels: s.labels.Clone(), Histogram: &histogram})
+		}
+	}
+
+	return metrics
+}
+
+// ExportMetrics method to get all stored series
+func (storage *MemStorage) ExportMetrics(ctx context.Context) ([]model.Metrics, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return storage.snapshot(), nil
+}
+
+// Checkpoint method to save all metrics into snapshot file and remove write-ahead log records saved in it.
+// Updates are blocked only while metrics are copied and log is rotated, rotated segment is removed after snapshot is written,
+// so failed checkpoint loses no record
+func (storage *MemStorage) Checkpoint(ctx context.Context, fileStoragePath string) error {
+	storage.checkpointLock.Lock()
+	defer storage.checkpointLock.Unlock()
+
+	metrics, seq, err := storage.snapshotAndRotate()
+	if err != nil {
+		return err
 	}
 
+	if err := WriteSnapshot(ctx, metrics, fileStoragePath, storage.snapshotGzip); err != nil {
+		return err
+	}
+
+	if storage.wal != nil {
+		if err := storage.wal.RemoveRotated(seq); err != nil {
+			return fmt.Errorf("could not remove write-ahead log segments: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// snapshotAndRotate returns all stored metrics and rotates write-ahead log, so records of the new segment are not in snapshot
+func (storage *MemStorage) snapshotAndRotate() ([]model.Metrics, uint64, error) {
+	storage.lockAll()
+	defer storage.unlockAll()
+
+	metrics := storage.snapshotLocked()
+	if storage.wal == nil {
+		return metrics, 0, nil
+	}
+
+	seq, err := storage.wal.Rotate()
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not rotate write-ahead log: %w", err)
+	}
+
+	return metrics, seq, nil
+}
+
+// restoreMetric set absolute value of metric read from snapshot or write-ahead log, restoring the same metric twice is idempotent
+func (storage *MemStorage) restoreMetric(metric model.Metrics) error {
+	key := model.SeriesKey(metric.ID, metric.Labels)
+	s := series{name: metric.ID, labels: metric.Labels.Clone()}
+
+	shard := storage.shardOf(key)
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	switch metric.MType {
+	case string(model.Gauge):
+		if metric.Value == nil {
+			return fmt.Errorf("gauge %s without value", key)
+		}
+		shard.gaugeSeries[key] = s
+		shard.gauge[key] = *metric.Value
+	case string(model.Counter):
+		if metric.Delta == nil {
+			return fmt.Errorf("counter %s without value", key)
+		}
+		shard.counterSeries[key] = s
+		shard.counter[key] = *metric.Delta
+	case string(model.Histogram):
+		if metric.Histogram == nil {
+			return fmt.Errorf("histogram %s without value", key)
+		}
+		shard.histogramSeries[key] = s
+		shard.histogram[key] = metric.Histogram.Clone()
+	default:
+		return fmt.Errorf("unknown metric type: %s", metric.MType)
+	}
+
+	return nil
+}
+
+// removeMetric delete series read from write-ahead log, removing missing series does nothing
+func (storage *MemStorage) removeMetric(metric model.Metrics) error {
+	mType := model.MetricType(metric.MType)
+	if err := checkMetricType(mType); err != nil {
+		return err
+	}
+
+	key := model.SeriesKey(metric.ID, metric.Labels)
+	shard := storage.shardOf(key)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	shard.remove(mType, key)
 	return nil
 }
@@ -1,8 +1,13 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
@@ -11,10 +16,10 @@ import (
 func TestMemStorage_UpdateGauge(t *testing.T) {
 	storage := NewMemStorage()
 
-	err := storage.UpdateGauge("TestGauge", 10.5)
+	err := storage.UpdateGauge(context.Background(), "TestGauge", nil, 10.5)
 	assert.NoError(t, err)
 
-	value, err := storage.GetGauge("TestGauge")
+	value, err := storage.GetGauge(context.Background(), "TestGauge", nil)
 	assert.NoError(t, err)
 	assert.Equal(t, 10.5, value)
 }
@@ -22,7 +27,7 @@ func TestMemStorage_UpdateGauge(t *testing.T) {
 func TestMemStorage_GetGauge_NotFound(t *testing.T) {
 	storage := NewMemStorage()
 
-	_, err := storage.GetGauge("NonExistingGauge")
+	_, err := storage.GetGauge(context.Background(), "NonExistingGauge", nil)
 	assert.Error(t, err)
 	assert.True(t, errors.Is(err, ErrItemNotFound))
 }
@@ -33,13 +38,13 @@ func TestMemStorage_GetAllGauge(t *testing.T) {
 	expectedGauge1 := 10.1
 	expectedGauge2 := 20.2
 
-	err := storage.UpdateGauge("gauge1", expectedGauge1)
+	err := storage.UpdateGauge(context.Background(), "gauge1", nil, expectedGauge1)
 	assert.NoError(t, err)
 
-	err = storage.UpdateGauge("gauge2", expectedGauge2)
+	err = storage.UpdateGauge(context.Background(), "gauge2", nil, expectedGauge2)
 	assert.NoError(t, err)
 
-	gauges, err := storage.GetAllGauge()
+	gauges, err := storage.GetAllGauge(context.Background())
 	assert.NoError(t, err)
 
 	assert.Equal(t, 2, len(gauges))
@@ -50,17 +55,17 @@ func TestMemStorage_GetAllGauge(t *testing.T) {
 func TestMemStorage_UpdateCounter(t *testing.T) {
 	storage := NewMemStorage()
 
-	err := storage.UpdateCounter("TestCounter", 5)
+	err := storage.UpdateCounter(context.Background(), "TestCounter", nil, 5)
 	assert.NoError(t, err)
 
-	value, err := storage.GetCounter("TestCounter")
+	value, err := storage.GetCounter(context.Background(), "TestCounter", nil)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(5), value)
 
-	err = storage.UpdateCounter("TestCounter", 3)
+	err = storage.UpdateCounter(context.Background(), "TestCounter", nil, 3)
 	assert.NoError(t, err)
 
-	value, err = storage.GetCounter("TestCounter")
+	value, err = storage.GetCounter(context.Background(), "TestCounter", nil)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(8), value)
 }
@@ -68,7 +73,7 @@ func TestMemStorage_UpdateCounter(t *testing.T) {
 func TestMemStorage_GetCounter_NotFound(t *testing.T) {
 	storage := NewMemStorage()
 
-	_, err := storage.GetCounter("NonExistingCounter")
+	_, err := storage.GetCounter(context.Background(), "NonExistingCounter", nil)
 	assert.Error(t, err)
 	assert.True(t, errors.Is(err, ErrItemNotFound))
 }
@@ -76,11 +81,11 @@ func TestMemStorage_GetCounter_NotFound(t *testing.T) {
 func TestMemStorage_UpdateCounterAndReturn(t *testing.T) {
 	storage := NewMemStorage()
 
-	value, err := storage.UpdateCounterAndReturn("TestCounter", 2)
+	value, err := storage.UpdateCounterAndReturn(context.Background(), "TestCounter", nil, 2)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(2), value)
 
-	value, err = storage.UpdateCounterAndReturn("TestCounter", 3)
+	value, err = storage.UpdateCounterAndReturn(context.Background(), "TestCounter", nil, 3)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(5), value)
 }
@@ -91,13 +96,13 @@ func TestMemStorage_GetAllCounter(t *testing.T) {
 	expectedCounter1 := int64(10)
 	expectedCounter2 := int64(20)
 
-	err := storage.UpdateCounter("counter1", expectedCounter1)
+	err := storage.UpdateCounter(context.Background(), "counter1", nil, expectedCounter1)
 	assert.NoError(t, err)
 
-	err = storage.UpdateCounter("counter2", expectedCounter2)
+	err = storage.UpdateCounter(context.Background(), "counter2", nil, expectedCounter2)
 	assert.NoError(t, err)
 
-	counters, err := storage.GetAllCounter()
+	counters, err := storage.GetAllCounter(context.Background())
 	assert.NoError(t, err)
 
 	assert.Equal(t, 2, len(counters))
@@ -116,14 +121,323 @@ func TestMemStorage_UpdateMetrics(t *testing.T) {
 		{ID: "TestCounter", MType: string(model.Counter), Delta: &expectedCounter},
 	}
 
-	err := storage.UpdateMetrics(metrics)
+	err := storage.UpdateMetrics(context.Background(), metrics)
 	assert.NoError(t, err)
 
-	gauge, err := storage.GetGauge("TestGauge")
+	gauge, err := storage.GetGauge(context.Background(), "TestGauge", nil)
 	assert.NoError(t, err)
 	assert.Equal(t, expectedGauge, gauge)
 
-	counter, err := storage.GetCounter("TestCounter")
+	counter, err := storage.GetCounter(context.Background(), "TestCounter", nil)
 	assert.NoError(t, err)
 	assert.Equal(t, expectedCounter, counter)
 }
+
+func TestMemStorage_UpdateMetrics_SameSeries(t *testing.T) {
+	storage := NewMemStorage()
+
+	first, second := int64(3), int64(4)
+	gauge1, gauge2 := 1.0, 2.0
+
+	err := storage.UpdateMetrics(context.Background(), []model.Metrics{
+		{ID: "PollCount", MType: string(model.Counter), Delta: &first},
+		{ID: "Alloc", MType: string(model.Gauge), Value: &gauge1},
+		{ID: "PollCount", MType: string(model.Counter), Delta: &second},
+		{ID: "Alloc", MType: string(model.Gauge), Value: &gauge2},
+	})
+	assert.NoError(t, err)
+
+	counter, err := storage.GetCounter(context.Background(), "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), counter)
+
+	gauge, err := storage.GetGauge(context.Background(), "Alloc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, gauge)
+}
+
+func TestMemStorage_UpdateMetrics_Atomic(t *testing.T) {
+	gauge := 12.5
+	delta := int64(5)
+
+	tests := []struct {
+		name        string
+		metrics     []model.Metrics
+		expectedErr error
+	}{
+		{
+			name: "Counter without delta",
+			metrics: []model.Metrics{
+				{ID: "Alloc", MType: string(model.Gauge), Value: &gauge},
+				{ID: "PollCount", MType: string(model.Counter)},
+			},
+			expectedErr: ErrInvalidMetric,
+		},
+		{
+			name: "Histogram bounds mismatch",
+			metrics: []model.Metrics{
+				{ID: "Alloc", MType: string(model.Gauge), Value: &gauge},
+				{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+				{ID: "Latency", MType: string(model.Histogram),
+					Histogram: &model.HistogramData{Bounds: []float64{0.5}, Counts: []int64{1, 0}, Sum: 0.2, Count: 1}},
+			},
+			expectedErr: ErrHistogramBoundsMismatch,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := NewMemStorage()
+
+			err := storage.UpdateHistogram(context.Background(), "Latency", nil,
+				model.HistogramData{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 0}, Sum: 1.5, Count: 3})
+			assert.NoError(t, err)
+
+			err = storage.UpdateMetrics(context.Background(), test.metrics)
+			assert.ErrorIs(t, err, test.expectedErr)
+
+			// Nothing from failed batch is applied
+			gauges, err := storage.GetAllGauge(context.Background())
+			assert.NoError(t, err)
+			assert.Empty(t, gauges)
+
+			counters, err := storage.GetAllCounter(context.Background())
+			assert.NoError(t, err)
+			assert.Empty(t, counters)
+
+			histogram, err := storage.GetHistogram(context.Background(), "Latency", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(3), histogram.Count)
+		})
+	}
+}
+
+func TestMemStorage_Labels(t *testing.T) {
+	storage := NewMemStorage()
+
+	cpu0 := model.Labels{"cpu": "0"}
+	cpu1 := model.Labels{"cpu": "1"}
+
+	err := storage.UpdateGauge(context.Background(), "CPUutilization", cpu0, 10)
+	assert.NoError(t, err)
+
+	err = storage.UpdateGauge(context.Background(), "CPUutilization", cpu1, 20)
+	assert.NoError(t, err)
+
+	value, err := storage.GetGauge(context.Background(), "CPUutilization", model.Labels{"cpu": "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 20.0, value)
+
+	_, err = storage.GetGauge(context.Background(), "CPUutilization", nil)
+	assert.True(t, errors.Is(err, ErrItemNotFound))
+
+	gauges, err := storage.GetAllGauge(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{`CPUutilization{cpu="0"}`: 10, `CPUutilization{cpu="1"}`: 20}, gauges)
+}
+
+func TestMemStorage_UpdateHistogram(t *testing.T) {
+	storage := NewMemStorage()
+
+	err := storage.UpdateHistogram(context.Background(), "Latency", nil,
+		model.HistogramData{Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 0}, Sum: 1.5, Count: 3})
+	assert.NoError(t, err)
+
+	err = storage.UpdateHistogram(context.Background(), "Latency", nil,
+		model.HistogramData{Bounds: []float64{0.1, 1}, Counts: []int64{0, 1, 1}, Sum: 3, Count: 2})
+	assert.NoError(t, err)
+
+	histogram, err := storage.GetHistogram(context.Background(), "Latency", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, model.HistogramData{Bounds: []float64{0.1, 1}, Counts: []int64{1, 3, 1}, Sum: 4.5, Count: 5}, histogram)
+
+	err = storage.UpdateHistogram(context.Background(), "Latency", nil,
+		model.HistogramData{Bounds: []float64{0.5}, Counts: []int64{1, 0}, Sum: 0.2, Count: 1})
+	assert.ErrorIs(t, err, ErrHistogramBoundsMismatch)
+
+	_, err = storage.GetHistogram(context.Background(), "Unknown", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+}
+
+func TestMemStorage_GetHistory(t *testing.T) {
+	storage := NewMemStorage()
+	storage.SetHistorySize(2)
+
+	from := time.Now()
+
+	for _, value := range []float64{1, 2, 3} {
+		err := storage.UpdateGauge(context.Background(), "HeapAlloc", nil, value)
+		assert.NoError(t, err)
+	}
+
+	err := storage.UpdateCounter(context.Background(), "PollCount", nil, 5)
+	assert.NoError(t, err)
+	err = storage.UpdateCounter(context.Background(), "PollCount", nil, 5)
+	assert.NoError(t, err)
+
+	to := time.Now()
+
+	// Only last two gauge samples fit into ring buffer
+	samples, err := storage.GetHistory(context.Background(), model.Gauge, "HeapAlloc", nil, from, to)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 2)
+	assert.Equal(t, 2.0, samples[0].Value)
+	assert.Equal(t, 3.0, samples[1].Value)
+
+	// Counter history keeps accumulated values
+	samples, err = storage.GetHistory(context.Background(), model.Counter, "PollCount", nil, from, to)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 2)
+	assert.Equal(t, 5.0, samples[0].Value)
+	assert.Equal(t, 10.0, samples[1].Value)
+
+	samples, err = storage.GetHistory(context.Background(), model.Gauge, "HeapAlloc", nil, to.Add(time.Second), to.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, samples)
+}
+
+func TestMemStorage_ConcurrentUpdates(t *testing.T) {
+	storage := NewMemStorage()
+
+	const workers = 16
+	const updates = 100
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				assert.NoError(t, storage.UpdateCounter(context.Background(), "PollCount", nil, 1))
+				assert.NoError(t, storage.UpdateGauge(context.Background(), "Gauge"+strconv.Itoa(worker), nil, float64(j)))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	counter, err := storage.GetCounter(context.Background(), "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(workers*updates), counter)
+
+	gauges, err := storage.GetAllGauge(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, gauges, workers)
+}
+
+// benchmarkShards compares global lock (one shard) with default sharding
+func benchmarkShards(b *testing.B, run func(b *testing.B, storage *MemStorage)) {
+	for _, shardCount := range []int{1, defaultShardCount} {
+		b.Run("shards="+strconv.Itoa(shardCount), func(b *testing.B) {
+			run(b, newMemStorageWithShards(shardCount))
+		})
+	}
+}
+
+// seriesNames names of series updated by agents in benchmarks
+func seriesNames(count int) []string {
+	names := make([]string, count)
+	for i := range names {
+		names[i] = "metric_" + strconv.Itoa(i)
+	}
+	return names
+}
+
+func BenchmarkMemStorage_UpdateGauge_Parallel(b *testing.B) {
+	names := seriesNames(1024)
+
+	benchmarkShards(b, func(b *testing.B, storage *MemStorage) {
+		var worker atomic.Int64
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := int(worker.Add(1)) * 7919
+			for pb.Next() {
+				_ = storage.UpdateGauge(context.Background(), names[i%len(names)], nil, float64(i))
+				i++
+			}
+		})
+	})
+}
+
+func BenchmarkMemStorage_UpdateCounter_Parallel(b *testing.B) {
+	names := seriesNames(1024)
+
+	benchmarkShards(b, func(b *testing.B, storage *MemStorage) {
+		var worker atomic.Int64
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := int(worker.Add(1)) * 7919
+			for pb.Next() {
+				_ = storage.UpdateCounter(context.Background(), names[i%len(names)], nil, 1)
+				i++
+			}
+		})
+	})
+}
+
+func BenchmarkMemStorage_UpdateMetrics_Parallel(b *testing.B) {
+	names := seriesNames(1024)
+
+	benchmarkShards(b, func(b *testing.B, storage *MemStorage) {
+		var worker atomic.Int64
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			offset := int(worker.Add(1)) * 7919
+			value := 1.5
+			delta := int64(1)
+			batch := make([]model.Metrics, 0, 32)
+			for i := 0; i < 32; i++ {
+				name := names[(offset+i)%len(names)]
+				batch = append(batch,
+					model.Metrics{ID: name, MType: string(model.Gauge), Value: &value},
+					model.Metrics{ID: name, MType: string(model.Counter), Delta: &delta},
+				)
+			}
+
+			for pb.Next() {
+				_ = storage.UpdateMetrics(context.Background(), batch)
+			}
+		})
+	})
+}
+
+func BenchmarkMemStorage_ReadWrite_Parallel(b *testing.B) {
+	names := seriesNames(1024)
+
+	benchmarkShards(b, func(b *testing.B, storage *MemStorage) {
+		for _, name := range names {
+			_ = storage.UpdateGauge(context.Background(), name, nil, 1)
+		}
+		var worker atomic.Int64
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := int(worker.Add(1)) * 7919
+			for pb.Next() {
+				name := names[i%len(names)]
+				if i%4 == 0 {
+					_ = storage.UpdateGauge(context.Background(), name, nil, float64(i))
+				} else {
+					_, _ = storage.GetGauge(context.Background(), name, nil)
+				}
+				i++
+			}
+		})
+	})
+}
+
+func BenchmarkMemStorage_GetAllGauge(b *testing.B) {
+	names := seriesNames(1024)
+
+	benchmarkShards(b, func(b *testing.B, storage *MemStorage) {
+		for _, name := range names {
+			_ = storage.UpdateGauge(context.Background(), name, nil, 1)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, _ = storage.GetAllGauge(context.Background())
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+)
+
+// migrationsFS migrations are embedded, so server does not depend on working directory
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+var ErrMigrationsFailed = errors.New("migrations failed")
+
+// MigrationStatus applied version of database schema and all embedded migrations
+type MigrationStatus struct {
+	Available []uint
+	Version   uint
+	Dirty     bool
+	// Applied is false when database has no applied migrations
+	Applied bool
+}
+
+// newMigrate create migrate instance of embedded migrations on dedicated connection of pool.
+// golang-migrate works with database/sql, so adapter over the pool is opened only for migrations,
+// returned function closes the adapter and keeps the pool open
+func (storage *DBStorage) newMigrate(ctx context.Context) (*migrate.Migrate, func(), error) {
+	if storage.pool == nil {
+		return nil, nil, ErrNoPool
+	}
+
+	source, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open embedded migrations: %w", err)
+	}
+
+	db := stdlib.OpenDBFromPool(storage.pool)
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		_ = db.Close()
+		return nil, nil, fmt.Errorf("failed to get database connection: %w", err)
+	}
+
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		_ = conn.Close()
+		_ = db.Close()
+		return nil, nil, fmt.Errorf("failed to create migration driver: %w", err)
+	}
+
+	migration, err := migrate.NewWithInstance("iofs", source, "public", driver)
+	if err != nil {
+		_ = driver.Close()
+		_ = db.Close()
+		return nil, nil, fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+
+	closeMigrate := func() {
+		sourceErr, databaseErr := migration.Close()
+		if sourceErr != nil || databaseErr != nil {
+			zap.L().Error("Failed to close migrate instance", zap.NamedError("source", sourceErr), zap.NamedError("database", databaseErr))
+		}
+		if err := db.Close(); err != nil {
+			zap.L().Error("Failed to close database adapter", zap.Error(err))
+		}
+	}
+
+	return migration, closeMigrate, nil
+}
+
+// MigrateUp method to apply all migrations which are not applied yet
+func (storage *DBStorage) MigrateUp(ctx context.Context) error {
+	return storage.runMigrate(ctx, "up", func(migration *migrate.Migrate) error {
+		return migration.Up()
+	})
+}
+
+// MigrateDown method to roll back the last applied migration
+func (storage *DBStorage) MigrateDown(ctx context.Context) error {
+	return storage.runMigrate(ctx, "down", func(migration *migrate.Migrate) error {
+		return migration.Steps(-1)
+	})
+}
+
+// MigrateTo method to migrate up or down to given version, version 0 rolls back all migrations
+func (storage *DBStorage) MigrateTo(ctx context.Context, version uint) error {
+	return storage.runMigrate(ctx, fmt.Sprintf("to %d", version), func(migration *migrate.Migrate) error {
+		if version == 0 {
+			return migration.Down()
+		}
+		return migration.Migrate(version)
+	})
+}
+
+// runMigrate run migration command, no change is not an error
+func (storage *DBStorage) runMigrate(ctx context.Context, command string, run func(migration *migrate.Migrate) error) error {
+	migration, closeMigrate, err := storage.newMigrate(ctx)
+	if err != nil {
+		zap.L().Error("Failed to prepare migrations", zap.Error(err))
+		return fmt.Errorf("%w: %w", ErrMigrationsFailed, err)
+	}
+	defer closeMigrate()
+
+	err = run(migration)
+	if errors.Is(err, migrate.ErrNoChange) {
+		zap.L().Info("No migrations to run", zap.String("command", command))
+		return nil
+	}
+	if err != nil {
+		zap.L().Error("Failed to run migrations", zap.String("command", command), zap.Error(err))
+		return fmt.Errorf("%w: %w", ErrMigrationsFailed, err)
+	}
+
+	zap.L().Info("Successfully ran migrations", zap.String("command", command))
+	return nil
+}
+
+// MigrationStatus method to get applied version of database schema
+func (storage *DBStorage) MigrationStatus(ctx context.Context) (MigrationStatus, error) {
+	available, err := availableMigrations()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	migration, closeMigrate, err := storage.newMigrate(ctx)
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("%w: %w", ErrMigrationsFailed, err)
+	}
+	defer closeMigrate()
+
+	status := MigrationStatus{Available: available}
+
+	version, dirty, err := migration.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return status, nil
+	}
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("failed to read migration version: %w", err)
+	}
+
+	status.Version = version
+	status.Dirty = dirty
+	status.Applied = true
+	return status, nil
+}
+
+// availableMigrations versions of embedded migrations in ascending order
+func availableMigrations() ([]uint, error) {
+	source, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open embedded migrations: %w", err)
+	}
+	defer source.Close()
+
+	version, err := source.First()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded migrations: %w", err)
+	}
+
+	versions := []uint{version}
+	for {
+		version, err = source.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return versions, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read embedded migrations: %w", err)
+		}
+		versions = append(versions, version)
+	}
+}
@@ -0,0 +1,9 @@
+DELETE FROM gauge WHERE labels <> '{}'::jsonb;
+ALTER TABLE gauge DROP CONSTRAINT IF EXISTS gauge_name_labels_key;
+ALTER TABLE gauge ADD CONSTRAINT gauge_name_key UNIQUE (name);
+ALTER TABLE gauge DROP COLUMN IF EXISTS labels;
+
+DELETE FROM counter WHERE labels <> '{}'::jsonb;
+ALTER TABLE counter DROP CONSTRAINT IF EXISTS counter_name_labels_key;
+ALTER TABLE counter ADD CONSTRAINT counter_name_key UNIQUE (name);
+ALTER TABLE counter DROP COLUMN IF EXISTS labels;
@@ -0,0 +1,7 @@
+ALTER TABLE gauge ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE gauge DROP CONSTRAINT IF EXISTS gauge_name_key;
+ALTER TABLE gauge ADD CONSTRAINT gauge_name_labels_key UNIQUE (name, labels);
+
+ALTER TABLE counter ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE counter DROP CONSTRAINT IF EXISTS counter_name_key;
+ALTER TABLE counter ADD CONSTRAINT counter_name_labels_key UNIQUE (name, labels);
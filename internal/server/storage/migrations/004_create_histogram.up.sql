@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS histogram
+(
+    id     SERIAL PRIMARY KEY,
+    name   VARCHAR(255)       NOT NULL,
+    labels JSONB              NOT NULL DEFAULT '{}'::jsonb,
+    bounds DOUBLE PRECISION[] NOT NULL,
+    counts BIGINT[]           NOT NULL,
+    sum    DOUBLE PRECISION   NOT NULL,
+    count  BIGINT             NOT NULL,
+    CONSTRAINT histogram_name_labels_key UNIQUE (name, labels)
+);
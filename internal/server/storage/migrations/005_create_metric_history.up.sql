@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS metric_history
+(
+    id         BIGSERIAL PRIMARY KEY,
+    type       VARCHAR(16)      NOT NULL,
+    name       VARCHAR(255)     NOT NULL,
+    labels     JSONB            NOT NULL DEFAULT '{}'::jsonb,
+    value      DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMPTZ      NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS metric_history_series_idx ON metric_history (type, name, labels, created_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS metric_history_created_at_idx;
+DROP TABLE metric_history_rollup;
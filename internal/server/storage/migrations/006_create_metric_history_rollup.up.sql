@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS metric_history_rollup
+(
+    type       VARCHAR(16)      NOT NULL,
+    name       VARCHAR(255)     NOT NULL,
+    labels     JSONB            NOT NULL DEFAULT '{}'::jsonb,
+    resolution BIGINT           NOT NULL,
+    bucket     TIMESTAMPTZ      NOT NULL,
+    min        DOUBLE PRECISION NOT NULL,
+    max        DOUBLE PRECISION NOT NULL,
+    avg        DOUBLE PRECISION NOT NULL,
+    count      BIGINT           NOT NULL,
+    PRIMARY KEY (type, name, labels, resolution, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS metric_history_created_at_idx ON metric_history (created_at);
+CREATE INDEX IF NOT EXISTS metric_history_rollup_bucket_idx ON metric_history_rollup (resolution, bucket);
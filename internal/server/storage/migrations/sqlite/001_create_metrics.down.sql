@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS histogram;
+DROP TABLE IF EXISTS counter;
+DROP TABLE IF EXISTS gauge;
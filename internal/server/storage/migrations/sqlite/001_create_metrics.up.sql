@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS gauge
+(
+    name   TEXT NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    value  REAL NOT NULL,
+    PRIMARY KEY (name, labels)
+);
+
+CREATE TABLE IF NOT EXISTS counter
+(
+    name   TEXT    NOT NULL,
+    labels TEXT    NOT NULL DEFAULT '{}',
+    value  INTEGER NOT NULL,
+    PRIMARY KEY (name, labels)
+);
+
+CREATE TABLE IF NOT EXISTS histogram
+(
+    name   TEXT    NOT NULL,
+    labels TEXT    NOT NULL DEFAULT '{}',
+    bounds TEXT    NOT NULL,
+    counts TEXT    NOT NULL,
+    sum    REAL    NOT NULL,
+    count  INTEGER NOT NULL,
+    PRIMARY KEY (name, labels)
+);
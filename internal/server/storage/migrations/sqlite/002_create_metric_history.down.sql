@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS metric_history_rollup;
+DROP TABLE IF EXISTS metric_history;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS metric_history
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    type       TEXT    NOT NULL,
+    name       TEXT    NOT NULL,
+    labels     TEXT    NOT NULL DEFAULT '{}',
+    value      REAL    NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS metric_history_series_idx ON metric_history (type, name, labels, created_at);
+CREATE INDEX IF NOT EXISTS metric_history_created_at_idx ON metric_history (created_at);
+
+CREATE TABLE IF NOT EXISTS metric_history_rollup
+(
+    type       TEXT    NOT NULL,
+    name       TEXT    NOT NULL,
+    labels     TEXT    NOT NULL DEFAULT '{}',
+    resolution INTEGER NOT NULL,
+    bucket     INTEGER NOT NULL,
+    min        REAL    NOT NULL,
+    max        REAL    NOT NULL,
+    avg        REAL    NOT NULL,
+    count      INTEGER NOT NULL,
+    PRIMARY KEY (type, name, labels, resolution, bucket)
+);
+
+CREATE INDEX IF NOT EXISTS metric_history_rollup_bucket_idx ON metric_history_rollup (resolution, bucket);
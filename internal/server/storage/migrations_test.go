@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAvailableMigrations(t *testing.T) {
+	versions, err := availableMigrations()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{1, 2, 3, 4, 5, 6}, versions)
+}
+
+func TestMigrationsFS_UpAndDown(t *testing.T) {
+	for _, version := range []string{"001_create_gauge", "006_create_metric_history_rollup"} {
+		for _, direction := range []string{"up", "down"} {
+			_, err := migrationsFS.ReadFile("migrations/" + version + "." + direction + ".sql")
+			assert.NoError(t, err)
+		}
+	}
+}
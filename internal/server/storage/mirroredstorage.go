@@ -0,0 +1,439 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"go.uber.org/zap"
+)
+
+// MirrorPolicy what mirrored storage does when update of secondary storage fails
+type MirrorPolicy string
+
+const (
+	// MirrorPolicyFail error of secondary is returned to caller wrapped in ErrMirrorFailed, primary keeps the update
+	MirrorPolicyFail MirrorPolicy = "fail"
+	// MirrorPolicyLog error of secondary is logged and the update is lost for this secondary
+	MirrorPolicyLog MirrorPolicy = "log"
+	// MirrorPolicyRetry the update is queued and written to secondary in background until it succeeds
+	MirrorPolicyRetry MirrorPolicy = "retry"
+)
+
+var ErrInvalidMirrorPolicy = errors.New("invalid mirror policy")
+
+// ErrMirrorFailed update is applied to primary storage, but not to secondary
+var ErrMirrorFailed = errors.New("update is stored in primary storage, but mirroring failed")
+
+// ParseMirrorPolicy parse policy name: fail, log or retry
+func ParseMirrorPolicy(value string) (MirrorPolicy, error) {
+	switch policy := MirrorPolicy(value); policy {
+	case MirrorPolicyFail, MirrorPolicyLog, MirrorPolicyRetry:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidMirrorPolicy, value)
+	}
+}
+
+// mirror secondary storage with queue of updates waiting for retry
+type mirror struct {
+	storage Storage
+	queue   *seriesBatch
+	lock    sync.Mutex
+}
+
+// MirroredStorage forwards every update to primary storage and then to all secondary storages.
+// Update which primary rejects is not forwarded. Reads are served by primary and fall back to secondaries
+// in order when primary fails, missing metric in primary is not looked up in secondaries
+type MirroredStorage struct {
+	primary       Storage
+	worker        *worker
+	policy        MirrorPolicy
+	secondaries   []*mirror
+	retryInterval time.Duration
+}
+
+// NewMirroredStorage constructor to create storage mirroring {primary} into {secondaries}
+func NewMirroredStorage(primary Storage, policy MirrorPolicy, retryInterval time.Duration, secondaries ...Storage) *MirroredStorage {
+	mirrors := make([]*mirror, 0, len(secondaries))
+	for _, secondary := range secondaries {
+		mirrors = append(mirrors, &mirror{storage: secondary, queue: newSeriesBatch(0)})
+	}
+
+	return &MirroredStorage{primary: primary, policy: policy, retryInterval: retryInterval, secondaries: mirrors}
+}
+
+// Start method to start background retries of queued updates
+func (mirrored *MirroredStorage) Start(ctx context.Context) error {
+	if mirrored.policy == MirrorPolicyRetry {
+		mirrored.worker = startWorker(ctx, mirrored.run)
+	}
+	return nil
+}
+
+// Stop method to stop background retries and make the last attempt to write queued updates
+func (mirrored *MirroredStorage) Stop(ctx context.Context) error {
+	var err error
+	if mirrored.worker != nil {
+		err = mirrored.worker.stop(ctx)
+	}
+
+	return errors.Join(err, mirrored.Retry(ctx))
+}
+
+// run retry queued updates every retry interval until context is done
+func (mirrored *MirroredStorage) run(ctx context.Context) {
+	ticker := time.NewTicker(mirrored.retryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := mirrored.Retry(ctx); err != nil {
+				zap.L().Warn("Failed to retry mirrored updates", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Retry method to write queued updates of every secondary with one batch
+func (mirrored *MirroredStorage) Retry(ctx context.Context) error {
+	var errs []error
+	for i, secondary := range mirrored.secondaries {
+		if err := secondary.drain(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("secondary %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// drain write queued updates, queue is kept when write fails
+func (secondary *mirror) drain(ctx context.Context) error {
+	secondary.lock.Lock()
+	defer secondary.lock.Unlock()
+
+	if secondary.queue.len() == 0 {
+		return nil
+	}
+
+	if err := secondary.storage.UpdateMetrics(ctx, secondary.queue.metrics); err != nil {
+		return fmt.Errorf("could not write %d queued series: %w", secondary.queue.len(), err)
+	}
+
+	zap.L().Info("Wrote queued updates to secondary storage", zap.Int("series", secondary.queue.len()))
+	secondary.queue = newSeriesBatch(0)
+	return nil
+}
+
+// forward apply update to every secondary according to policy, {metrics} is the same update used for retry queue
+func (mirrored *MirroredStorage) forward(ctx context.Context, metrics []model.Metrics, update func(st Storage) error) error {
+	var errs []error
+
+	for i, secondary := range mirrored.secondaries {
+		err := secondary.apply(ctx, mirrored.policy, metrics, update)
+		if err == nil {
+			continue
+		}
+
+		switch mirrored.policy {
+		case MirrorPolicyFail:
+			errs = append(errs, fmt.Errorf("secondary %d: %w", i, err))
+		default:
+			zap.L().Error("Failed to update secondary storage", zap.Int("secondary", i),
+				zap.String("policy", string(mirrored.policy)), zap.Error(err))
+		}
+	}
+
+	return mirrorFailed(errs)
+}
+
+// mirrorFailed join errors of secondaries into ErrMirrorFailed, nil is returned without errors
+func mirrorFailed(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrMirrorFailed, errors.Join(errs...))
+}
+
+// apply update to secondary. With retry policy failed update is queued,
+// and while queue is not empty new updates are queued after it, so they are not applied out of order
+func (secondary *mirror) apply(ctx context.Context, policy MirrorPolicy, metrics []model.Metrics, update func(st Storage) error) error {
+	if policy != MirrorPolicyRetry {
+		return update(secondary.storage)
+	}
+
+	secondary.lock.Lock()
+	defer secondary.lock.Unlock()
+
+	if secondary.queue.len() == 0 {
+		err := update(secondary.storage)
+		if err == nil || errors.Is(err, ErrInvalidMetric) || errors.Is(err, ErrHistogramBoundsMismatch) {
+			// Rejected update would be rejected on retry too
+			return err
+		}
+		zap.L().Warn("Queueing update of secondary storage for retry", zap.Error(err))
+	}
+
+	for _, metric := range metrics {
+		if err := secondary.queue.add(metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateGauge method to update gauge metric
+func (mirrored *MirroredStorage) UpdateGauge(ctx context.Context, name string, labels model.Labels, metric float64) error {
+	if err := mirrored.primary.UpdateGauge(ctx, name, labels, metric); err != nil {
+		return err
+	}
+
+	update := []model.Metrics{{ID: name, MType: string(model.Gauge), Labels: labels, Value: &metric}}
+	return mirrored.forward(ctx, update, func(st Storage) error {
+		return st.UpdateGauge(ctx, name, labels, metric)
+	})
+}
+
+// UpdateCounter method to update counter metric
+func (mirrored *MirroredStorage) UpdateCounter(ctx context.Context, name string, labels model.Labels, metric int64) error {
+	_, err := mirrored.UpdateCounterAndReturn(ctx, name, labels, metric)
+	return err
+}
+
+// UpdateCounterAndReturn method to update counter metric and return value of primary
+func (mirrored *MirroredStorage) UpdateCounterAndReturn(ctx context.Context, name string, labels model.Labels, metric int64) (int64, error) {
+	value, err := mirrored.primary.UpdateCounterAndReturn(ctx, name, labels, metric)
+	if err != nil {
+		return 0, err
+	}
+
+	update := []model.Metrics{{ID: name, MType: string(model.Counter), Labels: labels, Delta: &metric}}
+	return value, mirrored.forward(ctx, update, func(st Storage) error {
+		return st.UpdateCounter(ctx, name, labels, metric)
+	})
+}
+
+// UpdateHistogram method to merge observations into histogram metric
+func (mirrored *MirroredStorage) UpdateHistogram(ctx context.Context, name string, labels model.Labels, metric model.HistogramData) error {
+	if err := mirrored.primary.UpdateHistogram(ctx, name, labels, metric); err != nil {
+		return err
+	}
+
+	update := []model.Metrics{{ID: name, MType: string(model.Histogram), Labels: labels, Histogram: &metric}}
+	return mirrored.forward(ctx, update, func(st Storage) error {
+		return st.UpdateHistogram(ctx, name, labels, metric)
+	})
+}
+
+// UpdateMetrics method to update batch of metrics
+func (mirrored *MirroredStorage) UpdateMetrics(ctx context.Context, metrics []model.Metrics) error {
+	if err := mirrored.primary.UpdateMetrics(ctx, metrics); err != nil {
+		return err
+	}
+
+	return mirrored.forward(ctx, metrics, func(st Storage) error {
+		return st.UpdateMetrics(ctx, metrics)
+	})
+}
+
+// Delete method to remove series from primary and from every secondary according to policy.
+// Deletion is not retried, with retry policy queued updates of series are dropped and failed deletion is only logged
+func (mirrored *MirroredStorage) Delete(ctx context.Context, mType model.MetricType, name string, labels model.Labels) error {
+	if err := mirrored.primary.Delete(ctx, mType, name, labels); err != nil {
+		return err
+	}
+
+	return mirrored.forwardDelete(matchSeries(mType, name, labels), func(st Storage) error {
+		return st.Delete(ctx, mType, name, labels)
+	})
+}
+
+// DeleteByPrefix method to remove series with name starting with prefix from primary and every secondary,
+// returns number of series removed from primary
+func (mirrored *MirroredStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	deleted, err := mirrored.primary.DeleteByPrefix(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, mirrored.forwardDelete(matchPrefix(prefix), func(st Storage) error {
+		_, err := st.DeleteByPrefix(ctx, prefix)
+		return err
+	})
+}
+
+// forwardDelete drop queued updates matched by {match} and apply deletion to every secondary,
+// series missing in secondary is not an error
+func (mirrored *MirroredStorage) forwardDelete(match func(metric model.Metrics) bool, remove func(st Storage) error) error {
+	var errs []error
+
+	for i, secondary := range mirrored.secondaries {
+		secondary.lock.Lock()
+		secondary.queue.remove(match)
+		err := remove(secondary.storage)
+		secondary.lock.Unlock()
+
+		if err == nil || errors.Is(err, ErrItemNotFound) {
+			continue
+		}
+
+		if mirrored.policy == MirrorPolicyFail {
+			errs = append(errs, fmt.Errorf("secondary %d: %w", i, err))
+			continue
+		}
+		zap.L().Error("Failed to delete from secondary storage", zap.Int("secondary", i),
+			zap.String("policy", string(mirrored.policy)), zap.Error(err))
+	}
+
+	return mirrorFailed(errs)
+}
+
+// readWithFallback call {get} on primary and on secondaries in order while it fails, ErrItemNotFound is returned as is
+func readWithFallback[T any](mirrored *MirroredStorage, get func(st Storage) (T, error)) (T, error) {
+	value, err := get(mirrored.primary)
+	if err == nil || errors.Is(err, ErrItemNotFound) {
+		return value, err
+	}
+
+	for i, secondary := range mirrored.secondaries {
+		zap.L().Warn("Failed to read from primary storage, falling back to secondary", zap.Int("secondary", i), zap.Error(err))
+
+		fallback, fallbackErr := get(secondary.storage)
+		if fallbackErr == nil || errors.Is(fallbackErr, ErrItemNotFound) {
+			return fallback, fallbackErr
+		}
+		err = errors.Join(err, fallbackErr)
+	}
+
+	return value, err
+}
+
+// GetGauge method to get gauge metric by name
+func (mirrored *MirroredStorage) GetGauge(ctx context.Context, name string, labels model.Labels) (float64, error) {
+	return readWithFallback(mirrored, func(st Storage) (float64, error) {
+		return st.GetGauge(ctx, name, labels)
+	})
+}
+
+// GetCounter method to get counter metric by name
+func (mirrored *MirroredStorage) GetCounter(ctx context.Context, name string, labels model.Labels) (int64, error) {
+	return readWithFallback(mirrored, func(st Storage) (int64, error) {
+		return st.GetCounter(ctx, name, labels)
+	})
+}
+
+// GetAllGauge method to get all gauge metrics
+func (mirrored *MirroredStorage) GetAllGauge(ctx context.Context) (map[string]float64, error) {
+	return readWithFallback(mirrored, func(st Storage) (map[string]float64, error) {
+		return st.GetAllGauge(ctx)
+	})
+}
+
+// GetAllCounter method to get all counter metrics
+func (mirrored *MirroredStorage) GetAllCounter(ctx context.Context) (map[string]int64, error) {
+	return readWithFallback(mirrored, func(st Storage) (map[string]int64, error) {
+		return st.GetAllCounter(ctx)
+	})
+}
+
+// GetHistogram method to get histogram metric by name and labels
+func (mirrored *MirroredStorage) GetHistogram(ctx context.Context, name string, labels model.Labels) (model.HistogramData, error) {
+	return readWithFallback(mirrored, func(st Storage) (model.HistogramData, error) {
+		return st.GetHistogram(ctx, name, labels)
+	})
+}
+
+// GetAllHistogram method to get all histogram metrics
+func (mirrored *MirroredStorage) GetAllHistogram(ctx context.Context) (map[string]model.HistogramData, error) {
+	return readWithFallback(mirrored, func(st Storage) (map[string]model.HistogramData, error) {
+		return st.GetAllHistogram(ctx)
+	})
+}
+
+// GetMetrics method to get values of requested series
+func (mirrored *MirroredStorage) GetMetrics(ctx context.Context, metrics []model.Metrics) ([]model.Metrics, error) {
+	return readWithFallback(mirrored, func(st Storage) ([]model.Metrics, error) {
+		return st.GetMetrics(ctx, metrics)
+	})
+}
+
+// QueryMetrics method to get page of series selected by type and name prefix
+func (mirrored *MirroredStorage) QueryMetrics(ctx context.Context, query model.MetricsQuery) ([]model.Metrics, error) {
+	return readWithFallback(mirrored, func(st Storage) ([]model.Metrics, error) {
+		return st.QueryMetrics(ctx, query)
+	})
+}
+
+// GetHistory method to get samples of series
+func (mirrored *MirroredStorage) GetHistory(ctx context.Context, mType model.MetricType, name string, labels model.Labels, from time.Time, to time.Time) ([]model.Sample, error) {
+	return readWithFallback(mirrored, func(st Storage) ([]model.Sample, error) {
+		return st.GetHistory(ctx, mType, name, labels, from, to)
+	})
+}
+
+// GetRollups method to get rollups of series
+func (mirrored *MirroredStorage) GetRollups(ctx context.Context, mType model.MetricType, name string, labels model.Labels, resolution time.Duration, from time.Time, to time.Time) ([]model.Rollup, error) {
+	return readWithFallback(mirrored, func(st Storage) ([]model.Rollup, error) {
+		return st.GetRollups(ctx, mType, name, labels, resolution, from, to)
+	})
+}
+
+// ExportMetrics method to get all series of primary
+func (mirrored *MirroredStorage) ExportMetrics(ctx context.Context) ([]model.Metrics, error) {
+	return readWithFallback(mirrored, func(st Storage) ([]model.Metrics, error) {
+		exporter, ok := st.(Exporter)
+		if !ok {
+			return nil, ErrBackendNotSupported
+		}
+		return exporter.ExportMetrics(ctx)
+	})
+}
+
+// Compact method to compact history of primary and every secondary which supports compaction
+func (mirrored *MirroredStorage) Compact(ctx context.Context, policy RetentionPolicy, now time.Time) error {
+	var errs []error
+
+	storages := []Storage{mirrored.primary}
+	for _, secondary := range mirrored.secondaries {
+		storages = append(storages, secondary.storage)
+	}
+
+	for _, st := range storages {
+		if compactor, ok := st.(Compactor); ok {
+			errs = append(errs, compactor.Compact(ctx, policy, now))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Ping verifies a connection to the first storage with database is still alive
+func (mirrored *MirroredStorage) Ping(ctx context.Context) error {
+	if repository, ok := mirrored.primary.(Repository); ok {
+		return repository.Ping(ctx)
+	}
+	for _, secondary := range mirrored.secondaries {
+		if repository, ok := secondary.storage.(Repository); ok {
+			return repository.Ping(ctx)
+		}
+	}
+	return ErrBackendNotSupported
+}
+
+// PoolStats method to get statistics of connection pool of the first storage with database
+func (mirrored *MirroredStorage) PoolStats() (PoolStats, error) {
+	if provider, ok := mirrored.primary.(PoolStatsProvider); ok {
+		return provider.PoolStats()
+	}
+	for _, secondary := range mirrored.secondaries {
+		if provider, ok := secondary.storage.(PoolStatsProvider); ok {
+			return provider.PoolStats()
+		}
+	}
+	return PoolStats{}, ErrNoPool
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+// flakyStorage memory storage whose updates and reads of gauges fail while err is set
+type flakyStorage struct {
+	*MemStorage
+	err  error
+	lock sync.Mutex
+}
+
+func newFlakyStorage() *flakyStorage {
+	return &flakyStorage{MemStorage: NewMemStorage()}
+}
+
+func (st *flakyStorage) setErr(err error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	st.err = err
+}
+
+func (st *flakyStorage) failure() error {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	return st.err
+}
+
+func (st *flakyStorage) UpdateGauge(ctx context.Context, name string, labels model.Labels, metric float64) error {
+	if err := st.failure(); err != nil {
+		return err
+	}
+	return st.MemStorage.UpdateGauge(ctx, name, labels, metric)
+}
+
+func (st *flakyStorage) UpdateCounter(ctx context.Context, name string, labels model.Labels, metric int64) error {
+	if err := st.failure(); err != nil {
+		return err
+	}
+	return st.MemStorage.UpdateCounter(ctx, name, labels, metric)
+}
+
+func (st *flakyStorage) UpdateMetrics(ctx context.Context, metrics []model.Metrics) error {
+	if err := st.failure(); err != nil {
+		return err
+	}
+	return st.MemStorage.UpdateMetrics(ctx, metrics)
+}
+
+func (st *flakyStorage) GetGauge(ctx context.Context, name string, labels model.Labels) (float64, error) {
+	if err := st.failure(); err != nil {
+		return 0, err
+	}
+	return st.MemStorage.GetGauge(ctx, name, labels)
+}
+
+func TestParseMirrorPolicy(t *testing.T) {
+	policy, err := ParseMirrorPolicy("retry")
+	assert.NoError(t, err)
+	assert.Equal(t, MirrorPolicyRetry, policy)
+
+	_, err = ParseMirrorPolicy("ignore")
+	assert.ErrorIs(t, err, ErrInvalidMirrorPolicy)
+}
+
+func TestMirroredStorage_SecondaryFailure(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        MirrorPolicy
+		expectErr     bool
+		expectedAfter int64
+	}{
+		{name: "Fail", policy: MirrorPolicyFail, expectErr: true, expectedAfter: 1},
+		{name: "Log", policy: MirrorPolicyLog, expectErr: false, expectedAfter: 1},
+		{name: "Retry", policy: MirrorPolicyRetry, expectErr: false, expectedAfter: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			primary := NewMemStorage()
+			secondary := newFlakyStorage()
+			mirrored := NewMirroredStorage(primary, test.policy, time.Hour, secondary)
+
+			secondary.setErr(errors.New("something went wrong"))
+
+			err := mirrored.UpdateCounter(context.Background(), "PollCount", nil, 2)
+			if test.expectErr {
+				assert.ErrorIs(t, err, ErrMirrorFailed)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// Primary keeps the update whatever happened to secondary
+			counter, err := primary.GetCounter(context.Background(), "PollCount", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(2), counter)
+
+			secondary.setErr(nil)
+			err = mirrored.UpdateCounter(context.Background(), "PollCount", nil, 1)
+			assert.NoError(t, err)
+
+			err = mirrored.Stop(context.Background())
+			assert.NoError(t, err)
+
+			counter, err = secondary.GetCounter(context.Background(), "PollCount", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedAfter, counter)
+		})
+	}
+}
+
+func TestMirroredStorage_RetryKeepsOrder(t *testing.T) {
+	primary := NewMemStorage()
+	secondary := newFlakyStorage()
+	mirrored := NewMirroredStorage(primary, MirrorPolicyRetry, time.Hour, secondary)
+
+	secondary.setErr(errors.New("something went wrong"))
+	err := mirrored.UpdateGauge(context.Background(), "Alloc", nil, 1)
+	assert.NoError(t, err)
+
+	secondary.setErr(nil)
+
+	// Update after failed one is queued, otherwise retry would overwrite it with older value
+	err = mirrored.UpdateGauge(context.Background(), "Alloc", nil, 2)
+	assert.NoError(t, err)
+
+	_, err = secondary.GetGauge(context.Background(), "Alloc", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	err = mirrored.Retry(context.Background())
+	assert.NoError(t, err)
+
+	gauge, err := secondary.GetGauge(context.Background(), "Alloc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, gauge)
+}
+
+func TestMirroredStorage_PrimaryFailure(t *testing.T) {
+	primary := newFlakyStorage()
+	secondary := NewMemStorage()
+	mirrored := NewMirroredStorage(primary, MirrorPolicyFail, time.Hour, secondary)
+
+	err := mirrored.UpdateGauge(context.Background(), "Alloc", nil, 1)
+	assert.NoError(t, err)
+
+	// Rejected update is not forwarded
+	primary.setErr(errors.New("something went wrong"))
+	err = mirrored.UpdateGauge(context.Background(), "Alloc", nil, 2)
+	assert.Error(t, err)
+
+	// Read falls back to secondary
+	gauge, err := mirrored.GetGauge(context.Background(), "Alloc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, gauge)
+
+	// Missing metric of primary is not looked up in secondary
+	primary.setErr(nil)
+	err = secondary.UpdateGauge(context.Background(), "HeapAlloc", nil, 3)
+	assert.NoError(t, err)
+
+	_, err = mirrored.GetGauge(context.Background(), "HeapAlloc", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+}
+
+func TestMirroredStorage_UpdateMetrics(t *testing.T) {
+	primary := NewMemStorage()
+	secondary := NewMemStorage()
+	mirrored := NewMirroredStorage(primary, MirrorPolicyFail, time.Hour, secondary)
+
+	value := 1.5
+	err := mirrored.UpdateMetrics(context.Background(), []model.Metrics{
+		{ID: "Alloc", MType: string(model.Gauge), Value: &value},
+	})
+	assert.NoError(t, err)
+
+	for _, st := range []Storage{primary, secondary} {
+		gauge, err := st.GetGauge(context.Background(), "Alloc", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, value, gauge)
+	}
+
+	// Invalid batch is rejected by primary and not forwarded
+	err = mirrored.UpdateMetrics(context.Background(), []model.Metrics{{ID: "PollCount", MType: string(model.Counter)}})
+	assert.ErrorIs(t, err, ErrInvalidMetric)
+}
+
+func TestMirroredStorage_DeleteDropsQueuedUpdates(t *testing.T) {
+	primary := NewMemStorage()
+	secondary := newFlakyStorage()
+	mirrored := NewMirroredStorage(primary, MirrorPolicyRetry, time.Hour, secondary)
+
+	secondary.setErr(errors.New("something went wrong"))
+	err := mirrored.UpdateGauge(context.Background(), "Alloc", nil, 1)
+	assert.NoError(t, err)
+	secondary.setErr(nil)
+
+	// Queued update of deleted series would restore it in secondary on retry
+	err = mirrored.Delete(context.Background(), model.Gauge, "Alloc", nil)
+	assert.NoError(t, err)
+
+	err = mirrored.Retry(context.Background())
+	assert.NoError(t, err)
+
+	for _, st := range []Storage{primary, secondary} {
+		_, err := st.GetGauge(context.Background(), "Alloc", nil)
+		assert.ErrorIs(t, err, ErrItemNotFound)
+	}
+
+	err = mirrored.Delete(context.Background(), model.Gauge, "Alloc", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+}
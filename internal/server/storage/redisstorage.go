@@ -0,0 +1,769 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"go.uber.org/zap"
+)
+
+// redisKeyPrefix prefix of all keys, so metrics can share Redis database with other data
+const redisKeyPrefix = "gometrics:"
+
+var ErrInvalidRedisDsn = errors.New("invalid Redis DSN")
+
+// redisCounterScript adds delta to counter and records accumulated value in series history in one step.
+// KEYS: counter hash, history sorted set. ARGV: series key, delta, sample score, sample member prefix
+var redisCounterScript = redis.NewScript(`
+local value = redis.call('HINCRBY', KEYS[1], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[4] .. value)
+return value
+`)
+
+// RedisStorage storage in Redis shared by server replicas.
+// Gauges, counters and histograms are fields of hashes keyed by model.SeriesKey, counters are changed with HINCRBY.
+// Series hash keeps name and labels of every series, history and rollups of series are sorted sets scored by unix nanoseconds
+type RedisStorage struct {
+	client *redis.Client
+	// instance random replica id, so samples recorded by replicas at the same time are different members
+	instance string
+	sequence atomic.Uint64
+}
+
+// IsRedisDsn check that database DSN selects Redis storage
+func IsRedisDsn(dsn string) bool {
+	return strings.HasPrefix(dsn, "redis://") || strings.HasPrefix(dsn, "rediss://")
+}
+
+// NewRedisStorage constructor to create Redis client by URL redis://[user:password@]host:port/db, connections are established lazily
+func NewRedisStorage(url string) (*RedisStorage, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRedisDsn, err)
+	}
+
+	instance := make([]byte, 4)
+	if _, err := rand.Read(instance); err != nil {
+		return nil, fmt.Errorf("failed to generate instance id: %w", err)
+	}
+
+	zap.L().Info("Created Redis client", zap.String("address", options.Addr), zap.Int("db", options.DB))
+
+	return &RedisStorage{client: redis.NewClient(options), instance: hex.EncodeToString(instance)}, nil
+}
+
+// Start method to check that Redis is reachable before storage is used
+func (storage *RedisStorage) Start(ctx context.Context) error {
+	if err := storage.Ping(ctx); err != nil {
+		return fmt.Errorf("could not connect to Redis: %w", err)
+	}
+	return nil
+}
+
+// Stop method to close Redis connections
+func (storage *RedisStorage) Stop(_ context.Context) error {
+	if err := storage.client.Close(); err != nil {
+		return fmt.Errorf("could not close Redis client: %w", err)
+	}
+
+	zap.L().Info("Redis client closed")
+	return nil
+}
+
+// Ping verifies a connection to Redis is still alive
+func (storage *RedisStorage) Ping(ctx context.Context) error {
+	return storage.client.Ping(ctx).Err()
+}
+
+// UpdateGauge method to update gauge metric
+func (storage *RedisStorage) UpdateGauge(ctx context.Context, name string, labels model.Labels, metric float64) error {
+	_, err := storage.apply(ctx, []model.Metrics{{ID: name, MType: string(model.Gauge), Labels: labels, Value: &metric}})
+	return err
+}
+
+// UpdateCounter method to update counter metric
+func (storage *RedisStorage) UpdateCounter(ctx context.Context, name string, labels model.Labels, metric int64) error {
+	_, err := storage.UpdateCounterAndReturn(ctx, name, labels, metric)
+	return err
+}
+
+// UpdateCounterAndReturn method to update counter metric and return updated value
+func (storage *RedisStorage) UpdateCounterAndReturn(ctx context.Context, name string, labels model.Labels, metric int64) (int64, error) {
+	counters, err := storage.apply(ctx, []model.Metrics{{ID: name, MType: string(model.Counter), Labels: labels, Delta: &metric}})
+	if err != nil {
+		return 0, err
+	}
+
+	return counters[model.SeriesKey(name, labels)], nil
+}
+
+// UpdateHistogram method to merge observations into histogram metric
+func (storage *RedisStorage) UpdateHistogram(ctx context.Context, name string, labels model.Labels, metric model.HistogramData) error {
+	_, err := storage.apply(ctx, []model.Metrics{{ID: name, MType: string(model.Histogram), Labels: labels, Histogram: &metric}})
+	return err
+}
+
+// UpdateMetrics method to update batch of different types of metrics.
+// Batch is validated and merged by series first, then all commands are sent in one MULTI/EXEC pipeline
+func (storage *RedisStorage) UpdateMetrics(ctx context.Context, metrics []model.Metrics) error {
+	if err := ValidateMetrics(metrics); err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	merged, err := mergeMetrics(metrics)
+	if err != nil {
+		return err
+	}
+
+	_, err = storage.apply(ctx, merged)
+	return err
+}
+
+// apply write metrics merged by series in one transaction and return accumulated values of counters by series key.
+// Batch with histograms watches histogram hash: stored histograms are merged in Go and transaction is retried
+// when another replica changes them before EXEC
+func (storage *RedisStorage) apply(ctx context.Context, metrics []model.Metrics) (map[string]int64, error) {
+	index := make(map[string]any, len(metrics))
+	histograms := make([]model.Metrics, 0)
+	for _, metric := range metrics {
+		data, err := json.Marshal(model.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode series %s: %w", metric.ID, err)
+		}
+		index[metric.MType+":"+model.SeriesKey(metric.ID, metric.Labels)] = string(data)
+
+		if metric.MType == string(model.Histogram) {
+			histograms = append(histograms, metric)
+		}
+	}
+
+	now := time.Now()
+	var counters map[string]*redis.Cmd
+
+	if len(histograms) == 0 {
+		_, err := storage.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			counters = storage.queue(ctx, pipe, metrics, index, nil, now)
+			return nil
+		})
+		if err != nil {
+			zap.L().Error("Failed to update metrics", zap.Error(err))
+			return nil, err
+		}
+		return counterValues(counters)
+	}
+
+	var err error
+	for i := 0; i < maxRetries; i++ {
+		err = storage.client.Watch(ctx, func(tx *redis.Tx) error {
+			encoded, err := mergeStoredHistograms(ctx, tx, histograms)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				counters = storage.queue(ctx, pipe, metrics, index, encoded, now)
+				return nil
+			})
+			return err
+		}, redisKey("histogram"))
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+		zap.L().Info("Histograms changed by another client, retrying transaction", zap.Int("Retry count", i))
+	}
+	if err != nil {
+		zap.L().Error("Failed to update metrics", zap.Error(err))
+		return nil, err
+	}
+
+	return counterValues(counters)
+}
+
+// queue add commands updating metrics to pipeline, {histograms} are merged JSON values by series key.
+// Returns commands of counters by series key
+func (storage *RedisStorage) queue(ctx context.Context, pipe redis.Pipeliner, metrics []model.Metrics, index map[string]any,
+	histograms map[string]string, now time.Time) map[string]*redis.Cmd {
+	counters := make(map[string]*redis.Cmd)
+
+	pipe.HSet(ctx, redisKey("series"), index)
+
+	for _, metric := range metrics {
+		seriesKey := model.SeriesKey(metric.ID, metric.Labels)
+		score := strconv.FormatInt(now.UnixNano(), 10)
+
+		switch metric.MType {
+		case string(model.Gauge):
+			value := strconv.FormatFloat(*metric.Value, 'g', -1, 64)
+			pipe.HSet(ctx, redisKey("gauge"), seriesKey, value)
+			pipe.ZAdd(ctx, historyKey(model.Gauge, seriesKey), redis.Z{Score: float64(now.UnixNano()), Member: storage.sampleMember(now) + value})
+		case string(model.Counter):
+			counters[seriesKey] = redisCounterScript.Eval(ctx, pipe,
+				[]string{redisKey("counter"), historyKey(model.Counter, seriesKey)},
+				seriesKey, *metric.Delta, score, storage.sampleMember(now))
+		case string(model.Histogram):
+			pipe.HSet(ctx, redisKey("histogram"), seriesKey, histograms[seriesKey])
+		}
+	}
+
+	return counters
+}
+
+// sampleMember prefix of history sorted set member "nanoseconds:instance-sequence:", value is appended to it
+func (storage *RedisStorage) sampleMember(now time.Time) string {
+	return strconv.FormatInt(now.UnixNano(), 10) + ":" + storage.instance + "-" + strconv.FormatUint(storage.sequence.Add(1), 10) + ":"
+}
+
+// mergeStoredHistograms read stored histograms of batch and merge batch into them, bucket bounds must be equal
+func mergeStoredHistograms(ctx context.Context, tx *redis.Tx, histograms []model.Metrics) (map[string]string, error) {
+	fields := make([]string, 0, len(histograms))
+	for _, metric := range histograms {
+		fields = append(fields, model.SeriesKey(metric.ID, metric.Labels))
+	}
+
+	stored, err := tx.HMGet(ctx, redisKey("histogram"), fields...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := make(map[string]string, len(histograms))
+	for i, metric := range histograms {
+		merged := metric.Histogram.Clone()
+
+		if data, ok := stored[i].(string); ok {
+			var current model.HistogramData
+			if err := json.Unmarshal([]byte(data), &current); err != nil {
+				return nil, fmt.Errorf("failed to decode histogram %s: %w", metric.ID, err)
+			}
+			if err := current.Merge(metric.Histogram); err != nil {
+				return nil, err
+			}
+			merged = current
+		}
+
+		data, err := json.Marshal(merged)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode histogram %s: %w", metric.ID, err)
+		}
+		encoded[fields[i]] = string(data)
+	}
+
+	return encoded, nil
+}
+
+// counterValues results of counter scripts by series key
+func counterValues(counters map[string]*redis.Cmd) (map[string]int64, error) {
+	values := make(map[string]int64, len(counters))
+	for seriesKey, cmd := range counters {
+		value, err := cmd.Int64()
+		if err != nil {
+			return nil, err
+		}
+		values[seriesKey] = value
+	}
+
+	return values, nil
+}
+
+// GetGauge method to get gauge metric by name
+func (storage *RedisStorage) GetGauge(ctx context.Context, name string, labels model.Labels) (float64, error) {
+	value, err := storage.client.HGet(ctx, redisKey("gauge"), model.SeriesKey(name, labels)).Float64()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrItemNotFound
+	}
+	return value, err
+}
+
+// GetCounter method to get counter metric by name
+func (storage *RedisStorage) GetCounter(ctx context.Context, name string, labels model.Labels) (int64, error) {
+	value, err := storage.client.HGet(ctx, redisKey("counter"), model.SeriesKey(name, labels)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrItemNotFound
+	}
+	return value, err
+}
+
+// GetHistogram method to get histogram metric by name and labels
+func (storage *RedisStorage) GetHistogram(ctx context.Context, name string, labels model.Labels) (model.HistogramData, error) {
+	var histogram model.HistogramData
+
+	data, err := storage.client.HGet(ctx, redisKey("histogram"), model.SeriesKey(name, labels)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return histogram, ErrItemNotFound
+	}
+	if err != nil {
+		return histogram, err
+	}
+
+	if err := json.Unmarshal(data, &histogram); err != nil {
+		return histogram, fmt.Errorf("failed to decode histogram %s: %w", name, err)
+	}
+
+	return histogram, nil
+}
+
+// GetAllGauge method to get all gauge metrics
+func (storage *RedisStorage) GetAllGauge(ctx context.Context) (map[string]float64, error) {
+	values, err := storage.client.HGetAll(ctx, redisKey("gauge")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeHash(values, func(value string) (float64, error) {
+		return strconv.ParseFloat(value, 64)
+	})
+}
+
+// GetAllCounter method to get all counter metrics
+func (storage *RedisStorage) GetAllCounter(ctx context.Context) (map[string]int64, error) {
+	values, err := storage.client.HGetAll(ctx, redisKey("counter")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeHash(values, func(value string) (int64, error) {
+		return strconv.ParseInt(value, 10, 64)
+	})
+}
+
+// GetAllHistogram method to get all histogram metrics
+func (storage *RedisStorage) GetAllHistogram(ctx context.Context) (map[string]model.HistogramData, error) {
+	values, err := storage.client.HGetAll(ctx, redisKey("histogram")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeHash(values, decodeHistogram)
+}
+
+// ExportMetrics method to get all stored series, all hashes are read in one transaction
+func (storage *RedisStorage) ExportMetrics(ctx context.Context) ([]model.Metrics, error) {
+	var series, gauges, counters, histograms *redis.MapStringStringCmd
+
+	_, err := storage.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		series = pipe.HGetAll(ctx, redisKey("series"))
+		gauges = pipe.HGetAll(ctx, redisKey("gauge"))
+		counters = pipe.HGetAll(ctx, redisKey("counter"))
+		histograms = pipe.HGetAll(ctx, redisKey("histogram"))
+		return nil
+	})
+	if err != nil {
+		zap.L().Error("Failed to export metrics", zap.Error(err))
+		return nil, err
+	}
+
+	values := map[string]map[string]string{
+		string(model.Gauge):     gauges.Val(),
+		string(model.Counter):   counters.Val(),
+		string(model.Histogram): histograms.Val(),
+	}
+
+	metrics := make([]model.Metrics, 0, len(series.Val()))
+	for _, data := range series.Val() {
+		var metric model.Metrics
+		if err := json.Unmarshal([]byte(data), &metric); err != nil {
+			return nil, fmt.Errorf("failed to decode series: %w", err)
+		}
+
+		value, ok := values[metric.MType][model.SeriesKey(metric.ID, metric.Labels)]
+		if !ok {
+			continue
+		}
+
+		if err := decodeValue(&metric, value); err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+// GetMetrics method to get values of requested series in one pipeline, missing series are skipped
+func (storage *RedisStorage) GetMetrics(ctx context.Context, metrics []model.Metrics) ([]model.Metrics, error) {
+	if err := checkRequestTypes(metrics); err != nil {
+		return nil, err
+	}
+
+	values := make([]*redis.StringCmd, len(metrics))
+	_, err := storage.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, metric := range metrics {
+			values[i] = pipe.HGet(ctx, redisKey(metric.MType), model.SeriesKey(metric.ID, metric.Labels))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		zap.L().Error("Failed to get metrics", zap.Error(err))
+		return nil, err
+	}
+
+	found := make([]model.Metrics, 0, len(metrics))
+	for i, requested := range metrics {
+		value, err := values[i].Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		metric := model.Metrics{ID: requested.ID, MType: requested.MType, Labels: requested.Labels}
+		if err := decodeValue(&metric, value); err != nil {
+			return nil, err
+		}
+		found = append(found, metric)
+	}
+
+	return found, nil
+}
+
+// QueryMetrics method to get page of series selected by type and name prefix
+func (storage *RedisStorage) QueryMetrics(ctx context.Context, query model.MetricsQuery) ([]model.Metrics, error) {
+	return queryMetrics(ctx, storage, query)
+}
+
+// decodeValue set value of metric from hash field of its type
+func decodeValue(metric *model.Metrics, value string) error {
+	switch metric.MType {
+	case string(model.Gauge):
+		gauge, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("failed to decode gauge %s: %w", metric.ID, err)
+		}
+		metric.Value = &gauge
+	case string(model.Counter):
+		counter, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to decode counter %s: %w", metric.ID, err)
+		}
+		metric.Delta = &counter
+	case string(model.Histogram):
+		histogram, err := decodeHistogram(value)
+		if err != nil {
+			return err
+		}
+		metric.Histogram = &histogram
+	}
+
+	return nil
+}
+
+// Delete method to remove series with its history and rollups in one transaction
+func (storage *RedisStorage) Delete(ctx context.Context, mType model.MetricType, name string, labels model.Labels) error {
+	if err := checkMetricType(mType); err != nil {
+		return err
+	}
+
+	deleted, err := storage.deleteSeries(ctx, []model.Metrics{{ID: name, MType: string(mType), Labels: labels}})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%s metric with name: %s not found %w", mType, model.SeriesKey(name, labels), ErrItemNotFound)
+	}
+
+	return nil
+}
+
+// DeleteByPrefix method to remove series of all types with name starting with prefix in one transaction,
+// series are found by names kept in series hash
+func (storage *RedisStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	index, err := storage.client.HGetAll(ctx, redisKey("series")).Result()
+	if err != nil {
+		zap.L().Error("Failed to list series", zap.Error(err))
+		return 0, err
+	}
+
+	matched := make([]model.Metrics, 0)
+	for _, data := range index {
+		var metric model.Metrics
+		if err := json.Unmarshal([]byte(data), &metric); err != nil {
+			return 0, fmt.Errorf("failed to decode series: %w", err)
+		}
+		if strings.HasPrefix(metric.ID, prefix) {
+			matched = append(matched, metric)
+		}
+	}
+
+	if len(matched) == 0 {
+		return 0, nil
+	}
+
+	return storage.deleteSeries(ctx, matched)
+}
+
+// deleteSeries delete values, index fields, history and rollups of series in one transaction
+// and returns number of series which had value
+func (storage *RedisStorage) deleteSeries(ctx context.Context, metrics []model.Metrics) (int, error) {
+	resolutions, err := storage.client.SMembers(ctx, redisKey("resolutions")).Result()
+	if err != nil {
+		zap.L().Error("Failed to list rollup resolutions", zap.Error(err))
+		return 0, err
+	}
+
+	deleted := make([]*redis.IntCmd, 0, len(metrics))
+	_, err = storage.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, metric := range metrics {
+			mType := model.MetricType(metric.MType)
+			seriesKey := model.SeriesKey(metric.ID, metric.Labels)
+
+			deleted = append(deleted, pipe.HDel(ctx, redisKey(metric.MType), seriesKey))
+			pipe.HDel(ctx, redisKey("series"), metric.MType+":"+seriesKey)
+			pipe.Del(ctx, historyKey(mType, seriesKey))
+			for _, resolution := range resolutions {
+				pipe.Del(ctx, redisKey("rollup:"+resolution+":"+metric.MType+":"+seriesKey))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		zap.L().Error("Failed to delete metrics", zap.Error(err))
+		return 0, err
+	}
+
+	var count int
+	for _, cmd := range deleted {
+		count += int(cmd.Val())
+	}
+
+	return count, nil
+}
+
+// GetHistory method to get samples of gauge or counter series recorded between from and to
+func (storage *RedisStorage) GetHistory(ctx context.Context, mType model.MetricType, name string, labels model.Labels, from time.Time, to time.Time) ([]model.Sample, error) {
+	if mType != model.Gauge && mType != model.Counter {
+		return nil, fmt.Errorf("history is not supported for metric type: %s", mType)
+	}
+
+	members, err := storage.client.ZRangeByScore(ctx, historyKey(mType, model.SeriesKey(name, labels)), &redis.ZRangeBy{
+		Min: strconv.FormatInt(unixNanos(from), 10),
+		Max: strconv.FormatInt(unixNanos(to), 10),
+	}).Result()
+	if err != nil {
+		zap.L().Error("Failed to get metric history", zap.Error(err))
+		return nil, err
+	}
+
+	samples := make([]model.Sample, 0, len(members))
+	for _, member := range members {
+		sample, err := parseSampleMember(member)
+		if err != nil {
+			return nil, err
+		}
+		// Scores are doubles, so boundaries are checked again with exact timestamps
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+
+	return samples, nil
+}
+
+// GetRollups method to get rollups of gauge or counter series with given resolution between from and to
+func (storage *RedisStorage) GetRollups(ctx context.Context, mType model.MetricType, name string, labels model.Labels, resolution time.Duration, from time.Time, to time.Time) ([]model.Rollup, error) {
+	if mType != model.Gauge && mType != model.Counter {
+		return nil, fmt.Errorf("history is not supported for metric type: %s", mType)
+	}
+
+	members, err := storage.client.ZRangeByScore(ctx, rollupKey(resolution, mType, model.SeriesKey(name, labels)), &redis.ZRangeBy{
+		Min: strconv.FormatInt(unixNanos(from), 10),
+		Max: strconv.FormatInt(unixNanos(to), 10),
+	}).Result()
+	if err != nil {
+		zap.L().Error("Failed to get metric rollups", zap.Error(err))
+		return nil, err
+	}
+
+	rollups := make([]model.Rollup, 0, len(members))
+	for _, member := range members {
+		var rollup model.Rollup
+		if err := json.Unmarshal([]byte(member), &rollup); err != nil {
+			return nil, fmt.Errorf("failed to decode rollup: %w", err)
+		}
+		rollups = append(rollups, rollup)
+	}
+
+	return rollups, nil
+}
+
+// Compact method to roll raw samples up into retention tiers and delete expired history.
+// Every series is compacted in its own transaction, so replicas compacting at the same time rebuild the same buckets
+func (storage *RedisStorage) Compact(ctx context.Context, policy RetentionPolicy, now time.Time) error {
+	series, err := storage.client.HKeys(ctx, redisKey("series")).Result()
+	if err != nil {
+		zap.L().Error("Failed to list series", zap.Error(err))
+		return err
+	}
+
+	stored, err := storage.client.SMembers(ctx, redisKey("resolutions")).Result()
+	if err != nil {
+		zap.L().Error("Failed to list rollup resolutions", zap.Error(err))
+		return err
+	}
+
+	// Tiers removed from policy are not kept anymore
+	resolutions := make(map[string]bool, len(policy.Rollups))
+	for _, tier := range policy.Rollups {
+		resolutions[strconv.FormatInt(int64(tier.Resolution/time.Second), 10)] = true
+	}
+	removed := make([]time.Duration, 0)
+	for _, resolution := range stored {
+		if resolutions[resolution] {
+			continue
+		}
+		seconds, err := strconv.ParseInt(resolution, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid rollup resolution %s: %w", resolution, err)
+		}
+		removed = append(removed, time.Duration(seconds)*time.Second)
+	}
+
+	for _, field := range series {
+		mType, seriesKey, _ := strings.Cut(field, ":")
+		if mType != string(model.Gauge) && mType != string(model.Counter) {
+			continue
+		}
+
+		if err := storage.compactSeries(ctx, model.MetricType(mType), seriesKey, policy, removed, now); err != nil {
+			zap.L().Error("Failed to compact metric history", zap.String("series", field), zap.Error(err))
+			return err
+		}
+	}
+
+	if len(removed) > 0 {
+		members := make([]any, 0, len(removed))
+		for _, resolution := range removed {
+			members = append(members, strconv.FormatInt(int64(resolution/time.Second), 10))
+		}
+		if err := storage.client.SRem(ctx, redisKey("resolutions"), members...).Err(); err != nil {
+			return err
+		}
+	}
+
+	zap.L().Info("Compacted metric history", zap.Time("now", now))
+	return nil
+}
+
+// compactSeries rebuild complete buckets of every tier from raw samples of one series,
+// then delete expired rollups, rollups of {removed} tiers and expired raw samples
+func (storage *RedisStorage) compactSeries(ctx context.Context, mType model.MetricType, seriesKey string, policy RetentionPolicy, removed []time.Duration, now time.Time) error {
+	rebuilt := make([][]model.Rollup, len(policy.Rollups))
+	for i, tier := range policy.Rollups {
+		from, until := rollupRange(policy, tier.Resolution, time.Time{}, now)
+
+		members, err := storage.client.ZRangeByScore(ctx, historyKey(mType, seriesKey), &redis.ZRangeBy{
+			Min: strconv.FormatInt(unixNanos(from), 10),
+			Max: "(" + strconv.FormatInt(unixNanos(until), 10),
+		}).Result()
+		if err != nil {
+			return err
+		}
+
+		samples := make([]model.Sample, 0, len(members))
+		for _, member := range members {
+			sample, err := parseSampleMember(member)
+			if err != nil {
+				return err
+			}
+			samples = append(samples, sample)
+		}
+
+		rebuilt[i] = buildRollups(samples, tier.Resolution, from, until)
+	}
+
+	_, err := storage.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, tier := range policy.Rollups {
+			key := rollupKey(tier.Resolution, mType, seriesKey)
+			from, until := rollupRange(policy, tier.Resolution, time.Time{}, now)
+
+			pipe.ZRemRangeByScore(ctx, key, strconv.FormatInt(unixNanos(from), 10), "("+strconv.FormatInt(unixNanos(until), 10))
+			for _, rollup := range rebuilt[i] {
+				data, err := json.Marshal(rollup)
+				if err != nil {
+					return fmt.Errorf("failed to encode rollup: %w", err)
+				}
+				pipe.ZAdd(ctx, key, redis.Z{Score: float64(unixNanos(rollup.Timestamp)), Member: string(data)})
+			}
+			pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(unixNanos(now.Add(-tier.Retention)), 10))
+			pipe.SAdd(ctx, redisKey("resolutions"), strconv.FormatInt(int64(tier.Resolution/time.Second), 10))
+		}
+
+		for _, resolution := range removed {
+			pipe.Del(ctx, rollupKey(resolution, mType, seriesKey))
+		}
+
+		pipe.ZRemRangeByScore(ctx, historyKey(mType, seriesKey), "-inf", "("+strconv.FormatInt(unixNanos(now.Add(-policy.Raw)), 10))
+		return nil
+	})
+
+	return err
+}
+
+// parseSampleMember decode sample from history sorted set member "nanoseconds:instance-sequence:value"
+func parseSampleMember(member string) (model.Sample, error) {
+	parts := strings.SplitN(member, ":", 3)
+	if len(parts) != 3 {
+		return model.Sample{}, fmt.Errorf("invalid history sample: %s", member)
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return model.Sample{}, fmt.Errorf("invalid history sample timestamp %s: %w", member, err)
+	}
+	value, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return model.Sample{}, fmt.Errorf("invalid history sample value %s: %w", member, err)
+	}
+
+	return model.Sample{Timestamp: time.Unix(0, nanos), Value: value}, nil
+}
+
+// decodeHash decode values of hash fields
+func decodeHash[T any](values map[string]string, decode func(value string) (T, error)) (map[string]T, error) {
+	decoded := make(map[string]T, len(values))
+	for field, value := range values {
+		result, err := decode(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", field, err)
+		}
+		decoded[field] = result
+	}
+
+	return decoded, nil
+}
+
+func decodeHistogram(value string) (model.HistogramData, error) {
+	var histogram model.HistogramData
+	if err := json.Unmarshal([]byte(value), &histogram); err != nil {
+		return histogram, fmt.Errorf("failed to decode histogram: %w", err)
+	}
+	return histogram, nil
+}
+
+func redisKey(name string) string {
+	return redisKeyPrefix + name
+}
+
+// historyKey sorted set of samples of series
+func historyKey(mType model.MetricType, seriesKey string) string {
+	return redisKey("history:" + string(mType) + ":" + seriesKey)
+}
+
+// rollupKey sorted set of rollups of series with given resolution
+func rollupKey(resolution time.Duration, mType model.MetricType, seriesKey string) string {
+	return redisKey("rollup:" + strconv.FormatInt(int64(resolution/time.Second), 10) + ":" + string(mType) + ":" + seriesKey)
+}
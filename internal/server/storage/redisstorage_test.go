@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+// newTestRedisStorage Redis storage connected to in-process miniredis server
+func newTestRedisStorage(t *testing.T) (*RedisStorage, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+
+	storage, err := NewRedisStorage("redis://" + server.Addr())
+	require.NoError(t, err)
+	require.NoError(t, storage.Start(context.Background()))
+
+	t.Cleanup(func() {
+		_ = storage.Stop(context.Background())
+	})
+
+	return storage, server
+}
+
+func TestNewRedisStorage_InvalidDsn(t *testing.T) {
+	_, err := NewRedisStorage("postgres://localhost/metrics")
+	assert.ErrorIs(t, err, ErrInvalidRedisDsn)
+}
+
+func TestRedisStorage_GaugeAndCounter(t *testing.T) {
+	storage, server := newTestRedisStorage(t)
+	ctx := context.Background()
+	labels := model.Labels{"host": "a"}
+
+	_, err := storage.GetGauge(ctx, "Alloc", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 1.5))
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", labels, 7))
+
+	gauge, err := storage.GetGauge(ctx, "Alloc", labels)
+	assert.NoError(t, err)
+	assert.Equal(t, 7.0, gauge)
+
+	assert.NoError(t, storage.UpdateCounter(ctx, "PollCount", nil, 10))
+	counter, err := storage.UpdateCounterAndReturn(ctx, "PollCount", nil, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), counter)
+
+	// Counters are plain hash fields changed with HINCRBY
+	assert.Equal(t, "15", server.HGet(redisKey("counter"), model.SeriesKey("PollCount", nil)))
+
+	gauges, err := storage.GetAllGauge(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		model.SeriesKey("Alloc", nil):    1.5,
+		model.SeriesKey("Alloc", labels): 7,
+	}, gauges)
+
+	counters, err := storage.GetAllCounter(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{model.SeriesKey("PollCount", nil): 15}, counters)
+
+	samples, err := storage.GetHistory(ctx, model.Counter, "PollCount", nil, time.Now().Add(-time.Minute), time.Now())
+	assert.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, 10.0, samples[0].Value)
+	assert.Equal(t, 15.0, samples[1].Value)
+}
+
+func TestRedisStorage_SharedBetweenReplicas(t *testing.T) {
+	first, server := newTestRedisStorage(t)
+	ctx := context.Background()
+
+	second, err := NewRedisStorage("redis://" + server.Addr())
+	require.NoError(t, err)
+	defer second.Stop(ctx)
+
+	assert.NoError(t, first.UpdateCounter(ctx, "PollCount", nil, 1))
+	assert.NoError(t, second.UpdateCounter(ctx, "PollCount", nil, 2))
+
+	counter, err := first.GetCounter(ctx, "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), counter)
+}
+
+func TestRedisStorage_UpdateHistogram(t *testing.T) {
+	storage, _ := newTestRedisStorage(t)
+	ctx := context.Background()
+
+	histogram := model.HistogramData{Bounds: []float64{1, 10}, Counts: []int64{1, 2, 0}, Sum: 12, Count: 3}
+	assert.NoError(t, storage.UpdateHistogram(ctx, "Latency", nil, histogram))
+	assert.NoError(t, storage.UpdateHistogram(ctx, "Latency", nil, histogram))
+
+	stored, err := storage.GetHistogram(ctx, "Latency", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, model.HistogramData{Bounds: []float64{1, 10}, Counts: []int64{2, 4, 0}, Sum: 24, Count: 6}, stored)
+
+	err = storage.UpdateHistogram(ctx, "Latency", nil, model.HistogramData{Bounds: []float64{5}, Counts: []int64{1, 0}, Sum: 1, Count: 1})
+	assert.ErrorIs(t, err, ErrHistogramBoundsMismatch)
+
+	histograms, err := storage.GetAllHistogram(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]model.HistogramData{model.SeriesKey("Latency", nil): stored}, histograms)
+}
+
+func TestRedisStorage_UpdateMetrics(t *testing.T) {
+	storage, _ := newTestRedisStorage(t)
+	ctx := context.Background()
+
+	err := storage.UpdateHistogram(ctx, "Latency", nil, model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 1, Count: 1})
+	assert.NoError(t, err)
+
+	// Batch with mismatched histogram is not applied at all
+	delta := int64(2)
+	err = storage.UpdateMetrics(ctx, []model.Metrics{
+		{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+		{ID: "Latency", MType: string(model.Histogram), Histogram: &model.HistogramData{Bounds: []float64{5}, Counts: []int64{1, 0}, Sum: 1, Count: 1}},
+	})
+	assert.ErrorIs(t, err, ErrHistogramBoundsMismatch)
+
+	_, err = storage.GetCounter(ctx, "PollCount", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	gauge := 1.5
+	err = storage.UpdateMetrics(ctx, []model.Metrics{
+		{ID: "Alloc", MType: string(model.Gauge), Value: &gauge, Labels: model.Labels{"host": "a"}},
+		{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+		{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+		{ID: "Latency", MType: string(model.Histogram), Histogram: &model.HistogramData{Bounds: []float64{1}, Counts: []int64{0, 1}, Sum: 2, Count: 1}},
+	})
+	assert.NoError(t, err)
+
+	counter, err := storage.GetCounter(ctx, "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), counter)
+
+	metrics, err := storage.ExportMetrics(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []model.Metrics{
+		{ID: "Alloc", MType: string(model.Gauge), Value: &gauge, Labels: model.Labels{"host": "a"}},
+		{ID: "PollCount", MType: string(model.Counter), Delta: &counter},
+		{ID: "Latency", MType: string(model.Histogram), Histogram: &model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 1}, Sum: 3, Count: 2}},
+	}, metrics)
+
+	err = storage.UpdateMetrics(ctx, []model.Metrics{{ID: "PollCount", MType: string(model.Counter)}})
+	assert.ErrorIs(t, err, ErrInvalidMetric)
+}
+
+func TestRedisStorage_Compact(t *testing.T) {
+	storage, _ := newTestRedisStorage(t)
+	ctx := context.Background()
+
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 1))
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 3))
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 5))
+
+	// Compaction in the future rolls all samples up and deletes them as expired
+	now := time.Now().Add(2 * time.Hour).Truncate(time.Hour)
+	policy := RetentionPolicy{Raw: 24 * time.Hour, Rollups: []RetentionTier{{Resolution: time.Hour, Retention: 48 * time.Hour}}}
+	err := storage.Compact(ctx, policy, now)
+	assert.NoError(t, err)
+
+	rollups, err := storage.GetRollups(ctx, model.Gauge, "Alloc", nil, time.Hour, now.Add(-24*time.Hour), now)
+	assert.NoError(t, err)
+	require.NotEmpty(t, rollups)
+
+	var count int64
+	for _, rollup := range rollups {
+		count += rollup.Count
+	}
+	assert.Equal(t, int64(3), count)
+
+	err = storage.Compact(ctx, RetentionPolicy{Raw: time.Nanosecond}, now)
+	assert.NoError(t, err)
+
+	samples, err := storage.GetHistory(ctx, model.Gauge, "Alloc", nil, time.Time{}, now)
+	assert.NoError(t, err)
+	assert.Empty(t, samples)
+
+	rollups, err = storage.GetRollups(ctx, model.Gauge, "Alloc", nil, time.Hour, now.Add(-24*time.Hour), now)
+	assert.NoError(t, err)
+	assert.Empty(t, rollups)
+}
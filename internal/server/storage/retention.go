@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"go.uber.org/zap"
+)
+
+// rawTierName name of raw samples tier in retention policy
+const rawTierName = "raw"
+
+var ErrInvalidRetentionPolicy = errors.New("invalid retention policy")
+
+// RetentionTier rollups with given resolution are kept for Retention
+type RetentionTier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// RetentionPolicy how long raw samples are kept and which rollup tiers are built from them
+type RetentionPolicy struct {
+	Raw     time.Duration
+	Rollups []RetentionTier
+}
+
+// Compactor storage which rolls raw history up into retention tiers and deletes expired data
+type Compactor interface {
+	Compact(ctx context.Context, policy RetentionPolicy, now time.Time) error
+}
+
+// ParseRetentionPolicy parse policy in format "raw:24h,1m:720h,1h:8760h" where every tier is resolution:retention.
+// Raw tier is required, rollup resolutions must be whole seconds and not longer than raw retention,
+// otherwise raw samples are deleted before bucket is complete
+func ParseRetentionPolicy(value string) (RetentionPolicy, error) {
+	var policy RetentionPolicy
+
+	for _, tier := range strings.Split(value, ",") {
+		resolution, retention, ok := strings.Cut(strings.TrimSpace(tier), ":")
+		if !ok {
+			return RetentionPolicy{}, fmt.Errorf("%w: tier %q must be in resolution:retention format", ErrInvalidRetentionPolicy, tier)
+		}
+
+		retentionDuration, err := time.ParseDuration(retention)
+		if err != nil || retentionDuration <= 0 {
+			return RetentionPolicy{}, fmt.Errorf("%w: invalid retention of tier %q", ErrInvalidRetentionPolicy, tier)
+		}
+
+		if resolution == rawTierName {
+			policy.Raw = retentionDuration
+			continue
+		}
+
+		resolutionDuration, err := time.ParseDuration(resolution)
+		if err != nil || resolutionDuration < time.Second || resolutionDuration%time.Second != 0 {
+			return RetentionPolicy{}, fmt.Errorf("%w: invalid resolution of tier %q", ErrInvalidRetentionPolicy, tier)
+		}
+
+		policy.Rollups = append(policy.Rollups, RetentionTier{Resolution: resolutionDuration, Retention: retentionDuration})
+	}
+
+	if policy.Raw == 0 {
+		return RetentionPolicy{}, fmt.Errorf("%w: raw tier is required", ErrInvalidRetentionPolicy)
+	}
+
+	sort.Slice(policy.Rollups, func(i, j int) bool {
+		return policy.Rollups[i].Resolution < policy.Rollups[j].Resolution
+	})
+
+	for i, tier := range policy.Rollups {
+		if i > 0 && policy.Rollups[i-1].Resolution == tier.Resolution {
+			return RetentionPolicy{}, fmt.Errorf("%w: duplicate resolution %s", ErrInvalidRetentionPolicy, tier.Resolution)
+		}
+		if tier.Resolution > policy.Raw {
+			return RetentionPolicy{}, fmt.Errorf("%w: resolution %s is longer than raw retention %s", ErrInvalidRetentionPolicy, tier.Resolution, policy.Raw)
+		}
+	}
+
+	return policy, nil
+}
+
+// CompactorRunner lifecycle of background compaction of metric history
+type CompactorRunner struct {
+	compactor Compactor
+	worker    *worker
+	policy    RetentionPolicy
+	interval  time.Duration
+}
+
+// NewCompactorRunner constructor to create runner compacting storage every {interval}
+func NewCompactorRunner(compactor Compactor, policy RetentionPolicy, interval time.Duration) *CompactorRunner {
+	return &CompactorRunner{compactor: compactor, policy: policy, interval: interval}
+}
+
+// Start method to start background compaction
+func (runner *CompactorRunner) Start(ctx context.Context) error {
+	runner.worker = startWorker(ctx, runner.run)
+	return nil
+}
+
+// Stop method to stop background compaction and wait for running compaction
+func (runner *CompactorRunner) Stop(ctx context.Context) error {
+	if runner.worker == nil {
+		return nil
+	}
+	return runner.worker.stop(ctx)
+}
+
+// run compact storage every interval until context is done
+func (runner *CompactorRunner) run(ctx context.Context) {
+	ticker := time.NewTicker(runner.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := runner.compactor.Compact(ctx, runner.policy, now)
+			if err != nil {
+				zap.L().Error("Failed to compact metric history", zap.Error(err))
+			}
+		}
+	}
+}
+
+// bucketStart start of bucket with given resolution containing t, buckets are aligned to unix epoch
+func bucketStart(t time.Time, resolution time.Duration) time.Time {
+	nanos := t.UnixNano()
+	return time.Unix(0, nanos-nanos%int64(resolution))
+}
+
+// rollupRange range of complete buckets [from, until) which can be rebuilt from raw samples kept at {now}.
+// Bucket containing {truncatedAt}, the newest dropped raw sample, has lost part of its samples and is skipped
+func rollupRange(policy RetentionPolicy, resolution time.Duration, truncatedAt time.Time, now time.Time) (time.Time, time.Time) {
+	from := bucketStart(now.Add(-policy.Raw), resolution)
+	if from.Before(now.Add(-policy.Raw)) {
+		from = from.Add(resolution)
+	}
+
+	if !truncatedAt.IsZero() {
+		afterTruncated := bucketStart(truncatedAt, resolution).Add(resolution)
+		if afterTruncated.After(from) {
+			from = afterTruncated
+		}
+	}
+
+	return from, bucketStart(now, resolution)
+}
+
+// buildRollups aggregate samples ordered by time into buckets of given resolution in [from, until)
+func buildRollups(samples []model.Sample, resolution time.Duration, from time.Time, until time.Time) []model.Rollup {
+	rollups := make([]model.Rollup, 0)
+
+	var current *model.Rollup
+	var sum float64
+
+	for _, sample := range samples {
+		if sample.Timestamp.Before(from) || !sample.Timestamp.Before(until) {
+			continue
+		}
+
+		start := bucketStart(sample.Timestamp, resolution)
+		if current == nil || !current.Timestamp.Equal(start) {
+			if current != nil {
+				current.Avg = sum / float64(current.Count)
+			}
+			rollups = append(rollups, model.Rollup{Timestamp: start, Min: sample.Value, Max: sample.Value})
+			current = &rollups[len(rollups)-1]
+			sum = 0
+		}
+
+		current.Min = min(current.Min, sample.Value)
+		current.Max = max(current.Max, sample.Value)
+		current.Count++
+		sum += sample.Value
+	}
+
+	if current != nil {
+		current.Avg = sum / float64(current.Count)
+	}
+
+	return rollups
+}
+
+// mergeRollups replace buckets of {existing} with rebuilt ones, drop buckets older than {expireBefore}
+// and return result ordered by time
+func mergeRollups(existing []model.Rollup, rebuilt []model.Rollup, expireBefore time.Time) []model.Rollup {
+	byTimestamp := make(map[int64]model.Rollup, len(existing)+len(rebuilt))
+	for _, rollup := range existing {
+		byTimestamp[rollup.Timestamp.UnixNano()] = rollup
+	}
+	for _, rollup := range rebuilt {
+		byTimestamp[rollup.Timestamp.UnixNano()] = rollup
+	}
+
+	result := make([]model.Rollup, 0, len(byTimestamp))
+	for _, rollup := range byTimestamp {
+		if rollup.Timestamp.Before(expireBefore) {
+			continue
+		}
+		result = append(result, rollup)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+
+	return result
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    RetentionPolicy
+		wantErr bool
+	}{
+		{
+			name:  "Positive scenario. Tiers are sorted by resolution",
+			value: "1h:8760h, raw:24h, 1m:720h",
+			want: RetentionPolicy{
+				Raw: 24 * time.Hour,
+				Rollups: []RetentionTier{
+					{Resolution: time.Minute, Retention: 720 * time.Hour},
+					{Resolution: time.Hour, Retention: 8760 * time.Hour},
+				},
+			},
+		},
+		{
+			name:  "Positive scenario. Raw only",
+			value: "raw:1h",
+			want:  RetentionPolicy{Raw: time.Hour},
+		},
+		{name: "Negative scenario. No raw tier", value: "1m:720h", wantErr: true},
+		{name: "Negative scenario. Invalid format", value: "raw", wantErr: true},
+		{name: "Negative scenario. Invalid retention", value: "raw:forever", wantErr: true},
+		{name: "Negative scenario. Sub-second resolution", value: "raw:24h,500ms:1h", wantErr: true},
+		{name: "Negative scenario. Duplicate resolution", value: "raw:24h,1m:1h,60s:2h", wantErr: true},
+		{name: "Negative scenario. Resolution longer than raw retention", value: "raw:1h,1d:720h", wantErr: true},
+		{name: "Negative scenario. Bucket longer than raw retention", value: "raw:1h,2h:720h", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := ParseRetentionPolicy(test.value)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidRetentionPolicy)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, policy)
+		})
+	}
+}
+
+func TestSeriesHistory_Compact(t *testing.T) {
+	policy := RetentionPolicy{
+		Raw:     10 * time.Minute,
+		Rollups: []RetentionTier{{Resolution: time.Minute, Retention: time.Hour}},
+	}
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	history := newSeriesHistory(defaultHistorySize)
+	for i, value := range []float64{1, 3, 5, 10, 20} {
+		history.raw.push(model.Sample{Timestamp: start.Add(time.Duration(i) * 20 * time.Second), Value: value})
+	}
+
+	// Last bucket is not complete yet and only first bucket is rolled up
+	history.compact(policy, start.Add(90*time.Second))
+	assert.Equal(t, []model.Rollup{
+		{Timestamp: start, Min: 1, Max: 5, Avg: 3, Count: 3},
+	}, history.rollupsBetween(time.Minute, start, start.Add(time.Hour)))
+
+	// Next compaction rolls up the second bucket, raw samples expire later and rollups stay
+	history.compact(policy, start.Add(5*time.Minute))
+	history.compact(policy, start.Add(15*time.Minute))
+	assert.Empty(t, history.raw.between(start, start.Add(time.Hour)))
+	assert.Equal(t, []model.Rollup{
+		{Timestamp: start, Min: 1, Max: 5, Avg: 3, Count: 3},
+		{Timestamp: start.Add(time.Minute), Min: 10, Max: 20, Avg: 15, Count: 2},
+	}, history.rollupsBetween(time.Minute, start, start.Add(time.Hour)))
+
+	// Rollups expire
+	history.compact(policy, start.Add(2*time.Hour))
+	assert.Empty(t, history.rollupsBetween(time.Minute, start, start.Add(3*time.Hour)))
+}
+
+func TestSeriesHistory_CompactSkipsTruncatedBucket(t *testing.T) {
+	policy := RetentionPolicy{
+		Raw:     time.Hour,
+		Rollups: []RetentionTier{{Resolution: time.Minute, Retention: time.Hour}},
+	}
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	// Ring keeps only two samples, the first bucket lost its first sample
+	history := newSeriesHistory(2)
+	history.raw.push(model.Sample{Timestamp: start, Value: 1})
+	history.raw.push(model.Sample{Timestamp: start.Add(30 * time.Second), Value: 2})
+	history.raw.push(model.Sample{Timestamp: start.Add(70 * time.Second), Value: 3})
+
+	history.compact(policy, start.Add(3*time.Minute))
+	assert.Equal(t, []model.Rollup{
+		{Timestamp: start.Add(time.Minute), Min: 3, Max: 3, Avg: 3, Count: 1},
+	}, history.rollupsBetween(time.Minute, start, start.Add(time.Hour)))
+}
+
+func TestSeriesHistory_PushCompactsBeforeOverwrite(t *testing.T) {
+	policy := RetentionPolicy{
+		Raw:     24 * time.Hour,
+		Rollups: []RetentionTier{{Resolution: time.Minute, Retention: 24 * time.Hour}},
+	}
+	start := time.Unix(1700000000, 0).Truncate(time.Minute)
+
+	// Raw tier keeps a day, but ring holds only four samples, 20 samples every 15 seconds are pushed
+	history := newSeriesHistory(4)
+	for i := 0; i < 20; i++ {
+		history.push(model.Sample{Timestamp: start.Add(time.Duration(i) * 15 * time.Second), Value: float64(i)}, &policy)
+	}
+	assert.Len(t, history.raw.between(start, start.Add(time.Hour)), 4)
+
+	history.compact(policy, start.Add(10*time.Minute))
+
+	// Every bucket is rolled up with all its samples, though most of them were overwritten
+	rollups := history.rollupsBetween(time.Minute, start, start.Add(time.Hour))
+	assert.Len(t, rollups, 5)
+	for i, rollup := range rollups {
+		assert.Equal(t, start.Add(time.Duration(i)*time.Minute), rollup.Timestamp)
+		assert.Equal(t, int64(4), rollup.Count)
+		assert.Equal(t, float64(4*i), rollup.Min)
+		assert.Equal(t, float64(4*i+3), rollup.Max)
+	}
+
+	// Without policy overwritten samples are lost
+	history = newSeriesHistory(4)
+	for i := 0; i < 20; i++ {
+		history.push(model.Sample{Timestamp: start.Add(time.Duration(i) * 15 * time.Second), Value: float64(i)}, nil)
+	}
+	history.compact(policy, start.Add(10*time.Minute))
+	assert.Len(t, history.rollupsBetween(time.Minute, start, start.Add(time.Hour)), 1)
+}
+
+func TestMemStorage_Compact(t *testing.T) {
+	storage := NewMemStorage()
+	policy := RetentionPolicy{
+		Raw:     time.Hour,
+		Rollups: []RetentionTier{{Resolution: time.Minute, Retention: 24 * time.Hour}},
+	}
+
+	err := storage.UpdateGauge(context.Background(), "HeapAlloc", nil, 2)
+	assert.NoError(t, err)
+	err = storage.UpdateCounter(context.Background(), "PollCount", nil, 5)
+	assert.NoError(t, err)
+
+	now := time.Now().Add(2 * time.Minute)
+	err = storage.Compact(context.Background(), policy, now)
+	assert.NoError(t, err)
+
+	rollups, err := storage.GetRollups(context.Background(), model.Gauge, "HeapAlloc", nil, time.Minute, now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	assert.Len(t, rollups, 1)
+	assert.Equal(t, 2.0, rollups[0].Avg)
+
+	rollups, err = storage.GetRollups(context.Background(), model.Counter, "PollCount", nil, time.Minute, now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	assert.Len(t, rollups, 1)
+	assert.Equal(t, int64(1), rollups[0].Count)
+
+	// Raw samples are deleted after raw retention
+	err = storage.Compact(context.Background(), policy, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+
+	samples, err := storage.GetHistory(context.Background(), model.Gauge, "HeapAlloc", nil, now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	assert.Empty(t, samples)
+
+	_, err = storage.GetRollups(context.Background(), model.Histogram, "Latency", nil, time.Minute, now, now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,735 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"go.uber.org/zap"
+
+	// Pure Go SQLite driver registered as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteScheme prefix of database DSN which selects SQLite storage, the rest of DSN is path to database file
+const SQLiteScheme = "sqlite://"
+
+// sqlitePragmas applied to every connection: wait for lock instead of failing with SQLITE_BUSY,
+// write-ahead log lets readers of other processes work while server writes
+const sqlitePragmas = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+
+// sqliteMigrationsFS migrations of SQLite schema, kept apart from PostgreSQL ones because of different column types
+//
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrationsFS embed.FS
+
+var ErrInvalidSQLiteDsn = errors.New("invalid SQLite DSN")
+
+// SQLiteStorage storage in SQLite database file with the same schema as DBStorage.
+// Labels, histogram bounds and counts are stored as JSON text, timestamps as unix nanoseconds
+type SQLiteStorage struct {
+	DB *sql.DB
+}
+
+// IsSQLiteDsn check that database DSN selects SQLite storage
+func IsSQLiteDsn(dsn string) bool {
+	return strings.HasPrefix(dsn, SQLiteScheme)
+}
+
+// NewSQLiteStorage method to open SQLite database by DSN sqlite://path, file is created on first use.
+// Database is used through one connection: SQLite allows one writer at a time, so updates wait in pool instead of failing
+func NewSQLiteStorage(dsn string) (*SQLiteStorage, error) {
+	path := strings.TrimPrefix(dsn, SQLiteScheme)
+	if !IsSQLiteDsn(dsn) || path == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSQLiteDsn, dsn)
+	}
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	db, err := sql.Open("sqlite", path+separator+sqlitePragmas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	zap.L().Info("Opened SQLite database", zap.String("path", path))
+
+	return &SQLiteStorage{DB: db}, nil
+}
+
+// Start method to run migrations before storage is used
+func (storage *SQLiteStorage) Start(ctx context.Context) error {
+	return storage.MigrateUp(ctx)
+}
+
+// Stop method to close database, queries in progress are finished first
+func (storage *SQLiteStorage) Stop(_ context.Context) error {
+	if err := storage.DB.Close(); err != nil {
+		return fmt.Errorf("could not close database: %w", err)
+	}
+
+	zap.L().Info("SQLite database closed")
+	return nil
+}
+
+// Ping verifies database file can be opened
+func (storage *SQLiteStorage) Ping(ctx context.Context) error {
+	return storage.DB.PingContext(ctx)
+}
+
+// MigrateUp method to apply all SQLite migrations which are not applied yet
+func (storage *SQLiteStorage) MigrateUp(ctx context.Context) error {
+	source, err := iofs.New(sqliteMigrationsFS, "migrations/sqlite")
+	if err != nil {
+		return fmt.Errorf("%w: failed to open embedded migrations: %w", ErrMigrationsFailed, err)
+	}
+	defer source.Close()
+
+	if err := storage.Ping(ctx); err != nil {
+		return fmt.Errorf("%w: %w", ErrMigrationsFailed, err)
+	}
+
+	driver, err := sqlite.WithInstance(storage.DB, &sqlite.Config{})
+	if err != nil {
+		return fmt.Errorf("%w: failed to create migration driver: %w", ErrMigrationsFailed, err)
+	}
+
+	// Migrate instance is not closed, because closing SQLite driver closes database
+	migration, err := migrate.NewWithInstance("iofs", source, "sqlite", driver)
+	if err != nil {
+		return fmt.Errorf("%w: failed to create migrate instance: %w", ErrMigrationsFailed, err)
+	}
+
+	err = migration.Up()
+	if errors.Is(err, migrate.ErrNoChange) {
+		zap.L().Info("No migrations to run")
+		return nil
+	}
+	if err != nil {
+		zap.L().Error("Failed to run migrations", zap.Error(err))
+		return fmt.Errorf("%w: %w", ErrMigrationsFailed, err)
+	}
+
+	zap.L().Info("Successfully ran migrations")
+	return nil
+}
+
+const (
+	sqliteUpsertGaugeQuery = `
+		INSERT INTO gauge (name, labels, value) VALUES (?, ?, ?)
+		ON CONFLICT (name, labels) DO UPDATE SET value = excluded.value;
+	`
+	sqliteUpsertCounterQuery = `
+		INSERT INTO counter (name, labels, value) VALUES (?, ?, ?)
+		ON CONFLICT (name, labels) DO UPDATE SET value = counter.value + excluded.value
+		RETURNING value;
+	`
+	sqliteInsertHistoryQuery = `
+		INSERT INTO metric_history (type, name, labels, value, created_at) VALUES (?, ?, ?, ?, ?);
+	`
+)
+
+// UpdateGauge method to update gauge metric
+func (storage *SQLiteStorage) UpdateGauge(ctx context.Context, name string, labels model.Labels, metric float64) error {
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	return storage.inTransaction(ctx, func(tx *sql.Tx) error {
+		return sqliteUpdateGauge(ctx, tx, name, encodedLabels, metric, time.Now())
+	})
+}
+
+// UpdateCounter method to update counter metric
+func (storage *SQLiteStorage) UpdateCounter(ctx context.Context, name string, labels model.Labels, metric int64) error {
+	_, err := storage.UpdateCounterAndReturn(ctx, name, labels, metric)
+	return err
+}
+
+// UpdateCounterAndReturn method to update counter metric and return updated value
+func (storage *SQLiteStorage) UpdateCounterAndReturn(ctx context.Context, name string, labels model.Labels, metric int64) (int64, error) {
+	var value int64
+
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return 0, err
+	}
+
+	err = storage.inTransaction(ctx, func(tx *sql.Tx) error {
+		value, err = sqliteUpdateCounter(ctx, tx, name, encodedLabels, metric, time.Now())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// UpdateHistogram method to merge observations into histogram metric
+func (storage *SQLiteStorage) UpdateHistogram(ctx context.Context, name string, labels model.Labels, metric model.HistogramData) error {
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	return storage.inTransaction(ctx, func(tx *sql.Tx) error {
+		return sqliteUpdateHistogram(ctx, tx, name, encodedLabels, &metric)
+	})
+}
+
+// UpdateMetrics method to update batch of different types of metrics in one transaction.
+// Batch is validated and merged by series first, transaction is rolled back entirely on any error
+func (storage *SQLiteStorage) UpdateMetrics(ctx context.Context, metrics []model.Metrics) error {
+	if err := ValidateMetrics(metrics); err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	merged, err := mergeMetrics(metrics)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return storage.inTransaction(ctx, func(tx *sql.Tx) error {
+		for _, metric := range merged {
+			encodedLabels, err := labelsToJSON(metric.Labels)
+			if err != nil {
+				return err
+			}
+
+			switch metric.MType {
+			case string(model.Gauge):
+				err = sqliteUpdateGauge(ctx, tx, metric.ID, encodedLabels, *metric.Value, now)
+			case string(model.Counter):
+				_, err = sqliteUpdateCounter(ctx, tx, metric.ID, encodedLabels, *metric.Delta, now)
+			case string(model.Histogram):
+				err = sqliteUpdateHistogram(ctx, tx, metric.ID, encodedLabels, metric.Histogram)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// sqliteHistoryTables tables with samples and rollups of series
+var sqliteHistoryTables = []string{"metric_history", "metric_history_rollup"}
+
+// Delete method to remove series with its history and rollups in one transaction
+func (storage *SQLiteStorage) Delete(ctx context.Context, mType model.MetricType, name string, labels model.Labels) error {
+	if err := checkMetricType(mType); err != nil {
+		return err
+	}
+
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	return storage.inTransaction(ctx, func(tx *sql.Tx) error {
+		// Table name is one of known metric types
+		result, err := tx.ExecContext(ctx, `DELETE FROM `+string(mType)+` WHERE name = ? AND labels = ?;`, name, encodedLabels)
+		if err != nil {
+			zap.L().Error("Failed to delete metric", zap.Error(err))
+			return err
+		}
+
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return fmt.Errorf("%s metric with name: %s not found %w", mType, model.SeriesKey(name, labels), ErrItemNotFound)
+		}
+
+		for _, table := range sqliteHistoryTables {
+			_, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE type = ? AND name = ? AND labels = ?;`, string(mType), name, encodedLabels)
+			if err != nil {
+				zap.L().Error("Failed to delete metric history", zap.String("table", table), zap.Error(err))
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// DeleteByPrefix method to remove series of all types with name starting with prefix in one transaction
+func (storage *SQLiteStorage) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	var deleted int64
+
+	// LIKE would treat % and _ of prefix as wildcards
+	const condition = ` WHERE substr(name, 1, length(?)) = ?;`
+
+	err := storage.inTransaction(ctx, func(tx *sql.Tx) error {
+		for _, mType := range []model.MetricType{model.Gauge, model.Counter, model.Histogram} {
+			result, err := tx.ExecContext(ctx, `DELETE FROM `+string(mType)+condition, prefix, prefix)
+			if err != nil {
+				zap.L().Error("Failed to delete metrics by prefix", zap.String("type", string(mType)), zap.Error(err))
+				return err
+			}
+
+			rows, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			deleted += rows
+		}
+
+		for _, table := range sqliteHistoryTables {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+condition, prefix, prefix); err != nil {
+				zap.L().Error("Failed to delete metric history by prefix", zap.String("table", table), zap.Error(err))
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(deleted), nil
+}
+
+// inTransaction run {update} in transaction, commit it on success and roll it back on error
+func (storage *SQLiteStorage) inTransaction(ctx context.Context, update func(tx *sql.Tx) error) error {
+	tx, err := storage.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := update(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			zap.L().Error("Failed to rollback transaction", zap.Error(rollbackErr))
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		zap.L().Error("Failed to commit transaction", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func sqliteUpdateGauge(ctx context.Context, tx *sql.Tx, name string, encodedLabels string, value float64, now time.Time) error {
+	if _, err := tx.ExecContext(ctx, sqliteUpsertGaugeQuery, name, encodedLabels, value); err != nil {
+		zap.L().Error("Failed to update gauge metric", zap.Error(err))
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, sqliteInsertHistoryQuery, string(model.Gauge), name, encodedLabels, value, now.UnixNano()); err != nil {
+		zap.L().Error("Failed to record gauge metric", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func sqliteUpdateCounter(ctx context.Context, tx *sql.Tx, name string, encodedLabels string, delta int64, now time.Time) (int64, error) {
+	var value int64
+	if err := tx.QueryRowContext(ctx, sqliteUpsertCounterQuery, name, encodedLabels, delta).Scan(&value); err != nil {
+		zap.L().Error("Failed to update counter metric", zap.Error(err))
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, sqliteInsertHistoryQuery, string(model.Counter), name, encodedLabels, value, now.UnixNano()); err != nil {
+		zap.L().Error("Failed to record counter metric", zap.Error(err))
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// sqliteUpdateHistogram merge observations into stored histogram, bucket bounds must be equal
+func sqliteUpdateHistogram(ctx context.Context, tx *sql.Tx, name string, encodedLabels string, metric *model.HistogramData) error {
+	var stored model.HistogramData
+	row := tx.QueryRowContext(ctx, `SELECT bounds, counts, sum, count FROM histogram WHERE name = ? AND labels = ?`, name, encodedLabels)
+	err := row.Scan(jsonColumn{&stored.Bounds}, jsonColumn{&stored.Counts}, &stored.Sum, &stored.Count)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		stored = metric.Clone()
+	case err != nil:
+		zap.L().Error("Failed to select histogram metric", zap.Error(err))
+		return err
+	default:
+		if err := stored.Merge(metric); err != nil {
+			return err
+		}
+	}
+
+	bounds, err := json.Marshal(stored.Bounds)
+	if err != nil {
+		return fmt.Errorf("failed to encode histogram bounds: %w", err)
+	}
+	counts, err := json.Marshal(stored.Counts)
+	if err != nil {
+		return fmt.Errorf("failed to encode histogram counts: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO histogram (name, labels, bounds, counts, sum, count) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name, labels) DO UPDATE SET counts = excluded.counts, sum = excluded.sum, count = excluded.count;
+	`, name, encodedLabels, string(bounds), string(counts), stored.Sum, stored.Count)
+	if err != nil {
+		zap.L().Error("Failed to update histogram metric", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetGauge method to get gauge metric by name
+func (storage *SQLiteStorage) GetGauge(ctx context.Context, name string, labels model.Labels) (float64, error) {
+	var value float64
+	err := storage.getValue(ctx, `SELECT value FROM gauge WHERE name = ? AND labels = ?`, name, labels, &value)
+	return value, err
+}
+
+// GetCounter method to get counter metric by name
+func (storage *SQLiteStorage) GetCounter(ctx context.Context, name string, labels model.Labels) (int64, error) {
+	var value int64
+	err := storage.getValue(ctx, `SELECT value FROM counter WHERE name = ? AND labels = ?`, name, labels, &value)
+	return value, err
+}
+
+// GetHistogram method to get histogram metric by name and labels
+func (storage *SQLiteStorage) GetHistogram(ctx context.Context, name string, labels model.Labels) (model.HistogramData, error) {
+	var histogram model.HistogramData
+	err := storage.getValue(ctx, `SELECT bounds, counts, sum, count FROM histogram WHERE name = ? AND labels = ?`, name, labels,
+		jsonColumn{&histogram.Bounds}, jsonColumn{&histogram.Counts}, &histogram.Sum, &histogram.Count)
+	return histogram, err
+}
+
+// getValue scan row of one series selected by name and labels, missing row returns ErrItemNotFound
+func (storage *SQLiteStorage) getValue(ctx context.Context, query string, name string, labels model.Labels, dest ...any) error {
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return err
+	}
+
+	err = storage.DB.QueryRowContext(ctx, query, name, encodedLabels).Scan(dest...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrItemNotFound
+	}
+	if err != nil {
+		zap.L().Error("Failed to select metric", zap.String("name", name), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// GetAllGauge method to get all gauge metrics
+func (storage *SQLiteStorage) GetAllGauge(ctx context.Context) (map[string]float64, error) {
+	metrics, err := storage.exportType(ctx, model.Gauge)
+	if err != nil {
+		return nil, err
+	}
+
+	gaugeMetrics := make(map[string]float64, len(metrics))
+	for _, metric := range metrics {
+		gaugeMetrics[model.SeriesKey(metric.ID, metric.Labels)] = *metric.Value
+	}
+
+	return gaugeMetrics, nil
+}
+
+// GetAllCounter method to get all counter metrics
+func (storage *SQLiteStorage) GetAllCounter(ctx context.Context) (map[string]int64, error) {
+	metrics, err := storage.exportType(ctx, model.Counter)
+	if err != nil {
+		return nil, err
+	}
+
+	counterMetrics := make(map[string]int64, len(metrics))
+	for _, metric := range metrics {
+		counterMetrics[model.SeriesKey(metric.ID, metric.Labels)] = *metric.Delta
+	}
+
+	return counterMetrics, nil
+}
+
+// GetAllHistogram method to get all histogram metrics
+func (storage *SQLiteStorage) GetAllHistogram(ctx context.Context) (map[string]model.HistogramData, error) {
+	metrics, err := storage.exportType(ctx, model.Histogram)
+	if err != nil {
+		return nil, err
+	}
+
+	histogramMetrics := make(map[string]model.HistogramData, len(metrics))
+	for _, metric := range metrics {
+		histogramMetrics[model.SeriesKey(metric.ID, metric.Labels)] = *metric.Histogram
+	}
+
+	return histogramMetrics, nil
+}
+
+// GetMetrics method to get values of requested series, missing series are skipped
+func (storage *SQLiteStorage) GetMetrics(ctx context.Context, metrics []model.Metrics) ([]model.Metrics, error) {
+	return getMetrics(ctx, storage, metrics)
+}
+
+// QueryMetrics method to get page of series selected by type and name prefix
+func (storage *SQLiteStorage) QueryMetrics(ctx context.Context, query model.MetricsQuery) ([]model.Metrics, error) {
+	return queryMetrics(ctx, storage, query)
+}
+
+// ExportMetrics method to get all stored series, database without migrations gives ErrSchemaMissing
+func (storage *SQLiteStorage) ExportMetrics(ctx context.Context) ([]model.Metrics, error) {
+	metrics := make([]model.Metrics, 0)
+
+	for _, mType := range []model.MetricType{model.Gauge, model.Counter, model.Histogram} {
+		exported, err := storage.exportType(ctx, mType)
+		if err != nil && strings.Contains(err.Error(), "no such table") {
+			return nil, fmt.Errorf("%w: %w", ErrSchemaMissing, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, exported...)
+	}
+
+	return metrics, nil
+}
+
+// exportType get all stored series of one metric type
+func (storage *SQLiteStorage) exportType(ctx context.Context, mType model.MetricType) ([]model.Metrics, error) {
+	var query string
+	var values func(metric *model.Metrics) []any
+
+	switch mType {
+	case model.Gauge:
+		query = `SELECT name, labels, value FROM gauge`
+		values = func(metric *model.Metrics) []any {
+			metric.MType = string(model.Gauge)
+			metric.Value = new(float64)
+			return []any{metric.Value}
+		}
+	case model.Counter:
+		query = `SELECT name, labels, value FROM counter`
+		values = func(metric *model.Metrics) []any {
+			metric.MType = string(model.Counter)
+			metric.Delta = new(int64)
+			return []any{metric.Delta}
+		}
+	case model.Histogram:
+		query = `SELECT name, labels, bounds, counts, sum, count FROM histogram`
+		values = func(metric *model.Metrics) []any {
+			metric.MType = string(model.Histogram)
+			metric.Histogram = &model.HistogramData{}
+			return []any{jsonColumn{&metric.Histogram.Bounds}, jsonColumn{&metric.Histogram.Counts},
+				&metric.Histogram.Sum, &metric.Histogram.Count}
+		}
+	default:
+		return nil, fmt.Errorf("unknown metric type: %s", mType)
+	}
+
+	rows, err := storage.DB.QueryContext(ctx, query)
+	if err != nil {
+		zap.L().Error("Failed to export metrics", zap.String("type", string(mType)), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanExported(rows, make([]model.Metrics, 0), values)
+}
+
+// GetHistory method to get samples of gauge or counter series recorded between from and to
+func (storage *SQLiteStorage) GetHistory(ctx context.Context, mType model.MetricType, name string, labels model.Labels, from time.Time, to time.Time) ([]model.Sample, error) {
+	if mType != model.Gauge && mType != model.Counter {
+		return nil, fmt.Errorf("history is not supported for metric type: %s", mType)
+	}
+
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := storage.DB.QueryContext(ctx, `
+		SELECT created_at, value FROM metric_history
+		WHERE type = ? AND name = ? AND labels = ? AND created_at BETWEEN ? AND ?
+		ORDER BY created_at, id;
+	`, string(mType), name, encodedLabels, unixNanos(from), unixNanos(to))
+	if err != nil {
+		zap.L().Error("Failed to get metric history", zap.Error(err))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	samples := make([]model.Sample, 0)
+
+	for rows.Next() {
+		var createdAt int64
+		var sample model.Sample
+		if err := rows.Scan(&createdAt, &sample.Value); err != nil {
+			zap.L().Error("Failed to get metric history", zap.Error(err))
+			return nil, err
+		}
+		sample.Timestamp = time.Unix(0, createdAt)
+		samples = append(samples, sample)
+	}
+
+	if err := rows.Err(); err != nil {
+		zap.L().Error("Failed to get metric history", zap.Error(err))
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+// GetRollups method to get rollups of gauge or counter series with given resolution between from and to
+func (storage *SQLiteStorage) GetRollups(ctx context.Context, mType model.MetricType, name string, labels model.Labels, resolution time.Duration, from time.Time, to time.Time) ([]model.Rollup, error) {
+	if mType != model.Gauge && mType != model.Counter {
+		return nil, fmt.Errorf("history is not supported for metric type: %s", mType)
+	}
+
+	encodedLabels, err := labelsToJSON(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := storage.DB.QueryContext(ctx, `
+		SELECT bucket, min, max, avg, count FROM metric_history_rollup
+		WHERE type = ? AND name = ? AND labels = ? AND resolution = ? AND bucket BETWEEN ? AND ?
+		ORDER BY bucket;
+	`, string(mType), name, encodedLabels, int64(resolution/time.Second), unixNanos(from), unixNanos(to))
+	if err != nil {
+		zap.L().Error("Failed to get metric rollups", zap.Error(err))
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	rollups := make([]model.Rollup, 0)
+
+	for rows.Next() {
+		var bucket int64
+		var rollup model.Rollup
+		if err := rows.Scan(&bucket, &rollup.Min, &rollup.Max, &rollup.Avg, &rollup.Count); err != nil {
+			zap.L().Error("Failed to get metric rollups", zap.Error(err))
+			return nil, err
+		}
+		rollup.Timestamp = time.Unix(0, bucket)
+		rollups = append(rollups, rollup)
+	}
+
+	if err := rows.Err(); err != nil {
+		zap.L().Error("Failed to get metric rollups", zap.Error(err))
+		return nil, err
+	}
+
+	return rollups, nil
+}
+
+// sqliteRollupHistoryQuery rebuilds buckets of one resolution from raw samples in [from, until),
+// arguments are resolution in seconds, resolution in nanoseconds, from and until
+const sqliteRollupHistoryQuery = `
+	INSERT INTO metric_history_rollup (type, name, labels, resolution, bucket, min, max, avg, count)
+	SELECT type, name, labels, ?, created_at - created_at % ? AS bucket, min(value), max(value), avg(value), count(*)
+	FROM metric_history
+	WHERE created_at >= ? AND created_at < ?
+	GROUP BY type, name, labels, bucket
+	ON CONFLICT (type, name, labels, resolution, bucket) DO UPDATE
+	SET min = excluded.min, max = excluded.max, avg = excluded.avg, count = excluded.count;
+`
+
+// Compact method to roll raw samples up into retention tiers and delete expired history in one transaction
+func (storage *SQLiteStorage) Compact(ctx context.Context, policy RetentionPolicy, now time.Time) error {
+	err := storage.inTransaction(ctx, func(tx *sql.Tx) error {
+		resolutions := make([]any, 0, len(policy.Rollups))
+
+		for _, tier := range policy.Rollups {
+			resolution := int64(tier.Resolution / time.Second)
+			resolutions = append(resolutions, resolution)
+
+			from, until := rollupRange(policy, tier.Resolution, time.Time{}, now)
+			_, err := tx.ExecContext(ctx, sqliteRollupHistoryQuery, resolution, int64(tier.Resolution), unixNanos(from), unixNanos(until))
+			if err != nil {
+				zap.L().Error("Failed to roll metric history up", zap.Duration("resolution", tier.Resolution), zap.Error(err))
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, `DELETE FROM metric_history_rollup WHERE resolution = ? AND bucket < ?;`,
+				resolution, unixNanos(now.Add(-tier.Retention)))
+			if err != nil {
+				zap.L().Error("Failed to delete expired rollups", zap.Duration("resolution", tier.Resolution), zap.Error(err))
+				return err
+			}
+		}
+
+		// Tiers removed from policy are not kept anymore
+		deleteRemovedTiers := `DELETE FROM metric_history_rollup`
+		if len(resolutions) > 0 {
+			deleteRemovedTiers += ` WHERE resolution NOT IN (?` + strings.Repeat(", ?", len(resolutions)-1) + `)`
+		}
+		if _, err := tx.ExecContext(ctx, deleteRemovedTiers, resolutions...); err != nil {
+			zap.L().Error("Failed to delete rollups of removed tiers", zap.Error(err))
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM metric_history WHERE created_at < ?;`, unixNanos(now.Add(-policy.Raw))); err != nil {
+			zap.L().Error("Failed to delete expired metric history", zap.Error(err))
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	zap.L().Info("Compacted metric history", zap.Time("now", now))
+	return nil
+}
+
+// unixNanos timestamp column value of {t}, times out of int64 nanoseconds range are clamped
+func unixNanos(t time.Time) int64 {
+	switch {
+	case t.Before(time.Unix(0, math.MinInt64)):
+		return math.MinInt64
+	case t.After(time.Unix(0, math.MaxInt64)):
+		return math.MaxInt64
+	default:
+		return t.UnixNano()
+	}
+}
+
+// jsonColumn scanner of TEXT column with JSON encoded value
+type jsonColumn struct {
+	value any
+}
+
+func (column jsonColumn) Scan(src any) error {
+	switch data := src.(type) {
+	case string:
+		return json.Unmarshal([]byte(data), column.value)
+	case []byte:
+		return json.Unmarshal(data, column.value)
+	default:
+		return fmt.Errorf("unsupported JSON column type: %T", src)
+	}
+}
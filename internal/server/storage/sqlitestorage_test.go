@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+// newTestSQLiteStorage migrated SQLite storage in temporary directory, closed when test ends
+func newTestSQLiteStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+
+	storage, err := NewSQLiteStorage(SQLiteScheme + filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	require.NoError(t, storage.Start(context.Background()))
+
+	t.Cleanup(func() {
+		assert.NoError(t, storage.Stop(context.Background()))
+	})
+
+	return storage
+}
+
+func TestNewSQLiteStorage_InvalidDsn(t *testing.T) {
+	_, err := NewSQLiteStorage("sqlite://")
+	assert.ErrorIs(t, err, ErrInvalidSQLiteDsn)
+
+	_, err = NewSQLiteStorage("postgres://localhost/metrics")
+	assert.ErrorIs(t, err, ErrInvalidSQLiteDsn)
+}
+
+func TestSQLiteStorage_MigrateUp_Twice(t *testing.T) {
+	storage := newTestSQLiteStorage(t)
+
+	err := storage.MigrateUp(context.Background())
+	assert.NoError(t, err)
+
+	err = storage.Ping(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestSQLiteStorage_GaugeAndCounter(t *testing.T) {
+	storage := newTestSQLiteStorage(t)
+	ctx := context.Background()
+	labels := model.Labels{"host": "a"}
+
+	_, err := storage.GetGauge(ctx, "Alloc", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 1.5))
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 2.5))
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", labels, 7))
+
+	gauge, err := storage.GetGauge(ctx, "Alloc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.5, gauge)
+
+	gauge, err = storage.GetGauge(ctx, "Alloc", labels)
+	assert.NoError(t, err)
+	assert.Equal(t, 7.0, gauge)
+
+	assert.NoError(t, storage.UpdateCounter(ctx, "PollCount", nil, 10))
+	counter, err := storage.UpdateCounterAndReturn(ctx, "PollCount", nil, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), counter)
+
+	counter, err = storage.GetCounter(ctx, "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(15), counter)
+
+	gauges, err := storage.GetAllGauge(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		model.SeriesKey("Alloc", nil):    2.5,
+		model.SeriesKey("Alloc", labels): 7,
+	}, gauges)
+
+	counters, err := storage.GetAllCounter(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{model.SeriesKey("PollCount", nil): 15}, counters)
+}
+
+func TestSQLiteStorage_UpdateHistogram(t *testing.T) {
+	storage := newTestSQLiteStorage(t)
+	ctx := context.Background()
+
+	histogram := model.HistogramData{Bounds: []float64{1, 10}, Counts: []int64{1, 2, 0}, Sum: 12, Count: 3}
+	assert.NoError(t, storage.UpdateHistogram(ctx, "Latency", nil, histogram))
+	assert.NoError(t, storage.UpdateHistogram(ctx, "Latency", nil, histogram))
+
+	stored, err := storage.GetHistogram(ctx, "Latency", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, model.HistogramData{Bounds: []float64{1, 10}, Counts: []int64{2, 4, 0}, Sum: 24, Count: 6}, stored)
+
+	mismatched := model.HistogramData{Bounds: []float64{5}, Counts: []int64{1, 0}, Sum: 1, Count: 1}
+	err = storage.UpdateHistogram(ctx, "Latency", nil, mismatched)
+	assert.ErrorIs(t, err, ErrHistogramBoundsMismatch)
+
+	histograms, err := storage.GetAllHistogram(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]model.HistogramData{model.SeriesKey("Latency", nil): stored}, histograms)
+
+	_, err = storage.GetHistogram(ctx, "Unknown", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+}
+
+func TestSQLiteStorage_UpdateMetrics(t *testing.T) {
+	storage := newTestSQLiteStorage(t)
+	ctx := context.Background()
+
+	gauge := 1.5
+	delta := int64(2)
+	err := storage.UpdateMetrics(ctx, []model.Metrics{
+		{ID: "Alloc", MType: string(model.Gauge), Value: &gauge},
+		{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+		{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+		{ID: "Latency", MType: string(model.Histogram), Histogram: &model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 1, Count: 1}},
+	})
+	assert.NoError(t, err)
+
+	counter, err := storage.GetCounter(ctx, "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), counter)
+
+	// One sample per merged series
+	samples, err := storage.GetHistory(ctx, model.Counter, "PollCount", nil, time.Now().Add(-time.Minute), time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, samples, 1)
+	assert.Equal(t, 4.0, samples[0].Value)
+
+	metrics, err := storage.ExportMetrics(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 3)
+}
+
+func TestSQLiteStorage_UpdateMetrics_Rollback(t *testing.T) {
+	storage := newTestSQLiteStorage(t)
+	ctx := context.Background()
+
+	err := storage.UpdateHistogram(ctx, "Latency", nil, model.HistogramData{Bounds: []float64{1}, Counts: []int64{1, 0}, Sum: 1, Count: 1})
+	assert.NoError(t, err)
+
+	delta := int64(2)
+	err = storage.UpdateMetrics(ctx, []model.Metrics{
+		{ID: "PollCount", MType: string(model.Counter), Delta: &delta},
+		{ID: "Latency", MType: string(model.Histogram), Histogram: &model.HistogramData{Bounds: []float64{5}, Counts: []int64{1, 0}, Sum: 1, Count: 1}},
+	})
+	assert.ErrorIs(t, err, ErrHistogramBoundsMismatch)
+
+	_, err = storage.GetCounter(ctx, "PollCount", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	err = storage.UpdateMetrics(ctx, []model.Metrics{{ID: "PollCount", MType: string(model.Counter)}})
+	assert.ErrorIs(t, err, ErrInvalidMetric)
+}
+
+func TestSQLiteStorage_GetHistory(t *testing.T) {
+	storage := newTestSQLiteStorage(t)
+	ctx := context.Background()
+
+	_, err := storage.GetHistory(ctx, model.Histogram, "Latency", nil, time.Time{}, time.Now())
+	assert.Error(t, err)
+
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 1))
+	assert.NoError(t, storage.UpdateGauge(ctx, "Alloc", nil, 2))
+
+	samples, err := storage.GetHistory(ctx, model.Gauge, "Alloc", nil, time.Time{}, time.Now())
+	assert.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, 1.0, samples[0].Value)
+	assert.Equal(t, 2.0, samples[1].Value)
+	assert.False(t, samples[1].Timestamp.Before(samples[0].Timestamp))
+}
+
+func TestSQLiteStorage_Compact(t *testing.T) {
+	storage := newTestSQLiteStorage(t)
+	ctx := context.Background()
+
+	now := time.Now().Truncate(time.Minute)
+	for i, value := range []float64{1, 3, 5} {
+		_, err := storage.DB.ExecContext(ctx, sqliteInsertHistoryQuery, string(model.Gauge), "Alloc", "{}", value,
+			now.Add(-2*time.Hour+time.Duration(i)*time.Second).UnixNano())
+		require.NoError(t, err)
+	}
+
+	policy := RetentionPolicy{Raw: time.Hour, Rollups: []RetentionTier{{Resolution: time.Minute, Retention: 24 * time.Hour}}}
+
+	// Samples older than raw retention can not be rolled up anymore and are deleted
+	err := storage.Compact(ctx, policy, now)
+	assert.NoError(t, err)
+
+	samples, err := storage.GetHistory(ctx, model.Gauge, "Alloc", nil, time.Time{}, now)
+	assert.NoError(t, err)
+	assert.Empty(t, samples)
+
+	for i, value := range []float64{1, 3, 5} {
+		_, err := storage.DB.ExecContext(ctx, sqliteInsertHistoryQuery, string(model.Gauge), "Alloc", "{}", value,
+			now.Add(-30*time.Minute+time.Duration(i)*time.Second).UnixNano())
+		require.NoError(t, err)
+	}
+
+	err = storage.Compact(ctx, policy, now)
+	assert.NoError(t, err)
+
+	rollups, err := storage.GetRollups(ctx, model.Gauge, "Alloc", nil, time.Minute, now.Add(-time.Hour), now)
+	assert.NoError(t, err)
+	require.Len(t, rollups, 1)
+	assert.Equal(t, model.Rollup{Timestamp: time.Unix(0, now.Add(-30*time.Minute).UnixNano()), Min: 1, Max: 5, Avg: 3, Count: 3}, rollups[0])
+}
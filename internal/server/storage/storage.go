@@ -2,22 +2,309 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/zavtra-na-rabotu/gometrics/internal/model"
 )
 
-// Storage interface for all types of storages
+// Storage interface for all types of storages.
+// One series is identified by metric name and labels, nil labels mean metric without dimensions.
+// GetAllGauge, GetAllCounter and GetAllHistogram return metrics keyed by model.SeriesKey.
+// UpdateHistogram merges observations into stored histogram, bucket bounds must match.
+// Every accepted gauge and counter update is recorded as timestamped sample available through GetHistory,
+// rollups built by Compactor are available through GetRollups.
+// Delete and DeleteByPrefix remove series together with its history and rollups
 type Storage interface {
-	UpdateGauge(name string, metric float64) error
-	UpdateCounter(name string, metric int64) error
-	UpdateCounterAndReturn(name string, metric int64) (int64, error)
-	GetGauge(name string) (float64, error)
-	GetCounter(name string) (int64, error)
-	GetAllGauge() (map[string]float64, error)
-	GetAllCounter() (map[string]int64, error)
+	UpdateGauge(ctx context.Context, name string, labels model.Labels, metric float64) error
+	UpdateCounter(ctx context.Context, name string, labels model.Labels, metric int64) error
+	UpdateCounterAndReturn(ctx context.Context, name string, labels model.Labels, metric int64) (int64, error)
+	GetGauge(ctx context.Context, name string, labels model.Labels) (float64, error)
+	GetCounter(ctx context.Context, name string, labels model.Labels) (int64, error)
+	GetAllGauge(ctx context.Context) (map[string]float64, error)
+	GetAllCounter(ctx context.Context) (map[string]int64, error)
+	UpdateHistogram(ctx context.Context, name string, labels model.Labels, metric model.HistogramData) error
+	GetHistogram(ctx context.Context, name string, labels model.Labels) (model.HistogramData, error)
+	GetAllHistogram(ctx context.Context) (map[string]model.HistogramData, error)
+	GetHistory(ctx context.Context, mType model.MetricType, name string, labels model.Labels, from time.Time, to time.Time) ([]model.Sample, error)
+	GetRollups(ctx context.Context, mType model.MetricType, name string, labels model.Labels, resolution time.Duration, from time.Time, to time.Time) ([]model.Rollup, error)
 
-	UpdateMetrics([]model.Metrics) error
+	// UpdateMetrics applies batch atomically: invalid batch (ErrInvalidMetric) or failed update leaves storage unchanged
+	UpdateMetrics(ctx context.Context, metrics []model.Metrics) error
+
+	// GetMetrics returns values of requested series (id, type and labels) in request order, missing series are skipped
+	GetMetrics(ctx context.Context, metrics []model.Metrics) ([]model.Metrics, error)
+	// QueryMetrics returns series selected by type and name prefix ordered by name, type and labels,
+	// page starts after query.After and has at most query.Limit series
+	QueryMetrics(ctx context.Context, query model.MetricsQuery) ([]model.Metrics, error)
+
+	// Delete removes one series, ErrItemNotFound is returned when series does not exist
+	Delete(ctx context.Context, mType model.MetricType, name string, labels model.Labels) error
+	// DeleteByPrefix removes series of every type and labels with name starting with prefix and returns number of removed series
+	DeleteByPrefix(ctx context.Context, prefix string) (int, error)
 }
 
 var ErrItemNotFound = errors.New("item not found")
+var ErrUnknownMetricType = errors.New("unknown metric type")
+var ErrHistogramBoundsMismatch = model.ErrHistogramBoundsMismatch
+var ErrInvalidMetric = model.ErrInvalidMetric
+
+// ErrSchemaMissing database has no tables of metrics, migrations were not applied
+var ErrSchemaMissing = errors.New("database schema is missing")
+
+// Exporter storage which can return all stored series with their names and labels,
+// counters are returned with accumulated value in Delta
+type Exporter interface {
+	ExportMetrics(ctx context.Context) ([]model.Metrics, error)
+}
+
+// ValidateMetrics check every metric of batch before anything is applied, so invalid batch never changes storage.
+// Error wraps ErrInvalidMetric and contains position of the first invalid metric
+func ValidateMetrics(metrics []model.Metrics) error {
+	for i := range metrics {
+		if err := metrics[i].Validate(); err != nil {
+			return fmt.Errorf("metric %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// checkMetricType returns ErrUnknownMetricType for type other than gauge, counter or histogram
+func checkMetricType(mType model.MetricType) error {
+	switch mType {
+	case model.Gauge, model.Counter, model.Histogram:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownMetricType, mType)
+	}
+}
+
+// seriesID identity of series of given type, used as key of merged and requested series
+func seriesID(metric model.Metrics) string {
+	return metric.MType + ":" + model.SeriesKey(metric.ID, metric.Labels)
+}
+
+// getMetrics batch read through single series getters for storages without own batch read
+func getMetrics(ctx context.Context, storage Storage, metrics []model.Metrics) ([]model.Metrics, error) {
+	found := make([]model.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		result := model.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels}
+
+		var err error
+		switch model.MetricType(metric.MType) {
+		case model.Gauge:
+			var value float64
+			value, err = storage.GetGauge(ctx, metric.ID, metric.Labels)
+			result.Value = &value
+		case model.Counter:
+			var delta int64
+			delta, err = storage.GetCounter(ctx, metric.ID, metric.Labels)
+			result.Delta = &delta
+		case model.Histogram:
+			var histogram model.HistogramData
+			histogram, err = storage.GetHistogram(ctx, metric.ID, metric.Labels)
+			result.Histogram = &histogram
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnknownMetricType, metric.MType)
+		}
+
+		if errors.Is(err, ErrItemNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		found = append(found, result)
+	}
+
+	return found, nil
+}
+
+// inRequestOrder pick stored series keyed by seriesID in order of request, missing series are skipped
+func inRequestOrder(metrics []model.Metrics, stored map[string]model.Metrics) []model.Metrics {
+	found := make([]model.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		if result, ok := stored[seriesID(metric)]; ok {
+			result.Labels = metric.Labels
+			found = append(found, result)
+		}
+	}
+
+	return found
+}
+
+// queryMetrics select, order and limit exported series for storages without own query
+func queryMetrics(ctx context.Context, exporter Exporter, query model.MetricsQuery) ([]model.Metrics, error) {
+	if query.Type != "" {
+		if err := checkMetricType(query.Type); err != nil {
+			return nil, err
+		}
+	}
+
+	metrics, err := exporter.ExportMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]model.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		if query.Type != "" && metric.MType != string(query.Type) {
+			continue
+		}
+		if !strings.HasPrefix(metric.ID, query.Prefix) {
+			continue
+		}
+		if query.After != nil && !inQueryOrder(*query.After, metric, query.Desc) {
+			continue
+		}
+		selected = append(selected, metric)
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return inQueryOrder(selected[i], selected[j], query.Desc)
+	})
+
+	if query.Limit > 0 && len(selected) > query.Limit {
+		selected = selected[:query.Limit]
+	}
+
+	return selected, nil
+}
+
+// inQueryOrder reports whether series a goes before series b in listing ordered by name, type and labels
+func inQueryOrder(a model.Metrics, b model.Metrics, desc bool) bool {
+	compared := strings.Compare(a.ID, b.ID)
+	if compared == 0 {
+		compared = strings.Compare(a.MType, b.MType)
+	}
+	if compared == 0 {
+		compared = strings.Compare(a.Labels.String(), b.Labels.String())
+	}
+
+	if desc {
+		return compared > 0
+	}
+	return compared < 0
+}
+
+// checkRequestTypes returns ErrUnknownMetricType for the first requested series with unknown type
+func checkRequestTypes(metrics []model.Metrics) error {
+	for _, metric := range metrics {
+		if err := checkMetricType(model.MetricType(metric.MType)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchSeries returns matcher of one series of given type
+func matchSeries(mType model.MetricType, name string, labels model.Labels) func(metric model.Metrics) bool {
+	key := model.SeriesKey(name, labels)
+	return func(metric model.Metrics) bool {
+		return metric.MType == string(mType) && model.SeriesKey(metric.ID, metric.Labels) == key
+	}
+}
+
+// matchPrefix returns matcher of series of any type with name starting with prefix
+func matchPrefix(prefix string) func(metric model.Metrics) bool {
+	return func(metric model.Metrics) bool {
+		return strings.HasPrefix(metric.ID, prefix)
+	}
+}
+
+// mergeMetrics merge metrics of the same series in valid batch in order of first appearance.
+// Input is not modified, histogram bounds mismatch inside batch returns ErrHistogramBoundsMismatch
+func mergeMetrics(metrics []model.Metrics) ([]model.Metrics, error) {
+	batch := newSeriesBatch(len(metrics))
+	for _, metric := range metrics {
+		if err := batch.add(metric); err != nil {
+			return nil, err
+		}
+	}
+
+	return batch.metrics, nil
+}
+
+// seriesBatch valid metrics merged by series in order of first appearance:
+// counter deltas are summed, the last gauge value is kept and histograms are merged
+type seriesBatch struct {
+	positions map[string]int
+	metrics   []model.Metrics
+}
+
+func newSeriesBatch(capacity int) *seriesBatch {
+	return &seriesBatch{positions: make(map[string]int, capacity), metrics: make([]model.Metrics, 0, capacity)}
+}
+
+// add merge copy of metric into batch, batch is not changed on error
+func (batch *seriesBatch) add(metric model.Metrics) error {
+	key := seriesID(metric)
+
+	position, ok := batch.positions[key]
+	if !ok {
+		batch.positions[key] = len(batch.metrics)
+		batch.metrics = append(batch.metrics, cloneMetric(metric))
+		return nil
+	}
+
+	current := &batch.metrics[position]
+	switch metric.MType {
+	case string(model.Gauge):
+		value := *metric.Value
+		current.Value = &value
+	case string(model.Counter):
+		*current.Delta += *metric.Delta
+	case string(model.Histogram):
+		if err := current.Histogram.Merge(metric.Histogram); err != nil {
+			return fmt.Errorf("failed to merge histogram %s: %w", current.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// remove drop series matched by {match} keeping order of the rest, returns number of removed series
+func (batch *seriesBatch) remove(match func(metric model.Metrics) bool) int {
+	kept := newSeriesBatch(len(batch.metrics))
+	for _, metric := range batch.metrics {
+		if match(metric) {
+			continue
+		}
+		kept.positions[metric.MType+":"+model.SeriesKey(metric.ID, metric.Labels)] = len(kept.metrics)
+		kept.metrics = append(kept.metrics, metric)
+	}
+
+	removed := len(batch.metrics) - len(kept.metrics)
+	*batch = *kept
+	return removed
+}
+
+// len number of series in batch
+func (batch *seriesBatch) len() int {
+	return len(batch.metrics)
+}
+
+// cloneMetric deep copy of metric, so merging does not change values of caller
+func cloneMetric(metric model.Metrics) model.Metrics {
+	clone := model.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels.Clone()}
+	if metric.Value != nil {
+		value := *metric.Value
+		clone.Value = &value
+	}
+	if metric.Delta != nil {
+		delta := *metric.Delta
+		clone.Delta = &delta
+	}
+	if metric.Histogram != nil {
+		histogram := metric.Histogram.Clone()
+		clone.Histogram = &histogram
+	}
+	return clone
+}
@@ -0,0 +1,349 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+	"go.uber.org/zap"
+)
+
+// walHeaderSize every record starts with payload length and CRC-32 of payload, both uint32 big endian
+const walHeaderSize = 8
+
+// walMaxRecordSize records longer than this are treated as corrupted
+const walMaxRecordSize = 16 << 20
+
+var ErrWALClosed = errors.New("write-ahead log is closed")
+
+// walRecord payload of one record, metrics hold absolute values of series after update,
+// so replaying the same record twice gives the same state. Batch is written as one record and is replayed entirely or not at all.
+// Deleted holds series removed by record, only name, type and labels are set
+type walRecord struct {
+	Metrics []model.Metrics `json:"metrics"`
+	Deleted []model.Metrics `json:"deleted,omitempty"`
+	Seq     uint64          `json:"seq"`
+}
+
+// walSegment segment of log closed by Rotate, seq is sequence number of its last record
+type walSegment struct {
+	path string
+	seq  uint64
+}
+
+// WAL append only write-ahead log of metric updates. Every record is framed with length and checksum
+// and synced to disk before update is applied. Log is rotated before its state is saved into snapshot
+// and rotated segments are removed after snapshot is written
+type WAL struct {
+	file *os.File
+	path string
+	seq  uint64
+	size int64
+	lock sync.Mutex
+}
+
+// OpenWAL open or create write-ahead log, Replay must be called before appending to existing log
+func OpenWAL(path string) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening write-ahead log: %w", err)
+	}
+
+	return &WAL{file: file, path: path}, nil
+}
+
+// Replay read records of rotated segments and then of current one in order and call apply for every updated metric
+// and remove for every deleted series of record with increasing sequence number.
+// Reading of segment stops at the first torn or corrupted record, current segment is truncated there so new records follow the last valid one
+func (wal *WAL) Replay(apply func(metric model.Metrics) error, remove func(metric model.Metrics) error) error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	segments, err := wal.rotatedSegments()
+	if err != nil {
+		return err
+	}
+
+	var replayed int
+
+	for _, segment := range segments {
+		file, err := os.Open(segment.path)
+		if err != nil {
+			return fmt.Errorf("error opening write-ahead log segment: %w", err)
+		}
+
+		_, count, err := wal.replaySegment(segment.path, file, apply, remove)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		replayed += count
+	}
+
+	if _, err := wal.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking write-ahead log: %w", err)
+	}
+
+	offset, count, err := wal.replaySegment(wal.path, wal.file, apply, remove)
+	if err != nil {
+		return err
+	}
+	replayed += count
+
+	if err := wal.file.Truncate(offset); err != nil {
+		return fmt.Errorf("error truncating write-ahead log: %w", err)
+	}
+	if _, err := wal.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking write-ahead log: %w", err)
+	}
+	wal.size = offset
+
+	zap.L().Info("Replayed write-ahead log", zap.String("path", wal.path), zap.Int("records", replayed), zap.Uint64("seq", wal.seq))
+	return nil
+}
+
+// replaySegment apply records of one segment newer than the last replayed one.
+// Returns size of valid part of segment and number of replayed records
+func (wal *WAL) replaySegment(path string, segment io.Reader, apply func(metric model.Metrics) error, remove func(metric model.Metrics) error) (int64, int, error) {
+	reader := bufio.NewReader(segment)
+	var offset int64
+	var replayed int
+
+	for {
+		record, size, err := readWALRecord(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			zap.L().Warn("Write-ahead log has torn or corrupted record, discarding tail",
+				zap.String("path", path), zap.Int64("offset", offset), zap.Error(err))
+			break
+		}
+
+		offset += size
+
+		if record.Seq <= wal.seq {
+			zap.L().Warn("Skipping out of order write-ahead log record", zap.Uint64("seq", record.Seq), zap.Uint64("lastSeq", wal.seq))
+			continue
+		}
+
+		for _, metric := range record.Metrics {
+			if err := apply(metric); err != nil {
+				return 0, 0, fmt.Errorf("error applying write-ahead log record %d: %w", record.Seq, err)
+			}
+		}
+		for _, metric := range record.Deleted {
+			if err := remove(metric); err != nil {
+				return 0, 0, fmt.Errorf("error applying write-ahead log record %d: %w", record.Seq, err)
+			}
+		}
+
+		wal.seq = record.Seq
+		replayed++
+	}
+
+	return offset, replayed, nil
+}
+
+// readWALRecord read one framed record and returns it with its size on disk.
+// io.EOF is returned only on clean end of log, partial header or payload gives io.ErrUnexpectedEOF
+func readWALRecord(reader io.Reader) (walRecord, int64, error) {
+	var record walRecord
+
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return record, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	checksum := binary.BigEndian.Uint32(header[4:])
+	if length > walMaxRecordSize {
+		return record, 0, fmt.Errorf("record length %d exceeds limit", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return record, 0, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return record, 0, errors.New("checksum mismatch")
+	}
+
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, 0, fmt.Errorf("error unmarshalling record: %w", err)
+	}
+
+	return record, int64(walHeaderSize) + int64(length), nil
+}
+
+// Append write metrics with absolute values as next record and sync it to disk
+func (wal *WAL) Append(metrics ...model.Metrics) error {
+	return wal.append(walRecord{Metrics: metrics})
+}
+
+// AppendDelete write series removed from storage as next record and sync it to disk
+func (wal *WAL) AppendDelete(metrics ...model.Metrics) error {
+	return wal.append(walRecord{Metrics: []model.Metrics{}, Deleted: metrics})
+}
+
+// append write record with next sequence number
+func (wal *WAL) append(record walRecord) error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if wal.file == nil {
+		return ErrWALClosed
+	}
+
+	record.Seq = wal.seq + 1
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error marshalling record: %w", err)
+	}
+
+	data := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(data[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(data[4:], crc32.ChecksumIEEE(payload))
+	data = append(data, payload...)
+
+	if _, err := wal.file.Write(data); err != nil {
+		return fmt.Errorf("error writing record: %w", err)
+	}
+	if err := wal.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing write-ahead log: %w", err)
+	}
+
+	wal.seq++
+	wal.size += int64(len(data))
+	return nil
+}
+
+// Rotate close current segment and start the next one, called when state of log is about to be saved into snapshot.
+// Closed segment is kept and replayed until RemoveRotated is called. Returns sequence number of the last record of closed segment.
+// Sequence numbers keep growing
+func (wal *WAL) Rotate() (uint64, error) {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if wal.file == nil {
+		return 0, ErrWALClosed
+	}
+
+	if wal.size == 0 {
+		return wal.seq, nil
+	}
+
+	segmentPath := fmt.Sprintf("%s.%020d", wal.path, wal.seq)
+	if err := os.Rename(wal.path, segmentPath); err != nil {
+		return 0, fmt.Errorf("error rotating write-ahead log: %w", err)
+	}
+
+	file, err := os.OpenFile(wal.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		// Records keep being appended to current segment
+		if renameErr := os.Rename(segmentPath, wal.path); renameErr != nil {
+			zap.L().Error("Failed to restore write-ahead log segment", zap.String("path", segmentPath), zap.Error(renameErr))
+		}
+		return 0, fmt.Errorf("error creating write-ahead log segment: %w", err)
+	}
+
+	if err := wal.file.Close(); err != nil {
+		zap.L().Warn("Failed to close write-ahead log segment", zap.String("path", segmentPath), zap.Error(err))
+	}
+	syncDir(filepath.Dir(wal.path))
+
+	wal.file = file
+	wal.size = 0
+	return wal.seq, nil
+}
+
+// RemoveRotated delete rotated segments with records up to {seq}, called after their state is saved into snapshot
+func (wal *WAL) RemoveRotated(seq uint64) error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	segments, err := wal.rotatedSegments()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, segment := range segments {
+		if segment.seq > seq {
+			continue
+		}
+		if err := os.Remove(segment.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("error removing write-ahead log segment: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// rotatedSegments returns segments closed by Rotate ordered by sequence number
+func (wal *WAL) rotatedSegments() ([]walSegment, error) {
+	paths, err := filepath.Glob(wal.path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("error listing write-ahead log segments: %w", err)
+	}
+
+	segments := make([]walSegment, 0, len(paths))
+	for _, path := range paths {
+		seq, err := strconv.ParseUint(strings.TrimPrefix(path, wal.path+"."), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, walSegment{path: path, seq: seq})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].seq < segments[j].seq
+	})
+
+	return segments, nil
+}
+
+// Size returns number of bytes written to current segment
+func (wal *WAL) Size() int64 {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	return wal.size
+}
+
+// Seq returns sequence number of the last record
+func (wal *WAL) Seq() uint64 {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	return wal.seq
+}
+
+// Close close log file, following appends fail with ErrWALClosed
+func (wal *WAL) Close() error {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+
+	if wal.file == nil {
+		return nil
+	}
+
+	err := wal.file.Close()
+	wal.file = nil
+	return err
+}
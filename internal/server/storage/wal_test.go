@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zavtra-na-rabotu/gometrics/internal/model"
+)
+
+func replayAll(t *testing.T, wal *WAL) []model.Metrics {
+	metrics := make([]model.Metrics, 0)
+	err := wal.Replay(func(metric model.Metrics) error {
+		metrics = append(metrics, metric)
+		return nil
+	}, func(_ model.Metrics) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	return metrics
+}
+
+func TestWAL_AppendReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	wal, err := OpenWAL(path)
+	assert.NoError(t, err)
+
+	value := 1.5
+	delta := int64(7)
+	err = wal.Append(model.Metrics{ID: "HeapAlloc", MType: string(model.Gauge), Value: &value})
+	assert.NoError(t, err)
+	err = wal.Append(model.Metrics{ID: "PollCount", MType: string(model.Counter), Labels: model.Labels{"host": "a"}, Delta: &delta})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), wal.Seq())
+	assert.NoError(t, wal.Close())
+
+	wal, err = OpenWAL(path)
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	metrics := replayAll(t, wal)
+	assert.Len(t, metrics, 2)
+	assert.Equal(t, "HeapAlloc", metrics[0].ID)
+	assert.Equal(t, value, *metrics[0].Value)
+	assert.Equal(t, model.Labels{"host": "a"}, metrics[1].Labels)
+	assert.Equal(t, delta, *metrics[1].Delta)
+
+	// Numbering continues after replay
+	err = wal.Append(model.Metrics{ID: "HeapAlloc", MType: string(model.Gauge), Value: &value})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), wal.Seq())
+}
+
+func TestWAL_ReplayTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	wal, err := OpenWAL(path)
+	assert.NoError(t, err)
+
+	value := 1.5
+	err = wal.Append(model.Metrics{ID: "First", MType: string(model.Gauge), Value: &value})
+	assert.NoError(t, err)
+	err = wal.Append(model.Metrics{ID: "Second", MType: string(model.Gauge), Value: &value})
+	assert.NoError(t, err)
+	assert.NoError(t, wal.Close())
+
+	// Crash in the middle of the last record
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(path, info.Size()-3))
+
+	wal, err = OpenWAL(path)
+	assert.NoError(t, err)
+
+	metrics := replayAll(t, wal)
+	assert.Len(t, metrics, 1)
+	assert.Equal(t, "First", metrics[0].ID)
+
+	// New record follows the last valid one
+	err = wal.Append(model.Metrics{ID: "Third", MType: string(model.Gauge), Value: &value})
+	assert.NoError(t, err)
+	assert.NoError(t, wal.Close())
+
+	wal, err = OpenWAL(path)
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	metrics = replayAll(t, wal)
+	assert.Len(t, metrics, 2)
+	assert.Equal(t, "Third", metrics[1].ID)
+}
+
+func TestWAL_ReplayChecksumMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	wal, err := OpenWAL(path)
+	assert.NoError(t, err)
+
+	value := 1.5
+	err = wal.Append(model.Metrics{ID: "First", MType: string(model.Gauge), Value: &value})
+	assert.NoError(t, err)
+	assert.NoError(t, wal.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	data[len(data)-2] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+
+	wal, err = OpenWAL(path)
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	assert.Empty(t, replayAll(t, wal))
+	assert.Equal(t, int64(0), wal.Size())
+}
+
+func TestMemStorage_Checkpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	wal, err := OpenWAL(WALPath(path))
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	memStorage := NewMemStorage()
+	memStorage.SetWAL(wal)
+
+	err = memStorage.UpdateCounter(context.Background(), "PollCount", nil, 5)
+	assert.NoError(t, err)
+	assert.Greater(t, wal.Size(), int64(0))
+
+	err = memStorage.Checkpoint(context.Background(), path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), wal.Size())
+
+	restored := NewMemStorage()
+	err = RestoreMetricsFromFile(context.Background(), restored, path)
+	assert.NoError(t, err)
+
+	counter, err := restored.GetCounter(context.Background(), "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), counter)
+}
+
+func TestMemStorage_DeleteReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	ctx := context.Background()
+
+	wal, err := OpenWAL(WALPath(path))
+	assert.NoError(t, err)
+
+	memStorage := NewMemStorage()
+	memStorage.SetWAL(wal)
+
+	assert.NoError(t, memStorage.UpdateCounter(ctx, "PollCount", nil, 5))
+	assert.NoError(t, memStorage.UpdateGauge(ctx, "Alloc", nil, 1.5))
+	assert.NoError(t, memStorage.Delete(ctx, model.Counter, "PollCount", nil))
+	assert.NoError(t, wal.Close())
+
+	// Deletion recorded after update is replayed, so deleted counter does not come back
+	wal, err = OpenWAL(WALPath(path))
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	restored := NewMemStorage()
+	assert.NoError(t, wal.Replay(restored.restoreMetric, restored.removeMetric))
+
+	_, err = restored.GetCounter(ctx, "PollCount", nil)
+	assert.ErrorIs(t, err, ErrItemNotFound)
+
+	gauge, err := restored.GetGauge(ctx, "Alloc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, gauge)
+}
+
+func TestWAL_RotateReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	wal, err := OpenWAL(path)
+	assert.NoError(t, err)
+
+	first, second := 1.0, 2.0
+	assert.NoError(t, wal.Append(model.Metrics{ID: "First", MType: string(model.Gauge), Value: &first}))
+
+	seq, err := wal.Rotate()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), seq)
+	assert.Equal(t, int64(0), wal.Size())
+
+	assert.NoError(t, wal.Append(model.Metrics{ID: "Second", MType: string(model.Gauge), Value: &second}))
+	assert.NoError(t, wal.Close())
+
+	// Rotated segment is replayed before current one
+	wal, err = OpenWAL(path)
+	assert.NoError(t, err)
+
+	metrics := replayAll(t, wal)
+	assert.Len(t, metrics, 2)
+	assert.Equal(t, "First", metrics[0].ID)
+	assert.Equal(t, "Second", metrics[1].ID)
+	assert.Equal(t, uint64(2), wal.Seq())
+	assert.NoError(t, wal.Close())
+
+	// Removed segment is not replayed anymore
+	wal, err = OpenWAL(path)
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	assert.NoError(t, wal.RemoveRotated(seq))
+
+	metrics = replayAll(t, wal)
+	assert.Len(t, metrics, 1)
+	assert.Equal(t, "Second", metrics[0].ID)
+}
+
+func TestMemStorage_CheckpointFailureKeepsLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.json")
+	ctx := context.Background()
+
+	wal, err := OpenWAL(WALPath(path))
+	assert.NoError(t, err)
+
+	memStorage := NewMemStorage()
+	memStorage.SetWAL(wal)
+
+	assert.NoError(t, memStorage.UpdateCounter(ctx, "PollCount", nil, 5))
+
+	// Snapshot can not be written into missing directory, rotated records are kept
+	err = memStorage.Checkpoint(ctx, filepath.Join(dir, "missing", "metrics.json"))
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), wal.Size())
+
+	assert.NoError(t, memStorage.UpdateCounter(ctx, "PollCount", nil, 5))
+	assert.NoError(t, wal.Close())
+
+	wal, err = OpenWAL(WALPath(path))
+	assert.NoError(t, err)
+
+	restored := NewMemStorage()
+	assert.NoError(t, wal.Replay(restored.restoreMetric, restored.removeMetric))
+
+	counter, err := restored.GetCounter(ctx, "PollCount", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), counter)
+
+	// Successful checkpoint removes all rotated segments
+	restored.SetWAL(wal)
+	assert.NoError(t, restored.Checkpoint(ctx, path))
+	assert.NoError(t, wal.Close())
+
+	segments, err := filepath.Glob(WALPath(path) + ".*")
+	assert.NoError(t, err)
+	assert.Empty(t, segments)
+}
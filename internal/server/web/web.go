@@ -0,0 +1,13 @@
+// Package web contains templates of server html pages embedded into binary
+package web
+
+import (
+	"embed"
+	"html/template"
+)
+
+//go:embed metrics/metrics.tmpl
+var templates embed.FS
+
+// MetricsTemplate template of page with list of all metrics, parsed once at startup
+var MetricsTemplate = template.Must(template.ParseFS(templates, "metrics/metrics.tmpl"))